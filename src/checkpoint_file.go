@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"time"
+)
+
+// checkpointFile is the persistent checkpoint of --checkpoint-file: a JSON line identifying the restore
+// (checkpointIdentity), followed by a JSON line per committed table (checkpointEntry). Every entry is appended
+// and synced to the disk right after the commit of its table, so a restore restarted after a crash skips the tables
+// committed before it. The parts of a table are not recorded: a table is loaded in a single transaction,
+// so a crash in the middle rolls the whole table back.
+type checkpointFile struct {
+	// fileName the name of the file
+	fileName string
+	// file the file opened for appending
+	file *os.File
+	// tables the committed tables recorded in the file when it was opened
+	tables []string
+}
+
+// checkpointIdentity identifies the restore that the checkpoint file belongs to.
+type checkpointIdentity struct {
+	// Snapshot the name of the exported snapshot
+	Snapshot string `json:"snapshot"`
+	// Database the name of the database in the snapshot
+	Database string `json:"database"`
+	// Target the destination database (see target.DbWriter.Identity)
+	Target string `json:"target"`
+}
+
+// checkpointEntry is a table committed by the restore.
+type checkpointEntry struct {
+	// Table the name of the table including the schema name
+	Table string `json:"table"`
+	// CommittedAt the time of the commit
+	CommittedAt time.Time `json:"committed_at"`
+}
+
+// openCheckpointFile opens the checkpoint file of the restore for appending and reads the committed tables,
+// or creates the file if it is missing or empty. It fails if the file belongs to another restore. An incomplete
+// last line, left by a crash while it was written, is removed.
+func openCheckpointFile(fileName string, identity checkpointIdentity) (*checkpointFile, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read the checkpoint file '%s': %w", fileName, err)
+	}
+	complete := content[:bytes.LastIndexByte(content, '\n')+1]
+	ret := &checkpointFile{fileName: fileName}
+	if len(complete) > 0 {
+		lines := bytes.Split(bytes.TrimSuffix(complete, []byte("\n")), []byte("\n"))
+		var recorded checkpointIdentity
+		if err := json.Unmarshal(lines[0], &recorded); err != nil {
+			return nil, fmt.Errorf("failed to parse the checkpoint file '%s': %w", fileName, err)
+		}
+		if recorded != identity {
+			return nil, fmt.Errorf("the checkpoint file '%s' belongs to the restore of the snapshot '%s' "+
+				"(database '%s') into '%s', not of the snapshot '%s' (database '%s') into '%s'; remove it "+
+				"or use another file to start a new restore", fileName, recorded.Snapshot, recorded.Database,
+				recorded.Target, identity.Snapshot, identity.Database, identity.Target)
+		}
+		for i, line := range lines[1:] {
+			var entry checkpointEntry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return nil, fmt.Errorf("failed to parse the line %d of the checkpoint file '%s': %w", i+2, fileName, err)
+			}
+			if entry.Table == "" {
+				return nil, fmt.Errorf("the line %d of the checkpoint file '%s' has no table", i+2, fileName)
+			}
+			ret.tables = append(ret.tables, entry.Table)
+		}
+	}
+	if len(complete) < len(content) {
+		log.Warn("Removing the incomplete last line of the checkpoint file", zap.String("file", fileName))
+		if err := os.Truncate(fileName, int64(len(complete))); err != nil {
+			return nil, fmt.Errorf("failed to repair the checkpoint file '%s': %w", fileName, err)
+		}
+	}
+	if ret.file, err = os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, fmt.Errorf("failed to open the checkpoint file '%s': %w", fileName, err)
+	}
+	if len(complete) == 0 {
+		if err := ret.appendLine(identity); err != nil {
+			ret.close()
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// record appends the committed table to the file.
+func (f *checkpointFile) record(table string) error {
+	return f.appendLine(checkpointEntry{Table: table, CommittedAt: time.Now().UTC()})
+}
+
+// appendLine appends the value as a JSON line and syncs the file, so that the line survives a crash.
+func (f *checkpointFile) appendLine(value any) error {
+	line, err := json.Marshal(value)
+	if err == nil {
+		_, err = f.file.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = f.file.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the checkpoint file '%s': %w", f.fileName, err)
+	}
+	return nil
+}
+
+// close closes the file.
+func (f *checkpointFile) close() {
+	if err := f.file.Close(); err != nil {
+		log.Error("ERROR: ", zap.Error(err))
+	}
+}
+
+// remove closes and removes the file once the restore succeeded.
+func (f *checkpointFile) remove() {
+	f.close()
+	if err := os.Remove(f.fileName); err != nil {
+		log.Error("ERROR: ", zap.Error(err))
+		return
+	}
+	log.Info("Removed the checkpoint file of the completed restore", zap.String("file", f.fileName))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	identity := checkpointIdentity{Snapshot: "snap", Database: "db", Target: "localhost:5432/app"}
+	file, err := openCheckpointFile(fileName, identity)
+	if err != nil {
+		t.Fatalf("openCheckpointFile() of a new file error: %v", err)
+	}
+	if len(file.tables) != 0 {
+		t.Errorf("openCheckpointFile() of a new file tables = %v; want none", file.tables)
+	}
+	for _, table := range []string{"public.a", "public.b"} {
+		if err := file.record(table); err != nil {
+			t.Fatalf("record() error: %v", err)
+		}
+	}
+	file.close()
+
+	// a crash while writing the third entry left an incomplete line
+	appendFile, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND, 0644)
+	if err == nil {
+		_, err = appendFile.WriteString(`{"table":"public.c","comm`)
+		_ = appendFile.Close()
+	}
+	if err != nil {
+		t.Fatalf("Failed to append to the checkpoint file: %v", err)
+	}
+	file, err = openCheckpointFile(fileName, identity)
+	if err != nil {
+		t.Fatalf("openCheckpointFile() of an existing file error: %v", err)
+	}
+	if expected := []string{"public.a", "public.b"}; !reflect.DeepEqual(file.tables, expected) {
+		t.Errorf("openCheckpointFile() tables = %v; want %v", file.tables, expected)
+	}
+	if err := file.record("public.c"); err != nil {
+		t.Fatalf("record() error: %v", err)
+	}
+	file.close()
+	file, err = openCheckpointFile(fileName, identity)
+	if err != nil {
+		t.Fatalf("openCheckpointFile() of the repaired file error: %v", err)
+	}
+	if len(file.tables) != 3 {
+		t.Errorf("openCheckpointFile() of the repaired file tables = %v; want 3 tables", file.tables)
+	}
+	file.close()
+
+	other := identity
+	other.Target = "other.example.com:5432/app"
+	if _, err := openCheckpointFile(fileName, other); err == nil || !strings.Contains(err.Error(), "belongs to") {
+		t.Errorf("openCheckpointFile() of another target = %v; want an error", err)
+	}
+
+	progress := newCheckpoint()
+	if err := progress.resume(fileName, identity); err != nil {
+		t.Fatalf("resume() error: %v", err)
+	}
+	if !progress.isCompleted("public.b") || progress.isCompleted("public.d") {
+		t.Errorf("resume() completed tables = %v; want the recorded ones", progress.completed)
+	}
+	progress.file.remove()
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Errorf("remove() left the file behind: %v", err)
+	}
+}
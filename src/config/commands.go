@@ -0,0 +1,284 @@
+package config
+
+import (
+	"dbrestore/utils"
+	"dbrestore/version"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Commands of the program, the first command line argument
+const (
+	// CommandRestore loads the export into the destination database
+	CommandRestore = "restore"
+	// CommandListDatabases lists the database instances in the export
+	CommandListDatabases = "list-databases"
+	// CommandListTables lists the selected tables of the export with their columns and Parquet part files
+	CommandListTables = "list-tables"
+	// CommandTruncate truncates the tables of the destination database without loading data
+	CommandTruncate = "truncate"
+	// CommandFinishIndexes recreates the indexes and constraints left missing by an interrupted restore
+	CommandFinishIndexes = "finish-indexes"
+	// CommandValidate checks the options and the metadata of the export without connecting to the database
+	CommandValidate = "validate"
+	// CommandDiff compares the export with the manifest of a previous restore
+	CommandDiff = "diff"
+	// CommandEstimate predicts the duration of the restore by loading samples of the largest tables
+	CommandEstimate = "estimate"
+	// CommandVersion prints the version of the program (the same as --version)
+	CommandVersion = "version"
+)
+
+// Groups of the command line flags accepted by the commands
+var (
+	// commonFlags are accepted by all commands
+	commonFlags = []string{"help", "version", "work-dir", "config", "json-logs", "verbose", "trace", "dev-logs"}
+	// exportFlags locate and filter the export
+	exportFlags = []string{"source-db", "dir", "s3-bucket", "gcs-bucket", "archive", "include-databases",
+		"exclude-databases", "include-tables", "exclude-tables", "include-tables-regex", "exclude-tables-regex",
+		"aws-access-key", "aws-secret-key", "aws-region", "s3-download", "s3-max-retries", "temp-dir",
+		"min-free-space", "max-open-parquet-files", "receipt", "success-marker", "no-success-marker"}
+	// databaseFlags connect to the destination database
+	databaseFlags = []string{"db-url", "db-user", "db-password", "db-password-file", "db-secret-arn", "db-host",
+		"db-port", "db-name", "db-sslmode", "db-sslrootcert", "db-sslcert", "db-sslkey", "db-iam-auth",
+		"pgbouncer-compat", "aws-access-key", "aws-secret-key", "aws-region", "db-connect-retries", "db-connect-timeout",
+		"db-max-attempts", "db-max-conns"}
+	// loadFlags control loading the data
+	loadFlags = []string{"truncate-all", "ignore-missing-tables", "system-schemas", "skip-not-empty", "keep-indexes",
+		"on-conflict-skip", "resilient-load", "check-duplicate-keys", "raw-strings", "raw-strings-tables", "analyze",
+		"unknown-type-fallback", "copy-count-mismatch", "table-timeout", "table-timeout-action", "continue-on-error",
+		"parquet-batch-size", "parquet-readers", "type-override", "generate-ddl", "ddl-file", "manifest-out",
+		"report-file", "max-run-attempts", "run-retry-delay", "dump-graph", "pending-indexes", "tables-only",
+		"max-rows-per-sec", "max-write-mbps", "run-timeout", "concurrent-indexes",
+		"skip-dependents", "truncate-target-all", "checkpoint-file", "no-tracking", "resume", "jobs",
+		"verify-checksums", "parts-jobs", "notify-webhook", "notify-timeout"}
+	// legacyCommandFlags are the deprecated flags selecting a command, accepted only without a command
+	legacyCommandFlags = map[string]string{"list": CommandListDatabases, "list-parts": CommandListTables}
+)
+
+// command is a command of the program with the flags that apply to it.
+type command struct {
+	// name the name of the command on the command line
+	name string
+	// description the one-line description of the command in the help
+	description string
+	// flags the groups of the flags accepted by the command in addition to commonFlags
+	flags [][]string
+	// apply marks the command in the configuration
+	apply func(c *Config)
+}
+
+// commands the commands of the program in the order of the help
+var commands = []command{
+	{name: CommandRestore, description: "load the export into the destination database",
+		flags: [][]string{exportFlags, databaseFlags, loadFlags, {"dry-run"}}, apply: func(c *Config) {}},
+	{name: CommandListDatabases, description: "list the database instances in the export",
+		flags: [][]string{exportFlags}, apply: func(c *Config) { c.ListCommand = true }},
+	{name: CommandListTables, description: "list the selected tables of the export with their Parquet part files",
+		flags: [][]string{exportFlags, {"with-rows", "json"}}, apply: func(c *Config) { c.ListPartsCommand = true }},
+	{name: CommandTruncate, description: "truncate all tables of the destination database without loading data",
+		flags: [][]string{databaseFlags, {"report-file", "system-schemas", "dump-graph", "notify-webhook",
+			"notify-timeout"}},
+		apply: func(c *Config) { c.TruncateCommand = true }},
+	{name: CommandValidate, description: "check the options and the metadata of the export without loading it",
+		flags: [][]string{exportFlags}, apply: func(c *Config) { c.ValidateCommand = true }},
+	{name: CommandDiff, description: "compare the export with the manifest of a previous restore",
+		flags: [][]string{exportFlags, {"manifest", "diff-out", "manifest-out"}},
+		apply: func(c *Config) { c.DiffCommand = true }},
+	{name: CommandEstimate, description: "predict the duration of the restore by loading samples of the largest tables",
+		flags: [][]string{exportFlags, databaseFlags, loadFlags, {"estimate-tables", "estimate-sample-rows"}},
+		apply: func(c *Config) { c.EstimateCommand = true }},
+	{name: CommandVersion, description: "print the version of the program", apply: func(c *Config) {}},
+}
+
+// accepts reports whether the flag applies to the command.
+func (cmd command) accepts(flagName string) bool {
+	if slices.Contains(commonFlags, flagName) {
+		return true
+	}
+	for _, group := range cmd.flags {
+		if slices.Contains(group, flagName) {
+			return true
+		}
+	}
+	return false
+}
+
+// findCommand returns the command with the given name.
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// CommandLine is the parsed command line of the program: the command and the options specified with its flags,
+// which override all other configuration sources (see GetConfig).
+type CommandLine struct {
+	// Command the name of the command
+	Command string
+
+	// config the options specified on the command line
+	config *Config
+	// options the flags that are not a part of Config
+	options flagOptions
+	// deprecations the warnings about the deprecated usage, logged once the logger is initialized
+	deprecations []string
+}
+
+// ParseCommandLine parses the command line arguments (without the program name): the command followed by its flags.
+// Without a command, the arguments are parsed the old way with all flags and translated to the command selected
+// by --list or --list-parts, or to "restore", with a deprecation warning. The help is printed on request,
+// and the program exits with code 1 on invalid arguments, like on an invalid configuration.
+func ParseCommandLine(args []string) *CommandLine {
+	ret, err := parseCommandLine(args, os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\nRun with --help for more information.\n", err)
+		os.Exit(1)
+	}
+	return ret
+}
+
+// parseCommandLine parses the arguments like ParseCommandLine, printing the help, the version and the errors
+// of the flags to output; it returns flag.ErrHelp when the help or the version was printed.
+func parseCommandLine(args []string, output io.Writer) (*CommandLine, error) {
+	if len(args) > 0 && args[0] == CommandVersion {
+		_, _ = fmt.Fprintln(output, version.String())
+		return nil, flag.ErrHelp
+	}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, found := findCommand(args[0])
+		if !found {
+			if args[0] == "help" {
+				printCommands(output)
+				return nil, flag.ErrHelp
+			}
+			return nil, fmt.Errorf("unknown command '%s'", args[0])
+		}
+		ret := &CommandLine{Command: cmd.name, config: &Config{}}
+		if err := ret.parseFlags(cmd, args[1:], output); err != nil {
+			return nil, err
+		}
+		return ret, nil
+	}
+
+	// the deprecated usage without a command: all flags are accepted
+	ret := &CommandLine{Command: CommandRestore, config: &Config{}}
+	fs := flag.NewFlagSet(programName(), flag.ContinueOnError)
+	fs.SetOutput(io.Discard) // the errors are reported by ParseCommandLine
+	fs.Usage = func() {
+		printCommands(output)
+	}
+	options, err := ret.config.loadFromFlags(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if options.version {
+		_, _ = fmt.Fprintln(output, version.String())
+		return nil, flag.ErrHelp
+	}
+	if options.help {
+		printCommands(output)
+		return nil, flag.ErrHelp
+	}
+	ret.options = options
+	fs.Visit(func(f *flag.Flag) {
+		if name, found := legacyCommandFlags[f.Name]; found && (f.Value.String() == "true") {
+			ret.Command = name
+			ret.deprecations = append(ret.deprecations, fmt.Sprintf("The flag --%s is deprecated and will be "+
+				"removed in the next release, use the command '%s %s' instead", f.Name, programName(), name))
+		}
+	})
+	if ret.Command == CommandRestore {
+		ret.deprecations = append(ret.deprecations, fmt.Sprintf("Running without a command is deprecated "+
+			"and will not be supported in the next release, use '%s %s' instead", programName(), CommandRestore))
+	}
+	// the legacy flags have already marked the command in the configuration
+	return ret, nil
+}
+
+// parseFlags parses the flags of the command, rejecting the flags that do not apply to it.
+func (l *CommandLine) parseFlags(cmd command, args []string, output io.Writer) error {
+	name := programName() + " " + cmd.name
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard) // the errors are reported by ParseCommandLine
+	fs.Usage = func() {
+		printCommandUsage(output, cmd, fs)
+	}
+	options, err := l.config.loadFromFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	var rejected []string
+	fs.Visit(func(f *flag.Flag) {
+		if !cmd.accepts(f.Name) {
+			rejected = append(rejected, "--"+f.Name)
+		}
+	})
+	if len(rejected) > 0 {
+		return fmt.Errorf("the command '%s' does not accept %s", cmd.name, strings.Join(rejected, ", "))
+	}
+	if len(fs.Args()) > 0 {
+		return fmt.Errorf("unexpected arguments of the command '%s': %s", cmd.name, strings.Join(fs.Args(), " "))
+	}
+	if options.version {
+		_, _ = fmt.Fprintln(output, version.String())
+		return flag.ErrHelp
+	}
+	if options.help {
+		printCommandUsage(output, cmd, fs)
+		return flag.ErrHelp
+	}
+	l.options = options
+	cmd.apply(l.config)
+	return nil
+}
+
+// logDeprecations logs the warnings about the deprecated usage of the command line.
+func (l *CommandLine) logDeprecations() {
+	for _, deprecation := range l.deprecations {
+		utils.Logger.Warn(deprecation)
+	}
+}
+
+// programName returns the name of the program for the help.
+func programName() string {
+	if len(os.Args) == 0 {
+		return "dbrestore"
+	}
+	return os.Args[0]
+}
+
+// printCommands prints the list of the commands.
+func printCommands(output io.Writer) {
+	_, _ = fmt.Fprintf(output, "Usage: %s <command> [flags]\n\nCommands:\n", programName())
+	for _, cmd := range commands {
+		_, _ = fmt.Fprintf(output, "  %-16s %s\n", cmd.name, cmd.description)
+	}
+	_, _ = fmt.Fprintf(output, "\nRun '%s <command> --help' for the flags of a command.\n", programName())
+}
+
+// printCommandUsage prints the flags that apply to the command; fs is the flag set of the command.
+func printCommandUsage(output io.Writer, cmd command, fs *flag.FlagSet) {
+	_, _ = fmt.Fprintf(output, "Usage of %s %s: %s\n", programName(), cmd.name, cmd.description)
+	accepted := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	accepted.SetOutput(output)
+	fs.VisitAll(func(f *flag.Flag) {
+		if cmd.accepts(f.Name) {
+			accepted.Var(f.Value, f.Name, f.Usage)
+			accepted.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	accepted.PrintDefaults()
+	_, _ = fmt.Fprintf(output, "\nEvery flag can also be set with an environment variable, "+
+		"for example %s for --db-host.\n", envVariableName("db-host"))
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		name              string
+		args              []string
+		expectedCommand   string
+		expectDeprecation bool
+		expectError       string
+		check             func(c *Config) bool
+	}{
+		{name: "restore", args: []string{"restore", "--dir", "/exports", "--db-name", "restored"},
+			expectedCommand: CommandRestore,
+			check:           func(c *Config) bool { return c.LocalDir == "/exports" && c.DBName == "restored" }},
+		{name: "list databases", args: []string{"list-databases", "--dir", "/exports"},
+			expectedCommand: CommandListDatabases, check: func(c *Config) bool { return c.ListCommand }},
+		{name: "list tables", args: []string{"list-tables", "--dir", "/exports", "--include-tables", "users"},
+			expectedCommand: CommandListTables, check: func(c *Config) bool { return c.ListPartsCommand }},
+		{name: "truncate", args: []string{"truncate", "--db-name", "restored"},
+			expectedCommand: CommandTruncate, check: func(c *Config) bool { return c.TruncateCommand }},
+		{name: "validate", args: []string{"validate", "--dir", "/exports"},
+			expectedCommand: CommandValidate, check: func(c *Config) bool { return c.ValidateCommand }},
+		{name: "estimate", args: []string{"estimate", "--dir", "/exports", "--estimate-tables", "3"},
+			expectedCommand: CommandEstimate,
+			check:           func(c *Config) bool { return c.EstimateCommand && c.EstimateTables == 3 }},
+		{name: "flag of another command", args: []string{"list-databases", "--dir", "/exports", "--db-name", "x"},
+			expectError: "does not accept --db-name"},
+		{name: "legacy flag in a command", args: []string{"restore", "--list"},
+			expectError: "does not accept --list"},
+		{name: "unknown command", args: []string{"load"}, expectError: "unknown command 'load'"},
+		{name: "unexpected argument", args: []string{"validate", "--dir", "/exports", "extra"},
+			expectError: "unexpected arguments"},
+		{name: "legacy list", args: []string{"--list", "--dir", "/exports"},
+			expectedCommand: CommandListDatabases, expectDeprecation: true,
+			check: func(c *Config) bool { return c.ListCommand }},
+		{name: "legacy list-parts", args: []string{"--dir", "/exports", "--list-parts"},
+			expectedCommand: CommandListTables, expectDeprecation: true,
+			check: func(c *Config) bool { return c.ListPartsCommand }},
+		{name: "legacy restore", args: []string{"--dir", "/exports", "--db-name", "restored", "--truncate-all"},
+			expectedCommand: CommandRestore, expectDeprecation: true,
+			check: func(c *Config) bool { return c.TruncateAllCommand && !c.ListCommand }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commandLine, err := parseCommandLine(tt.args, &bytes.Buffer{})
+			if tt.expectError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+					t.Errorf("parseCommandLine() error = %v; want %q", err, tt.expectError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCommandLine() error: %v", err)
+			}
+			if commandLine.Command != tt.expectedCommand {
+				t.Errorf("Command = %s; want %s", commandLine.Command, tt.expectedCommand)
+			}
+			if (len(commandLine.deprecations) > 0) != tt.expectDeprecation {
+				t.Errorf("deprecations = %q; expectDeprecation %v", commandLine.deprecations, tt.expectDeprecation)
+			}
+			if !tt.check(commandLine.config) {
+				t.Errorf("Unexpected configuration %+v", commandLine.config)
+			}
+		})
+	}
+}
+
+func TestCommandHelp(t *testing.T) {
+	output := &bytes.Buffer{}
+	if _, err := parseCommandLine([]string{"list-tables", "--help"}, output); !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("parseCommandLine() error = %v; want flag.ErrHelp", err)
+	}
+	help := output.String()
+	if !strings.Contains(help, "-include-tables") || strings.Contains(help, "-db-name") {
+		t.Errorf("The help of list-tables lists other flags:\n%s", help)
+	}
+
+	output.Reset()
+	if _, err := parseCommandLine([]string{"--help"}, output); !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("parseCommandLine() error = %v; want flag.ErrHelp", err)
+	}
+	for _, cmd := range commands {
+		if !strings.Contains(output.String(), cmd.name) {
+			t.Errorf("The help does not list the command %s:\n%s", cmd.name, output.String())
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	for _, args := range [][]string{{"version"}, {"--version"}, {"restore", "--version"}} {
+		output := &bytes.Buffer{}
+		if _, err := parseCommandLine(args, output); !errors.Is(err, flag.ErrHelp) {
+			t.Fatalf("parseCommandLine(%q) error = %v; want flag.ErrHelp", args, err)
+		}
+		if !strings.HasPrefix(output.String(), "dbrestore dev (commit dev") {
+			t.Errorf("parseCommandLine(%q) printed %q; want the version", args, output.String())
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package config
+
+import (
+	"bytes"
+	"dbrestore/utils"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFile is the configuration file used when --config is not specified, if it exists.
+const DefaultConfigFile = "dbrestore.yaml"
+
+// fileConfig is the structure of the YAML configuration file; the keys match the command line flags
+// with underscores instead of dashes, and the lists are YAML sequences.
+type fileConfig struct {
+	SourceDatabase             string                          `yaml:"source_db"`
+	LocalDir                   string                          `yaml:"dir"`
+	AWSBucketPath              string                          `yaml:"s3_bucket"`
+	GCSBucketPath              string                          `yaml:"gcs_bucket"`
+	ArchivePath                string                          `yaml:"archive"`
+	IncludeDatabases           []string                        `yaml:"include_databases"`
+	ExcludeDatabases           []string                        `yaml:"exclude_databases"`
+	IncludeTables              []string                        `yaml:"include_tables"`
+	ExcludeTables              []string                        `yaml:"exclude_tables"`
+	IncludeTablesRegex         string                          `yaml:"include_tables_regex"`
+	ExcludeTablesRegex         string                          `yaml:"exclude_tables_regex"`
+	TablesOnly                 []string                        `yaml:"tables_only"`
+	IgnoreMissingTablePrefixes []string                        `yaml:"ignore_missing_tables"`
+	SystemSchemas              []string                        `yaml:"system_schemas"`
+	SkipNotEmpty               bool                            `yaml:"skip_not_empty"`
+	KeepIndexes                bool                            `yaml:"keep_indexes"`
+	ConcurrentIndexes          bool                            `yaml:"concurrent_indexes"`
+	OnConflictSkip             bool                            `yaml:"on_conflict_skip"`
+	ResilientLoad              bool                            `yaml:"resilient_load"`
+	CheckDuplicateKeys         bool                            `yaml:"check_duplicate_keys"`
+	RawStrings                 bool                            `yaml:"raw_strings"`
+	RawStringsTables           []string                        `yaml:"raw_strings_tables"`
+	CopyCSV                    utils.CopyCSVOptions            `yaml:"copy_csv"`
+	CopyCSVTables              map[string]utils.CopyCSVOptions `yaml:"copy_csv_tables"`
+	Analyze                    bool                            `yaml:"analyze"`
+	UnknownTypeFallback        string                          `yaml:"unknown_type_fallback"`
+	CopyCountMismatch          string                          `yaml:"copy_count_mismatch"`
+	TableTimeout               time.Duration                   `yaml:"table_timeout"`
+	TableTimeoutAction         string                          `yaml:"table_timeout_action"`
+	RunTimeout                 time.Duration                   `yaml:"run_timeout"`
+	ContinueOnError            bool                            `yaml:"continue_on_error"`
+	SkipDependents             bool                            `yaml:"skip_dependents"`
+	ParquetBatchSize           int                             `yaml:"parquet_batch_size"`
+	ParquetReaders             int                             `yaml:"parquet_readers"`
+	Jobs                       int                             `yaml:"jobs"`
+	PartsJobs                  int                             `yaml:"parts_jobs"`
+	EstimateTables             int                             `yaml:"estimate_tables"`
+	EstimateSampleRows         int64                           `yaml:"estimate_sample_rows"`
+	MaxOpenParquetFiles        int                             `yaml:"max_open_parquet_files"`
+	MaxRowsPerSec              int64                           `yaml:"max_rows_per_sec"`
+	MaxWriteMBps               float64                         `yaml:"max_write_mbps"`
+	TypeOverrides              map[string]string               `yaml:"type_overrides"`
+	ManifestOutFile            string                          `yaml:"manifest_out"`
+	ReportFile                 string                          `yaml:"report_file"`
+	NotifyWebhook              string                          `yaml:"notify_webhook"`
+	NotifyTimeout              time.Duration                   `yaml:"notify_timeout"`
+	ReceiptFile                string                          `yaml:"receipt"`
+	GraphFile                  string                          `yaml:"dump_graph"`
+	PendingIndexesFile         string                          `yaml:"pending_indexes"`
+	CheckpointFile             string                          `yaml:"checkpoint_file"`
+	NoTracking                 bool                            `yaml:"no_tracking"`
+	Resume                     bool                            `yaml:"resume"`
+	VerifyChecksums            bool                            `yaml:"verify_checksums"`
+	SuccessMarkers             []string                        `yaml:"success_marker"`
+	NoSuccessMarker            bool                            `yaml:"no_success_marker"`
+	WorkDir                    string                          `yaml:"work_dir"`
+	AWSAccessKey               string                          `yaml:"aws_access_key"`
+	AWSSecretKey               string                          `yaml:"aws_secret_key"`
+	AWSRegion                  string                          `yaml:"aws_region"`
+	S3Download                 bool                            `yaml:"s3_download"`
+	S3MaxRetries               int                             `yaml:"s3_max_retries"`
+	TempDir                    string                          `yaml:"temp_dir"`
+	MinFreeSpace               string                          `yaml:"min_free_space"`
+	DBURL                      string                          `yaml:"db_url"`
+	DBHost                     string                          `yaml:"db_host"`
+	DBPort                     int                             `yaml:"db_port"`
+	DBName                     string                          `yaml:"db_name"`
+	DBUser                     string                          `yaml:"db_user"`
+	DBPassword                 string                          `yaml:"db_password"`
+	DBPasswordFile             string                          `yaml:"db_password_file"`
+	DBSecretARN                string                          `yaml:"db_secret_arn"`
+	DBSSLMode                  string                          `yaml:"db_sslmode"`
+	DBSSLRootCert              string                          `yaml:"db_sslrootcert"`
+	DBSSLCert                  string                          `yaml:"db_sslcert"`
+	DBSSLKey                   string                          `yaml:"db_sslkey"`
+	DBIAMAuth                  bool                            `yaml:"db_iam_auth"`
+	PgBouncerCompat            bool                            `yaml:"pgbouncer_compat"`
+	DBConnectRetries           int                             `yaml:"db_connect_retries"`
+	DBConnectTimeout           time.Duration                   `yaml:"db_connect_timeout"`
+	DBMaxAttempts              int                             `yaml:"db_max_attempts"`
+	DBMaxConns                 int                             `yaml:"db_max_conns"`
+	MaxRunAttempts             int                             `yaml:"max_run_attempts"`
+	RunRetryDelay              time.Duration                   `yaml:"run_retry_delay"`
+}
+
+// parseConfigFile parses the YAML configuration file content; it returns the parsed configuration
+// and the top-level keys not known to the program. The errors contain the line number in the file.
+func parseConfigFile(data []byte) (*fileConfig, []string, error) {
+	var root yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&root); err != nil {
+		if errors.Is(err, io.EOF) { // an empty file
+			return &fileConfig{}, nil, nil
+		}
+		return nil, nil, err
+	}
+	ret := &fileConfig{}
+	if len(root.Content) == 0 {
+		return ret, nil, nil
+	}
+	mapping := root.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("line %d: expected a mapping of configuration keys", mapping.Line)
+	}
+	known := fileConfigKeys()
+	var unknown []string
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if key := mapping.Content[i].Value; !slices.Contains(known, key) {
+			unknown = append(unknown, key)
+		}
+	}
+	if err := mapping.Decode(ret); err != nil {
+		return nil, nil, err
+	}
+	return ret, unknown, nil
+}
+
+// fileConfigKeys returns all keys supported in the YAML configuration file.
+func fileConfigKeys() []string {
+	t := reflect.TypeOf(fileConfig{})
+	ret := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		ret = append(ret, t.Field(i).Tag.Get("yaml"))
+	}
+	return ret
+}
+
+// apply copies the values set in the configuration file to the Config, validating them the same way
+// as the command line flags.
+func (f *fileConfig) apply(c *Config) error {
+	if f.UnknownTypeFallback != "" {
+		switch f.UnknownTypeFallback {
+		case UnknownTypePanic, UnknownTypeString, UnknownTypeSkipTable:
+		default:
+			return fmt.Errorf("invalid value for unknown_type_fallback: %s", f.UnknownTypeFallback)
+		}
+	}
+	if f.CopyCountMismatch != "" {
+		switch f.CopyCountMismatch {
+		case CopyCountMismatchError, CopyCountMismatchWarn:
+		default:
+			return fmt.Errorf("invalid value for copy_count_mismatch: %s", f.CopyCountMismatch)
+		}
+	}
+	if f.TableTimeout < 0 {
+		return fmt.Errorf("invalid value for table_timeout: %s", f.TableTimeout)
+	}
+	if f.RunTimeout < 0 {
+		return fmt.Errorf("invalid value for run_timeout: %s", f.RunTimeout)
+	}
+	if f.TableTimeoutAction != "" {
+		switch f.TableTimeoutAction {
+		case TableTimeoutAbort, TableTimeoutSkip:
+		default:
+			return fmt.Errorf("invalid value for table_timeout_action: %s", f.TableTimeoutAction)
+		}
+	}
+	if f.ParquetBatchSize < 0 {
+		return fmt.Errorf("invalid value for parquet_batch_size: %d", f.ParquetBatchSize)
+	}
+	if f.ParquetReaders < 0 {
+		return fmt.Errorf("invalid value for parquet_readers: %d", f.ParquetReaders)
+	}
+	if f.Jobs < 0 {
+		return fmt.Errorf("invalid value for jobs: %d", f.Jobs)
+	}
+	if f.PartsJobs < 0 {
+		return fmt.Errorf("invalid value for parts_jobs: %d", f.PartsJobs)
+	}
+	if f.EstimateTables < 0 {
+		return fmt.Errorf("invalid value for estimate_tables: %d", f.EstimateTables)
+	}
+	if f.EstimateSampleRows < 0 {
+		return fmt.Errorf("invalid value for estimate_sample_rows: %d", f.EstimateSampleRows)
+	}
+	if f.MaxOpenParquetFiles < 0 {
+		return fmt.Errorf("invalid value for max_open_parquet_files: %d", f.MaxOpenParquetFiles)
+	}
+	if f.MaxRowsPerSec < 0 {
+		return fmt.Errorf("invalid value for max_rows_per_sec: %d", f.MaxRowsPerSec)
+	}
+	if f.MaxWriteMBps < 0 {
+		return fmt.Errorf("invalid value for max_write_mbps: %g", f.MaxWriteMBps)
+	}
+	if f.S3MaxRetries < 0 {
+		return fmt.Errorf("invalid value for s3_max_retries: %d", f.S3MaxRetries)
+	}
+	if f.DBConnectRetries < 0 {
+		return fmt.Errorf("invalid value for db_connect_retries: %d", f.DBConnectRetries)
+	}
+	if f.DBConnectTimeout < 0 {
+		return fmt.Errorf("invalid value for db_connect_timeout: %v", f.DBConnectTimeout)
+	}
+	if f.DBMaxAttempts < 0 {
+		return fmt.Errorf("invalid value for db_max_attempts: %d", f.DBMaxAttempts)
+	}
+	if f.NotifyTimeout < 0 {
+		return fmt.Errorf("invalid value for notify_timeout: %v", f.NotifyTimeout)
+	}
+	if f.DBMaxConns < 0 {
+		return fmt.Errorf("invalid value for db_max_conns: %d", f.DBMaxConns)
+	}
+	if f.MaxRunAttempts < 0 {
+		return fmt.Errorf("invalid value for max_run_attempts: %d", f.MaxRunAttempts)
+	}
+	for originalType, handler := range f.TypeOverrides {
+		if !slices.Contains(TypeHandlers, handler) {
+			return fmt.Errorf("unknown type handler '%s' for the type '%s' in type_overrides, expected one of %s",
+				handler, originalType, strings.Join(TypeHandlers, ", "))
+		}
+	}
+	if err := f.CopyCSV.Validate(); err != nil {
+		return fmt.Errorf("invalid value for copy_csv: %w", err)
+	}
+	for table, options := range f.CopyCSVTables {
+		if err := options.Validate(); err != nil {
+			return fmt.Errorf("invalid value for the table '%s' in copy_csv_tables: %w", table, err)
+		}
+	}
+	var minFreeSpace int64
+	if f.MinFreeSpace != "" {
+		size, err := utils.ParseByteSize(f.MinFreeSpace)
+		if err != nil {
+			return fmt.Errorf("invalid value for min_free_space: %w", err)
+		}
+		minFreeSpace = int64(size)
+	}
+
+	c.override(&Config{
+		SourceDatabase:             f.SourceDatabase,
+		LocalDir:                   f.LocalDir,
+		AWSBucketPath:              f.AWSBucketPath,
+		GCSBucketPath:              f.GCSBucketPath,
+		ArchivePath:                f.ArchivePath,
+		IncludeDatabases:           listToSet(f.IncludeDatabases),
+		ExcludeDatabases:           listToSet(f.ExcludeDatabases),
+		IncludeTables:              listToSet(f.IncludeTables),
+		ExcludeTables:              listToSet(f.ExcludeTables),
+		IncludeTablesRegex:         f.IncludeTablesRegex,
+		ExcludeTablesRegex:         f.ExcludeTablesRegex,
+		TablesOnly:                 listToSet(f.TablesOnly),
+		IgnoreMissingTablePrefixes: listToSet(f.IgnoreMissingTablePrefixes),
+		SystemSchemas:              listToSet(f.SystemSchemas),
+		SkipNotEmpty:               f.SkipNotEmpty,
+		KeepIndexes:                f.KeepIndexes,
+		ConcurrentIndexes:          f.ConcurrentIndexes,
+		OnConflictSkip:             f.OnConflictSkip,
+		ResilientLoad:              f.ResilientLoad,
+		CheckDuplicateKeys:         f.CheckDuplicateKeys,
+		RawStrings:                 f.RawStrings,
+		RawStringsTables:           listToSet(f.RawStringsTables),
+		CopyCSV:                    f.CopyCSV,
+		CopyCSVTables:              f.CopyCSVTables,
+		Analyze:                    f.Analyze,
+		UnknownTypeFallback:        f.UnknownTypeFallback,
+		CopyCountMismatch:          f.CopyCountMismatch,
+		TableTimeout:               f.TableTimeout,
+		TableTimeoutAction:         f.TableTimeoutAction,
+		RunTimeout:                 f.RunTimeout,
+		ContinueOnError:            f.ContinueOnError,
+		SkipDependents:             f.SkipDependents,
+		ParquetBatchSize:           f.ParquetBatchSize,
+		ParquetReaders:             f.ParquetReaders,
+		Jobs:                       f.Jobs,
+		PartsJobs:                  f.PartsJobs,
+		EstimateTables:             f.EstimateTables,
+		EstimateSampleRows:         f.EstimateSampleRows,
+		MaxOpenParquetFiles:        f.MaxOpenParquetFiles,
+		MaxRowsPerSec:              f.MaxRowsPerSec,
+		MaxWriteMBps:               f.MaxWriteMBps,
+		TypeOverrides:              f.TypeOverrides,
+		ManifestOutFile:            f.ManifestOutFile,
+		ReportFile:                 f.ReportFile,
+		NotifyWebhook:              f.NotifyWebhook,
+		NotifyTimeout:              f.NotifyTimeout,
+		ReceiptFile:                f.ReceiptFile,
+		GraphFile:                  f.GraphFile,
+		PendingIndexesFile:         f.PendingIndexesFile,
+		CheckpointFile:             f.CheckpointFile,
+		NoTracking:                 f.NoTracking,
+		Resume:                     f.Resume,
+		VerifyChecksums:            f.VerifyChecksums,
+		SuccessMarkers:             listToSet(f.SuccessMarkers),
+		NoSuccessMarker:            f.NoSuccessMarker,
+		WorkDir:                    f.WorkDir,
+		AWSAccessKey:               f.AWSAccessKey,
+		AWSSecretKey:               f.AWSSecretKey,
+		AWSRegion:                  f.AWSRegion,
+		S3Download:                 f.S3Download,
+		S3MaxRetries:               f.S3MaxRetries,
+		TempDir:                    f.TempDir,
+		MinFreeSpace:               minFreeSpace,
+		DBURL:                      f.DBURL,
+		DBHost:                     f.DBHost,
+		DBPort:                     f.DBPort,
+		DBName:                     f.DBName,
+		DBUser:                     f.DBUser,
+		DBPassword:                 f.DBPassword,
+		DBPasswordFile:             f.DBPasswordFile,
+		DBSecretARN:                f.DBSecretARN,
+		DBSSLModeName:              f.DBSSLMode,
+		DBSSLRootCert:              f.DBSSLRootCert,
+		DBSSLCert:                  f.DBSSLCert,
+		DBSSLKey:                   f.DBSSLKey,
+		DBIAMAuth:                  f.DBIAMAuth,
+		PgBouncerCompat:            f.PgBouncerCompat,
+		DBConnectRetries:           f.DBConnectRetries,
+		DBConnectTimeout:           f.DBConnectTimeout,
+		DBMaxAttempts:              f.DBMaxAttempts,
+		DBMaxConns:                 f.DBMaxConns,
+		MaxRunAttempts:             f.MaxRunAttempts,
+		RunRetryDelay:              f.RunRetryDelay,
+	})
+	return nil
+}
+
+// listToSet converts a YAML list into a set of trimmed strings; it returns nil for an empty list
+// so that it does not override other configuration sources.
+func listToSet(list []string) map[string]struct{} {
+	if len(list) == 0 {
+		return nil
+	}
+	ret := make(map[string]struct{}, len(list))
+	for _, item := range list {
+		ret[strings.TrimSpace(item)] = struct{}{}
+	}
+	return ret
+}
+
+// readConfigFile reads and applies the YAML configuration file; a missing file is an error only when required.
+func (c *Config) readConfigFile(fileName string, required bool) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		if !required && os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read the config file %s: %w", fileName, err)
+	}
+	parsed, unknown, err := parseConfigFile(data)
+	if err != nil {
+		return fmt.Errorf("malformed config file %s: %w", fileName, err)
+	}
+	if len(unknown) > 0 {
+		utils.Logger.Warn(fmt.Sprintf("Unknown keys in the config file %s are ignored: %s",
+			fileName, strings.Join(unknown, ", ")))
+	}
+	if err := parsed.apply(c); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", fileName, err)
+	}
+	return nil
+}
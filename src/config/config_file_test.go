@@ -0,0 +1,146 @@
+package config
+
+import (
+	"dbrestore/utils"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	tests := []struct {
+		name            string
+		content         string
+		expectedUnknown []string
+		expectedError   string
+	}{
+		{name: "empty", content: ""},
+		{name: "known keys", content: "db_host: db.example.com\ninclude_tables:\n  - public.users\n"},
+		{
+			name:            "unknown keys",
+			content:         "db_host: db.example.com\ndb_hots: typo\nverbose: true\n",
+			expectedUnknown: []string{"db_hots", "verbose"},
+		},
+		{name: "malformed", content: "db_host: a\n  db_port: [1\n", expectedError: "line 2"},
+		{name: "wrong type", content: "db_host: a\n\ndb_port: abc\n", expectedError: "line 3"},
+		{name: "not a mapping", content: "- a\n- b\n", expectedError: "line 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, unknown, err := parseConfigFile([]byte(tt.content))
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Fatalf("parseConfigFile() error = %v; want an error containing '%s'", err, tt.expectedError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseConfigFile() error: %v", err)
+			}
+			if !reflect.DeepEqual(unknown, tt.expectedUnknown) {
+				t.Errorf("parseConfigFile() unknown keys = %v; want %v", unknown, tt.expectedUnknown)
+			}
+		})
+	}
+}
+
+func TestConfigFilePrecedence(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "dbrestore.yaml")
+	content := `
+db_host: file-host
+db_port: 6432
+aws_region: eu-west-1
+include_tables:
+  - public.users
+  - orders
+type_overrides:
+  citext: string
+run_retry_delay: 5s
+min_free_space: 2GB
+system_schemas:
+  - cron
+`
+	if err := os.WriteFile(fileName, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write the config file: %v", err)
+	}
+
+	c := &Config{}
+	c.loadDefaults()
+	if _, found := c.SystemSchemas["topology"]; !found {
+		t.Errorf("SystemSchemas = %v; want the default schemas", c.SystemSchemas)
+	}
+	c.AWSRegion = "us-east-1" // from the environment
+	if err := c.readConfigFile(fileName, true); err != nil {
+		t.Fatalf("readConfigFile() error: %v", err)
+	}
+	c.override(&Config{DBPort: 7432}) // from the command line
+
+	if c.DBHost != "file-host" || c.DBPort != 7432 || c.AWSRegion != "eu-west-1" {
+		t.Errorf("DBHost, DBPort, AWSRegion = %s, %d, %s; want file-host, 7432, eu-west-1",
+			c.DBHost, c.DBPort, c.AWSRegion)
+	}
+	expectedTables := map[string]struct{}{"public.users": {}, "orders": {}}
+	if !reflect.DeepEqual(c.IncludeTables, expectedTables) {
+		t.Errorf("IncludeTables = %v; want %v", c.IncludeTables, expectedTables)
+	}
+	if !reflect.DeepEqual(c.SystemSchemas, map[string]struct{}{"cron": {}}) {
+		t.Errorf("SystemSchemas = %v; want the schemas of the file replacing the default", c.SystemSchemas)
+	}
+	if c.TypeOverrides["citext"] != "string" || c.RunRetryDelay != 5*time.Second || c.MinFreeSpace != 2<<30 {
+		t.Errorf("TypeOverrides, RunRetryDelay, MinFreeSpace = %v, %v, %d", c.TypeOverrides, c.RunRetryDelay,
+			c.MinFreeSpace)
+	}
+	if c.CopyCountMismatch != CopyCountMismatchError || c.ParquetBatchSize != defaultParquetBatchSize {
+		t.Errorf("The defaults were lost: CopyCountMismatch = %s, ParquetBatchSize = %d",
+			c.CopyCountMismatch, c.ParquetBatchSize)
+	}
+}
+
+func TestConfigFileCopyCSV(t *testing.T) {
+	content := `
+copy_csv:
+  delimiter: "\t"
+  null_string: \N
+copy_csv_tables:
+  public.notes:
+    delimiter: "|"
+    header: true
+`
+	parsed, unknown, err := parseConfigFile([]byte(content))
+	if err != nil || len(unknown) > 0 {
+		t.Fatalf("parseConfigFile() unknown keys = %v, error = %v", unknown, err)
+	}
+	c := &Config{}
+	if err := parsed.apply(c); err != nil {
+		t.Fatalf("apply() error: %v", err)
+	}
+	if expected := (utils.CopyCSVOptions{Delimiter: "\t", Null: `\N`}); c.CopyCSV != expected {
+		t.Errorf("CopyCSV = %+v; want %+v", c.CopyCSV, expected)
+	}
+	if expected := (utils.CopyCSVOptions{Delimiter: "|", Header: true}); c.CopyCSVFor("notes") != expected {
+		t.Errorf("CopyCSVFor(notes) = %+v; want %+v", c.CopyCSVFor("notes"), expected)
+	}
+
+	parsed, _, err = parseConfigFile([]byte("copy_csv_tables:\n  notes:\n    delimiter: '\"'\n"))
+	if err != nil {
+		t.Fatalf("parseConfigFile() error: %v", err)
+	}
+	if err := parsed.apply(&Config{}); err == nil || !strings.Contains(err.Error(), "copy_csv_tables") {
+		t.Errorf("apply() of the delimiter equal to the quote = %v; want an error", err)
+	}
+}
+
+func TestConfigFileMissing(t *testing.T) {
+	c := &Config{}
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	if err := c.readConfigFile(missing, false); err != nil {
+		t.Errorf("readConfigFile() of an optional missing file error: %v", err)
+	}
+	if err := c.readConfigFile(missing, true); err == nil {
+		t.Errorf("readConfigFile() of a required missing file did not fail")
+	}
+}
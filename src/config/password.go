@@ -0,0 +1,152 @@
+package config
+
+import (
+	"bufio"
+	"dbrestore/utils"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// resolvePassword takes the database password from the first available source when it is not configured directly
+// (with --db-password, its environment variable or the configuration file): DBPasswordFile, then the standard
+// PGPASSWORD environment variable, then the password file of PostgreSQL (PGPASSFILE or ~/.pgpass).
+func (c *Config) resolvePassword() error {
+	if c.DBURL != "" {
+		// the connection string is used verbatim, and pgx reads PGPASSWORD and .pgpass itself
+		return nil
+	}
+	if c.DBIAMAuth {
+		// the password is an authentication token generated for every connection
+		return nil
+	}
+	if c.DBPassword != "" {
+		if c.DBPasswordFile != "" {
+			utils.Logger.Warn(fmt.Sprintf("Both --db-password and --db-password-file are specified, "+
+				"ignoring the file '%s'", c.DBPasswordFile))
+		}
+		return nil
+	}
+	if c.DBPasswordFile != "" {
+		password, err := readPasswordFile(c.DBPasswordFile)
+		if err != nil {
+			return err
+		}
+		c.DBPassword = password
+		return nil
+	}
+	if password := os.Getenv("PGPASSWORD"); password != "" {
+		c.DBPassword = password
+		return nil
+	}
+	fileName := pgpassFileName()
+	if fileName == "" {
+		return nil
+	}
+	password, err := lookupPgpass(fileName, c.DBHost, c.DBPort, c.DBName, c.DBUser)
+	if err != nil {
+		return err
+	}
+	c.DBPassword = password
+	return nil
+}
+
+// readPasswordFile reads the password from a file (for example a mounted Kubernetes secret),
+// trimming the surrounding whitespace and the trailing newline.
+func readPasswordFile(fileName string) (string, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the password file: %w", err)
+	}
+	password := strings.TrimSpace(string(content))
+	if password == "" {
+		return "", fmt.Errorf("the password file '%s' is empty", fileName)
+	}
+	return password, nil
+}
+
+// pgpassFileName returns the path of the PostgreSQL password file: PGPASSFILE if it is set, otherwise ~/.pgpass.
+// It returns an empty string if the home directory is unknown.
+func pgpassFileName() string {
+	if fileName := os.Getenv("PGPASSFILE"); fileName != "" {
+		return fileName
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".pgpass")
+}
+
+// lookupPgpass finds the password in a PostgreSQL password file with lines in the form
+// hostname:port:database:username:password, where the first four fields can be '*' to match anything,
+// and ':' and '\' in the fields are escaped with '\'. The first matching line wins.
+// A missing file or a file accessible by the group or others (like libpq does) yields an empty password.
+func lookupPgpass(fileName string, host string, port int, database string, user string) (string, error) {
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to open the password file: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	if info, err := file.Stat(); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		utils.Logger.Warn(fmt.Sprintf("The password file '%s' has group or world access and is ignored; "+
+			"permissions should be u=rw (0600) or less", fileName))
+		return "", nil
+	}
+
+	keys := []string{host, strconv.Itoa(port), database, user}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitPgpassLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+		matches := true
+		for i, key := range keys {
+			if fields[i] != "*" && fields[i] != key {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return fields[4], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read the password file '%s': %w", fileName, err)
+	}
+	return "", nil
+}
+
+// splitPgpassLine splits a line of the PostgreSQL password file into its fields, removing the escapes.
+func splitPgpassLine(line string) (fields []string) {
+	var field strings.Builder
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			field.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ':' && len(fields) < 4:
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteRune(r)
+		}
+	}
+	return append(fields, field.String())
+}
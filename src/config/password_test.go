@@ -0,0 +1,101 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupPgpass(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), ".pgpass")
+	content := `# hostname:port:database:username:password
+db.example.com:5432:orders:alice:alice-orders
+db.example.com:5432:*:alice:alice-any
+*:*:*:bob:bob\:with\\escapes
+malformed line
+`
+	if err := os.WriteFile(fileName, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write the password file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		host     string
+		port     int
+		database string
+		user     string
+		expected string
+	}{
+		{name: "exact match", host: "db.example.com", port: 5432, database: "orders", user: "alice",
+			expected: "alice-orders"},
+		{name: "wildcard database", host: "db.example.com", port: 5432, database: "users", user: "alice",
+			expected: "alice-any"},
+		{name: "escapes", host: "localhost", port: 6432, database: "orders", user: "bob",
+			expected: `bob:with\escapes`},
+		{name: "other port", host: "db.example.com", port: 6432, database: "orders", user: "alice"},
+		{name: "unknown user", host: "db.example.com", port: 5432, database: "orders", user: "carol"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			password, err := lookupPgpass(fileName, tt.host, tt.port, tt.database, tt.user)
+			if err != nil {
+				t.Fatalf("lookupPgpass() error: %v", err)
+			}
+			if password != tt.expected {
+				t.Errorf("lookupPgpass() = '%s'; want '%s'", password, tt.expected)
+			}
+		})
+	}
+
+	if err := os.Chmod(fileName, 0o644); err != nil {
+		t.Fatalf("Failed to change the permissions: %v", err)
+	}
+	if password, _ := lookupPgpass(fileName, "db.example.com", 5432, "orders", "alice"); password != "" {
+		t.Errorf("lookupPgpass() of a world-readable file = '%s'; want an empty password", password)
+	}
+}
+
+func TestResolvePasswordPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	if err := os.WriteFile(passwordFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write the password file: %v", err)
+	}
+	pgpassFile := filepath.Join(dir, ".pgpass")
+	if err := os.WriteFile(pgpassFile, []byte("*:*:*:*:from-pgpass\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write the password file: %v", err)
+	}
+	t.Setenv("PGPASSFILE", pgpassFile)
+
+	tests := []struct {
+		name       string
+		password   string
+		file       string
+		pgPassword string
+		expected   string
+	}{
+		{name: "flag", password: "from-flag", file: passwordFile, pgPassword: "from-env", expected: "from-flag"},
+		{name: "password file", file: passwordFile, pgPassword: "from-env", expected: "from-file"},
+		{name: "PGPASSWORD", pgPassword: "from-env", expected: "from-env"},
+		{name: "pgpass", expected: "from-pgpass"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PGPASSWORD", tt.pgPassword)
+			c := &Config{DBHost: defaultDBHost, DBPort: defaultDBPort, DBPassword: tt.password, DBPasswordFile: tt.file}
+			if err := c.resolvePassword(); err != nil {
+				t.Fatalf("resolvePassword() error: %v", err)
+			}
+			if c.DBPassword != tt.expected {
+				t.Errorf("DBPassword = '%s'; want '%s'", c.DBPassword, tt.expected)
+			}
+		})
+	}
+
+	c := &Config{DBPasswordFile: filepath.Join(dir, "missing")}
+	if err := c.resolvePassword(); err == nil {
+		t.Errorf("resolvePassword() with a missing password file did not fail")
+	}
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"strconv"
+)
+
+// secretsAPI is the part of the Secrets Manager client used by the program (replaced by a fake in the tests).
+type secretsAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput,
+		optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// rdsSecret is the standard JSON format of the RDS database secrets in Secrets Manager.
+type rdsSecret struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Host     string `json:"host"`
+	// Port the port number, stored either as a number or as a string
+	Port any `json:"port"`
+}
+
+// AWS returns the AWS configuration of the program: the loaded configuration (see AWSConfig)
+// with the region and the static credentials of the program options.
+func (c *Config) AWS() aws.Config {
+	var ret aws.Config
+	if c.AWSConfig != nil {
+		ret = c.AWSConfig.Copy()
+	}
+	if c.AWSRegion != "" {
+		ret.Region = c.AWSRegion
+	}
+	if c.AWSAccessKey != "" && c.AWSSecretKey != "" {
+		ret.Credentials = credentials.NewStaticCredentialsProvider(c.AWSAccessKey, c.AWSSecretKey, "")
+	}
+	return ret
+}
+
+// loadSecret fills the connection options from the secret DBSecretARN in Secrets Manager, except the options
+// specified on the command line (in explicit). It runs at startup, so that a missing permission or a malformed
+// secret is reported before reading the export.
+func (c *Config) loadSecret(explicit *Config) error {
+	if c.DBSecretARN == "" {
+		return nil
+	}
+	if c.DBURL != "" {
+		return fmt.Errorf("--db-secret-arn cannot be combined with --db-url")
+	}
+	secretARN, err := arn.Parse(c.DBSecretARN)
+	if err != nil {
+		return fmt.Errorf("invalid --db-secret-arn: %w", err)
+	}
+	client := secretsmanager.NewFromConfig(c.AWS(), func(o *secretsmanager.Options) {
+		// the secret may be kept in another region than the export
+		o.Region = secretARN.Region
+	})
+	return c.applySecret(context.TODO(), client, explicit)
+}
+
+// applySecret fetches the secret DBSecretARN and fills DBUser, DBPassword, DBHost and DBPort from it,
+// unless they are set in explicit.
+func (c *Config) applySecret(ctx context.Context, client secretsAPI, explicit *Config) error {
+	output, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(c.DBSecretARN)})
+	if err != nil {
+		return fmt.Errorf("failed to fetch the database secret '%s': %w", c.DBSecretARN, err)
+	}
+	if output.SecretString == nil {
+		return fmt.Errorf("the database secret '%s' has no string value", c.DBSecretARN)
+	}
+	var secret rdsSecret
+	if err := json.Unmarshal([]byte(*output.SecretString), &secret); err != nil {
+		// the content is not included in the error - it is a secret
+		return fmt.Errorf("malformed database secret '%s': expected the JSON of an RDS secret", c.DBSecretARN)
+	}
+	if secret.Username == "" || secret.Password == "" {
+		return fmt.Errorf("malformed database secret '%s': username and password are required", c.DBSecretARN)
+	}
+	port := 0
+	switch value := secret.Port.(type) {
+	case nil:
+	case float64:
+		port = int(value)
+	case string:
+		if port, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("malformed database secret '%s': invalid port", c.DBSecretARN)
+		}
+	default:
+		return fmt.Errorf("malformed database secret '%s': invalid port", c.DBSecretARN)
+	}
+
+	if explicit.DBUser == "" {
+		c.DBUser = secret.Username
+	}
+	if explicit.DBPassword == "" && explicit.DBPasswordFile == "" {
+		c.DBPassword = secret.Password
+		c.DBPasswordFile = ""
+	}
+	if explicit.DBHost == "" && secret.Host != "" {
+		c.DBHost = secret.Host
+	}
+	if explicit.DBPort == 0 && port != 0 {
+		c.DBPort = port
+	}
+	return nil
+}
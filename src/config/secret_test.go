@@ -0,0 +1,86 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"testing"
+)
+
+// fakeSecrets is an in-memory replacement of the Secrets Manager client.
+type fakeSecrets struct {
+	// value the string value of the secret; nil means a binary secret
+	value *string
+	// err the error returned instead of the secret
+	err error
+}
+
+func (f *fakeSecrets) GetSecretValue(_ context.Context, _ *secretsmanager.GetSecretValueInput,
+	_ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: f.value}, nil
+}
+
+func TestApplySecret(t *testing.T) {
+	const secret = `{"username": "restore", "password": "p@ss", "engine": "postgres",
+		"host": "db.example.com", "port": 6543}`
+	tests := []struct {
+		name          string
+		client        *fakeSecrets
+		explicit      Config
+		expected      Config
+		expectedError bool
+	}{
+		{name: "all values", client: &fakeSecrets{value: aws.String(secret)},
+			expected: Config{DBUser: "restore", DBPassword: "p@ss", DBHost: "db.example.com", DBPort: 6543}},
+		{name: "command line overrides", client: &fakeSecrets{value: aws.String(secret)},
+			explicit: Config{DBUser: "admin", DBHost: "localhost", DBPort: 5433},
+			expected: Config{DBUser: "admin", DBPassword: "p@ss", DBHost: "localhost", DBPort: 5433}},
+		{name: "port as a string without host",
+			client:   &fakeSecrets{value: aws.String(`{"username": "restore", "password": "p@ss", "port": "6543"}`)},
+			expected: Config{DBUser: "restore", DBPassword: "p@ss", DBHost: defaultDBHost, DBPort: 6543}},
+		{name: "access denied", client: &fakeSecrets{err: errors.New("AccessDeniedException")}, expectedError: true},
+		{name: "binary secret", client: &fakeSecrets{}, expectedError: true},
+		{name: "not JSON", client: &fakeSecrets{value: aws.String("p@ss")}, expectedError: true},
+		{name: "without password", client: &fakeSecrets{value: aws.String(`{"username": "restore"}`)},
+			expectedError: true},
+		{name: "invalid port",
+			client:        &fakeSecrets{value: aws.String(`{"username": "restore", "password": "p@ss", "port": "x"}`)},
+			expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{DBSecretARN: "arn:aws:secretsmanager:eu-west-1:123456789012:secret:db-AbCdEf",
+				DBHost: defaultDBHost, DBPort: defaultDBPort}
+			c.override(&tt.explicit) // as in GetConfig
+			err := c.applySecret(context.Background(), tt.client, &tt.explicit)
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("applySecret() error = %v; want an error: %v", err, tt.expectedError)
+			}
+			if err != nil {
+				return
+			}
+			if c.DBUser != tt.expected.DBUser || c.DBPassword != tt.expected.DBPassword ||
+				c.DBHost != tt.expected.DBHost || c.DBPort != tt.expected.DBPort {
+				t.Errorf("applySecret() = %s:%s@%s:%d; want %s:%s@%s:%d", c.DBUser, c.DBPassword, c.DBHost, c.DBPort,
+					tt.expected.DBUser, tt.expected.DBPassword, tt.expected.DBHost, tt.expected.DBPort)
+			}
+		})
+	}
+}
+
+func TestLoadSecretValidation(t *testing.T) {
+	c := Config{DBSecretARN: "db-credentials"}
+	if err := c.loadSecret(&Config{}); err == nil {
+		t.Errorf("loadSecret() with a secret name instead of the ARN did not fail")
+	}
+	c = Config{DBSecretARN: "arn:aws:secretsmanager:eu-west-1:123456789012:secret:db-AbCdEf",
+		DBURL: "postgres://localhost/db"}
+	if err := c.loadSecret(&Config{}); err == nil {
+		t.Errorf("loadSecret() with --db-url did not fail")
+	}
+}
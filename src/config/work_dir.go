@@ -0,0 +1,51 @@
+package config
+
+import (
+	"dbrestore/utils"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writesFiles checks whether any of the enabled features writes files (see WorkDir).
+func (c *Config) writesFiles() bool {
+	return c.ManifestOutFile != "" || c.DiffOutFile != "" || c.ReportFile != "" ||
+		c.ReceiptFile != "" || c.GraphFile != "" || c.PendingIndexesFile != "" || c.CheckpointFile != "" ||
+		(c.GenerateDDLCommand && c.DDLFile != "")
+}
+
+// WorkPath resolves the path of a file written by the program: absolute paths are kept as they are,
+// and relative paths are resolved against WorkDir (the current directory if it is empty).
+func (c *Config) WorkPath(name string) string {
+	if name == "" || filepath.IsAbs(name) || c.WorkDir == "" {
+		return name
+	}
+	return filepath.Join(c.WorkDir, name)
+}
+
+// prepareWorkDir creates WorkDir if it is missing, verifies that it is writable and resolves the paths
+// of all files written by the program against it. It does nothing if no enabled feature writes files,
+// so the program can still run from a read-only directory.
+func (c *Config) prepareWorkDir() error {
+	if !c.writesFiles() {
+		return nil
+	}
+	dir := c.WorkDir
+	if dir == "" {
+		dir = "."
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create the work directory '%s': %w", dir, err)
+	}
+	if err := utils.CheckWritableDir(dir); err != nil {
+		return err
+	}
+	c.ManifestOutFile = c.WorkPath(c.ManifestOutFile)
+	c.DiffOutFile = c.WorkPath(c.DiffOutFile)
+	c.ReportFile = c.WorkPath(c.ReportFile)
+	c.ReceiptFile = c.WorkPath(c.ReceiptFile)
+	c.GraphFile = c.WorkPath(c.GraphFile)
+	c.PendingIndexesFile = c.WorkPath(c.PendingIndexesFile)
+	c.CheckpointFile = c.WorkPath(c.CheckpointFile)
+	c.DDLFile = c.WorkPath(c.DDLFile)
+	return nil
+}
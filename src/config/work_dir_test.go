@@ -0,0 +1,53 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrepareWorkDir(t *testing.T) {
+	tmp := t.TempDir()
+	blocker := filepath.Join(tmp, "file")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatalf("Failed to create the fixture: %v", err)
+	}
+	absolute := filepath.Join(tmp, "abs.json")
+
+	tests := []struct {
+		name             string
+		workDir          string
+		manifestOutFile  string
+		expectedManifest string
+		expectedError    bool
+	}{
+		{name: "default", manifestOutFile: "manifest.json", expectedManifest: "manifest.json"},
+		{
+			name:             "explicit and created",
+			workDir:          filepath.Join(tmp, "work", "nested"),
+			manifestOutFile:  "manifest.json",
+			expectedManifest: filepath.Join(tmp, "work", "nested", "manifest.json"),
+		},
+		{
+			name:             "absolute file name",
+			workDir:          filepath.Join(tmp, "work"),
+			manifestOutFile:  absolute,
+			expectedManifest: absolute,
+		},
+		{name: "unwritable", workDir: filepath.Join(blocker, "work"), manifestOutFile: "m.json", expectedError: true},
+		{name: "nothing written", workDir: filepath.Join(blocker, "work")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{WorkDir: tt.workDir, ManifestOutFile: tt.manifestOutFile}
+			err := c.prepareWorkDir()
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("prepareWorkDir() error = %v; want error %v", err, tt.expectedError)
+			}
+			if err == nil && c.ManifestOutFile != tt.expectedManifest {
+				t.Errorf("ManifestOutFile = %s; want %s", c.ManifestOutFile, tt.expectedManifest)
+			}
+		})
+	}
+}
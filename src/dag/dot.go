@@ -0,0 +1,57 @@
+package dag
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// WriteDOT writes the Graph in the Graphviz DOT format: an edge goes from every node to each of its Children
+// and is labeled by the relation when T implements fmt.Stringer (for example, the name of the foreign key).
+// The self-referencing edges are drawn dashed, so that they stand out from the edges between different Nodes.
+// Nodes and edges are written in alphabetical order, so that the output does not change between runs.
+func (g *FKeysGraph[T]) WriteDOT(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph fkeys {\n\trankdir=LR;\n\tnode [shape=box];"); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(g.Graph))
+	for name := range g.Graph {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		node := g.GetNode(name)
+		if _, err := fmt.Fprintf(w, "\t%s;\n", strconv.Quote(name)); err != nil {
+			return err
+		}
+		children := make([]string, 0, len(node.Children))
+		for child := range node.Children {
+			children = append(children, child)
+		}
+		slices.Sort(children)
+		for _, child := range children {
+			for _, relation := range node.Children[child] {
+				if _, err := fmt.Fprintf(w, "\t%s -> %s [%s];\n", strconv.Quote(name), strconv.Quote(child),
+					dotEdgeAttributes(relation, name == child)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// dotEdgeAttributes returns the DOT attributes of an edge: the label of the relation and the style of a self-cycle.
+func dotEdgeAttributes[T any](relation T, selfCycle bool) string {
+	label := ""
+	if stringer, ok := any(relation).(fmt.Stringer); ok {
+		label = stringer.String()
+	}
+	ret := "label=" + strconv.Quote(label)
+	if selfCycle {
+		ret += ", style=dashed, color=red"
+	}
+	return ret
+}
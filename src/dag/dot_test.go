@@ -0,0 +1,53 @@
+package dag
+
+import (
+	"strings"
+	"testing"
+)
+
+// testRelation is a relation with a name, used as the label of the DOT edges
+type testRelation string
+
+func (r testRelation) String() string {
+	return string(r)
+}
+
+func TestWriteDOT(t *testing.T) {
+	graph := NewFKeysGraph[testRelation](10)
+	orders, _ := graph.AddNode("public.orders")
+	orders.AddChild("public.customers", "orders_customer_fk")
+	orders.AddChild("public.orders", "orders_parent_fk")
+	items, _ := graph.AddNode("public.items")
+	items.AddChild("public.orders", "items_order_fk")
+	items.AddChild("public.orders", "items_\"quoted\"_fk")
+
+	var out strings.Builder
+	if err := graph.WriteDOT(&out); err != nil {
+		t.Fatalf("WriteDOT() failed: %v", err)
+	}
+	expected := `digraph fkeys {
+	rankdir=LR;
+	node [shape=box];
+	"public.items";
+	"public.items" -> "public.orders" [label="items_order_fk"];
+	"public.items" -> "public.orders" [label="items_\"quoted\"_fk"];
+	"public.orders";
+	"public.orders" -> "public.customers" [label="orders_customer_fk"];
+	"public.orders" -> "public.orders" [label="orders_parent_fk", style=dashed, color=red];
+}
+`
+	if out.String() != expected {
+		t.Errorf("WriteDOT() = \n%s\nexpected\n%s", out.String(), expected)
+	}
+
+	t.Run("Relations without names", func(t *testing.T) {
+		graph := *newGraph(TestMap{"A": {"B"}})
+		var out strings.Builder
+		if err := graph.WriteDOT(&out); err != nil {
+			t.Fatalf("WriteDOT() failed: %v", err)
+		}
+		if !strings.Contains(out.String(), "\t\"A\" -> \"B\" [label=\"\"];\n") {
+			t.Errorf("WriteDOT() = \n%s\nexpected an edge without a label", out.String())
+		}
+	})
+}
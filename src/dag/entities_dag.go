@@ -4,6 +4,7 @@ import (
 	"dbrestore/utils"
 	"fmt"
 	"go.uber.org/zap"
+	"slices"
 	"sort"
 )
 
@@ -39,13 +40,9 @@ func NewDagNode[T any]() Node[T] {
 }
 
 // AddChild adds a child node to the current node with the specified Name and relation. Updates the Children map.
+// A child may be added several times with different relations (for example, two foreign keys to the same table).
 func (n *Node[T]) AddChild(name string, relation T) {
-	list, ok := n.Children[name]
-	if !ok {
-		list = make([]T, 1)
-		n.Children[name] = list
-	}
-	list = append(list, relation)
+	n.Children[name] = append(n.Children[name], relation)
 }
 
 // FKeysGraph the Graph of all tables and FK relations
@@ -112,6 +109,15 @@ func (g *FKeysGraph[T]) GetNode(name string) *Node[T] {
 	return &g.Nodes[index]
 }
 
+// GetOrAddNode retrieves the node with the specified Name from the Graph, adding it if it is missing.
+// The returned pointer is valid only until the next node is added.
+func (g *FKeysGraph[T]) GetOrAddNode(name string) (*Node[T], error) {
+	if node := g.GetNode(name); node != nil {
+		return node, nil
+	}
+	return g.AddNode(name)
+}
+
 // GetNodeChildren retrieves child Nodes of the node with the specified Name from the Graph.
 // Returns a pointer to the node array if found, otherwise nil.
 func (g *FKeysGraph[T]) GetNodeChildren(name string) *ChildrenMap[T] {
@@ -279,6 +285,91 @@ func (g *FKeysGraph[T]) dfs(index int, visited map[int]struct{}, recStack []int)
 	return ret
 }
 
+// FindCycles returns the cycles of the Graph as paths of node names that start and end with the same node,
+// for example ["a.b", "a.c", "a.b"]. Self-referencing cycles are permitted (see IsAcyclic) and are not returned.
+// Nodes and Children are visited in alphabetical order, so the same Graph always reports the same cycles;
+// every back edge of the depth-first search yields one cycle, which is enough to explain why IsAcyclic fails.
+func (g *FKeysGraph[T]) FindCycles() (ret [][]string) {
+	names := make([]string, 0, len(g.Graph))
+	for name := range g.Graph {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// finished the Nodes whose descendants have all been visited
+	finished := make(map[string]bool, len(names))
+	// path the Nodes of the current DFS path, with their positions in it
+	var path []string
+	onPath := make(map[string]int, len(names))
+	var visit func(name string)
+	visit = func(name string) {
+		onPath[name] = len(path)
+		path = append(path, name)
+		node := g.GetNode(name)
+		children := make([]string, 0, len(node.Children))
+		for child := range node.Children {
+			children = append(children, child)
+		}
+		sort.Strings(children)
+		for _, child := range children {
+			if child == name || finished[child] || g.GetNode(child) == nil {
+				continue
+			}
+			if position, found := onPath[child]; found {
+				cycle := append(slices.Clone(path[position:]), child)
+				ret = append(ret, cycle)
+				continue
+			}
+			visit(child)
+		}
+		path = path[:len(path)-1]
+		delete(onPath, name)
+		finished[name] = true
+	}
+	for _, name := range names {
+		if !finished[name] {
+			visit(name)
+		}
+	}
+	return ret
+}
+
+// Subgraph returns a new Graph with the Nodes of the given names and all Nodes reachable from them through
+// their Children, keeping the relations between them, and the names of its Nodes in alphabetical order.
+// Names and Children without Nodes in this Graph become Nodes without Children. The in-degrees of the new
+// Graph are calculated, so it can be sorted and checked for cycles like the original one.
+func (g *FKeysGraph[T]) Subgraph(names []string) (FKeysGraph[T], []string) {
+	ret := NewFKeysGraph[T](len(names) + 1)
+	queue := slices.Sorted(slices.Values(names))
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if ret.GetNode(name) != nil {
+			continue
+		}
+		node, _ := ret.AddNode(name) // cannot fail, the node is missing
+		original := g.GetNode(name)
+		if original == nil {
+			continue
+		}
+		children := make([]string, 0, len(original.Children))
+		for child := range original.Children {
+			children = append(children, child)
+		}
+		sort.Strings(children)
+		for _, child := range children {
+			node.Children[child] = slices.Clone(original.Children[child])
+			queue = append(queue, child)
+		}
+	}
+	ret.CalculateInDegree()
+	nodeNames := make([]string, 0, len(ret.Graph))
+	for name := range ret.Graph {
+		nodeNames = append(nodeNames, name)
+	}
+	sort.Strings(nodeNames)
+	return ret, nodeNames
+}
+
 // CalculateInDegree initialize in-degree values for all Nodes to detect root Nodes in the Graph
 func (g *FKeysGraph[T]) CalculateInDegree() {
 	for _, index := range g.Graph {
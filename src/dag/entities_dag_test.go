@@ -1,6 +1,7 @@
 package dag
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -40,6 +41,31 @@ func TestCount(t *testing.T) {
 	})
 }
 
+func TestAddChild(t *testing.T) {
+	node := NewDagNode[string]()
+	node.AddChild("B", "fk_1")
+	node.AddChild("B", "fk_2")
+	node.AddChild("C", "fk_3")
+	if result := node.Children["B"]; len(result) != 2 || result[0] != "fk_1" || result[1] != "fk_2" {
+		t.Errorf("Children[B] = %v; want [fk_1 fk_2]", result)
+	}
+	if result := node.Children["C"]; len(result) != 1 || result[0] != "fk_3" {
+		t.Errorf("Children[C] = %v; want [fk_3]", result)
+	}
+}
+
+func TestGetOrAddNode(t *testing.T) {
+	graph := *newGraph(TestMap{"A": {"B"}})
+	node, err := graph.GetOrAddNode("A")
+	if err != nil || node.Index != graph.Graph["A"] || len(node.Children) != 1 {
+		t.Errorf("GetOrAddNode(A) = %v, %v; want the existing node", node, err)
+	}
+	node, err = graph.GetOrAddNode("B")
+	if err != nil || node.Name != "B" || graph.GetNodeCount() != 2 {
+		t.Errorf("GetOrAddNode(B) = %v, %v; want a new node", node, err)
+	}
+}
+
 func TestAddNodeError(t *testing.T) {
 	t.Run("Test AddNode Error", func(t *testing.T) {
 		graph := *newGraph(TestMap{
@@ -121,6 +147,97 @@ func TestIsAcyclic(t *testing.T) {
 	}
 }
 
+func TestFindCycles(t *testing.T) {
+	tests := []struct {
+		name           string
+		graph          FKeysGraph[string]
+		expectedResult [][]string
+	}{
+		{
+			name:           "Graph with no cycles",
+			graph:          *newGraph(TestMap{"A": {"B"}, "B": {"C"}, "C": {}}),
+			expectedResult: nil,
+		},
+		{
+			name:           "Graph with a cycle",
+			graph:          *newGraph(TestMap{"A": {"B"}, "B": {"C"}, "C": {"A"}}),
+			expectedResult: [][]string{{"A", "B", "C", "A"}},
+		},
+		{
+			name:           "Cycle below a root",
+			graph:          *newGraph(TestMap{"A": {"B"}, "B": {"C"}, "C": {"B", "D"}}),
+			expectedResult: [][]string{{"B", "C", "B"}},
+		},
+		{
+			name:           "Two separate cycles",
+			graph:          *newGraph(TestMap{"A": {"B"}, "B": {"A"}, "C": {"D"}, "D": {"C"}}),
+			expectedResult: [][]string{{"A", "B", "A"}, {"C", "D", "C"}},
+		},
+		{
+			name:           "Self-referencing node is not a cycle",
+			graph:          *newGraph(TestMap{"A": {"A", "B"}, "B": {}}),
+			expectedResult: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.graph.FindCycles()
+			if !reflect.DeepEqual(result, tt.expectedResult) {
+				t.Errorf("FindCycles() = %v; want %v", result, tt.expectedResult)
+			}
+			if tt.graph.IsAcyclic() != (len(result) == 0) {
+				t.Errorf("IsAcyclic() disagrees with FindCycles() = %v", result)
+			}
+		})
+	}
+}
+
+func TestSubgraph(t *testing.T) {
+	graph := newGraph(TestMap{
+		"A": {"B"},
+		"B": {"C", "X"}, // X is a leaf without a node
+		"C": {"C"},
+		"D": {"C"},
+		"E": {"F"},
+		"F": {},
+	})
+	tests := []struct {
+		name          string
+		names         []string
+		expectedNames []string
+		expectedOrder []string
+	}{
+		{name: "Ancestors are included", names: []string{"A"},
+			expectedNames: []string{"A", "B", "C", "X"}, expectedOrder: []string{"C", "X", "B", "A"}},
+		{name: "Descendants are not included", names: []string{"B"},
+			expectedNames: []string{"B", "C", "X"}, expectedOrder: []string{"C", "X", "B"}},
+		{name: "Shared ancestors", names: []string{"D", "A"},
+			expectedNames: []string{"A", "B", "C", "D", "X"}, expectedOrder: []string{"C", "X", "B", "A", "D"}},
+		{name: "Table without relations", names: []string{"Z"},
+			expectedNames: []string{"Z"}, expectedOrder: []string{"Z"}},
+		{name: "Empty", names: nil, expectedNames: []string{}, expectedOrder: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subgraph, names := graph.Subgraph(tt.names)
+			if !reflect.DeepEqual(names, tt.expectedNames) {
+				t.Errorf("Subgraph() names = %v; want %v", names, tt.expectedNames)
+			}
+			if order := subgraph.TopologicalSort(); !reflect.DeepEqual(order, tt.expectedOrder) {
+				t.Errorf("TopologicalSort() = %v; want %v", order, tt.expectedOrder)
+			}
+			if !subgraph.IsAcyclic() {
+				t.Errorf("IsAcyclic() = false; want true")
+			}
+		})
+	}
+	if children := graph.GetNodeChildren("B"); len(*children) != 2 {
+		t.Errorf("the original Graph was modified: children of B = %v", *children)
+	}
+}
+
 func TestTopologicalSort(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	config2 "dbrestore/config"
+	source2 "dbrestore/source"
+	"dbrestore/target"
+	"dbrestore/utils"
+	"fmt"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// tablePlan is a table of the destination database in the restore plan printed by --dry-run.
+type tablePlan struct {
+	// table the table name including the schema name
+	table string
+	// inExport indicates that the export has data for the table
+	inExport bool
+	// files the number of Parquet part files of the table
+	files int
+	// bytes the total size of the Parquet part files
+	bytes int64
+	// rows the number of rows in the Parquet part files (from their footers)
+	rows int64
+	// skipReason why the table would be skipped, or empty if it would be loaded
+	skipReason string
+	// note the remark about a table that would be loaded, for example that it is not empty
+	note string
+}
+
+// planRestore implements --dry-run: it performs the read-only steps of the restore - ordering the tables
+// of the destination database, matching them with the export metadata, creating the field mappers
+// and listing the Parquet part files - and prints the plan without truncating, copying, or changing the indexes
+// or the triggers. Cyclic foreign keys and tables missing in the export fail the plan like the restore.
+func planRestore(ctx context.Context, conf *config2.Config, source source2.Source, reader *source2.Reader, writer *target.DbWriter,
+	progress *checkpoint) error {
+	tables, err := databaseTables(ctx, writer, reader)
+	if err != nil {
+		return err
+	}
+	parquetTables, err := reader.IterateOverTables(tables)
+	if err != nil {
+		return sourceError(utils.NewFatalError(err))
+	}
+	parquetTableMap := make(map[string]source2.ParquetFileInfo, len(parquetTables))
+	for _, table := range parquetTables {
+		parquetTableMap[table.TableName] = table
+	}
+
+	plans := make([]tablePlan, 0, len(tables))
+	for _, table := range tables {
+		plan := tablePlan{table: table}
+		parquetInfo, exists := parquetTableMap[table]
+		if !exists {
+			plans = append(plans, plan)
+			continue
+		}
+		plan.inExport = true
+		parts, err := target.ListTableParts(source, conf.SourceDatabase, table)
+		if err != nil {
+			return sourceError(utils.NewFatalError(
+				fmt.Errorf("failed to list the parts of the table '%s': %w", table, err)))
+		}
+		for _, part := range parts {
+			if part.RelativePath != "" {
+				plan.files++
+			}
+			plan.bytes += part.Size
+			plan.rows += part.Rows
+		}
+		mapper, err := writer.GetFieldMapper(parquetInfo, conf)
+		if err != nil {
+			return fmt.Errorf("error mapping fields for table '%s': %w", table, err)
+		}
+		reason, skip := mapper.ShouldSkip()
+		if skip {
+			plan.skipReason = reason
+			progress.report.setTable(tableReport{Table: table, Status: tableSkipped, Reason: reason})
+		} else {
+			// the result is cached, so it costs no query if GetFieldMapper checked the table already
+			notEmpty, err := writer.TableNotEmpty(table)
+			if err != nil {
+				return targetError(err)
+			}
+			switch {
+			case notEmpty && conf.TruncateAllCommand:
+				plan.note = "truncated first by --truncate-all"
+			case notEmpty:
+				plan.note = "the table is not empty"
+			}
+			progress.report.setTable(tableReport{Table: table, Status: tablePlanned, Rows: plan.rows})
+		}
+		plans = append(plans, plan)
+	}
+
+	fmt.Printf("Restore plan of the database '%s' (dry run, nothing is written):\n", conf.SourceDatabase)
+	fmt.Print(formatPlan(plans))
+	log.Info("Planned the restore", zap.Int("tables", len(plans)))
+	return nil
+}
+
+// formatPlan formats the restore plan: a line per table in the loading order and the totals.
+func formatPlan(plans []tablePlan) string {
+	buf := &strings.Builder{}
+	var loaded, skipped, noData int
+	var files int
+	var bytes, rows int64
+	for i, plan := range plans {
+		_, _ = fmt.Fprintf(buf, "%4d. %s: ", i+1, plan.table)
+		if !plan.inExport {
+			noData++
+			buf.WriteString("no data in the export\n")
+			continue
+		}
+		_, _ = fmt.Fprintf(buf, "%d file(s), %s, %d rows - ", plan.files, utils.FormatByteSize(uint64(plan.bytes)),
+			plan.rows)
+		if plan.skipReason != "" {
+			skipped++
+			_, _ = fmt.Fprintf(buf, "skip (%s)\n", plan.skipReason)
+			continue
+		}
+		loaded++
+		files += plan.files
+		bytes += plan.bytes
+		rows += plan.rows
+		if plan.note != "" {
+			_, _ = fmt.Fprintf(buf, "load (%s)\n", plan.note)
+		} else {
+			buf.WriteString("load\n")
+		}
+	}
+	_, _ = fmt.Fprintf(buf, "%d table(s) to load with %d file(s), %s, %d rows; %d skipped; %d without data\n",
+		loaded, files, utils.FormatByteSize(uint64(bytes)), rows, skipped, noData)
+	return buf.String()
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"dbrestore/target"
+	"testing"
+)
+
+func TestFormatPlan(t *testing.T) {
+	plans := []tablePlan{
+		{table: "public.users", inExport: true, files: 2, bytes: 2048, rows: 100},
+		{table: "public.orders", inExport: true, files: 1, bytes: 1024, rows: 50, note: "the table is not empty"},
+		{table: "public.audit", inExport: true, files: 3, bytes: 4096, rows: 70, skipReason: target.ReasonNotEmpty},
+		{table: "public.cache"},
+	}
+	expected := "   1. public.users: 2 file(s), 2.0 KB, 100 rows - load\n" +
+		"   2. public.orders: 1 file(s), 1.0 KB, 50 rows - load (the table is not empty)\n" +
+		"   3. public.audit: 3 file(s), 4.0 KB, 70 rows - skip (Table is not empty)\n" +
+		"   4. public.cache: no data in the export\n" +
+		"2 table(s) to load with 3 file(s), 3.0 KB, 150 rows; 1 skipped; 1 without data\n"
+	if result := formatPlan(plans); result != expected {
+		t.Errorf("formatPlan() =\n%s\nwant\n%s", result, expected)
+	}
+}
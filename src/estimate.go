@@ -0,0 +1,104 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	config2 "dbrestore/config"
+	source2 "dbrestore/source"
+	"dbrestore/target"
+	"dbrestore/utils"
+	"fmt"
+	"go.uber.org/zap"
+	"slices"
+)
+
+// estimateCandidate is a table with data in the export, considered for sampling by the command "estimate".
+type estimateCandidate struct {
+	// info the table in the export
+	info source2.ParquetFileInfo
+	// parts the Parquet part files of the table
+	parts []target.TablePart
+	// size the total size of the part files
+	size int64
+}
+
+// estimateRestore implements the command "estimate": it pre-scans the Parquet parts of all tables to restore,
+// loads a sample of the largest tables into temporary tables (the destination tables are not modified),
+// and prints the duration of the full restore extrapolated from the measured speed.
+func estimateRestore(ctx context.Context, conf *config2.Config, source source2.Source, reader *source2.Reader, writer *target.DbWriter,
+	progress *checkpoint) error {
+	tables, err := databaseTables(ctx, writer, reader)
+	if err != nil {
+		return err
+	}
+	parquetTables, err := reader.IterateOverTables(tables)
+	if err != nil {
+		return sourceError(utils.NewFatalError(err))
+	}
+
+	candidates := make([]estimateCandidate, 0, len(parquetTables))
+	var totalBytes, totalRows int64
+	for _, table := range parquetTables {
+		parts, err := target.ListTableParts(source, conf.SourceDatabase, table.TableName)
+		if err != nil {
+			return sourceError(utils.NewFatalError(fmt.Errorf("failed to list the parts of the table '%s': %w",
+				table.TableName, err)))
+		}
+		candidate := estimateCandidate{info: table, parts: parts}
+		for _, part := range parts {
+			candidate.size += part.Size
+			totalRows += part.Rows
+		}
+		totalBytes += candidate.size
+		candidates = append(candidates, candidate)
+	}
+	slices.SortStableFunc(candidates, func(a, b estimateCandidate) int {
+		return cmp.Compare(b.size, a.size)
+	})
+
+	samples := make([]target.TableSample, 0, conf.EstimateTables)
+	for _, candidate := range candidates {
+		if len(samples) >= conf.EstimateTables {
+			break
+		}
+		index := slices.IndexFunc(candidate.parts, func(part target.TablePart) bool {
+			return part.RelativePath != "" && part.Rows > 0
+		})
+		if index < 0 {
+			continue
+		}
+		table := candidate.info.TableName
+		mapper, err := writer.GetFieldMapper(candidate.info, conf)
+		if err != nil {
+			log.Error("Error mapping fields for table", zap.String("table", table), zap.Error(err))
+			progress.report.setTable(tableReport{Table: table, Status: tableFailed, Error: err.Error()})
+			continue
+		}
+		if reason, skip := mapper.ShouldSkip(); skip {
+			log.Info("Skipping table", zap.String("table", table), zap.String("reason", reason))
+			progress.report.setTable(tableReport{Table: table, Status: tableSkipped, Reason: reason})
+			continue
+		}
+		sample, err := writer.LoadSample(source, mapper, candidate.parts[index], conf.EstimateSampleRows)
+		if err != nil {
+			progress.report.setTable(tableReport{Table: table, Status: tableFailed, Error: err.Error()})
+			return err
+		}
+		samples = append(samples, sample)
+		progress.report.setTable(tableReport{Table: table, Status: tableSampled, Rows: sample.Rows,
+			DurationSeconds: sample.Duration.Seconds(), RecordsPerSecond: sample.RowsPerSecond()})
+	}
+
+	estimate, err := target.EstimateRestore(samples, totalBytes, totalRows)
+	if err != nil {
+		return utils.NewFatalError(err)
+	}
+	fmt.Print(estimate.String())
+	progress.report.Estimate = &estimateReport{DurationSeconds: estimate.Duration.Seconds(),
+		MinDurationSeconds: estimate.MinDuration.Seconds(), MaxDurationSeconds: estimate.MaxDuration.Seconds(),
+		Bytes: estimate.TotalBytes, Rows: estimate.TotalRows}
+	log.Info("Estimated the restore", zap.Int("tables", len(candidates)), zap.Int("sampled_tables", len(samples)),
+		zap.Duration("estimate", estimate.Duration), zap.Duration("min", estimate.MinDuration),
+		zap.Duration("max", estimate.MaxDuration))
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Exit codes of the program
+const (
+	// exitSuccess everything was done
+	exitSuccess = 0
+	// exitConfigError invalid options or configuration, and the errors not classified otherwise
+	exitConfigError = 1
+	// exitSourceError the export cannot be read: missing files, broken metadata or Parquet files
+	exitSourceError = 2
+	// exitTargetError the destination database failed: connection, schema, or loading a table
+	exitTargetError = 3
+	// exitPartialFailure some tables were loaded and others failed (see --continue-on-error)
+	exitPartialFailure = 4
+	// exitRunTimeout the run was stopped by --run-timeout (the same code as the timeout command)
+	exitRunTimeout = 124
+	// exitInterrupted the program was stopped by a signal
+	exitInterrupted = 130
+)
+
+// exitCodeError attaches the exit code of the program to an error.
+type exitCodeError struct {
+	// code the exit code of the program
+	code int
+	// err the original error
+	err error
+}
+
+// Error implements the error interface.
+func (e *exitCodeError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the original error, so that errors.Is and errors.As (and utils.IsFatalError) keep working.
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
+// withExitCode attaches the exit code to the error unless it already has one: the classification closest
+// to the origin of the error wins. Returns nil for a nil error.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	var coded *exitCodeError
+	if errors.As(err, &coded) {
+		return err
+	}
+	return &exitCodeError{code: code, err: err}
+}
+
+// sourceError marks the error as a failure of reading the export (see exitSourceError).
+func sourceError(err error) error {
+	return withExitCode(exitSourceError, err)
+}
+
+// targetError marks the error as a failure of the destination database (see exitTargetError).
+func targetError(err error) error {
+	return withExitCode(exitTargetError, err)
+}
+
+// failedTablesError reports the tables that failed to load; it is a partial failure if other tables were loaded.
+func failedTablesError(failedTables []string, loadedTables int) error {
+	err := fmt.Errorf("loading %d tables failed: %s", len(failedTables), strings.Join(failedTables, ", "))
+	if loadedTables > 0 {
+		return withExitCode(exitPartialFailure, err)
+	}
+	return targetError(err)
+}
+
+// runTimeoutError reports the run stopped by --run-timeout; unlike withExitCode, it overrides the classification
+// of the error, which is only the consequence of the timeout.
+func runTimeoutError(err error, timeout time.Duration, elapsed time.Duration) error {
+	return &exitCodeError{code: exitRunTimeout, err: fmt.Errorf("the run timeout of %s was exceeded after %s: %w",
+		timeout, elapsed.Round(time.Second), err)}
+}
+
+// exitCode returns the exit code of the program for the result of the run.
+func exitCode(err error, interrupted bool) int {
+	if err == nil {
+		return exitSuccess
+	}
+	if interrupted {
+		return exitInterrupted
+	}
+	var coded *exitCodeError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return exitConfigError
+}
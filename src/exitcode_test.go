@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"dbrestore/utils"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestExitCode(t *testing.T) {
+	failure := errors.New("failure")
+	tests := []struct {
+		name        string
+		err         error
+		interrupted bool
+		expected    int
+	}{
+		{name: "success", err: nil, expected: exitSuccess},
+		{name: "unclassified", err: failure, expected: exitConfigError},
+		{name: "source", err: sourceError(failure), expected: exitSourceError},
+		{name: "target", err: targetError(failure), expected: exitTargetError},
+		{name: "wrapped", err: fmt.Errorf("context: %w", targetError(failure)), expected: exitTargetError},
+		{name: "closest to the origin", err: sourceError(targetError(failure)), expected: exitTargetError},
+		{name: "fatal", err: sourceError(utils.NewFatalError(failure)), expected: exitSourceError},
+		{name: "partial", err: failedTablesError([]string{"public.a"}, 2), expected: exitPartialFailure},
+		{name: "all tables failed", err: failedTablesError([]string{"public.a"}, 0), expected: exitTargetError},
+		{name: "run timeout", err: runTimeoutError(targetError(context.DeadlineExceeded), time.Hour, time.Hour),
+			expected: exitRunTimeout},
+		{name: "interrupted", err: targetError(context.Canceled), interrupted: true, expected: exitInterrupted},
+		{name: "interrupted success", err: nil, interrupted: true, expected: exitSuccess},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := exitCode(test.err, test.interrupted); actual != test.expected {
+				t.Errorf("exitCode() = %d, expected %d", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestFailedReference(t *testing.T) {
+	failures := map[string]string{"public.users": "failure"}
+	if parent, failed := failedReference([]string{"public.shops", "public.users"}, failures); !failed ||
+		parent != "public.users" {
+		t.Errorf("failedReference() = %s, %v; expected public.users", parent, failed)
+	}
+	if _, failed := failedReference([]string{"public.shops"}, failures); failed {
+		t.Errorf("failedReference() = true, expected false for the tables that did not fail")
+	}
+}
+
+func TestWithExitCode(t *testing.T) {
+	if err := withExitCode(exitSourceError, nil); err != nil {
+		t.Errorf("withExitCode(nil) = %v, expected nil", err)
+	}
+	failure := errors.New("failure")
+	err := targetError(utils.NewFatalError(failure))
+	if !errors.Is(err, failure) {
+		t.Errorf("errors.Is() = false, expected the original error to be wrapped")
+	}
+	if !utils.IsFatalError(err) {
+		t.Errorf("IsFatalError() = false, expected the fatal error to be preserved")
+	}
+	if err.Error() != "failure" {
+		t.Errorf("Error() = %q, expected %q", err.Error(), "failure")
+	}
+	err = runTimeoutError(targetError(context.DeadlineExceeded), 8*time.Hour, 8*time.Hour+1500*time.Millisecond)
+	if expected := "the run timeout of 8h0m0s was exceeded after 8h0m2s: context deadline exceeded"; err.Error() != expected {
+		t.Errorf("runTimeoutError() = %q, expected %q", err.Error(), expected)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("errors.Is() = false, expected the timeout to be wrapped")
+	}
+	expected := "loading 2 tables failed: public.a, public.b"
+	if err := failedTablesError([]string{"public.a", "public.b"}, 1); err.Error() != expected {
+		t.Errorf("failedTablesError() = %q, expected %q", err.Error(), expected)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	config2 "dbrestore/config"
+	"dbrestore/target"
+	"dbrestore/testdb"
+	"dbrestore/utils"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/parquet-go/parquet-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// updateGolden rewrites the golden files instead of comparing with them: go test -run Golden -update
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata instead of comparing with them")
+
+// goldenSnapshot the name of the export in the fixture (see writeExportFixture)
+const goldenSnapshot = "golden-snap"
+
+// TestMain sets the success markers of the RDS export, like execute does with the default configuration
+// (see config.Config.SuccessMarkers), because the tests call run directly.
+func TestMain(m *testing.M) {
+	target.SetSuccessMarkers([]string{"_success", "_SUCCESS"}, false)
+	os.Exit(m.Run())
+}
+
+// captureLogs replaces the shared logger with an observer of the INFO and higher events until the end of the test.
+func captureLogs(t *testing.T) *observer.ObservedLogs {
+	core, logs := observer.New(zap.InfoLevel)
+	saved := utils.Logger
+	utils.Logger = utils.CustomLogger{Logger: *zap.New(core)}
+	t.Cleanup(func() {
+		utils.Logger = saved
+	})
+	return logs
+}
+
+// normalizeLogs renders the log events one per line with the fields sorted by their keys; the values that change
+// between runs (durations, times and speeds) are replaced with placeholders, and so are the strings in replacements
+// (for example the temporary directory of the fixture).
+func normalizeLogs(entries []observer.LoggedEntry, replacements map[string]string) string {
+	var ret strings.Builder
+	for _, entry := range entries {
+		fields := entry.ContextMap()
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		ret.WriteString(strings.ToUpper(entry.Level.String()) + " " + replaceAll(entry.Message, replacements))
+		for _, key := range keys {
+			var value string
+			switch v := fields[key].(type) {
+			case time.Duration:
+				value = "<duration>"
+			case time.Time:
+				value = "<time>"
+			case float64, float32:
+				value = "<float>"
+			default:
+				value = replaceAll(fmt.Sprint(v), replacements)
+			}
+			ret.WriteString(" " + key + "=" + value)
+		}
+		ret.WriteString("\n")
+	}
+	return ret.String()
+}
+
+// normalizeReport parses the JSON report and replaces the timestamps, the durations and the speeds
+// with placeholders; the result is indented JSON with sorted keys.
+func normalizeReport(t *testing.T, fileName string) string {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Failed to read the report: %v", err)
+	}
+	var report map[string]any
+	if err := json.Unmarshal(content, &report); err != nil {
+		t.Fatalf("Failed to parse the report: %v", err)
+	}
+	normalizeReportValue(report)
+	var normalized strings.Builder
+	encoder := json.NewEncoder(&normalized)
+	encoder.SetEscapeHTML(false) // keep the placeholders readable
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		t.Fatalf("Failed to serialize the report: %v", err)
+	}
+	return normalized.String()
+}
+
+// normalizeReportValue replaces the volatile values of the report in place (see normalizeReport).
+func normalizeReportValue(value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			switch {
+			case strings.HasSuffix(key, "_at"):
+				v[key] = "<time>"
+			case strings.Contains(key, "duration_seconds") || strings.HasSuffix(key, "per_second"):
+				v[key] = "<float>"
+			default:
+				normalizeReportValue(item)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			normalizeReportValue(item)
+		}
+	}
+}
+
+// captureStdout redirects the standard output into a file while fn runs and returns what was printed.
+func captureStdout(t *testing.T, fn func()) string {
+	fileName := filepath.Join(t.TempDir(), "stdout.txt")
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Failed to create the output file: %v", err)
+	}
+	saved := os.Stdout
+	os.Stdout = file
+	defer func() {
+		os.Stdout = saved
+	}()
+	fn()
+	_ = file.Close()
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Failed to read the output: %v", err)
+	}
+	return string(content)
+}
+
+// replaceAll replaces every key of replacements in s with its value.
+func replaceAll(s string, replacements map[string]string) string {
+	for old, replacement := range replacements {
+		s = strings.ReplaceAll(s, old, replacement)
+	}
+	return s
+}
+
+// checkGolden compares the output with the golden file in testdata, or rewrites the file with -update;
+// a missing golden file fails the test, it is created with -update.
+func checkGolden(t *testing.T, name string, actual string) {
+	fileName := filepath.Join("testdata", name+".golden")
+	if *updateGolden {
+		if err := os.WriteFile(fileName, []byte(actual), 0644); err != nil {
+			t.Fatalf("Failed to update the golden file: %v", err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("The golden file %s is missing, run the test with -update to create it", fileName)
+	}
+	if err != nil {
+		t.Fatalf("Failed to read the golden file: %v", err)
+	}
+	if actual != string(expected) {
+		t.Errorf("The output differs from %s (run the test with -update to accept it):\n%s", fileName, actual)
+	}
+}
+
+// writeExportFixture writes a small export with the tables public.golden_parent and public.golden_child
+// of the database "db" into the directory and returns the root of the export.
+func writeExportFixture(t *testing.T, dir string) string {
+	root := filepath.Join(dir, goldenSnapshot)
+	column := func(name string, originalType string, exportedType string) map[string]any {
+		return map[string]any{"columnName": name, "originalType": originalType, "expectedExportedType": exportedType,
+			"originalCharMaxLength": 0, "originalNumPrecision": 0, "originalDateTimePrecision": 0}
+	}
+	table := func(name string, columns ...map[string]any) map[string]any {
+		return map[string]any{"tableStatistics": map[string]any{}, "target": "db.public." + name, "status": "COMPLETE",
+			"schemaMetadata": map[string]any{"originalTypeMappings": columns}}
+	}
+	tables := map[string]any{"perTableStatus": []any{
+		table("golden_parent", column("id", "bigint", "int64"), column("name", "text", "binary (UTF8)")),
+		table("golden_child", column("id", "bigint", "int64"), column("parent_id", "bigint", "int64")),
+	}}
+	exportInfo := map[string]any{"exportTaskIdentifier": goldenSnapshot, "status": "COMPLETE", "percentProgress": 100}
+	writeJSON := func(name string, value any) {
+		content, err := json.Marshal(value)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(root, name), content, 0644)
+		}
+		if err != nil {
+			t.Fatalf("Failed to write the fixture: %v", err)
+		}
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatalf("Failed to create the fixture: %v", err)
+	}
+	writeJSON(fmt.Sprintf("export_info_%s.json", goldenSnapshot), exportInfo)
+	writeJSON(fmt.Sprintf("export_tables_info_%s_from_1_to_2.json", goldenSnapshot), tables)
+	writeFixturePart(t, root, "golden_parent",
+		[]parentRow{{ID: 1, Name: "first"}, {ID: 2, Name: "second"}, {ID: 3, Name: "third"}})
+	writeFixturePart(t, root, "golden_child", []childRow{{ID: 10, ParentID: 1}, {ID: 11, ParentID: 1}, {ID: 12, ParentID: 3}})
+	return root
+}
+
+// writeFixturePart writes the rows into the only part file of the table of the database "db" in the export,
+// with the success marker.
+func writeFixturePart[T any](t *testing.T, root string, table string, rows []T) {
+	dir := filepath.Join(root, "db", "public."+table, "1")
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		err = parquet.WriteFile(filepath.Join(dir, "part-00000.parquet"), rows)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "_SUCCESS"), nil, 0644)
+	}
+	if err != nil {
+		t.Fatalf("Failed to write the fixture: %v", err)
+	}
+}
+
+// parentRow is a row of public.golden_parent in the fixture export
+type parentRow struct {
+	ID   int64  `parquet:"id"`
+	Name string `parquet:"name"`
+}
+
+// childRow is a row of public.golden_child in the fixture export
+type childRow struct {
+	ID       int64 `parquet:"id"`
+	ParentID int64 `parquet:"parent_id"`
+}
+
+func TestGoldenValidate(t *testing.T) {
+	dir := t.TempDir()
+	root := writeExportFixture(t, dir)
+	conf := &config2.Config{LocalDir: root, SourceDatabase: "db", ValidateCommand: true}
+	logs := captureLogs(t)
+	var err error
+	output := captureStdout(t, func() {
+		err = run(context.Background(), conf, newCheckpoint())
+	})
+	if err != nil {
+		t.Fatalf("run() error: %v", err)
+	}
+	checkGolden(t, "validate_logs", normalizeLogs(logs.All(), map[string]string{dir: "<dir>"}))
+	checkGolden(t, "validate_output", output)
+}
+
+func TestGoldenReport(t *testing.T) {
+	report := newRestoreReport()
+	report.expectTables([]string{"public.a", "public.b", "public.c", "public.d"})
+	report.Truncation = newTruncationReport(nil, time.Second)
+	report.setTable(tableReport{Table: "public.a", Status: tableLoaded, Rows: 10, Files: 2, DurationSeconds: 1.5,
+		RecordsPerSecond: 6.7})
+	report.setTable(tableReport{Table: "public.b", Status: tableSkipped, Reason: "Table is not empty"})
+	report.setTable(tableReport{Table: "public.c", Status: tableFailed, Error: "bad data", DurationSeconds: 0.5})
+	report.finish(errors.New("bad data"))
+	fileName := filepath.Join(t.TempDir(), "report.json")
+	if err := report.write(fileName); err != nil {
+		t.Fatalf("write() error: %v", err)
+	}
+	checkGolden(t, "report_schema", normalizeReport(t, fileName))
+}
+
+func TestGoldenRestore(t *testing.T) {
+	testdb.WithTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE golden_parent (id BIGINT PRIMARY KEY, name TEXT);
+			CREATE TABLE golden_child (id BIGINT PRIMARY KEY, parent_id BIGINT REFERENCES golden_parent (id));`)
+		if err != nil {
+			t.Fatalf("Failed to create the tables: %v", err)
+		}
+
+		dir := t.TempDir()
+		root := writeExportFixture(t, dir)
+		reportFile := filepath.Join(dir, "report.json")
+		conf := &config2.Config{LocalDir: root, SourceDatabase: "db", DBURL: connectionString,
+			ReportFile: reportFile, ParquetBatchSize: 1000, UnknownTypeFallback: config2.UnknownTypeString,
+			CopyCountMismatch: config2.CopyCountMismatchError, TableTimeoutAction: config2.TableTimeoutAbort}
+		logs := captureLogs(t)
+		progress := newCheckpoint()
+		err = run(context.Background(), conf, progress)
+		progress.report.finish(err)
+		if err != nil {
+			t.Fatalf("run() error: %v", err)
+		}
+		if err := progress.report.write(reportFile); err != nil {
+			t.Fatalf("write() error: %v", err)
+		}
+		replacements := map[string]string{dir: "<dir>", connectionString: "<connection>",
+			db.Config().Database: "<database>"}
+		checkGolden(t, "restore_logs", normalizeLogs(logs.All(), replacements))
+		checkGolden(t, "restore_report", normalizeReport(t, reportFile))
+	})
+}
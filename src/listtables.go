@@ -0,0 +1,135 @@
+package main
+
+import (
+	config2 "dbrestore/config"
+	source2 "dbrestore/source"
+	"dbrestore/target"
+	"dbrestore/utils"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// tableListing is a table of the export in the listing of the command "list-tables".
+type tableListing struct {
+	// Table the table name including the schema name
+	Table string `json:"table"`
+	// Columns the number of columns in the export metadata
+	Columns int `json:"columns"`
+	// Parts the number of Parquet part files
+	Parts int `json:"parts"`
+	// Size the total size of the Parquet part files in bytes
+	Size int64 `json:"size"`
+	// Rows the total number of rows in the Parquet part files, only with --with-rows
+	Rows *int64 `json:"rows,omitempty"`
+	// Files the Parquet part files, and the subfolders without part files (with an empty File)
+	Files []partListing `json:"files"`
+}
+
+// partListing is a Parquet part file of a table in the listing of the command "list-tables".
+type partListing struct {
+	// Subfolder the subfolder of the part file
+	Subfolder string `json:"subfolder"`
+	// File the name of the part file, empty for a subfolder without part files
+	File string `json:"file,omitempty"`
+	// Size the size of the part file in bytes
+	Size int64 `json:"size"`
+	// Rows the number of rows in the part file, only with --with-rows
+	Rows *int64 `json:"rows,omitempty"`
+	// SuccessMarker indicates that the subfolder contains the success marker file
+	SuccessMarker bool `json:"success_marker"`
+}
+
+// newTableListing summarizes the part files of the table (see target.ListTableParts); the row counts
+// are included only if withRows is set.
+func newTableListing(table source2.ParquetFileInfo, parts []target.TablePart, withRows bool) tableListing {
+	ret := tableListing{Table: table.TableName, Columns: len(table.Columns), Files: make([]partListing, 0, len(parts))}
+	var rows int64
+	for _, part := range parts {
+		file := partListing{Subfolder: part.Subfolder, SuccessMarker: part.SuccessMarker}
+		if part.RelativePath != "" {
+			ret.Parts++
+			ret.Size += part.Size
+			rows += part.Rows
+			file.File = filepath.Base(part.RelativePath)
+			file.Size = part.Size
+			if withRows {
+				file.Rows = &part.Rows
+			}
+		}
+		ret.Files = append(ret.Files, file)
+	}
+	if withRows {
+		ret.Rows = &rows
+	}
+	return ret
+}
+
+// print writes the listing of the table as text: the summary line followed by a line for every part file.
+func (l tableListing) print(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "%s: %d column(s), %d part(s), %s%s\n", l.Table, l.Columns, l.Parts,
+		utils.FormatByteSize(uint64(l.Size)), formatListedRows(l.Rows))
+	for _, file := range l.Files {
+		marker := "success marker found"
+		if !file.SuccessMarker {
+			marker = "SUCCESS MARKER MISSING"
+		}
+		if file.File == "" {
+			_, _ = fmt.Fprintf(w, "    %s: no part files, %s\n", file.Subfolder, marker)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "    %s: %s, %s%s, %s\n", file.Subfolder, file.File,
+			utils.FormatByteSize(uint64(file.Size)), formatListedRows(file.Rows), marker)
+	}
+}
+
+// formatListedRows formats the row count of the listing, or returns an empty string if the rows were not read.
+func formatListedRows(rows *int64) string {
+	if rows == nil {
+		return ""
+	}
+	return fmt.Sprintf(", %d rows", *rows)
+}
+
+// listParts implements the command "list-tables": it lists every selected table of the export with the number
+// of its columns and its Parquet part files (sizes and the presence of success markers), without loading them
+// and without a database. The row counts are read from the Parquet footers with --with-rows, and --json prints
+// the listing as a JSON array.
+func listParts(conf *config2.Config, source source2.Source, reader *source2.Reader) error {
+	if err := resolveSourceDatabase(conf, reader); err != nil {
+		return err
+	}
+	tables, err := selectedExportTables(conf, reader)
+	if err != nil {
+		return err
+	}
+	listings := make([]tableListing, 0, len(tables))
+	for _, table := range tables {
+		var parts []target.TablePart
+		if conf.ListWithRows {
+			parts, err = target.ListTableParts(source, conf.SourceDatabase, table.TableName)
+		} else {
+			parts, err = target.ListTableFiles(source, conf.SourceDatabase, table.TableName)
+		}
+		if err != nil {
+			return utils.NewFatalError(fmt.Errorf("failed to list the parts of the table '%s': %w",
+				table.TableName, err))
+		}
+		listing := newTableListing(table, parts, conf.ListWithRows)
+		if !conf.ListJSON {
+			listing.print(os.Stdout)
+		}
+		listings = append(listings, listing)
+	}
+	if !conf.ListJSON {
+		return nil
+	}
+	content, err := json.MarshalIndent(listings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize the list of the tables: %w", err)
+	}
+	_, err = fmt.Println(string(content))
+	return err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"dbrestore/source"
+	"dbrestore/target"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTableListing(t *testing.T) {
+	table := source.ParquetFileInfo{TableName: "public.t",
+		Columns: []source.ColumnInfo{{ColumnName: "id"}, {ColumnName: "name"}}}
+	parts := []target.TablePart{
+		{Subfolder: "db/public.t/1", RelativePath: "db/public.t/1/part-00000.parquet", Rows: 3, Size: 100,
+			SuccessMarker: true},
+		{Subfolder: "db/public.t/1", RelativePath: "db/public.t/1/part-00001.parquet", Rows: 2, Size: 50,
+			SuccessMarker: true},
+		{Subfolder: "db/public.t/2"},
+	}
+
+	t.Run("Without rows", func(t *testing.T) {
+		listing := newTableListing(table, parts, false)
+		content, err := json.Marshal(listing)
+		if err != nil {
+			t.Fatalf("Marshal() error: %v", err)
+		}
+		expected := `{"table":"public.t","columns":2,"parts":2,"size":150,"files":[` +
+			`{"subfolder":"db/public.t/1","file":"part-00000.parquet","size":100,"success_marker":true},` +
+			`{"subfolder":"db/public.t/1","file":"part-00001.parquet","size":50,"success_marker":true},` +
+			`{"subfolder":"db/public.t/2","size":0,"success_marker":false}]}`
+		if string(content) != expected {
+			t.Errorf("listing = %s; want %s", content, expected)
+		}
+		var out strings.Builder
+		listing.print(&out)
+		if line, _, _ := strings.Cut(out.String(), "\n"); strings.Contains(line, "rows") {
+			t.Errorf("print() = %q; want no rows", line)
+		}
+	})
+
+	t.Run("With rows", func(t *testing.T) {
+		listing := newTableListing(table, parts, true)
+		if listing.Rows == nil || *listing.Rows != 5 {
+			t.Fatalf("Rows = %v; want 5", listing.Rows)
+		}
+		if listing.Files[1].Rows == nil || *listing.Files[1].Rows != 2 || listing.Files[2].Rows != nil {
+			t.Errorf("Files = %+v; want the rows of the part files only", listing.Files)
+		}
+		var out strings.Builder
+		listing.print(&out)
+		if !strings.HasPrefix(out.String(), "public.t: 2 column(s), 2 part(s), ") ||
+			!strings.Contains(out.String(), ", 5 rows\n") || !strings.Contains(out.String(), "no part files") {
+			t.Errorf("print() = %q", out.String())
+		}
+	})
+}
@@ -1,70 +1,452 @@
 package main
 
 import (
+	"cloud.google.com/go/storage"
 	"context"
 	config2 "dbrestore/config"
 	source2 "dbrestore/source"
 	"dbrestore/target"
 	"dbrestore/utils"
+	"dbrestore/version"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	_ "github.com/lib/pq"
 	"go.uber.org/zap"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 )
 
 // log a convenience wrapper to shorten code lines
 var log = &utils.Logger
 
+// checkpoint keeps the progress of the restore between attempts (see --max-run-attempts),
+// so that a restarted attempt does not load the same tables again.
+type checkpoint struct {
+	// completed the set of tables that were committed to the target database
+	completed map[string]struct{}
+	// manifestTables the manifest entries of the committed tables (see --manifest-out)
+	manifestTables []source2.ManifestTable
+	// rowsBefore the number of rows in the committed tables before loading them, for the row count verification
+	rowsBefore map[string]int64
+	// rowsDropped the number of rows of the committed tables not loaded on purpose, including the rows rejected
+	// by a resilient load (see target.RowAccounting), for the row count verification
+	rowsDropped map[string]int64
+	// report the results of the tables over all attempts (see --report-file)
+	report *restoreReport
+	// receipt the files read from the export over all attempts (see --receipt); nil if it is not written
+	receipt *source2.Receipt
+	// file the committed tables persisted over the runs (see --checkpoint-file); nil if it is not used
+	file *checkpointFile
+	// identity the snapshot, the database and the destination of the restore, as far as they are known
+	// (see --notify-webhook)
+	identity checkpointIdentity
+}
+
+// newCheckpoint creates an empty checkpoint.
+func newCheckpoint() *checkpoint {
+	return &checkpoint{completed: make(map[string]struct{}), rowsBefore: make(map[string]int64),
+		rowsDropped: make(map[string]int64), report: newRestoreReport()}
+}
+
+// isCompleted checks whether the table was already committed by one of the previous attempts.
+func (c *checkpoint) isCompleted(table string) bool {
+	_, ok := c.completed[table]
+	return ok
+}
+
+// markCompleted records the table as committed, also in the checkpoint file if it is used.
+func (c *checkpoint) markCompleted(table string) error {
+	c.completed[table] = struct{}{}
+	if c.file == nil {
+		return nil
+	}
+	return c.file.record(table)
+}
+
+// resume opens the checkpoint file (see --checkpoint-file) in the first attempt, and marks the tables committed
+// by the previous runs as completed; they are reported as skipped.
+func (c *checkpoint) resume(fileName string, identity checkpointIdentity) error {
+	if c.file != nil {
+		return nil
+	}
+	file, err := openCheckpointFile(fileName, identity)
+	if err != nil {
+		return err
+	}
+	c.file = file
+	c.skipCommitted(file.tables, "committed by a previous run (see --checkpoint-file)")
+	if len(file.tables) > 0 {
+		log.Info("Resuming the restore from the checkpoint file", zap.String("file", fileName),
+			zap.Int("completed_tables", len(file.tables)))
+	}
+	return nil
+}
+
+// skipCommitted marks the tables committed by the previous runs as completed; they are reported as skipped
+// with the reason.
+func (c *checkpoint) skipCommitted(tables []string, reason string) {
+	for _, table := range tables {
+		c.completed[table] = struct{}{}
+		c.report.setTable(tableReport{Table: table, Status: tableSkipped, Reason: reason})
+	}
+}
+
+// resumeFromRestoreLog creates the restore log in the destination database (see --no-tracking), and with --resume
+// marks the tables committed by the previous runs restoring the same export as completed.
+func resumeFromRestoreLog(conf *config2.Config, writer *target.DbWriter, snapshot string, progress *checkpoint) error {
+	err := writer.CreateRestoreLog(target.RestoreLog{Snapshot: snapshot, SourceDatabase: conf.SourceDatabase})
+	if err != nil || !conf.Resume {
+		return err
+	}
+	tables, err := writer.RestoredTables()
+	if err != nil {
+		return err
+	}
+	progress.skipCommitted(tables, "committed by a previous run (see --resume)")
+	log.Info("Resuming the restore from the restore log", zap.Int("completed_tables", len(tables)))
+	return nil
+}
+
 func main() {
 	// reading configuration shall be the very first action because it also configures the logger
-	conf := config2.GetConfig()
-	log.Info("Starting the application")
-
-	var source source2.Source
-	if conf.LocalDir != "" {
-		log.Info("Using local directory: ", zap.String("dir", conf.LocalDir))
-		source = source2.NewLocalSource(conf.LocalDir)
-	} else {
-		log.Info("Using AWS S3 bucket: ", zap.String("bucket", conf.AWSBucketPath))
+	conf := config2.GetConfig(config2.ParseCommandLine(os.Args[1:]))
+	os.Exit(execute(conf))
+}
 
-		// Use credentials from configuration
-		var cfg aws.Config
-		var err error
+// execute runs the command with the retries (see run), writes the report and the receipt,
+// and returns the exit code of the program (see exitCode).
+func execute(conf *config2.Config) int {
+	log.Info("Starting the application", zap.String("version", version.String()))
+	source2.SetMaxOpenReaders(conf.MaxOpenParquetFiles)
+	target.SetWriteRateLimit(conf.MaxRowsPerSec, conf.MaxWriteMBps*1e6)
+	target.SetSuccessMarkers(slices.Sorted(maps.Keys(conf.SuccessMarkers)), conf.NoSuccessMarker)
 
-		if conf.AWSAccessKey != "" && conf.AWSSecretKey != "" {
-			// Create a credential provider with credentials from configuration
-			credentialsProvider := credentials.NewStaticCredentialsProvider(conf.AWSAccessKey,
-				conf.AWSSecretKey, "") // Last parameter is session token, usually empty
+	// remove the leftovers of downloaded files on normal exit and on interruption
+	defer source2.CleanupTempFiles(conf.TempDir)
+	// the root context of all database and storage calls: an interruption cancels the current statement,
+	// so the transaction of the current table is rolled back, and the restore stops
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-signalCtx.Done()
+		// a second signal terminates the program immediately
+		stop()
+	}()
+	ctx := signalCtx
+	startTime := time.Now()
+	if conf.RunTimeout > 0 {
+		// the run timeout cancels the restore like an interruption
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(signalCtx, conf.RunTimeout)
+		defer cancel()
+	}
 
-			cfg, err = config.LoadDefaultConfig(context.TODO(),
-				config.WithCredentialsProvider(credentialsProvider),
-				config.WithRegion(conf.AWSRegion))
+	progress := newCheckpoint()
+	if conf.ReceiptFile != "" {
+		progress.receipt = source2.NewReceipt()
+	}
+	err := utils.RetryAttempts(conf.MaxRunAttempts, conf.RunRetryDelay, func(attempt int) error {
+		if attempt > 1 {
+			log.Info("Restarting the restore from the checkpoint", zap.Int("attempt", attempt),
+				zap.Int("completed_tables", len(progress.completed)))
+		}
+		err := run(ctx, conf, progress)
+		if err != nil && ctx.Err() != nil {
+			// retrying an interrupted restore makes no sense
+			return utils.NewFatalError(err)
+		}
+		return err
+	})
+	if progress.file != nil && err == nil {
+		progress.file.remove()
+	} else if progress.file != nil {
+		progress.file.close()
+	}
+	interrupted := signalCtx.Err() != nil
+	if err != nil && !interrupted && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		elapsed := time.Since(startTime)
+		log.Error("Run timeout exceeded, the table being loaded was rolled back",
+			zap.Duration("elapsed", elapsed), zap.Duration("timeout", conf.RunTimeout))
+		err = runTimeoutError(err, conf.RunTimeout, elapsed)
+	}
+	progress.report.finish(err)
+	if conf.ReportFile != "" {
+		if reportErr := progress.report.write(conf.ReportFile); reportErr != nil {
+			log.Error("ERROR: ", zap.Error(reportErr))
+		} else {
+			log.Info("Report written", zap.String("file", conf.ReportFile))
+		}
+	}
+	if progress.receipt != nil {
+		if receiptErr := progress.receipt.Write(conf.ReceiptFile); receiptErr != nil {
+			log.Error("ERROR: ", zap.Error(receiptErr))
 		} else {
-			// Use default credentials provider chain (environment variables, shared credentials file, etc.)
-			cfg, err = config.LoadDefaultConfig(context.TODO(), config.WithRegion(conf.AWSRegion))
+			log.Info("Receipt written", zap.String("file", conf.ReceiptFile),
+				zap.Int("files", len(progress.receipt.Files)))
+		}
+	}
+	if err != nil {
+		log.Error("ERROR: ", zap.Error(err))
+		if interrupted {
+			log.Warn("Interrupted, the table being loaded was rolled back")
 		}
+	}
+	code := exitCode(err, interrupted)
+	if conf.NotifyWebhook != "" {
+		sendNotification(newWebhookNotifier(conf.NotifyWebhook),
+			newRunNotification(progress.report, progress.identity, code), conf.NotifyTimeout)
+	}
+	return code
+}
 
-		if err != nil {
-			log.Fatal("failed to load AWS configuration", zap.Error(err))
+// connect creates the database writer according to the configuration and connects it to the destination database.
+func connect(ctx context.Context, conf *config2.Config) (writer target.DbWriter, err error) {
+	if conf.DBURL != "" {
+		writer = target.NewDatabaseWriterWithURL(conf.DBURL)
+	} else {
+		writer = target.NewDatabaseWriter(conf.DBHost, conf.DBPort, conf.DBName, conf.DBUser, conf.DBPassword,
+			target.SSLOptions{Mode: conf.SSLMode(), RootCert: conf.DBSSLRootCert, Cert: conf.DBSSLCert,
+				Key: conf.DBSSLKey})
+	}
+	writer.PgBouncerCompat = conf.PgBouncerCompat
+	writer.ConcurrentIndexes = conf.ConcurrentIndexes
+	writer.PoolSize = conf.DBConnections()
+	writer.MaxConns = conf.DBMaxConns
+	writer.MaxAttempts = conf.DBMaxAttempts
+	writer.ConnectRetries = conf.DBConnectRetries
+	writer.ConnectTimeout = conf.DBConnectTimeout
+	writer.SystemSchemas = slices.Sorted(maps.Keys(conf.SystemSchemas))
+	writer.TablesOnly = slices.Sorted(maps.Keys(conf.TablesOnly))
+	writer.GraphFile = conf.GraphFile
+	writer.PendingIndexesFile = conf.PendingIndexesFile
+	if conf.DBIAMAuth {
+		writer.TokenProvider = target.NewIAMTokenProvider(conf.AWS())
+	}
+	if err = writer.Connect(ctx); err != nil {
+		return writer, targetError(fmt.Errorf("error connecting to the database: %w", err))
+	}
+	return writer, nil
+}
+
+// truncateTables implements the command "truncate": it truncates all tables of the destination database
+// in the reverse order of their dependencies, without loading data.
+func truncateTables(ctx context.Context, conf *config2.Config, progress *checkpoint) error {
+	writer, err := connect(ctx, conf)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+	progress.identity.Target = writer.Identity()
+	tables, err := writer.GetTablesOrdered(ctx)
+	if err != nil {
+		return targetError(fmt.Errorf("error working with the database: %w", err))
+	}
+	return truncateAll(ctx, &writer, tables, progress.report)
+}
+
+// finishIndexes implements the command "finish-indexes": it recreates the indexes and constraints recorded
+// in the pending indexes file that are missing in the destination database, without loading data.
+func finishIndexes(ctx context.Context, conf *config2.Config) error {
+	writer, err := connect(ctx, conf)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+	created, err := writer.FinishIndexes(conf.PendingIndexesFile)
+	if err != nil {
+		return targetError(fmt.Errorf("error working with the database: %w", err))
+	}
+	log.Info("Finished the pending indexes", zap.Int("created", created))
+	return nil
+}
+
+// databaseTables returns the tables of the destination database ordered by their dependencies, without the tables
+// of the system schemas and of the extensions, and restricted by --tables-only; the reader skips the other tables
+// in the export.
+func databaseTables(ctx context.Context, writer *target.DbWriter, reader *source2.Reader) ([]string, error) {
+	tables, err := writer.GetTablesOrdered(ctx)
+	if err != nil {
+		return nil, targetError(fmt.Errorf("error working with the database: %w", err))
+	}
+	extensionTables, err := writer.GetExtensionTables()
+	if err != nil {
+		return nil, targetError(fmt.Errorf("error working with the database: %w", err))
+	}
+	if len(extensionTables) > 0 {
+		log.Info("Skipping the tables owned by extensions", zap.Strings("tables", extensionTables))
+	}
+	reader.IgnoreSystemTables(extensionTables)
+	if len(writer.TablesOnly) > 0 {
+		reader.SelectTables(tables)
+	}
+	return tables, nil
+}
+
+// truncateAll truncates the tables in the reverse order, logs the totals and records the results in the report.
+func truncateAll(ctx context.Context, writer *target.DbWriter, tables []string, report *restoreReport) error {
+	startTime := time.Now()
+	results, err := writer.TruncateAllTables(ctx, tables)
+	report.Truncation = newTruncationReport(results, time.Since(startTime))
+	if err != nil {
+		return targetError(fmt.Errorf("error truncating tables: %w", err))
+	}
+	log.Info("Truncating all tables done", zap.Int("truncatedCount", report.Truncation.Truncated),
+		zap.Int("emptyCount", report.Truncation.Empty), zap.Int64("rows", report.Truncation.Rows),
+		zap.Duration("time", time.Since(startTime)))
+	return nil
+}
+
+// truncationPlan is the selection of the tables truncated by --truncate-all (see planTruncation).
+type truncationPlan struct {
+	// truncate the tables to truncate, in the loading order
+	truncate []string
+	// notInExport the number of the tables to truncate that are missing in the export
+	notInExport int
+	// kept the tables missing in the export that keep their rows
+	kept []string
+	// cascaded the tables missing in the export that reference the truncated tables, directly or indirectly,
+	// so that TRUNCATE ... CASCADE would empty them too
+	cascaded []string
+}
+
+// planTruncation selects the tables truncated by --truncate-all: the tables loaded from the export, or all tables
+// with --truncate-target-all. The tables are in the loading order, so a table comes after the tables it references;
+// references returns the tables referenced by a table (see target.DbWriter.ReferencedTables).
+func planTruncation(tables []string, loaded map[string]source2.ParquetFileInfo, all bool,
+	references func(table string) []string) (ret truncationPlan) {
+	emptied := make(map[string]bool)
+	for _, table := range tables {
+		_, exists := loaded[table]
+		switch {
+		case exists || all:
+			ret.truncate = append(ret.truncate, table)
+			emptied[table] = true
+			if !exists {
+				ret.notInExport++
+			}
+		case slices.ContainsFunc(references(table), func(parent string) bool { return emptied[parent] }):
+			ret.cascaded = append(ret.cascaded, table)
+			emptied[table] = true
+		default:
+			ret.kept = append(ret.kept, table)
 		}
+	}
+	return ret
+}
 
-		client := s3.NewFromConfig(cfg)
+// s3Retryer creates the retryer of the S3 client: the standard one of the SDK (an exponential backoff with jitter,
+// which also retries the throttling responses) with the configured number of retries. The client-side retry quota
+// is disabled, because a large export throttled by S3 would exhaust it and fail the requests without retrying.
+func s3Retryer(maxRetries int) func() aws.Retryer {
+	return func() aws.Retryer {
+		return retry.NewStandard(func(options *retry.StandardOptions) {
+			options.MaxAttempts = maxRetries + 1
+			options.RateLimiter = ratelimit.None
+		})
+	}
+}
 
-		// Example S3 operation (list buckets)
-		output, err := client.ListBuckets(context.TODO(), &s3.ListBucketsInput{})
+// createSource creates the data source (a local folder or an S3 bucket) according to the configuration;
+// the requests of the remote sources are made with the given context.
+func createSource(ctx context.Context, conf *config2.Config) (source2.Source, error) {
+	if conf.LocalDir != "" {
+		log.Info("Using local directory: ", zap.String("dir", conf.LocalDir))
+		return source2.NewLocalSource(conf.LocalDir), nil
+	}
+	if conf.ArchivePath != "" {
+		log.Info("Using archive: ", zap.String("archive", conf.ArchivePath))
+		source, err := source2.NewArchiveSource(conf.ArchivePath, conf.TempDir, uint64(conf.MinFreeSpace))
 		if err != nil {
-			log.Fatal("failed to list buckets", zap.Error(err))
+			return nil, utils.NewFatalError(err)
 		}
-
-		log.Debug("Available S3 buckets:")
-		for _, bucket := range output.Buckets {
-			log.Debug("Bucket: ", zap.String("name", *bucket.Name))
+		return source, nil
+	}
+	if conf.GCSBucketPath != "" {
+		log.Info("Using Google Cloud Storage bucket: ", zap.String("bucket", conf.GCSBucketPath))
+		// application-default credentials
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, utils.NewFatalError(fmt.Errorf("failed to create the Google Cloud Storage client: %w", err))
+		}
+		source, err := source2.NewGCSSource(ctx, client, conf.GCSBucketPath, conf.TempDir, uint64(conf.MinFreeSpace))
+		if err != nil {
+			return nil, utils.NewFatalError(err)
 		}
-		log.Error("ERROR: S3 source not fully implemented yet")
-		return
+		return source, nil
+	}
+	log.Info("Using AWS S3 bucket: ", zap.String("bucket", conf.AWSBucketPath))
+
+	// Use credentials from configuration
+	var cfg aws.Config
+	var err error
+
+	if conf.AWSAccessKey != "" && conf.AWSSecretKey != "" {
+		// Create a credential provider with credentials from configuration
+		credentialsProvider := credentials.NewStaticCredentialsProvider(conf.AWSAccessKey,
+			conf.AWSSecretKey, "") // Last parameter is session token, usually empty
+
+		cfg, err = config.LoadDefaultConfig(ctx,
+			config.WithCredentialsProvider(credentialsProvider),
+			config.WithRegion(conf.AWSRegion), config.WithRetryer(s3Retryer(conf.S3MaxRetries)))
+	} else {
+		// Use default credentials provider chain (environment variables, shared credentials file, etc.)
+		cfg, err = config.LoadDefaultConfig(ctx, config.WithRegion(conf.AWSRegion),
+			config.WithRetryer(s3Retryer(conf.S3MaxRetries)))
+	}
+
+	if err != nil {
+		return nil, utils.NewFatalError(fmt.Errorf("failed to load AWS configuration: %w", err))
+	}
+
+	client := s3.NewFromConfig(cfg)
+	source, err := source2.NewS3Source(ctx, client, conf.AWSBucketPath, conf.TempDir, uint64(conf.MinFreeSpace))
+	if err != nil {
+		return nil, utils.NewFatalError(err)
+	}
+	source.StreamParquet = !conf.S3Download
+	if source.StreamParquet {
+		// only the metadata files are downloaded
+		return source, nil
+	}
+	err = source.CheckFreeSpace()
+	if err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// run performs a single attempt of the restore, establishing the source and the database connection from scratch.
+// Tables recorded in the checkpoint by previous attempts are not loaded again.
+// Errors that cannot be fixed by retrying are marked with utils.NewFatalError.
+// The cancellation of the context stops the restore, rolling back the table being loaded.
+func run(ctx context.Context, conf *config2.Config, progress *checkpoint) error {
+	if conf.TruncateCommand {
+		// the export is not needed
+		return truncateTables(ctx, conf, progress)
+	}
+	if conf.FinishIndexesCommand {
+		// the export is not needed
+		return finishIndexes(ctx, conf)
+	}
+
+	source, err := createSource(ctx, conf)
+	if err != nil {
+		return sourceError(err)
+	}
+	if progress.receipt != nil {
+		source = progress.receipt.Wrap(source)
 	}
 
 	reader := source2.NewSourceReader(conf, source)
@@ -72,48 +454,79 @@ func main() {
 	if conf.ListCommand {
 		err := reader.ListDatabases()
 		if err != nil {
-			log.Error("ERROR: ", zap.Error(err))
+			return sourceError(utils.NewFatalError(err))
 		}
-		return
+		return nil
+	}
+
+	if conf.DiffCommand {
+		return sourceError(diffManifest(conf, &reader))
+	}
+
+	if conf.ValidateCommand {
+		return sourceError(validateExport(conf, source, &reader))
 	}
 
-	writer := target.NewDatabaseWriter(conf.DBHost, conf.DBPort, conf.DBName, conf.DBUser, conf.DBPassword, conf.DBSSLMode)
-	err := writer.Connect()
+	if conf.ListPartsCommand {
+		return sourceError(listParts(conf, source, &reader))
+	}
+
+	if conf.GenerateDDLCommand && conf.DDLFile != "" {
+		return sourceError(generateDDL(conf, &reader, nil))
+	}
+
+	writer, err := connect(ctx, conf)
 	if err != nil {
-		log.Error("Error connecting to the database: ", zap.Error(err))
-		return
+		return err
 	}
 	defer func() {
 		writer.Close()
 	}()
 
+	if conf.GenerateDDLCommand {
+		return sourceError(generateDDL(conf, &reader, &writer))
+	}
+
+	if err := resolveSourceDatabase(conf, &reader); err != nil {
+		return sourceError(err)
+	}
+	progress.identity = checkpointIdentity{Snapshot: reader.SnapshotName(), Database: conf.SourceDatabase,
+		Target: writer.Identity()}
+
+	if conf.EstimateCommand {
+		return targetError(estimateRestore(ctx, conf, source, &reader, &writer, progress))
+	}
+
+	if conf.DryRun {
+		return targetError(planRestore(ctx, conf, source, &reader, &writer, progress))
+	}
+
+	if conf.CheckpointFile != "" {
+		if err := progress.resume(conf.CheckpointFile, progress.identity); err != nil {
+			return utils.NewFatalError(err)
+		}
+	}
+	if !conf.NoTracking {
+		if err := resumeFromRestoreLog(conf, &writer, reader.SnapshotName(), progress); err != nil {
+			return targetError(err)
+		}
+	}
+
 	// Get the list of tables from PostgreSQL database - we can only populate these tables.
 	// The order is calculated based on relations between tables and it is very important.
 	startTime := time.Now()
-	tables, err := writer.GetTablesOrdered()
+	tables, err := databaseTables(ctx, &writer, &reader)
 	if err != nil {
-		log.Error("Error working with the database: ", zap.Error(err))
-		return
+		return err
 	}
 	log.Info("Retrieved tables from the database", zap.Int("count", len(tables)),
 		zap.Duration("time", time.Since(startTime)))
 
-	if conf.TruncateAllCommand {
-		startTime2 := time.Now()
-		truncatedCount, err := writer.TruncateAllTables(tables)
-		if err != nil {
-			log.Error("Error truncating tables: ", zap.Error(err))
-			return
-		}
-		log.Info("Truncating all tables done", zap.Int("truncatedCount", truncatedCount),
-			zap.Duration("time", time.Since(startTime2)))
-	}
-
 	// Get the list of tables in Parquet files - we only have data for those tables
 	parquetTables, err := reader.IterateOverTables(tables)
 	if err != nil {
-		log.Error("ERROR: ", zap.Error(err))
-		return
+		// the export does not match the target database - retrying will not help
+		return sourceError(utils.NewFatalError(err))
 	}
 	log.Info("Parsed Parquet files", zap.Int("count", len(parquetTables)),
 		zap.Duration("time", time.Since(startTime)))
@@ -123,39 +536,254 @@ func main() {
 	for _, table := range parquetTables {
 		parquetTableMap[table.TableName] = table
 	}
-
-	// Iterate over the list of tables in the correct order and process them
+	expectedTables := make([]string, 0, len(parquetTableMap))
 	for _, table := range tables {
-		if parquetInfo, exists := parquetTableMap[table]; exists {
-			// Construct the field mapper that defines the strategy of loading this table
-			mapper, err := writer.GetFieldMapper(parquetInfo, conf)
-			if err != nil {
-				log.Error("Error mapping fields for table", zap.String("table", table), zap.Error(err))
-				continue
-			}
+		if _, exists := parquetTableMap[table]; exists {
+			expectedTables = append(expectedTables, table)
+		}
+	}
+	progress.report.expectTables(expectedTables)
 
-			if reason, skip := mapper.ShouldSkip(); skip {
-				log.Info("Skipping table", zap.String("table", table), zap.String("reason", reason))
-			} else {
-				// Write data to the corresponding database table
-				tableStartTime := time.Now()
-				recordCount, err := writer.WriteTable(source, &mapper)
-				if err != nil {
-					log.Error("Error writing data for table", zap.String("table", table), zap.Error(err))
-					break
-				}
-				duration := time.Since(tableStartTime)
-				recordsPerSecond := 0.0
-				if duration.Seconds() > 0 {
-					recordsPerSecond = float64(recordCount) / duration.Seconds()
-				} else if duration.Microseconds() > 0 {
-					recordsPerSecond = (float64(recordCount) * 1000000.0) / float64(duration.Microseconds())
-				}
-				log.Info("Loaded table data", zap.String("table", table),
-					zap.Int("records", recordCount), zap.Duration("time", duration),
-					zap.Float64("records/sec", recordsPerSecond))
-			}
+	if conf.TruncateAllCommand && len(progress.completed) > 0 {
+		// truncating again would erase the tables restored by the previous attempts
+		log.Info("Skipping truncation of all tables because the restore is resumed")
+	} else if conf.TruncateAllCommand {
+		plan := planTruncation(tables, parquetTableMap, conf.TruncateTargetAll, writer.ReferencedTables)
+		if len(plan.cascaded) > 0 {
+			return targetError(utils.NewFatalError(fmt.Errorf("the tables %s are missing in the export, "+
+				"but TRUNCATE ... CASCADE would empty them because they reference the tables loaded from it; "+
+				"use --truncate-target-all to truncate all tables", strings.Join(plan.cascaded, ", "))))
 		}
+		log.Info("Truncating the tables before loading", zap.Int("truncateCount", len(plan.truncate)),
+			zap.Int("notInExportCount", plan.notInExport), zap.Int("keptCount", len(plan.kept)))
+		if conf.TruncateTargetAll && plan.notInExport > 0 {
+			log.Warn("Truncating the tables missing in the export, they stay empty after the restore",
+				zap.Int("count", plan.notInExport))
+		}
+		if err := truncateAll(ctx, &writer, plan.truncate, progress.report); err != nil {
+			return err
+		}
+	}
+
+	// Iterate over the list of tables in the correct order and process them
+	loader := newTableLoader(ctx, conf, source, &writer, parquetTableMap, progress)
+	if conf.Jobs > 1 {
+		err = loader.loadConcurrently(tables, conf.Jobs)
+	} else {
+		err = loader.loadSequentially(tables)
+	}
+	if err != nil {
+		return err
 	}
 	log.Info("Finished processing all tables", zap.Duration("total_time", time.Since(startTime)))
+
+	if err := verifyRowCounts(conf, source, &writer, progress); err != nil {
+		return err
+	}
+
+	if conf.ManifestOutFile != "" {
+		manifest := source2.Manifest{Snapshot: reader.SnapshotName(), Database: conf.SourceDatabase,
+			Tables: progress.manifestTables}
+		if err := source2.WriteManifest(conf.ManifestOutFile, manifest); err != nil {
+			return utils.NewFatalError(err)
+		}
+		log.Info("Manifest written", zap.String("file", conf.ManifestOutFile),
+			zap.Int("tables", len(manifest.Tables)))
+	}
+	if err := loader.allIndexFailures(); err != nil {
+		// the data is loaded, only the indexes are missing: with --pending-indexes, finish-indexes creates them
+		return targetError(utils.NewFatalError(fmt.Errorf("the tables were loaded, but some indexes failed "+
+			"to build concurrently: %w", err)))
+	}
+	if len(loader.failedTables) > 0 {
+		logFailureSummary(loader.failedTables, loader.failures)
+		return failedTablesError(loader.failedTables, len(progress.completed))
+	}
+	return nil
+}
+
+// failedReference returns the first of the referenced tables that failed (see --skip-dependents).
+func failedReference(referenced []string, failures map[string]string) (string, bool) {
+	for _, table := range referenced {
+		if _, failed := failures[table]; failed {
+			return table, true
+		}
+	}
+	return "", false
+}
+
+// logFailureSummary logs the block of the tables that failed in the attempt, in the order of loading,
+// each with its error, so that they are not lost among the log lines of the other tables.
+func logFailureSummary(failedTables []string, failures map[string]string) {
+	log.Error("Some tables were not loaded", zap.Int("failed_tables", len(failedTables)))
+	for _, table := range failedTables {
+		log.Error("Failed table", zap.String("table", table), zap.String("error", failures[table]))
+	}
+}
+
+// verifyRowCounts is the final reconciliation of the restore: for every loaded table (including the tables
+// loaded by the previous attempts) it compares the rows in the table before loading plus the rows in its Parquet
+// files, except the rows dropped on purpose, with the actual number of rows, and fails if any table mismatches.
+func verifyRowCounts(conf *config2.Config, source source2.Source, writer *target.DbWriter,
+	progress *checkpoint) error {
+	checks := make([]target.RowCountCheck, 0, len(progress.manifestTables))
+	for _, table := range progress.manifestTables {
+		parquetRows, err := target.ParquetRowCount(source, conf.SourceDatabase, table.Name)
+		if err != nil {
+			return sourceError(fmt.Errorf("failed to verify the table '%s': %w", table.Name, err))
+		}
+		actual, err := writer.TableRowCount(table.Name)
+		if err != nil {
+			return targetError(err)
+		}
+		checks = append(checks, target.RowCountCheck{Table: table.Name,
+			Expected: progress.rowsBefore[table.Name] + parquetRows - progress.rowsDropped[table.Name], Actual: actual})
+	}
+	if mismatches := target.ReportRowCounts(checks, conf.OnConflictSkip); mismatches > 0 {
+		// loading the same data again would not fix the difference
+		return targetError(utils.NewFatalError(fmt.Errorf("row count verification failed for %d table(s)", mismatches)))
+	}
+	return nil
+}
+
+// resolveSourceDatabase selects the source database if it is not configured:
+// it can be skipped if there is only one database selected in the snapshot.
+func resolveSourceDatabase(conf *config2.Config, reader *source2.Reader) error {
+	if conf.SourceDatabase != "" {
+		return nil
+	}
+	databases, err := reader.Databases()
+	if err != nil {
+		return utils.NewFatalError(err)
+	}
+	if len(databases) != 1 {
+		return utils.NewFatalError(fmt.Errorf("found %d databases in the snapshot (%s), "+
+			"select one with --source-db or the database filters", len(databases), strings.Join(databases, ", ")))
+	}
+	conf.SourceDatabase = databases[0]
+	log.Info("Using the only selected database in the snapshot", zap.String("database", conf.SourceDatabase))
+	return nil
+}
+
+// diffManifest implements the command "diff": it runs only the metadata phase on the export and compares it
+// with the manifest of a previous restore, printing the human-readable difference
+// and writing the JSON difference to --diff-out.
+func diffManifest(conf *config2.Config, reader *source2.Reader) error {
+	if err := resolveSourceDatabase(conf, reader); err != nil {
+		return err
+	}
+	oldManifest, err := source2.ReadManifest(conf.ManifestFile)
+	if err != nil {
+		return utils.NewFatalError(err)
+	}
+	newManifest, err := reader.BuildManifest(conf.SourceDatabase)
+	if err != nil {
+		return err
+	}
+	diff := source2.DiffManifests(oldManifest, newManifest)
+	fmt.Print(diff.String())
+
+	if conf.DiffOutFile != "" {
+		content, err := json.MarshalIndent(diff, "", "  ")
+		if err == nil {
+			err = os.WriteFile(conf.DiffOutFile, append(content, '\n'), 0644)
+		}
+		if err != nil {
+			return utils.NewFatalError(fmt.Errorf("failed to write the difference to '%s': %w", conf.DiffOutFile, err))
+		}
+	}
+	if conf.ManifestOutFile != "" {
+		if err := source2.WriteManifest(conf.ManifestOutFile, newManifest); err != nil {
+			return utils.NewFatalError(err)
+		}
+	}
+	log.Info("Compared the export with the manifest", zap.String("manifest", conf.ManifestFile),
+		zap.Int("added_tables", len(diff.AddedTables)), zap.Int("removed_tables", len(diff.RemovedTables)),
+		zap.Int("changed_tables", len(diff.ChangedTables)))
+	return nil
+}
+
+// generateDDL generates best-effort DDL for the tables in the export (respecting the table filters)
+// and either writes it to the configured file or executes it in the destination database.
+func generateDDL(conf *config2.Config, reader *source2.Reader, writer *target.DbWriter) error {
+	tables, err := selectedExportTables(conf, reader)
+	if err != nil {
+		return err
+	}
+	statements, err := target.GenerateDDL(tables)
+	if err != nil {
+		return utils.NewFatalError(err)
+	}
+
+	if writer == nil {
+		content := strings.Join(statements, "\n\n") + "\n"
+		if err := os.WriteFile(conf.DDLFile, []byte(content), 0644); err != nil {
+			return utils.NewFatalError(fmt.Errorf("failed to write the DDL file '%s': %w", conf.DDLFile, err))
+		}
+		log.Info("Generated DDL written to the file", zap.String("file", conf.DDLFile),
+			zap.Int("tables", len(tables)))
+		return nil
+	}
+
+	if err := writer.CreateTables(statements); err != nil {
+		return targetError(utils.NewFatalError(
+			fmt.Errorf("failed to create tables in the destination database: %w", err)))
+	}
+	log.Info("Generated DDL executed in the destination database", zap.Int("tables", len(tables)))
+	return nil
+}
+
+// selectedExportTables returns the tables in the export that pass the table filters.
+func selectedExportTables(conf *config2.Config, reader *source2.Reader) (source2.ParquetFileInfoList, error) {
+	exportTables, err := reader.ReadExportTables()
+	if err != nil {
+		return nil, utils.NewFatalError(err)
+	}
+	tables := make(source2.ParquetFileInfoList, 0, len(exportTables))
+	for _, table := range exportTables {
+		found, notEmpty := conf.TableNameInSet(conf.IncludeTables, table.TableName, conf.IncludeTablesRegex)
+		if !found && notEmpty {
+			continue
+		}
+		found, notEmpty = conf.TableNameInSet(conf.ExcludeTables, table.TableName, conf.ExcludeTablesRegex)
+		if found && notEmpty {
+			continue
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// validateExport implements the command "validate": it checks the export without a database. The status
+// of the export and the table lists are checked while reading the metadata; then every selected table
+// is checked by target.ValidateTableExport and printed with its problems. It fails if anything is broken.
+func validateExport(conf *config2.Config, source source2.Source, reader *source2.Reader) error {
+	if err := resolveSourceDatabase(conf, reader); err != nil {
+		return err
+	}
+	tables, err := selectedExportTables(conf, reader)
+	if err != nil {
+		return err
+	}
+	problems, broken := 0, 0
+	for _, table := range tables {
+		result := target.ValidateTableExport(source, conf.SourceDatabase, table)
+		status := "OK"
+		if len(result.Problems) > 0 {
+			status = "BROKEN"
+			problems += len(result.Problems)
+			broken++
+		}
+		fmt.Printf("%s: %s, %d part(s), %d rows, %s\n", table.TableName, status, result.Parts, result.Rows,
+			utils.FormatByteSize(uint64(result.Size)))
+		for _, problem := range result.Problems {
+			fmt.Printf("    %s\n", problem)
+		}
+	}
+	if problems > 0 {
+		return utils.NewFatalError(fmt.Errorf("the export has %d problem(s) in %d of %d table(s)",
+			problems, broken, len(tables)))
+	}
+	fmt.Printf("The export is valid: %d table(s) in the database '%s'\n", len(tables), conf.SourceDatabase)
+	return nil
 }
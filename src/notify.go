@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"dbrestore/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Statuses of the run in the notification
+const (
+	// runSucceeded the run finished without errors
+	runSucceeded = "succeeded"
+	// runPartialFailure some tables were loaded and others failed (see exitPartialFailure)
+	runPartialFailure = "partial_failure"
+	// runFailed the run stopped with an error
+	runFailed = "failed"
+)
+
+const (
+	// notifyAttempts how many times the notification is posted: once and one retry
+	notifyAttempts = 2
+	// maxNotificationErrors the number of the first errors included in the notification
+	maxNotificationErrors = 3
+)
+
+// notifyRetryDelay the delay before retrying a failed notification (a variable, so that the tests do not wait)
+var notifyRetryDelay = 2 * time.Second
+
+// runNotification is the compact summary of the run posted to --notify-webhook when the run finishes.
+type runNotification struct {
+	// Text a one-line human-readable summary, displayed by the chat webhooks like the ones of Slack
+	Text string `json:"text"`
+	// Status one of runSucceeded, runPartialFailure or runFailed
+	Status string `json:"status"`
+	// ExitCode the exit code of the program (see exitCode)
+	ExitCode int `json:"exit_code"`
+	// checkpointIdentity the snapshot, the database and the destination of the restore, empty if not known
+	checkpointIdentity
+	// StartedAt the time when the program started
+	StartedAt time.Time `json:"started_at"`
+	// DurationSeconds the wall-clock time of the run, including all attempts
+	DurationSeconds float64 `json:"duration_seconds"`
+	// Totals the numbers of the loaded and failed tables and of the rows (see restoreReport.Totals)
+	Totals reportTotals `json:"totals"`
+	// Errors the error that stopped the run and the errors of the failed tables, at most maxNotificationErrors
+	Errors []string `json:"errors,omitempty"`
+}
+
+// newRunNotification summarizes the finished report of the run (see restoreReport.finish) for the notification.
+func newRunNotification(report *restoreReport, identity checkpointIdentity, code int) runNotification {
+	ret := runNotification{Status: runFailed, ExitCode: code, checkpointIdentity: identity,
+		StartedAt: report.StartedAt, DurationSeconds: report.DurationSeconds, Totals: report.Totals}
+	switch code {
+	case exitSuccess:
+		ret.Status = runSucceeded
+	case exitPartialFailure:
+		ret.Status = runPartialFailure
+	}
+	addError := func(message string) {
+		if message != "" && len(ret.Errors) < maxNotificationErrors && !slices.Contains(ret.Errors, message) {
+			ret.Errors = append(ret.Errors, message)
+		}
+	}
+	addError(report.Error)
+	for _, table := range report.Tables {
+		if table.Status == tableFailed && table.Error != "" {
+			addError(table.Table + ": " + table.Error)
+		}
+	}
+	ret.Text = ret.summary()
+	return ret
+}
+
+// summary returns the one-line summary of the notification, for example
+// "dbrestore succeeded: snapshot snap into db.example.com:5432/app, 12 tables loaded, 0 failed, 1000 rows in 1m5s".
+func (n *runNotification) summary() string {
+	text := "dbrestore " + n.Status
+	if n.Snapshot != "" {
+		text += ": snapshot " + n.Snapshot
+	}
+	if n.Target != "" {
+		text += " into " + n.Target
+	}
+	duration := time.Duration(n.DurationSeconds * float64(time.Second)).Round(time.Second)
+	return fmt.Sprintf("%s, %d tables loaded, %d failed, %d rows in %s", text, n.Totals.Loaded, n.Totals.Failed,
+		n.Totals.Rows, duration)
+}
+
+// notifier posts the notification of the run (see --notify-webhook); the tests replace the webhook.
+type notifier interface {
+	// notify posts the notification, or returns an error
+	notify(ctx context.Context, notification runNotification) error
+}
+
+// webhookNotifier posts the notification as JSON to a webhook.
+type webhookNotifier struct {
+	// url the URL of the webhook
+	url string
+	// client the HTTP client posting the notification
+	client *http.Client
+}
+
+// newWebhookNotifier creates a notifier posting to the webhook URL.
+func newWebhookNotifier(webhookURL string) *webhookNotifier {
+	return &webhookNotifier{url: webhookURL, client: http.DefaultClient}
+}
+
+// notify implements notifier: it posts the notification and fails unless the webhook responds with 2xx.
+// The URL is not included in the errors, because the URLs of the webhooks usually contain a secret token.
+func (n *webhookNotifier) notify(ctx context.Context, notification runNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to encode the notification: %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid notification webhook: %w", withoutURL(err))
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := n.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to post the notification: %w", withoutURL(err))
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	// read the response, so that the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("the notification webhook responded with %s", response.Status)
+	}
+	return nil
+}
+
+// withoutURL removes the URL from the error of an HTTP request (see url.Error).
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// sendNotification posts the notification with the timeout for every attempt, retrying once after a failure.
+// A failed notification is only logged: it never changes the result of the run.
+func sendNotification(n notifier, notification runNotification, timeout time.Duration) {
+	err := utils.RetryAttempts(notifyAttempts, notifyRetryDelay, func(int) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return n.notify(ctx, notification)
+	})
+	if err != nil {
+		log.Error("Failed to send the notification", zap.String("status", notification.Status), zap.Error(err))
+		return
+	}
+	log.Info("Notification sent", zap.String("status", notification.Status))
+}
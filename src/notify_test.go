@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingNotifier records the notifications and fails the first attempts with the given errors.
+type recordingNotifier struct {
+	errs          []error
+	notifications []runNotification
+}
+
+func (n *recordingNotifier) notify(_ context.Context, notification runNotification) error {
+	n.notifications = append(n.notifications, notification)
+	if len(n.notifications) <= len(n.errs) {
+		return n.errs[len(n.notifications)-1]
+	}
+	return nil
+}
+
+func TestRunNotification(t *testing.T) {
+	report := newRestoreReport()
+	report.expectTables([]string{"public.a", "public.b", "public.c", "public.d", "public.e"})
+	report.setTable(tableReport{Table: "public.a", Status: tableLoaded, Rows: 10, Files: 2})
+	report.setTable(tableReport{Table: "public.b", Status: tableFailed, Error: "bad data"})
+	report.setTable(tableReport{Table: "public.c", Status: tableFailed, Error: "connection lost"})
+	report.setTable(tableReport{Table: "public.d", Status: tableFailed, Error: "timeout"})
+	report.finish(errors.New("loading 3 tables failed: public.b, public.c, public.d"))
+	identity := checkpointIdentity{Snapshot: "snap", Database: "db", Target: "localhost:5432/app"}
+
+	notification := newRunNotification(report, identity, exitPartialFailure)
+	if notification.Status != runPartialFailure || notification.checkpointIdentity != identity {
+		t.Errorf("newRunNotification() = %+v; want a partial failure of %+v", notification, identity)
+	}
+	if notification.Totals.Loaded != 1 || notification.Totals.Failed != 3 || notification.Totals.Rows != 10 {
+		t.Errorf("Totals = %+v; want 1 loaded, 3 failed and 10 rows", notification.Totals)
+	}
+	expectedErrors := []string{"loading 3 tables failed: public.b, public.c, public.d", "public.b: bad data",
+		"public.c: connection lost"}
+	if !reflect.DeepEqual(notification.Errors, expectedErrors) {
+		t.Errorf("Errors = %q; want %q", notification.Errors, expectedErrors)
+	}
+	expectedText := "dbrestore partial_failure: snapshot snap into localhost:5432/app, 1 tables loaded, 3 failed"
+	if !strings.HasPrefix(notification.Text, expectedText) {
+		t.Errorf("Text = %q; want the prefix %q", notification.Text, expectedText)
+	}
+
+	content, err := json.Marshal(notification)
+	if err != nil {
+		t.Fatalf("json.Marshal() error: %v", err)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(content, &payload); err != nil {
+		t.Fatalf("json.Unmarshal() error: %v", err)
+	}
+	for _, key := range []string{"text", "status", "exit_code", "snapshot", "database", "target", "started_at",
+		"duration_seconds", "totals", "errors"} {
+		if _, exists := payload[key]; !exists {
+			t.Errorf("the payload %s has no %s", content, key)
+		}
+	}
+
+	succeeded := newRestoreReport()
+	succeeded.finish(nil)
+	if notification := newRunNotification(succeeded, checkpointIdentity{}, exitSuccess); notification.Status !=
+		runSucceeded || len(notification.Errors) != 0 {
+		t.Errorf("newRunNotification() of a successful run = %+v; want succeeded without errors", notification)
+	}
+	if notification := newRunNotification(succeeded, checkpointIdentity{}, exitTargetError); notification.Status !=
+		runFailed {
+		t.Errorf("newRunNotification() of a failed run = %+v; want failed", notification)
+	}
+}
+
+func TestSendNotification(t *testing.T) {
+	defer func(delay time.Duration) { notifyRetryDelay = delay }(notifyRetryDelay)
+	notifyRetryDelay = time.Millisecond
+	notification := runNotification{Status: runSucceeded}
+
+	// a failed notification is retried once
+	retried := &recordingNotifier{errs: []error{errors.New("unavailable")}}
+	sendNotification(retried, notification, time.Second)
+	if len(retried.notifications) != 2 || retried.notifications[1].Status != runSucceeded {
+		t.Errorf("sendNotification() posted %+v; want the notification posted twice", retried.notifications)
+	}
+	// and then given up
+	failing := &recordingNotifier{errs: []error{errors.New("unavailable"), errors.New("unavailable"),
+		errors.New("unavailable")}}
+	sendNotification(failing, notification, time.Second)
+	if len(failing.notifications) != notifyAttempts {
+		t.Errorf("sendNotification() posted %d times; want %d", len(failing.notifications), notifyAttempts)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var received runNotification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if received.Status == runFailed {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	webhook := newWebhookNotifier(server.URL + "/hooks/secret-token")
+	notification := runNotification{Text: "dbrestore succeeded", Status: runSucceeded,
+		checkpointIdentity: checkpointIdentity{Snapshot: "snap"}}
+	if err := webhook.notify(context.Background(), notification); err != nil {
+		t.Fatalf("notify() error: %v", err)
+	}
+	if received.Status != runSucceeded || received.Snapshot != "snap" || received.Text != notification.Text {
+		t.Errorf("the webhook received %+v; want %+v", received, notification)
+	}
+	err := webhook.notify(context.Background(), runNotification{Status: runFailed})
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("notify() = %v; want the status of the webhook", err)
+	}
+
+	server.Close()
+	err = webhook.notify(context.Background(), notification)
+	if err == nil || strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("notify() of a stopped webhook = %v; want an error without the URL", err)
+	}
+}
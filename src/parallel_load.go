@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"dbrestore/target"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// tableScheduler decides which tables can be loaded concurrently: a table is ready when all tables it references
+// by its foreign keys that come before it in the order are finished, so that the constraints are satisfied
+// while it is loaded. The ready tables are started in the order, which keeps the behavior of the sequential load
+// for the tables that depend on each other.
+type tableScheduler struct {
+	// tables the tables in the order of loading
+	tables []string
+	// waiting the number of unfinished referenced tables of each table
+	waiting map[string]int
+	// dependents the tables referencing each table, which wait for it
+	dependents map[string][]string
+	// started the tables returned by next
+	started map[string]bool
+	// finished the number of finished tables
+	finished int
+	// startOrder the tables in the order they were started, for the validation of the order
+	startOrder []string
+}
+
+// newTableScheduler creates a scheduler of the tables ordered by GetTablesOrdered; references returns
+// the tables a table references by its foreign keys (see target.DbWriter.ReferencedTables).
+func newTableScheduler(tables []string, references func(table string) []string) *tableScheduler {
+	s := &tableScheduler{tables: tables, waiting: make(map[string]int, len(tables)),
+		dependents: make(map[string][]string), started: make(map[string]bool, len(tables))}
+	position := make(map[string]int, len(tables))
+	for index, table := range tables {
+		position[table] = index
+	}
+	for index, table := range tables {
+		for _, referenced := range references(table) {
+			// only the references to the earlier tables are waited for, so that some table is always ready
+			if referencedIndex, exists := position[referenced]; exists && referencedIndex < index {
+				s.waiting[table]++
+				s.dependents[referenced] = append(s.dependents[referenced], table)
+			}
+		}
+	}
+	return s
+}
+
+// next returns the first table in the order that is ready and not started yet, and marks it started.
+func (s *tableScheduler) next() (string, bool) {
+	for _, table := range s.tables {
+		if !s.started[table] && s.waiting[table] == 0 {
+			s.started[table] = true
+			s.startOrder = append(s.startOrder, table)
+			return table, true
+		}
+	}
+	return "", false
+}
+
+// finish marks the started table finished, successfully or not, which makes the tables referencing it ready
+// once their other references are finished.
+func (s *tableScheduler) finish(table string) {
+	s.finished++
+	for _, dependent := range s.dependents[table] {
+		s.waiting[dependent]--
+	}
+}
+
+// done checks whether all tables are finished.
+func (s *tableScheduler) done() bool {
+	return s.finished == len(s.tables)
+}
+
+// loadSteps are the steps of loading a table by runScheduled: prepare and finish (or abandon, when the restore
+// stops) run on the calling goroutine, write runs on the worker with the given index.
+type loadSteps struct {
+	// prepare returns the table to load, or nil if the table is not loaded
+	prepare func(table string) *tableLoad
+	// write loads the table on the worker
+	write func(ctx context.Context, worker int, load *tableLoad)
+	// finish records the loaded table; an error stops the restore
+	finish func(load *tableLoad) error
+	// abandon records the table that failed while the restore was stopping
+	abandon func(load *tableLoad)
+}
+
+// loadConcurrently loads the tables with the given number of workers, each with its own connection to the database
+// (see config.Config.Jobs); a table is started when the tables it references are finished. The tables are prepared
+// and finished by the calling goroutine like with loadSequentially.
+func (l *tableLoader) loadConcurrently(tables []string, jobs int) error {
+	writers := make([]*target.DbWriter, 0, jobs)
+	defer func() {
+		for _, writer := range writers {
+			writer.Close()
+		}
+	}()
+	for range jobs {
+		writer, err := l.writer.Clone(l.ctx)
+		if err != nil {
+			return targetError(fmt.Errorf("error connecting to the database: %w", err))
+		}
+		writers = append(writers, writer)
+	}
+	log.Info("Loading the tables concurrently", zap.Int("jobs", jobs))
+
+	scheduler := newTableScheduler(tables, l.writer.ReferencedTables)
+	err := runScheduled(l.ctx, scheduler, jobs, loadSteps{
+		prepare: l.prepare,
+		write: func(ctx context.Context, worker int, load *tableLoad) {
+			log.Info("Loading table", zap.String("table", load.table), zap.Int("worker", worker))
+			l.write(ctx, writers[worker], load)
+		},
+		finish: l.finish,
+		abandon: func(load *tableLoad) {
+			l.progress.report.setTable(tableReport{Table: load.table, Status: tableFailed,
+				Error: errors.Join(load.countErr, load.err).Error(), DurationSeconds: load.duration.Seconds()})
+		},
+	})
+	for _, writer := range writers {
+		if indexErr := writer.IndexFailures(); indexErr != nil {
+			l.indexFailures = append(l.indexFailures, indexErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	// the scheduler must never start a table before the tables it references
+	return targetError(l.writer.ValidateTableOrder(scheduler.startOrder))
+}
+
+// runScheduled loads the tables of the scheduler with the given number of workers until all tables are finished.
+// When the restore stops because of an error or the cancellation, the tables being loaded are cancelled
+// and rolled back, while the ones committed before the cancellation are still finished.
+func runScheduled(ctx context.Context, scheduler *tableScheduler, jobs int, steps loadSteps) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	loads := make(chan *tableLoad)
+	// the workers never wait for the results, there are at most jobs of them
+	results := make(chan *tableLoad, jobs)
+	var workers sync.WaitGroup
+	for worker := range jobs {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for load := range loads {
+				steps.write(ctx, worker, load)
+				results <- load
+			}
+		}()
+	}
+	defer func() {
+		close(loads)
+		workers.Wait()
+	}()
+
+	running := 0
+	err := dispatch(ctx, scheduler, jobs, steps, loads, results, &running)
+	if err != nil {
+		cancel()
+	}
+	for ; running > 0; running-- {
+		load := <-results
+		if load.countErr != nil || load.err != nil {
+			steps.abandon(load)
+		} else if finishErr := steps.finish(load); err == nil {
+			err = finishErr
+		}
+	}
+	return err
+}
+
+// dispatch hands the ready tables to the workers and finishes the loaded ones until all tables are finished;
+// running counts the tables handed to the workers and not finished yet.
+func dispatch(ctx context.Context, scheduler *tableScheduler, jobs int, steps loadSteps, loads chan<- *tableLoad,
+	results <-chan *tableLoad, running *int) error {
+	for !scheduler.done() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if *running < jobs {
+			if table, ok := scheduler.next(); ok {
+				load := steps.prepare(table)
+				if load == nil {
+					scheduler.finish(table)
+					continue
+				}
+				// a worker is idle, so it accepts the table
+				loads <- load
+				*running++
+				continue
+			}
+		}
+		if *running == 0 {
+			return fmt.Errorf("no table is ready to be loaded, %d tables are waiting for their references",
+				len(scheduler.tables)-scheduler.finished)
+		}
+		select {
+		case load := <-results:
+			*running--
+			scheduler.finish(load.table)
+			if err := steps.finish(load); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+		}
+	}
+	return nil
+}
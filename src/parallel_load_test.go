@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"dbrestore/dag"
+	"dbrestore/target"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestGraph creates the graph of the foreign keys from the referenced tables of each table.
+func newTestGraph(t *testing.T, references map[string][]string) *dag.FKeysGraph[target.Relation] {
+	graph := dag.NewFKeysGraph[target.Relation](len(references))
+	for table, referenced := range references {
+		node, err := graph.GetOrAddNode(table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range referenced {
+			node.AddChild(name, target.Relation{})
+		}
+	}
+	for _, referenced := range references {
+		for _, name := range referenced {
+			if _, err := graph.GetOrAddNode(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	graph.CalculateInDegree()
+	return &graph
+}
+
+// testReferences are the tables referenced by the test tables: a diamond, a chain, a self-reference
+// and the independent tables.
+var testReferences = map[string][]string{
+	"public.a": nil,
+	"public.b": {"public.a"},
+	"public.c": {"public.a"},
+	"public.d": {"public.b", "public.c"},
+	"public.e": nil,
+	"public.f": {"public.f"},
+	"public.g": {"public.e"},
+	"public.h": {"public.g", "public.d"},
+	"public.i": nil,
+	"public.j": nil,
+}
+
+// referencesOf returns the tables the table references, without itself (see target.DbWriter.ReferencedTables).
+func referencesOf(table string) []string {
+	return slices.DeleteFunc(slices.Clone(testReferences[table]), func(name string) bool { return name == table })
+}
+
+// fakeLoads records the tables loaded by the fake steps of runScheduled.
+type fakeLoads struct {
+	mutex sync.Mutex
+	// finished the finished tables
+	finished map[string]bool
+	// started the tables in the order the workers started them
+	started []string
+	// running the number of the tables being loaded
+	running int
+	// maxRunning the maximum number of the tables loaded at the same time
+	maxRunning int
+	// violations the tables started before the tables they reference were finished
+	violations []string
+}
+
+// steps returns the steps loading each table for the given time; failing fails finishing the tables.
+func (f *fakeLoads) steps(delay time.Duration, failing map[string]bool) loadSteps {
+	f.finished = make(map[string]bool)
+	return loadSteps{
+		prepare: func(table string) *tableLoad { return &tableLoad{table: table} },
+		write: func(ctx context.Context, worker int, load *tableLoad) {
+			f.mutex.Lock()
+			f.started = append(f.started, load.table)
+			for _, referenced := range referencesOf(load.table) {
+				if !f.finished[referenced] {
+					f.violations = append(f.violations, load.table)
+				}
+			}
+			f.running++
+			f.maxRunning = max(f.maxRunning, f.running)
+			f.mutex.Unlock()
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				load.err = ctx.Err()
+			}
+
+			f.mutex.Lock()
+			f.running--
+			f.mutex.Unlock()
+		},
+		finish: func(load *tableLoad) error {
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+			f.finished[load.table] = true
+			if failing[load.table] {
+				return errors.New("failed")
+			}
+			return nil
+		},
+		abandon: func(load *tableLoad) {},
+	}
+}
+
+func TestRunScheduled(t *testing.T) {
+	graph := newTestGraph(t, testReferences)
+	tables := graph.TopologicalSort()
+	if err := target.ValidateTableOrder(graph, tables); err != nil {
+		t.Fatal(err)
+	}
+	for _, jobs := range []int{1, 3, 16} {
+		scheduler := newTableScheduler(tables, referencesOf)
+		var loads fakeLoads
+		if err := runScheduled(context.Background(), scheduler, jobs, loads.steps(5*time.Millisecond, nil)); err != nil {
+			t.Fatalf("jobs %d: %v", jobs, err)
+		}
+		if len(loads.violations) > 0 {
+			t.Errorf("jobs %d: started before their references were finished: %v", jobs, loads.violations)
+		}
+		if len(loads.finished) != len(tables) {
+			t.Errorf("jobs %d: finished %d tables, expected %d", jobs, len(loads.finished), len(tables))
+		}
+		if loads.maxRunning > jobs {
+			t.Errorf("jobs %d: %d tables loaded at the same time", jobs, loads.maxRunning)
+		}
+		if jobs > 1 && loads.maxRunning < 2 {
+			t.Errorf("jobs %d: the independent tables were not loaded concurrently", jobs)
+		}
+		if err := target.ValidateTableOrder(graph, scheduler.startOrder); err != nil {
+			t.Errorf("jobs %d: %v", jobs, err)
+		}
+	}
+}
+
+func TestRunScheduledSkippedTables(t *testing.T) {
+	tables := []string{"public.a", "public.b", "public.c"}
+	references := func(table string) []string {
+		return map[string][]string{"public.b": {"public.a"}, "public.c": {"public.b"}}[table]
+	}
+	var loads fakeLoads
+	steps := loads.steps(0, nil)
+	prepare := steps.prepare
+	steps.prepare = func(table string) *tableLoad {
+		if table == "public.b" {
+			// not in the export
+			return nil
+		}
+		return prepare(table)
+	}
+	if err := runScheduled(context.Background(), newTableScheduler(tables, references), 2, steps); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(loads.started, []string{"public.a", "public.c"}) {
+		t.Errorf("unexpected loaded tables %v", loads.started)
+	}
+}
+
+func TestRunScheduledStops(t *testing.T) {
+	graph := newTestGraph(t, testReferences)
+	tables := graph.TopologicalSort()
+	scheduler := newTableScheduler(tables, referencesOf)
+	var loads fakeLoads
+	err := runScheduled(context.Background(), scheduler, 2, loads.steps(time.Millisecond,
+		map[string]bool{"public.b": true}))
+	if err == nil || err.Error() != "failed" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, table := range loads.started {
+		if table == "public.d" || table == "public.h" {
+			t.Errorf("the table %s referencing the failed table was loaded", table)
+		}
+	}
+	if loads.running != 0 {
+		t.Errorf("%d tables are still loaded", loads.running)
+	}
+}
+
+func TestRunScheduledCancelled(t *testing.T) {
+	graph := newTestGraph(t, testReferences)
+	tables := graph.TopologicalSort()
+	ctx, cancel := context.WithCancel(context.Background())
+	var loads fakeLoads
+	steps := loads.steps(time.Minute, nil)
+	var abandoned []string
+	steps.abandon = func(load *tableLoad) {
+		abandoned = append(abandoned, load.table)
+	}
+	write := steps.write
+	steps.write = func(ctx context.Context, worker int, load *tableLoad) {
+		cancel()
+		write(ctx, worker, load)
+	}
+	err := runScheduled(ctx, newTableScheduler(tables, referencesOf), 3, steps)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(abandoned) == 0 || len(abandoned) != len(loads.started) {
+		t.Errorf("abandoned %v, started %v", abandoned, loads.started)
+	}
+	if len(loads.finished) != 0 {
+		t.Errorf("the cancelled tables were finished: %v", loads.finished)
+	}
+}
+
+func TestTableSchedulerWaitsForEarlierTablesOnly(t *testing.T) {
+	// the order does not satisfy the reference of public.a, which must not block it forever
+	tables := []string{"public.a", "public.b"}
+	references := func(table string) []string {
+		return map[string][]string{"public.a": {"public.b"}}[table]
+	}
+	scheduler := newTableScheduler(tables, references)
+	for _, expected := range tables {
+		table, ok := scheduler.next()
+		if !ok || table != expected {
+			t.Fatalf("next() = %s, %v, expected %s", table, ok, expected)
+		}
+	}
+	if _, ok := scheduler.next(); ok {
+		t.Error("a table was started twice")
+	}
+	scheduler.finish("public.a")
+	scheduler.finish("public.b")
+	if !scheduler.done() {
+		t.Error("the scheduler is not done")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"dbrestore/target"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// Statuses of the tables in the report
+const (
+	// tableLoaded the table was loaded
+	tableLoaded = "loaded"
+	// tableSkipped the table was skipped (see tableReport.Reason)
+	tableSkipped = "skipped"
+	// tableFailed loading the table failed (see tableReport.Error)
+	tableFailed = "failed"
+	// tableSampled a sample of the table was loaded into a temporary table by the command "estimate"
+	tableSampled = "sampled"
+	// tablePlanned the table would be loaded, reported by --dry-run
+	tablePlanned = "planned"
+	// tableTruncated the table was truncated (see truncationReport)
+	tableTruncated = "truncated"
+	// tableEmpty the table was empty and was not truncated (see truncationReport)
+	tableEmpty = "empty"
+	// tableNotAttempted the restore stopped before reaching the table
+	tableNotAttempted = "not_attempted"
+)
+
+// restoreReport is the machine-readable summary of the restore written to --report-file.
+type restoreReport struct {
+	// StartedAt the time when the program started
+	StartedAt time.Time `json:"started_at"`
+	// FinishedAt the time when the restore finished
+	FinishedAt time.Time `json:"finished_at"`
+	// DurationSeconds the wall-clock time of the restore, including all attempts
+	DurationSeconds float64 `json:"duration_seconds"`
+	// Succeeded indicates that the restore finished without errors
+	Succeeded bool `json:"succeeded"`
+	// Error the error that stopped the restore, if any
+	Error string `json:"error,omitempty"`
+	// Tables the results of the tables in the processing order
+	Tables []tableReport `json:"tables"`
+	// Totals the totals over all tables
+	Totals reportTotals `json:"totals"`
+	// Estimate the extrapolated duration of the restore, written by the command "estimate"
+	Estimate *estimateReport `json:"estimate,omitempty"`
+	// Truncation the truncation of the tables by the command "truncate" or --truncate-all
+	Truncation *truncationReport `json:"truncation,omitempty"`
+
+	// expected the tables of the export that the restore is going to process, in the processing order;
+	// the tables without a result are reported as tableNotAttempted (see expectTables)
+	expected []string
+}
+
+// tableReport is the result of a single table in the restoreReport.
+type tableReport struct {
+	// Table the table name including the schema name
+	Table string `json:"table"`
+	// Status one of tableLoaded, tableSkipped, tableFailed, tableNotAttempted, tableSampled or tablePlanned
+	Status string `json:"status"`
+	// Reason the reason why the table was skipped
+	Reason string `json:"reason,omitempty"`
+	// Error the error of a failed table
+	Error string `json:"error,omitempty"`
+	// Rows the number of rows copied into the table
+	Rows int64 `json:"rows"`
+	// Files the number of Parquet part files loaded into the table
+	Files int64 `json:"files"`
+	// Accounting the rows of a loaded table on their way from the Parquet files into the table
+	Accounting *target.RowAccounting `json:"accounting,omitempty"`
+	// DurationSeconds the time of loading the table
+	DurationSeconds float64 `json:"duration_seconds"`
+	// RecordsPerSecond the loading speed
+	RecordsPerSecond float64 `json:"records_per_second"`
+}
+
+// reportTotals are the totals over all tables in the restoreReport.
+type reportTotals struct {
+	// Loaded the number of loaded tables
+	Loaded int `json:"loaded"`
+	// Skipped the number of skipped tables
+	Skipped int `json:"skipped"`
+	// Failed the number of failed tables
+	Failed int `json:"failed"`
+	// NotAttempted the number of tables that the restore did not reach
+	NotAttempted int `json:"not_attempted"`
+	// Sampled the number of tables sampled by the command "estimate"
+	Sampled int `json:"sampled,omitempty"`
+	// Planned the number of tables that would be loaded, reported by --dry-run
+	Planned int `json:"planned,omitempty"`
+	// Rows the number of rows copied into all tables (the samples and the planned tables are not counted)
+	Rows int64 `json:"rows"`
+	// Files the number of Parquet part files loaded into all tables
+	Files int64 `json:"files"`
+	// RecordsPerSecond the average loading speed over the wall-clock time
+	RecordsPerSecond float64 `json:"records_per_second"`
+}
+
+// estimateReport is the extrapolated duration of the restore in the restoreReport (see target.RestoreEstimate).
+type estimateReport struct {
+	// DurationSeconds the expected duration of the restore
+	DurationSeconds float64 `json:"duration_seconds"`
+	// MinDurationSeconds the lower bound of the duration
+	MinDurationSeconds float64 `json:"min_duration_seconds"`
+	// MaxDurationSeconds the upper bound of the duration
+	MaxDurationSeconds float64 `json:"max_duration_seconds"`
+	// Bytes the size of the Parquet files of all tables to restore
+	Bytes int64 `json:"bytes"`
+	// Rows the number of rows of all tables to restore
+	Rows int64 `json:"rows"`
+}
+
+// truncationReport is the truncation of the tables in the restoreReport, separate from loading them.
+type truncationReport struct {
+	// Tables the results of the tables in the order of truncation
+	Tables []truncatedTableReport `json:"tables"`
+	// Truncated the number of truncated tables
+	Truncated int `json:"truncated"`
+	// Empty the number of tables that were already empty
+	Empty int `json:"empty"`
+	// Rows the number of rows removed from all tables, including the estimated ones
+	Rows int64 `json:"rows"`
+	// DurationSeconds the time of truncating all tables
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// truncatedTableReport is the result of a single table in the truncationReport.
+type truncatedTableReport struct {
+	// Table the table name including the schema name
+	Table string `json:"table"`
+	// Status either tableTruncated or tableEmpty
+	Status string `json:"status"`
+	// Rows the number of rows removed from the table
+	Rows int64 `json:"rows"`
+	// RowsEstimated indicates that Rows is estimated from the planner statistics (see target.TruncateResult)
+	RowsEstimated bool `json:"rows_estimated,omitempty"`
+	// DurationSeconds the time of truncating the table
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// newTruncationReport summarizes the results of truncating the tables, which took the given time.
+func newTruncationReport(results []target.TruncateResult, duration time.Duration) *truncationReport {
+	ret := &truncationReport{Tables: make([]truncatedTableReport, 0, len(results)),
+		DurationSeconds: duration.Seconds()}
+	for _, result := range results {
+		table := truncatedTableReport{Table: result.Table, Status: tableEmpty, Rows: result.Rows,
+			RowsEstimated: result.RowsEstimated, DurationSeconds: result.Duration.Seconds()}
+		if result.Truncated {
+			table.Status = tableTruncated
+			ret.Truncated++
+		} else {
+			ret.Empty++
+		}
+		ret.Rows += result.Rows
+		ret.Tables = append(ret.Tables, table)
+	}
+	return ret
+}
+
+// newRestoreReport creates an empty report of a restore started now.
+func newRestoreReport() *restoreReport {
+	return &restoreReport{StartedAt: time.Now()}
+}
+
+// setTable records the result of a table; the result of a previous attempt for the same table is replaced.
+func (r *restoreReport) setTable(result tableReport) {
+	for i := range r.Tables {
+		if r.Tables[i].Table == result.Table {
+			r.Tables[i] = result
+			return
+		}
+	}
+	r.Tables = append(r.Tables, result)
+}
+
+// expectTables records the tables that the restore is going to process; the tables without a result
+// when the report is finished are reported as not attempted. Every attempt replaces the list.
+func (r *restoreReport) expectTables(tables []string) {
+	r.expected = tables
+}
+
+// finish completes the report with the final error of the restore (or nil) and calculates the totals.
+func (r *restoreReport) finish(err error) {
+	r.FinishedAt = time.Now()
+	r.DurationSeconds = r.FinishedAt.Sub(r.StartedAt).Seconds()
+	r.Succeeded = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+	for _, table := range r.expected {
+		if !slices.ContainsFunc(r.Tables, func(result tableReport) bool { return result.Table == table }) {
+			r.Tables = append(r.Tables, tableReport{Table: table, Status: tableNotAttempted})
+		}
+	}
+	r.Totals = reportTotals{}
+	for _, table := range r.Tables {
+		switch table.Status {
+		case tableLoaded:
+			r.Totals.Loaded++
+		case tableSkipped:
+			r.Totals.Skipped++
+		case tableFailed:
+			r.Totals.Failed++
+		case tableNotAttempted:
+			r.Totals.NotAttempted++
+		case tableSampled:
+			r.Totals.Sampled++
+			continue
+		case tablePlanned:
+			r.Totals.Planned++
+			continue
+		}
+		r.Totals.Rows += table.Rows
+		r.Totals.Files += table.Files
+	}
+	if r.DurationSeconds > 0 {
+		r.Totals.RecordsPerSecond = float64(r.Totals.Rows) / r.DurationSeconds
+	}
+}
+
+// write writes the report to a JSON file.
+func (r *restoreReport) write(fileName string) error {
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err == nil {
+		err = os.WriteFile(fileName, append(content, '\n'), 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the report to '%s': %w", fileName, err)
+	}
+	return nil
+}
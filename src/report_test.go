@@ -0,0 +1,83 @@
+package main
+
+import (
+	"dbrestore/target"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRestoreReport(t *testing.T) {
+	report := newRestoreReport()
+	report.expectTables([]string{"public.a", "public.b", "public.c", "public.d"})
+	report.setTable(tableReport{Table: "public.a", Status: tableFailed, Error: "connection lost"})
+	report.setTable(tableReport{Table: "public.b", Status: tableSkipped, Reason: "not empty"})
+	// the next attempt loads the table that failed before
+	report.setTable(tableReport{Table: "public.a", Status: tableLoaded, Rows: 10, Files: 2,
+		DurationSeconds: 1})
+	report.setTable(tableReport{Table: "public.c", Status: tableFailed, Error: "bad data"})
+	report.finish(errors.New("bad data"))
+
+	fileName := filepath.Join(t.TempDir(), "report.json")
+	if err := report.write(fileName); err != nil {
+		t.Fatalf("write() error: %v", err)
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Failed to read the report: %v", err)
+	}
+	var result restoreReport
+	if err := json.Unmarshal(content, &result); err != nil {
+		t.Fatalf("Failed to parse the report: %v", err)
+	}
+
+	if result.Succeeded || result.Error != "bad data" || len(result.Tables) != 4 {
+		t.Errorf("Succeeded, Error, len(Tables) = %v, %s, %d; want false, bad data, 4",
+			result.Succeeded, result.Error, len(result.Tables))
+	}
+	if result.Tables[0].Table != "public.a" || result.Tables[0].Status != tableLoaded || result.Tables[0].Error != "" {
+		t.Errorf("Tables[0] = %+v; want public.a loaded by the next attempt", result.Tables[0])
+	}
+	if result.Tables[3].Table != "public.d" || result.Tables[3].Status != tableNotAttempted {
+		t.Errorf("Tables[3] = %+v; want public.d not attempted after the failure", result.Tables[3])
+	}
+	expected := reportTotals{Loaded: 1, Skipped: 1, Failed: 1, NotAttempted: 1, Rows: 10, Files: 2}
+	result.Totals.RecordsPerSecond = 0
+	if result.Totals != expected {
+		t.Errorf("Totals = %+v; want %+v", result.Totals, expected)
+	}
+}
+
+func TestTruncationReport(t *testing.T) {
+	results := []target.TruncateResult{
+		{Table: "public.c", Truncated: true, Rows: 10, Duration: time.Second},
+		{Table: "public.b", Truncated: false, Duration: time.Millisecond},
+		{Table: "public.a", Truncated: true, Rows: 1000000, RowsEstimated: true, Duration: 3 * time.Second},
+	}
+	report := newTruncationReport(results, 5*time.Second)
+	if report.Truncated != 2 || report.Empty != 1 || report.Rows != 1000010 || report.DurationSeconds != 5 {
+		t.Errorf("Truncated, Empty, Rows, DurationSeconds = %d, %d, %d, %v; want 2, 1, 1000010, 5",
+			report.Truncated, report.Empty, report.Rows, report.DurationSeconds)
+	}
+	expected := []truncatedTableReport{
+		{Table: "public.c", Status: tableTruncated, Rows: 10, DurationSeconds: 1},
+		{Table: "public.b", Status: tableEmpty, DurationSeconds: 0.001},
+		{Table: "public.a", Status: tableTruncated, Rows: 1000000, RowsEstimated: true, DurationSeconds: 3},
+	}
+	if !reflect.DeepEqual(report.Tables, expected) {
+		t.Errorf("Tables = %+v; want %+v", report.Tables, expected)
+	}
+
+	// the truncation is reported separately from the loaded tables
+	restore := newRestoreReport()
+	restore.Truncation = report
+	restore.setTable(tableReport{Table: "public.a", Status: tableLoaded, Rows: 5})
+	restore.finish(nil)
+	if restore.Totals.Rows != 5 || restore.Totals.Loaded != 1 {
+		t.Errorf("Totals = %+v; want 5 rows of 1 loaded table", restore.Totals)
+	}
+}
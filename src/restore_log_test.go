@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	config2 "dbrestore/config"
+	"dbrestore/testdb"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestResumeFromRestoreLog(t *testing.T) {
+	testdb.WithTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE golden_parent (id BIGINT PRIMARY KEY, name TEXT);
+			CREATE TABLE golden_child (id BIGINT PRIMARY KEY, parent_id BIGINT REFERENCES golden_parent (id));`)
+		if err != nil {
+			t.Fatalf("Failed to create the tables: %v", err)
+		}
+		root := writeExportFixture(t, t.TempDir())
+		restore := func(resume bool) (*checkpoint, error) {
+			conf := &config2.Config{LocalDir: root, SourceDatabase: "db", DBURL: connectionString,
+				ParquetBatchSize: 1000, UnknownTypeFallback: config2.UnknownTypeString,
+				CopyCountMismatch: config2.CopyCountMismatchError, TableTimeoutAction: config2.TableTimeoutAbort,
+				Resume: resume}
+			progress := newCheckpoint()
+			return progress, run(context.Background(), conf, progress)
+		}
+
+		if _, err := restore(false); err != nil {
+			t.Fatalf("run() error: %v", err)
+		}
+		var files, tables int
+		err = db.QueryRow(context.Background(), `SELECT count(*), count(DISTINCT table_name)
+			FROM dbrestore.restore_log WHERE status = 'loaded'`).Scan(&files, &tables)
+		if err != nil {
+			t.Fatalf("Failed to read the restore log: %v", err)
+		}
+		if files == 0 || tables != 2 {
+			t.Errorf("the restore log has %d files of %d tables; want the files of 2 tables", files, tables)
+		}
+
+		// loading the tables again would violate their primary keys
+		progress, err := restore(true)
+		if err != nil {
+			t.Fatalf("run() with --resume error: %v", err)
+		}
+		for _, table := range []string{"public.golden_parent", "public.golden_child"} {
+			if !progress.isCompleted(table) {
+				t.Errorf("run() with --resume did not skip %s", table)
+			}
+		}
+		var rows int64
+		if err := db.QueryRow(context.Background(), "SELECT count(*) FROM golden_parent").Scan(&rows); err != nil {
+			t.Fatalf("Failed to count the rows: %v", err)
+		}
+		if rows != 3 {
+			t.Errorf("the rows of golden_parent = %d; want 3", rows)
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package source
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Manifest describes the tables of an export: their columns and row counts.
+// It is written by a restore (see --manifest-out) and compared with another export by the "diff" command.
+type Manifest struct {
+	// Snapshot the name of the exported snapshot
+	Snapshot string `json:"snapshot"`
+	// Database the name of the database in the snapshot
+	Database string `json:"database"`
+	// Tables the tables of the export, ordered by name
+	Tables []ManifestTable `json:"tables"`
+}
+
+// ManifestTable describes a single table in a Manifest.
+type ManifestTable struct {
+	// Name the table name including the schema name
+	Name string `json:"name"`
+	// Rows the number of rows in the export
+	Rows int64 `json:"rows"`
+	// Columns the columns of the table in the export order
+	Columns []ManifestColumn `json:"columns"`
+}
+
+// ManifestColumn describes a single column of a ManifestTable.
+type ManifestColumn struct {
+	// Name the column name
+	Name string `json:"name"`
+	// Type the original PostgreSQL type of the column
+	Type string `json:"type"`
+}
+
+// NewManifestTable creates a ManifestTable from the export metadata of the table and its row count.
+func NewManifestTable(info ParquetFileInfo, rows int64) ManifestTable {
+	columns := make([]ManifestColumn, 0, len(info.Columns))
+	for _, column := range info.Columns {
+		columns = append(columns, ManifestColumn{Name: column.ColumnName, Type: column.OriginalType})
+	}
+	return ManifestTable{Name: info.TableName, Rows: rows, Columns: columns}
+}
+
+// ReadManifest reads a manifest from a JSON file.
+func ReadManifest(fileName string) (ret Manifest, err error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return ret, fmt.Errorf("failed to read the manifest '%s': %w", fileName, err)
+	}
+	if err = json.Unmarshal(content, &ret); err != nil {
+		return ret, fmt.Errorf("failed to parse the manifest '%s': %w", fileName, err)
+	}
+	return ret, nil
+}
+
+// WriteManifest writes the manifest to a JSON file, with the tables ordered by name.
+func WriteManifest(fileName string, manifest Manifest) error {
+	manifest.Tables = slices.Clone(manifest.Tables)
+	sort.Slice(manifest.Tables, func(i, j int) bool {
+		return manifest.Tables[i].Name < manifest.Tables[j].Name
+	})
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(fileName, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write the manifest '%s': %w", fileName, err)
+	}
+	return nil
+}
+
+// BuildManifest runs only the metadata phase on the export: it parses the export metadata and reads the row counts
+// from the footers of the Parquet files of the given database, without reading the data.
+// The tables are filtered according to the table filters in the configuration.
+func (r *Reader) BuildManifest(database string) (ret Manifest, err error) {
+	tables, err := r.ReadExportTables()
+	if err != nil {
+		return ret, err
+	}
+	ret = Manifest{Snapshot: r.source.getSnapshotName(), Database: database}
+	for _, table := range tables {
+		found, notEmpty := r.config.TableNameInSet(r.config.IncludeTables, table.TableName,
+			r.config.IncludeTablesRegex)
+		if !found && notEmpty {
+			continue
+		}
+		found, notEmpty = r.config.TableNameInSet(r.config.ExcludeTables, table.TableName,
+			r.config.ExcludeTablesRegex)
+		if found && notEmpty {
+			continue
+		}
+		rows, err := r.exportedRowCount(database, table.TableName)
+		if err != nil {
+			return ret, err
+		}
+		ret.Tables = append(ret.Tables, NewManifestTable(table, rows))
+	}
+	return ret, nil
+}
+
+// exportedRowCount sums the row counts in the footers of all Parquet files of the table.
+func (r *Reader) exportedRowCount(database string, tableName string) (ret int64, err error) {
+	files, err := r.source.ListFilesRecursively(path.Join(database, tableName))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list the files of the table '%s': %w", tableName, err)
+	}
+	for _, relativePath := range files {
+		if !strings.HasSuffix(relativePath, ".parquet") {
+			continue
+		}
+		file := r.source.GetFileRanged(relativePath)
+		if !file.IsValid() {
+			return 0, fmt.Errorf("failed to get the file '%s'", relativePath)
+		}
+		rows, err := ParquetRowCount(file)
+		r.source.Dispose(file)
+		if err != nil {
+			return 0, err
+		}
+		ret += rows
+	}
+	return ret, nil
+}
+
+// ManifestDiff is the difference between two manifests.
+type ManifestDiff struct {
+	// AddedTables the tables present only in the new manifest
+	AddedTables []string `json:"addedTables"`
+	// RemovedTables the tables present only in the old manifest
+	RemovedTables []string `json:"removedTables"`
+	// ChangedTables the tables present in both manifests with different columns or row counts
+	ChangedTables []TableDiff `json:"changedTables"`
+}
+
+// TableDiff is the difference of a single table between two manifests.
+type TableDiff struct {
+	// Name the table name including the schema name
+	Name string `json:"name"`
+	// AddedColumns the columns present only in the new manifest
+	AddedColumns []string `json:"addedColumns,omitempty"`
+	// RemovedColumns the columns present only in the old manifest
+	RemovedColumns []string `json:"removedColumns,omitempty"`
+	// ChangedColumns the columns whose type changed, in the form "name: old type -> new type"
+	ChangedColumns []string `json:"changedColumns,omitempty"`
+	// OldRows the number of rows in the old manifest
+	OldRows int64 `json:"oldRows"`
+	// NewRows the number of rows in the new manifest
+	NewRows int64 `json:"newRows"`
+}
+
+// DiffManifests compares two manifests: added and removed tables, column changes and row count drift.
+func DiffManifests(oldManifest Manifest, newManifest Manifest) (ret ManifestDiff) {
+	oldTables := make(map[string]ManifestTable)
+	for _, table := range oldManifest.Tables {
+		oldTables[table.Name] = table
+	}
+	newTables := make(map[string]ManifestTable)
+	for _, table := range newManifest.Tables {
+		newTables[table.Name] = table
+		oldTable, exists := oldTables[table.Name]
+		if !exists {
+			ret.AddedTables = append(ret.AddedTables, table.Name)
+		} else if tableDiff, changed := diffTables(oldTable, table); changed {
+			ret.ChangedTables = append(ret.ChangedTables, tableDiff)
+		}
+	}
+	for _, table := range oldManifest.Tables {
+		if _, exists := newTables[table.Name]; !exists {
+			ret.RemovedTables = append(ret.RemovedTables, table.Name)
+		}
+	}
+	slices.Sort(ret.AddedTables)
+	slices.Sort(ret.RemovedTables)
+	sort.Slice(ret.ChangedTables, func(i, j int) bool {
+		return ret.ChangedTables[i].Name < ret.ChangedTables[j].Name
+	})
+	return ret
+}
+
+// diffTables compares the columns and the row counts of a table in two manifests.
+func diffTables(oldTable ManifestTable, newTable ManifestTable) (ret TableDiff, changed bool) {
+	ret = TableDiff{Name: newTable.Name, OldRows: oldTable.Rows, NewRows: newTable.Rows}
+	oldColumns := make(map[string]string)
+	for _, column := range oldTable.Columns {
+		oldColumns[column.Name] = column.Type
+	}
+	newColumns := make(map[string]string)
+	for _, column := range newTable.Columns {
+		newColumns[column.Name] = column.Type
+		oldType, exists := oldColumns[column.Name]
+		if !exists {
+			ret.AddedColumns = append(ret.AddedColumns, column.Name)
+		} else if oldType != column.Type {
+			ret.ChangedColumns = append(ret.ChangedColumns,
+				fmt.Sprintf("%s: %s -> %s", column.Name, oldType, column.Type))
+		}
+	}
+	for _, column := range oldTable.Columns {
+		if _, exists := newColumns[column.Name]; !exists {
+			ret.RemovedColumns = append(ret.RemovedColumns, column.Name)
+		}
+	}
+	changed = len(ret.AddedColumns) > 0 || len(ret.RemovedColumns) > 0 || len(ret.ChangedColumns) > 0 ||
+		ret.OldRows != ret.NewRows
+	return ret, changed
+}
+
+// IsEmpty checks whether the manifests are equal.
+func (d ManifestDiff) IsEmpty() bool {
+	return len(d.AddedTables) == 0 && len(d.RemovedTables) == 0 && len(d.ChangedTables) == 0
+}
+
+// String returns the human-readable representation of the difference.
+func (d ManifestDiff) String() string {
+	if d.IsEmpty() {
+		return "No differences found\n"
+	}
+	buf := &strings.Builder{}
+	for _, table := range d.AddedTables {
+		buf.WriteString(fmt.Sprintf("+ table %s\n", table))
+	}
+	for _, table := range d.RemovedTables {
+		buf.WriteString(fmt.Sprintf("- table %s\n", table))
+	}
+	for _, table := range d.ChangedTables {
+		buf.WriteString(fmt.Sprintf("~ table %s\n", table.Name))
+		for _, column := range table.AddedColumns {
+			buf.WriteString(fmt.Sprintf("    + column %s\n", column))
+		}
+		for _, column := range table.RemovedColumns {
+			buf.WriteString(fmt.Sprintf("    - column %s\n", column))
+		}
+		for _, column := range table.ChangedColumns {
+			buf.WriteString(fmt.Sprintf("    ~ column %s\n", column))
+		}
+		if table.OldRows != table.NewRows {
+			buf.WriteString(fmt.Sprintf("    ~ rows %d -> %d (%+d)\n", table.OldRows, table.NewRows,
+				table.NewRows-table.OldRows))
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,67 @@
+package source
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiffManifests(t *testing.T) {
+	oldManifest, err := ReadManifest(filepath.Join("testdata", "manifest_old.json"))
+	if err != nil {
+		t.Fatalf("ReadManifest() error: %v", err)
+	}
+	newManifest, err := ReadManifest(filepath.Join("testdata", "manifest_new.json"))
+	if err != nil {
+		t.Fatalf("ReadManifest() error: %v", err)
+	}
+
+	diff := DiffManifests(oldManifest, newManifest)
+	expected := ManifestDiff{
+		RemovedTables: []string{"public.legacy"},
+		ChangedTables: []TableDiff{{
+			Name:           "public.orders",
+			AddedColumns:   []string{"note"},
+			ChangedColumns: []string{"amount: numeric -> double precision"},
+			OldRows:        100,
+			NewRows:        120,
+		}},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("DiffManifests() = %+v; want %+v", diff, expected)
+	}
+	expectedText := "- table public.legacy\n" +
+		"~ table public.orders\n" +
+		"    + column note\n" +
+		"    ~ column amount: numeric -> double precision\n" +
+		"    ~ rows 100 -> 120 (+20)\n"
+	if diff.String() != expectedText {
+		t.Errorf("String() = %q; want %q", diff.String(), expectedText)
+	}
+
+	if !DiffManifests(newManifest, newManifest).IsEmpty() {
+		t.Errorf("DiffManifests() of equal manifests is not empty")
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	manifest := Manifest{Snapshot: "s", Database: "d", Tables: []ManifestTable{
+		NewManifestTable(ParquetFileInfo{TableName: "public.b",
+			Columns: []ColumnInfo{{ColumnName: "id", OriginalType: "bigint"}}}, 3),
+		NewManifestTable(ParquetFileInfo{TableName: "public.a"}, 0),
+	}}
+	fileName := filepath.Join(t.TempDir(), "manifest.json")
+	if err := WriteManifest(fileName, manifest); err != nil {
+		t.Fatalf("WriteManifest() error: %v", err)
+	}
+	result, err := ReadManifest(fileName)
+	if err != nil {
+		t.Fatalf("ReadManifest() error: %v", err)
+	}
+	if result.Tables[0].Name != "public.a" || result.Tables[1].Rows != 3 {
+		t.Errorf("ReadManifest() = %+v", result)
+	}
+	if !DiffManifests(manifest, result).IsEmpty() {
+		t.Errorf("The manifest changed after writing and reading: %+v", DiffManifests(manifest, result))
+	}
+}
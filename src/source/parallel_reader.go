@@ -0,0 +1,274 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ParallelReader reads several Parquet files concurrently with a number of producer goroutines and serves
+// their rows to a single consumer. It implements the interface pgx.CopyFromSource, so that CPU-bound parsing
+// of Parquet files can be balanced with IO-bound writing of a single COPY into the database.
+// The rows of different files are interleaved in arbitrary order.
+type ParallelReader struct {
+	// src the data source of the files; every file is requested and disposed of by the producer reading it
+	src Source
+
+	// relativePaths the paths of the Parquet files relative to src
+	relativePaths []string
+
+	// mapper is the transformer used to map Parquet fields to the target table; it must be safe for concurrent use
+	mapper Transformer
+
+	// Producers is the number of goroutines reading Parquet files concurrently.
+	Producers int
+
+	// BatchSize is the number of rows read from a Parquet file with a single ReadRows call.
+	BatchSize int
+
+	// channel receives batches of rows from all producers; it is closed when all producers are finished
+	channel chan []NextRow
+
+	// done is closed by Close to stop the producers when the consumer stops early
+	done chan struct{}
+
+	// ctx stops the producers when cancelled, and Next reports its error
+	ctx context.Context
+
+	// closeOnce makes Close idempotent
+	closeOnce sync.Once
+
+	// started indicates that the producers were started by the first call to Next
+	started bool
+
+	// startTime the time when the producers were started
+	startTime time.Time
+
+	// batch is the current batch of rows received from the channel and served one by one by Next
+	batch []NextRow
+
+	// nextRow the data of the current row
+	nextRow []any
+
+	// lastError the most recent error encountered while reading, or nil
+	lastError error
+
+	// rowCounter the number of rows served to the consumer
+	rowCounter int64
+
+	// rowCount the total number of rows in the files opened by the producers
+	rowCount atomic.Int64
+
+	// dataSize the total uncompressed size of the files opened by the producers in bytes (see RowSize)
+	dataSize atomic.Int64
+
+	// producersBlocked the total time in nanoseconds the producers waited for the consumer to take their batches
+	producersBlocked atomic.Int64
+
+	// consumerWait the total time the consumer waited for the producers
+	consumerWait time.Duration
+}
+
+// NewParallelReader creates a reader of the given Parquet files with the given number of producer goroutines;
+// reading stops when the context is cancelled.
+func NewParallelReader(ctx context.Context, src Source, relativePaths []string, transformer Transformer,
+	producers int) *ParallelReader {
+	return &ParallelReader{
+		ctx:           ctx,
+		src:           src,
+		relativePaths: relativePaths,
+		mapper:        transformer,
+		Producers:     max(producers, 1),
+		BatchSize:     DefaultBatchSize,
+		channel:       make(chan []NextRow),
+		done:          make(chan struct{}),
+	}
+}
+
+// Next advances to the next row, starting the producers on the first call; it returns false at the end
+// of all files or on an error. It implements the interface pgx.CopyFromSource
+func (r *ParallelReader) Next() bool {
+	if !r.started {
+		r.started = true
+		r.start()
+	}
+	if r.lastError != nil {
+		return false
+	}
+	if len(r.batch) == 0 {
+		waitStart := time.Now()
+		if err := r.ctx.Err(); err != nil {
+			r.lastError = err
+			return false
+		}
+		var batch []NextRow
+		var ok bool
+		select {
+		case batch, ok = <-r.channel:
+		case <-r.ctx.Done():
+			r.lastError = r.ctx.Err()
+			return false
+		}
+		r.consumerWait += time.Since(waitStart)
+		if !ok {
+			// the producers also stop on the cancellation, which must not look like the end of the files
+			r.lastError = r.ctx.Err()
+			r.logRatio()
+			return false
+		}
+		r.batch = batch
+	}
+	data := r.batch[0]
+	r.batch = r.batch[1:]
+	if data.err != nil {
+		r.lastError = data.err
+		return false
+	}
+	r.nextRow = data.row
+	r.rowCounter++
+	return true
+}
+
+// Values returns the values of the current row. It implements the interface pgx.CopyFromSource
+func (r *ParallelReader) Values() ([]any, error) {
+	if r.lastError != nil {
+		return nil, r.lastError
+	}
+	return r.nextRow, nil
+}
+
+// Err returns the last error encountered while reading. It implements the interface pgx.CopyFromSource
+func (r *ParallelReader) Err() error {
+	return r.lastError
+}
+
+// Close stops the producers if the consumer did not read all rows; it must be called when the reader is not needed.
+func (r *ParallelReader) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+}
+
+// RowCount returns the total number of rows in the Parquet files; it is final only after all rows were read.
+func (r *ParallelReader) RowCount() int64 {
+	return r.rowCount.Load()
+}
+
+// RowsRead returns the number of rows that were read from the Parquet files and handed over to the consumer.
+func (r *ParallelReader) RowsRead() int64 {
+	return r.rowCounter
+}
+
+// start launches the producers and a goroutine that distributes the files among them
+// and closes the channel when all of them are finished.
+func (r *ParallelReader) start() {
+	r.startTime = time.Now()
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for range r.Producers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for relativePath := range jobs {
+				if !r.readFile(relativePath) {
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+	distribute:
+		for _, relativePath := range r.relativePaths {
+			select {
+			case jobs <- relativePath:
+			case <-r.done:
+				break distribute
+			case <-r.ctx.Done():
+				break distribute
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(r.channel)
+	}()
+}
+
+// readFile reads a single Parquet file and sends its rows to the consumer;
+// it returns false if the reading failed or was stopped.
+func (r *ParallelReader) readFile(relativePath string) bool {
+	file := r.src.GetFile(relativePath)
+	if !file.IsValid() {
+		r.send([]NextRow{{err: fmt.Errorf("failed to get the file '%s'", relativePath)}})
+		return false
+	}
+	defer r.src.Dispose(file)
+
+	reader := NewParquetReader(r.ctx, file, r.mapper)
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Error("ERROR: ", zap.Error(err))
+		}
+	}()
+	if err := reader.Open(file); err != nil {
+		r.send([]NextRow{{err: err}})
+		return false
+	}
+	r.rowCount.Add(reader.RowCount())
+	r.dataSize.Add(reader.dataSize)
+
+	ok := true
+	reader.readBatches(max(r.BatchSize, 1), func(batch []NextRow) bool {
+		if batch[len(batch)-1].err != nil {
+			ok = false
+		}
+		ok = r.send(batch) && ok
+		return ok
+	})
+	return ok
+}
+
+// send passes a batch to the consumer and measures how long the producer was blocked;
+// it returns false if the reader was closed.
+func (r *ParallelReader) send(batch []NextRow) bool {
+	start := time.Now()
+	select {
+	case r.channel <- batch:
+		r.producersBlocked.Add(int64(time.Since(start)))
+		return true
+	case <-r.done:
+		return false
+	case <-r.ctx.Done():
+		return false
+	}
+}
+
+// RowSize returns the average uncompressed size of a row in bytes over the files opened so far (see ParquetReader.RowSize).
+// It is safe to call while the producers are running.
+func (r *ParallelReader) RowSize() float64 {
+	return averageRowSize(r.dataSize.Load(), r.rowCount.Load())
+}
+
+// logRatio reports the throughput and how the work was balanced between the producers and the consumer:
+// a consumer waiting for rows most of the time means that more producers could help, while producers
+// blocked most of the time mean that writing to the database is the bottleneck.
+func (r *ParallelReader) logRatio() {
+	elapsed := time.Since(r.startTime)
+	if elapsed <= 0 {
+		return
+	}
+	consumerWaitRatio := r.consumerWait.Seconds() / elapsed.Seconds()
+	producersBlockedRatio := time.Duration(r.producersBlocked.Load()).Seconds() /
+		(elapsed.Seconds() * float64(r.Producers))
+	bound := "writing"
+	if consumerWaitRatio > producersBlockedRatio {
+		bound = "reading"
+	}
+	log.Info("Finished reading the Parquet files in parallel", zap.Int("files", len(r.relativePaths)),
+		zap.Int("producers", r.Producers), zap.Int64("rows", r.rowCounter), zap.Duration("elapsed", elapsed),
+		zap.Float64("rows_per_sec", float64(r.rowCounter)/elapsed.Seconds()),
+		zap.Float64("consumer_wait_ratio", consumerWaitRatio),
+		zap.Float64("producers_blocked_ratio", producersBlockedRatio), zap.String("bound_by", bound))
+}
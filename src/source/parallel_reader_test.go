@@ -0,0 +1,113 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// writeParallelFixture writes the given number of Parquet files with consecutive ids and returns their paths
+// relative to the returned root directory.
+func writeParallelFixture(t *testing.T, files int, rowsPerFile int) (root string, relativePaths []string) {
+	root = t.TempDir()
+	id := int64(0)
+	for i := range files {
+		relativePath := filepath.Join("db", "public.t", "1", fmt.Sprintf("part-%05d.parquet", i))
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, relativePath)), 0755); err != nil {
+			t.Fatalf("Failed to create the fixture: %v", err)
+		}
+		rows := make([]streamRow, rowsPerFile)
+		for j := range rows {
+			id++
+			rows[j] = streamRow{ID: id, Name: "row"}
+		}
+		err := parquet.WriteFile(filepath.Join(root, relativePath), rows, parquet.MaxRowsPerRowGroup(7))
+		if err != nil {
+			t.Fatalf("Failed to write the Parquet fixture: %v", err)
+		}
+		relativePaths = append(relativePaths, relativePath)
+	}
+	return root, relativePaths
+}
+
+func TestParallelReaderNoRowLoss(t *testing.T) {
+	root, relativePaths := writeParallelFixture(t, 6, 25)
+	tests := []struct {
+		name      string
+		producers int
+		batchSize int
+	}{
+		{name: "single producer", producers: 1, batchSize: 10},
+		{name: "fewer producers than files", producers: 3, batchSize: 4},
+		{name: "more producers than files", producers: 10, batchSize: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := NewParallelReader(context.Background(), NewLocalSource(root), relativePaths, &passThrough{}, tt.producers)
+			reader.BatchSize = tt.batchSize
+			defer reader.Close()
+			var ids []int64
+			for reader.Next() {
+				values, err := reader.Values()
+				if err != nil {
+					t.Fatalf("Values() error: %v", err)
+				}
+				ids = append(ids, values[0].(int64))
+			}
+			if reader.Err() != nil {
+				t.Fatalf("Err() = %v", reader.Err())
+			}
+			slices.Sort(ids)
+			if len(ids) != 150 || reader.RowsRead() != 150 || reader.RowCount() != 150 {
+				t.Fatalf("Read %d rows (RowsRead() = %d, RowCount() = %d); want 150",
+					len(ids), reader.RowsRead(), reader.RowCount())
+			}
+			for i, id := range ids {
+				if id != int64(i+1) {
+					t.Fatalf("Row %d has id %d; want %d (rows lost or duplicated)", i, id, i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestParallelReaderErrors(t *testing.T) {
+	root, relativePaths := writeParallelFixture(t, 4, 10)
+
+	reader := NewParallelReader(context.Background(), NewLocalSource(root), relativePaths,
+		&failingTransformer{failOn: 35}, 2)
+	for reader.Next() {
+	}
+	if reader.Err() == nil {
+		t.Errorf("Err() = nil; want the transformation error")
+	}
+	reader.Close() // stops the remaining producers
+
+	missing := append(slices.Clone(relativePaths), filepath.Join("db", "public.t", "1", "missing.parquet"))
+	reader = NewParallelReader(context.Background(), NewLocalSource(root), missing, &passThrough{}, 2)
+	for reader.Next() {
+	}
+	if reader.Err() == nil {
+		t.Errorf("Err() = nil; want an error for the missing file")
+	}
+	reader.Close()
+}
+
+func TestParallelReaderEarlyClose(t *testing.T) {
+	root, relativePaths := writeParallelFixture(t, 4, 50)
+	reader := NewParallelReader(context.Background(), NewLocalSource(root), relativePaths, &passThrough{}, 3)
+	reader.BatchSize = 1
+	if !reader.Next() {
+		t.Fatalf("Next() = false; want the first row, error: %v", reader.Err())
+	}
+	// the consumer stops early (like a failed COPY): the producers must not stay blocked
+	reader.Close()
+	for range reader.channel {
+	}
+}
@@ -0,0 +1,83 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"github.com/parquet-go/parquet-go"
+	"go.uber.org/zap"
+	"io"
+)
+
+// VerifyParquetFile checks the integrity of a Parquet file before it is loaded: the size of a file downloaded from S3
+// must match the size of the object, the footer must be readable, the column chunks must lie inside the file,
+// and every page must be readable and match the CRC recorded in its header (the CRC is optional,
+// the pages without it are only read). This catches truncated or corrupted files before they produce confusing
+// COPY errors in the middle of a table. The whole file is read.
+func VerifyParquetFile(file FileInfo) error {
+	reader, size, closer, err := file.open()
+	if err != nil {
+		return err
+	}
+	defer func(closer io.Closer) {
+		_ = closer.Close()
+	}(closer)
+	if file.Size > 0 && size != file.Size {
+		return fmt.Errorf("the Parquet file '%s' has %d bytes, expected %d bytes (truncated download?)",
+			file.Name(), size, file.Size)
+	}
+
+	parquetFile, err := parquet.OpenFile(reader, size, parquet.SkipPageIndex(true), parquet.SkipBloomFilters(true))
+	if err != nil {
+		return fmt.Errorf("failed to read the Parquet footer of '%s': %w", file.Name(), err)
+	}
+	for i, rowGroup := range parquetFile.Metadata().RowGroups {
+		for j, chunk := range rowGroup.Columns {
+			start := chunk.MetaData.DataPageOffset
+			if offset := chunk.MetaData.DictionaryPageOffset; offset > 0 && offset < start {
+				start = offset
+			}
+			if end := start + chunk.MetaData.TotalCompressedSize; start < 0 || end > size {
+				return fmt.Errorf("the column chunk %d of the row group %d of the Parquet file '%s' "+
+					"is outside the file of %d bytes (bytes %d-%d)", j, i, file.Name(), size, start, end)
+			}
+		}
+	}
+
+	pages := 0
+	for i, rowGroup := range parquetFile.RowGroups() {
+		for _, chunk := range rowGroup.ColumnChunks() {
+			read, err := verifyPages(chunk)
+			pages += read
+			if err != nil {
+				if errors.Is(err, parquet.ErrCorrupted) {
+					return fmt.Errorf("the Parquet file '%s' is corrupted in the row group %d: %w", file.Name(), i, err)
+				}
+				return fmt.Errorf("failed to read the row group %d of the Parquet file '%s': %w", i, file.Name(), err)
+			}
+		}
+	}
+	log.Debug("Verified the Parquet file", zap.String("file", file.Name()), zap.Int64("size", size),
+		zap.Int("pages", pages))
+	return nil
+}
+
+// verifyPages reads all pages of the column chunk, which verifies their CRCs, and returns the number of pages read.
+func verifyPages(chunk parquet.ColumnChunk) (count int, err error) {
+	pages := chunk.Pages()
+	defer func() {
+		if closeErr := pages.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	for {
+		page, err := pages.ReadPage()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		parquet.Release(page)
+		count++
+	}
+}
@@ -0,0 +1,41 @@
+package source
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestVerifyParquetFile(t *testing.T) {
+	file := writeCompressedFixture(t, &parquet.Uncompressed)
+	if err := VerifyParquetFile(file); err != nil {
+		t.Fatalf("VerifyParquetFile() error: %v", err)
+	}
+	content, err := os.ReadFile(file.LocalPath)
+	if err != nil {
+		t.Fatalf("Failed to read the Parquet fixture: %v", err)
+	}
+
+	// a flipped byte in the values of the long column breaks the CRC of its page
+	corrupted := bytes.Clone(content)
+	corrupted[bytes.Index(corrupted, []byte(strings.Repeat("x", 500)))+100] = 'z'
+	if err := os.WriteFile(file.LocalPath, corrupted, 0644); err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	err = VerifyParquetFile(file)
+	if err == nil || !strings.Contains(err.Error(), "is corrupted") {
+		t.Errorf("VerifyParquetFile() of the corrupted file = %v; want the CRC mismatch", err)
+	}
+
+	// the size recorded for the downloaded object does not match the file
+	if err := os.WriteFile(file.LocalPath, content, 0644); err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	err = VerifyParquetFile(FileInfo{LocalPath: file.LocalPath, Size: file.Size + 10})
+	if err == nil || !strings.Contains(err.Error(), "truncated download") {
+		t.Errorf("VerifyParquetFile() of the truncated file = %v; want the size mismatch", err)
+	}
+}
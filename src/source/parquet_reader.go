@@ -1,13 +1,19 @@
 package source
 
 import (
+	"context"
+	"dbrestore/utils"
 	"fmt"
 	"github.com/parquet-go/parquet-go"
 	"go.uber.org/zap"
 	"io"
-	"os"
+	"strings"
+	"time"
 )
 
+// DefaultBatchSize is the default number of rows read from a Parquet file with a single ReadRows call.
+const DefaultBatchSize = 1000
+
 // ParquetReader is a structure for reading and processing Parquet files while mapping data to a defined schema.
 // It implements the interface pgx.CopyFromSource for reading rows in the format supported by CopyFrom() function.
 type ParquetReader struct {
@@ -15,28 +21,54 @@ type ParquetReader struct {
 	fileInfo FileInfo
 
 	// mapper is a reference to the source.Transformer used to map Parquet fields to a defined schema of the target table.
+	// When it is a SchemaValidator, it is replaced by the Transformer of the file when the file is opened.
 	mapper Transformer
 
-	// isOpen indicates whether the ParquetReader is currently open and ready for processing.
+	// isOpen indicates whether the ParquetReader is currently open and ready for processing; like wasClosed,
+	// it is the state of the consumer and is not changed by the reading goroutine.
 	isOpen bool
 
 	// wasClosed indicates whether the ParquetReader was closed after being opened.
 	wasClosed bool
 
+	// release releases the slot of the limit of open readers held by the reader (see SetMaxOpenReaders), or nil.
+	release func()
+
+	// done is closed by Cancel to stop the reading goroutine when the consumer stops early.
+	done chan struct{}
+
+	// ctx stops reading when cancelled, and Next reports its error.
+	ctx context.Context
+
 	// lastError stores the most recent error encountered by the ParquetReader, or nil if no errors occurred.
 	lastError error
 
-	// file represents the underlying os.File, used to read the current Parquet file's data.
-	file *os.File
+	// file represents the underlying file (a local os.File or a remote file with ranged reads), closed by Close,
+	// or nil once StartReading handed it over to the reading goroutine.
+	file io.Closer
 
 	// parquetFile is a reference to the open Parquet file being processed by the ParquetReader.
 	parquetFile *parquet.File
 
+	// lists the repetition of the columns of the Parquet file by the column index, or nil if the file
+	// has no repeated (LIST) columns (see ListTransformer)
+	lists []listColumn
+
 	// rowCount represents the total number of rows in the Parquet file being processed.
 	rowCount int64
 
-	// channel is a channel used for asynchronously receiving parsed rows from the Parquet file during processing.
-	channel chan NextRow
+	// dataSize the total uncompressed size of the row groups of the Parquet file in bytes (see RowSize)
+	dataSize int64
+
+	// BatchSize is the number of rows read from the Parquet file with a single ReadRows call;
+	// the rows are passed over the channel in batches of up to this size.
+	BatchSize int
+
+	// channel is a channel used for asynchronously receiving batches of parsed rows from the Parquet file.
+	channel chan []NextRow
+
+	// batch is the current batch of rows received from the channel and served one by one by Next.
+	batch []NextRow
 
 	// nextRow the data of the current row, represented as a slice of interface{} to accommodate any type.
 	nextRow []any
@@ -45,6 +77,18 @@ type ParquetReader struct {
 	rowCounter int64
 }
 
+// listColumn describes how a column of a Parquet file is repeated.
+type listColumn struct {
+	// repeated the column is a repeated (LIST) column, whose values in a row are the elements of the list
+	repeated bool
+	// elementLevel the definition level of the repeated node: a value with a lower level is not an element,
+	// but an empty or a NULL list
+	elementLevel int
+	// nullable the list itself is optional, so that the definition level elementLevel-1 is an empty list,
+	// and the lower ones are a NULL list
+	nullable bool
+}
+
 // NextRow represents a single row of data and an associated error, returned from the channel as a single structure.
 type NextRow struct {
 	// row represents a single row of data, stored as a slice of interface{} to accommodate various data types.
@@ -54,11 +98,15 @@ type NextRow struct {
 	err error
 }
 
-// NewParquetReader creates a new instance of ParquetReader using the supplied FileInfo and Transformer.
-func NewParquetReader(file FileInfo, transformer Transformer) *ParquetReader {
+// NewParquetReader creates a new instance of ParquetReader using the supplied FileInfo and Transformer;
+// reading stops when the context is cancelled.
+func NewParquetReader(ctx context.Context, file FileInfo, transformer Transformer) *ParquetReader {
 	reader := ParquetReader{
-		fileInfo: file,
-		mapper:   transformer,
+		fileInfo:  file,
+		mapper:    transformer,
+		BatchSize: DefaultBatchSize,
+		done:      make(chan struct{}),
+		ctx:       ctx,
 	}
 	return &reader
 }
@@ -76,11 +124,29 @@ func (r *ParquetReader) Next() bool {
 	if r.lastError != nil {
 		return false
 	}
-	data, ok := <-r.channel
-	if !ok {
-		// r.lastError = io.EOF // this caused a bug with small tables
-		return false
+	if len(r.batch) == 0 {
+		if err := r.ctx.Err(); err != nil {
+			r.lastError = err
+			return false
+		}
+		var batch []NextRow
+		var ok bool
+		select {
+		case batch, ok = <-r.channel:
+		case <-r.ctx.Done():
+			r.lastError = r.ctx.Err()
+			return false
+		}
+		if !ok {
+			// r.lastError = io.EOF // this caused a bug with small tables
+			// the reading goroutine also stops on the cancellation, which must not look like the end of the file
+			r.lastError = r.ctx.Err()
+			return false
+		}
+		r.batch = batch
 	}
+	data := r.batch[0]
+	r.batch = r.batch[1:]
 	if data.err != nil {
 		r.lastError = data.err
 		return false
@@ -112,42 +178,75 @@ func (r *ParquetReader) Open(fileInfo FileInfo) error {
 	}
 	r.fileInfo = fileInfo
 
-	// Open the Parquet file
-	fileName := fileInfo.LocalPath
-	osFile, err := os.Open(fileName)
+	// wait until the number of open readers is within the limit - released by Close
+	release, err := acquireReader(r.ctx)
 	if err != nil {
-		return fmt.Errorf("failed to open file %s: %w", fileName, err)
+		return fmt.Errorf("waiting to open the file %s: %w", fileInfo.Name(), err)
 	}
-	r.file = osFile
-	r.isOpen = true
+	r.release = release
 
-	fileStat, err := r.file.Stat()
+	// Open the Parquet file - either a local file or a remote file with ranged reads
+	fileName := fileInfo.Name()
+	reader, size, closer, err := fileInfo.open()
 	if err != nil {
-		return fmt.Errorf("failed to get file info for %s: %w", fileName, err)
+		r.release()
+		r.release = nil
+		return err
 	}
-	size := fileStat.Size()
-	f, err := parquet.OpenFile(r.file, size)
+	r.file = closer
+	r.isOpen = true
+
+	f, err := parquet.OpenFile(reader, size)
 	if err != nil {
 		return fmt.Errorf("failed to open the file %s: %w", fileName, err)
 	}
 	r.parquetFile = f
 	r.rowCount = f.NumRows()
+	r.dataSize = uncompressedSize(f)
+	if r.lists, err = listColumns(f.Schema()); err != nil {
+		return fmt.Errorf("unsupported schema of the file %s: %w", fileName, err)
+	}
+	if validator, ok := r.mapper.(SchemaValidator); ok {
+		transformer, err := validator.ValidateSchema(f.Schema())
+		if err != nil {
+			return fmt.Errorf("invalid schema of the file %s: %w", fileName, err)
+		}
+		r.mapper = transformer
+	}
 	log.Debug(fmt.Sprintf(`Row count = %d`, r.rowCount))
 
 	return nil
 }
 
 // Close releases the resources held by the ParquetReader and closes the associated file if it is currently open.
+// After StartReading, the file is closed by the reading goroutine, which Close stops (see Cancel).
 func (r *ParquetReader) Close() (err error) {
 	if r.isOpen {
 		r.isOpen = false
 		r.wasClosed = true
+		r.Cancel()
+	}
+	if r.file != nil {
 		err = r.file.Close()
 		r.file = nil
 	}
+	if r.release != nil {
+		r.release()
+		r.release = nil
+	}
 	return
 }
 
+// Cancel stops the reading goroutine if the consumer did not read all rows (for example, when COPY failed),
+// so that the reader is closed and does not hold its slot of the limit of open readers.
+func (r *ParquetReader) Cancel() {
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+}
+
 // StartReading reads rows from a parquet file using a transformer and starts a goroutine to process rows asynchronously.
 func (r *ParquetReader) StartReading() (int, error) {
 	log.Trace("f.Schema(): ", zap.String("name", r.parquetFile.Schema().Name()))
@@ -165,69 +264,195 @@ func (r *ParquetReader) StartReading() (int, error) {
 		}
 	}
 
-	r.channel = make(chan NextRow)
+	batchSize := r.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	r.channel = make(chan []NextRow)
 
+	// the goroutine owns the file and the slot of the limit of open readers from now on, and releases them
+	// when it stops; the state of the reader stays with the consumer
+	file, release := r.file, r.release
+	r.file, r.release = nil, nil
 	go func() {
-		defer func(r *ParquetReader) {
-			err := r.Close()
-			if err != nil {
+		defer func() {
+			if err := file.Close(); err != nil {
 				log.Error("ERROR: ", zap.Error(err))
 			}
-		}(r)
+			release()
+		}()
+
+		start := time.Now()
+		rowNumber := r.readBatches(batchSize, func(batch []NextRow) bool {
+			select {
+			case r.channel <- batch:
+				return true
+			case <-r.done:
+				return false
+			case <-r.ctx.Done():
+				return false
+			}
+		})
+		close(r.channel)
+		logReadSpeed(r.fileInfo.Name(), rowNumber, batchSize, time.Since(start))
+	}()
 
-		for _, rowGroup := range r.parquetFile.RowGroups() {
-			rowReader := rowGroup.Rows()
-			for {
-				row := make([]parquet.Row, 1)
-				rowCount, err := rowReader.ReadRows(row)
-				if err != nil {
-					if err == io.EOF {
-						break
-					}
-					log.Error("Error reading row", zap.Error(err))
-					break
-				}
+	return int(r.rowCount), nil
+}
 
-				if rowCount != 1 {
-					err = fmt.Errorf("the row count is not 1")
-				}
+// readBatches reads all rows of the open Parquet file in batches of up to batchSize rows, transforms them and passes
+// every batch to the send function; reading stops when send returns false. A transformation error is passed
+// as the last row of the last batch, and so is a panic of the transformation, which must not kill the program
+// from the goroutine of the reader. Returns the number of rows read.
+func (r *ParquetReader) readBatches(batchSize int, send func(batch []NextRow) bool) (rowNumber int64) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			panicErr := utils.NewPanicError(recovered)
+			log.Error("Recovered from a panic reading the Parquet file", zap.String("file", r.fileInfo.Name()),
+				zap.Int64("row", rowNumber), zap.Error(panicErr), zap.String("stack", panicErr.Stack))
+			send([]NextRow{{err: fmt.Errorf("error transforming row %d of %s: %w", rowNumber, r.fileInfo.Name(),
+				utils.NewFatalError(panicErr))}})
+		}
+	}()
+	for _, rowGroup := range r.parquetFile.RowGroups() {
+		rowReader := rowGroup.Rows()
+		for {
+			rows := make([]parquet.Row, batchSize)
+			rowCount, err := rowReader.ReadRows(rows)
+			// the last rows may be returned together with io.EOF
+			endOfRowGroup := err == io.EOF
+			if err != nil && !endOfRowGroup {
+				log.Error("Error reading row", zap.Error(err))
+				break
+			}
+			if rowCount == 0 {
+				break
+			}
 
-				singleRow := row[0]
+			batch := make([]NextRow, rowCount)
+			for k, singleRow := range rows[:rowCount] {
+				rowNumber++
 				log.Trace("singleRow", zap.Any("singleRow", singleRow))
 
-				var rowData = NextRow{
-					row: make([]any, len(singleRow)),
-					err: err,
-				}
-				for i, x := range singleRow {
-					rowData.row[i], err = r.mapper.Transform(x)
-					if err != nil {
-						log.Error("Error transforming row", zap.Int("index", i),
-							zap.Any("value", x), zap.Any("row", row), zap.Error(err))
-						close(r.channel)
-						return
-					}
+				batch[k].row, err = r.transformRow(singleRow)
+				if err != nil {
+					log.Error("Error transforming row", zap.Any("row", singleRow), zap.Error(err))
+					// the rows transformed before the failed one are still delivered
+					batch[k] = NextRow{err: fmt.Errorf("error transforming row %d of %s: %w",
+						rowNumber, r.fileInfo.Name(), err)}
+					send(batch[:k+1])
+					return rowNumber
 				}
+			}
 
-				r.channel <- rowData
+			if !send(batch) {
+				return rowNumber
+			}
 
-				log.Trace("Row", zap.Any("row", row), zap.Int64("rowCounter", r.rowCounter),
-					zap.Int("rowCount", rowCount))
-				// Process the row as needed
+			log.Trace("Batch", zap.Int64("rowNumber", rowNumber), zap.Int("rowCount", rowCount))
+			if endOfRowGroup {
+				break
 			}
 		}
+	}
+	return rowNumber
+}
 
-		close(r.channel)
-	}()
+// transformRow transforms the values of a Parquet row into the values of the row for COPY; the values
+// of a repeated column are passed together to the ListTransformer.
+func (r *ParquetReader) transformRow(singleRow parquet.Row) (ret []any, err error) {
+	if r.lists == nil {
+		ret = make([]any, len(singleRow))
+		for i, x := range singleRow {
+			if ret[i], err = r.mapper.Transform(x); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	}
+	ret = make([]any, 0, len(r.lists))
+	for i := 0; i < len(singleRow); {
+		x := singleRow[i]
+		column := x.Column()
+		if !r.lists[column].repeated {
+			value, err := r.mapper.Transform(x)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, value)
+			i++
+			continue
+		}
+		end := i + 1
+		for end < len(singleRow) && singleRow[end].Column() == column {
+			end++
+		}
+		listTransformer, ok := r.mapper.(ListTransformer)
+		if !ok {
+			return nil, fmt.Errorf("the repeated column %d is not supported", column)
+		}
+		list := r.lists[column]
+		elements := singleRow[i:end]
+		null := false
+		if level := x.DefinitionLevel(); level < list.elementLevel {
+			// no elements - an empty or a NULL list
+			null = list.nullable && level < list.elementLevel-1
+			elements = nil
+		}
+		value, err := listTransformer.TransformList(column, elements, null)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, value)
+		i = end
+	}
+	return ret, nil
+}
 
-	return int(r.rowCount), nil
+// listColumns describes the repetition of the columns of the schema, or returns nil if no column is repeated.
+// Only the lists of primitive values (one level of repetition) are supported.
+func listColumns(schema *parquet.Schema) ([]listColumn, error) {
+	paths := schema.Columns()
+	ret := make([]listColumn, len(paths))
+	hasLists := false
+	for i, path := range paths {
+		var node parquet.Node = schema
+		level := 0
+		for _, name := range path {
+			for _, field := range node.Fields() {
+				if field.Name() == name {
+					node = field
+					break
+				}
+			}
+			if node.Optional() || node.Repeated() {
+				level++
+			}
+			if node.Repeated() {
+				if ret[i].repeated {
+					return nil, fmt.Errorf("the column '%s' is a nested list", strings.Join(path, "."))
+				}
+				ret[i].repeated = true
+				ret[i].elementLevel = level
+				ret[i].nullable = level > 1
+				hasLists = true
+			}
+		}
+	}
+	if !hasLists {
+		return nil, nil
+	}
+	return ret, nil
 }
 
 func (r *ParquetReader) OpenAndStartReadingIfNotDoneYet() {
 	if r.lastError == nil {
 		if !r.isOpen && !r.wasClosed {
 			r.lastError = r.Open(r.fileInfo)
-			if r.lastError == nil {
+			if r.lastError != nil {
+				// a file that is not a valid Parquet file is open nevertheless
+				_ = r.Close()
+			} else {
 				count, err := r.StartReading()
 				log.Debug("ParquetReader.Next(): r.IsEmpty()", zap.Int("count", count), zap.Error(err))
 				if err != nil {
@@ -249,3 +474,24 @@ func (r *ParquetReader) LastError() error {
 func (r *ParquetReader) RowCount() int64 {
 	return r.rowCount
 }
+
+// RowsRead returns the number of rows that were actually read from the Parquet file and handed over to the consumer.
+func (r *ParquetReader) RowsRead() int64 {
+	return r.rowCounter
+}
+
+// RowSize returns the average uncompressed size of a row of the Parquet file in bytes, from the row group
+// statistics of the footer, or 0 if the file is not open yet or is empty.
+func (r *ParquetReader) RowSize() float64 {
+	return averageRowSize(r.dataSize, r.rowCount)
+}
+
+// logReadSpeed reports how fast the rows of a Parquet file were read and consumed, in rows per second.
+func logReadSpeed(fileName string, rows int64, batchSize int, elapsed time.Duration) {
+	rowsPerSec := float64(rows)
+	if elapsed > 0 {
+		rowsPerSec = float64(rows) / elapsed.Seconds()
+	}
+	log.Info("Finished reading the Parquet file", zap.String("file", fileName), zap.Int64("rows", rows),
+		zap.Int("batch_size", batchSize), zap.Duration("elapsed", elapsed), zap.Float64("rows_per_sec", rowsPerSec))
+}
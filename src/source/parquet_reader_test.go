@@ -0,0 +1,216 @@
+package source
+
+import (
+	"context"
+	"dbrestore/utils"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/parquet-go/parquet-go"
+)
+
+// failingTransformer is a Transformer that fails on the given int64 value and returns other values as they are.
+type failingTransformer struct {
+	failOn int64
+}
+
+func (f *failingTransformer) Transform(x parquet.Value) (any, error) {
+	if x.Kind() == parquet.Int64 && x.Int64() == f.failOn {
+		return nil, errors.New("bad value")
+	}
+	return (&passThrough{}).Transform(x)
+}
+
+// writeBatchFixture writes a Parquet file with the given number of rows, split into row groups of 3 rows.
+func writeBatchFixture(t *testing.T, rows int) string {
+	fileName := filepath.Join(t.TempDir(), "part-00000.parquet")
+	data := make([]streamRow, rows)
+	for i := range data {
+		data[i] = streamRow{ID: int64(i + 1), Name: "row"}
+	}
+	err := parquet.WriteFile(fileName, data, parquet.MaxRowsPerRowGroup(3))
+	if err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	return fileName
+}
+
+func TestParquetReaderBatchSizes(t *testing.T) {
+	fileName := writeBatchFixture(t, 7)
+	tests := []struct {
+		name      string
+		batchSize int
+	}{
+		{name: "single row", batchSize: 1},
+		{name: "smaller than a row group", batchSize: 2},
+		{name: "default", batchSize: DefaultBatchSize},
+		{name: "not set", batchSize: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := NewParquetReader(context.Background(), FileInfo{LocalPath: fileName}, &passThrough{})
+			reader.BatchSize = tt.batchSize
+			var ids []int64
+			for reader.Next() {
+				values, err := reader.Values()
+				if err != nil {
+					t.Fatalf("Values() error: %v", err)
+				}
+				ids = append(ids, values[0].(int64))
+			}
+			if reader.Err() != nil {
+				t.Fatalf("Err() = %v", reader.Err())
+			}
+			if len(ids) != 7 || reader.RowsRead() != 7 {
+				t.Fatalf("Read rows = %v (RowsRead() = %d); want 7 rows", ids, reader.RowsRead())
+			}
+			for i, id := range ids {
+				if id != int64(i+1) {
+					t.Errorf("Row %d has id %d; want %d", i, id, i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestParquetReaderRowSize(t *testing.T) {
+	fileName := writeBatchFixture(t, 7)
+	reader := NewParquetReader(context.Background(), FileInfo{LocalPath: fileName}, &passThrough{})
+	if size := reader.RowSize(); size != 0 {
+		t.Errorf("RowSize() before Open = %f; want 0", size)
+	}
+	if err := reader.Open(FileInfo{LocalPath: fileName}); err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	if size := reader.RowSize(); size <= 0 || size != float64(uncompressedSize(reader.parquetFile))/7 {
+		t.Errorf("RowSize() = %f; want the uncompressed size of the row groups divided by 7 rows", size)
+	}
+}
+
+func TestParquetReaderTransformErrorInBatch(t *testing.T) {
+	fileName := writeBatchFixture(t, 3)
+	reader := NewParquetReader(context.Background(), FileInfo{LocalPath: fileName}, &failingTransformer{failOn: 3})
+	reader.BatchSize = 10
+
+	count := 0
+	for reader.Next() {
+		count++
+	}
+	// the rows before the failed one are still served
+	if count != 2 {
+		t.Errorf("Next() returned %d rows before the error; want 2", count)
+	}
+	if reader.Err() == nil {
+		t.Errorf("Err() = nil; want the transformation error")
+	}
+}
+
+// panickingTransformer is a Transformer that panics on the given int64 value, like the conversion
+// of an unknown type with the fallback "panic".
+type panickingTransformer struct {
+	panicOn int64
+}
+
+func (p *panickingTransformer) Transform(x parquet.Value) (any, error) {
+	if x.Kind() == parquet.Int64 && x.Int64() == p.panicOn {
+		panic("unexpected column type: mystery")
+	}
+	return (&passThrough{}).Transform(x)
+}
+
+func TestParquetReaderTransformPanic(t *testing.T) {
+	fileName := writeBatchFixture(t, 5)
+	for _, parallel := range []bool{false, true} {
+		var reader pgx.CopyFromSource
+		if parallel {
+			dir := filepath.Dir(fileName)
+			reader = NewParallelReader(context.Background(), NewLocalSource(dir), []string{"part-00000.parquet"},
+				&panickingTransformer{panicOn: 4}, 2)
+		} else {
+			reader = NewParquetReader(context.Background(), FileInfo{LocalPath: fileName},
+				&panickingTransformer{panicOn: 4})
+		}
+		count := 0
+		for reader.Next() {
+			count++
+		}
+		var panicErr *utils.PanicError
+		if !errors.As(reader.Err(), &panicErr) || !utils.IsFatalError(reader.Err()) {
+			t.Fatalf("parallel %v: Err() = %v; want a fatal panic error", parallel, reader.Err())
+		}
+		if !strings.Contains(reader.Err().Error(), "row 4 of "+fileName) {
+			t.Errorf("parallel %v: Err() = %v; want the row and the file", parallel, reader.Err())
+		}
+		// the first row group is delivered, the batch of the panic is lost
+		if count != 3 {
+			t.Errorf("parallel %v: Next() returned %d rows before the panic; want 3", parallel, count)
+		}
+	}
+}
+
+func TestParquetReaderCancelled(t *testing.T) {
+	fileName := writeBatchFixture(t, 7)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := NewParquetReader(ctx, FileInfo{LocalPath: fileName}, &passThrough{})
+	reader.BatchSize = 1
+
+	count := 0
+	for reader.Next() {
+		count++
+		if count == 2 {
+			cancel()
+		}
+	}
+	if count >= 7 {
+		t.Errorf("Next() returned all %d rows after the cancellation", count)
+	}
+	if !errors.Is(reader.Err(), context.Canceled) {
+		t.Errorf("Err() = %v; want context.Canceled", reader.Err())
+	}
+}
+
+func TestListColumns(t *testing.T) {
+	type flatRow struct {
+		ID   int64  `parquet:"id"`
+		Name string `parquet:"name"`
+	}
+	type listRow struct {
+		ID       int64    `parquet:"id"`
+		Tags     []string `parquet:"tags,list"`
+		Optional []int64  `parquet:"optional,optional,list"`
+	}
+
+	lists, err := listColumns(parquet.SchemaOf(flatRow{}))
+	if err != nil || lists != nil {
+		t.Errorf("listColumns() = %v, %v; want nil for a schema without lists", lists, err)
+	}
+	lists, err = listColumns(parquet.SchemaOf(listRow{}))
+	expected := []listColumn{{}, {repeated: true, elementLevel: 1}, {repeated: true, elementLevel: 2, nullable: true}}
+	if err != nil || !reflect.DeepEqual(lists, expected) {
+		t.Errorf("listColumns() = %+v, %v; want %+v", lists, err, expected)
+	}
+	nested := parquet.NewSchema("nested", parquet.Group{"matrix": parquet.List(parquet.List(parquet.Int(64)))})
+	if _, err = listColumns(nested); err == nil {
+		t.Errorf("listColumns() accepted a nested list")
+	}
+
+	// a transformer without ListTransformer cannot read the lists
+	fileName := filepath.Join(t.TempDir(), "part-00000.parquet")
+	if err := parquet.WriteFile(fileName, []listRow{{ID: 1, Tags: []string{"a", "b"}}}); err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	reader := NewParquetReader(context.Background(), FileInfo{LocalPath: fileName}, &passThrough{})
+	defer reader.Cancel()
+	if reader.Next() || reader.LastError() == nil {
+		t.Errorf("Next() read a LIST column without a ListTransformer")
+	}
+}
@@ -0,0 +1,160 @@
+package source
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+	"io"
+	"slices"
+	"unicode/utf8"
+)
+
+// MaxStatisticsLengths reads the footer of a Parquet file and returns, for every byte-array column
+// (by the leaf column index), the maximal length in characters of the min/max values in the column chunk statistics.
+// The data pages are not read. The min/max values are not necessarily the longest values in the column,
+// so the result is a lower bound of the maximal value length; columns without statistics are not returned.
+func MaxStatisticsLengths(file FileInfo) (map[int]int, error) {
+	reader, size, closer, err := file.open()
+	if err != nil {
+		return nil, err
+	}
+	defer func(closer io.Closer) {
+		_ = closer.Close()
+	}(closer)
+
+	parquetFile, err := parquet.OpenFile(reader, size, parquet.SkipPageIndex(true), parquet.SkipBloomFilters(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Parquet footer of '%s': %w", file.Name(), err)
+	}
+	columns := parquetFile.Schema().Columns()
+	ret := make(map[int]int)
+	for _, rowGroup := range parquetFile.Metadata().RowGroups {
+		for i, chunk := range rowGroup.Columns {
+			if i >= len(columns) {
+				break
+			}
+			leaf, ok := parquetFile.Schema().Lookup(columns[i]...)
+			if !ok || leaf.Node.Type().Kind() != parquet.ByteArray {
+				continue
+			}
+			stats := chunk.MetaData.Statistics
+			for _, value := range [][]byte{stats.MaxValue, stats.MinValue, stats.Max, stats.Min} {
+				if value == nil {
+					continue
+				}
+				ret[i] = max(ret[i], utf8.RuneCount(value))
+			}
+		}
+	}
+	return ret, nil
+}
+
+// ParquetFooter is the part of the footer of a Parquet file checked by the validation of the export.
+type ParquetFooter struct {
+	// Rows the number of rows in the file
+	Rows int64
+	// Columns the paths of the leaf columns of the schema, in the order of the schema
+	Columns [][]string
+}
+
+// ReadParquetFooter reads the number of rows and the columns of the schema from the footer of a Parquet file,
+// without reading the data.
+func ReadParquetFooter(file FileInfo) (ret ParquetFooter, err error) {
+	reader, size, closer, err := file.open()
+	if err != nil {
+		return ret, err
+	}
+	defer func(closer io.Closer) {
+		_ = closer.Close()
+	}(closer)
+
+	parquetFile, err := parquet.OpenFile(reader, size, parquet.SkipPageIndex(true), parquet.SkipBloomFilters(true))
+	if err != nil {
+		return ret, fmt.Errorf("failed to read the Parquet footer of '%s': %w", file.Name(), err)
+	}
+	return ParquetFooter{Rows: parquetFile.NumRows(), Columns: parquetFile.Schema().Columns()}, nil
+}
+
+// ParquetRowCount reads the number of rows from the footer of a Parquet file, without reading the data.
+func ParquetRowCount(file FileInfo) (int64, error) {
+	reader, size, closer, err := file.open()
+	if err != nil {
+		return 0, err
+	}
+	defer func(closer io.Closer) {
+		_ = closer.Close()
+	}(closer)
+
+	parquetFile, err := parquet.OpenFile(reader, size, parquet.SkipPageIndex(true), parquet.SkipBloomFilters(true))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the Parquet footer of '%s': %w", file.Name(), err)
+	}
+	return parquetFile.NumRows(), nil
+}
+
+// UnsupportedCodecs reads the footer of a Parquet file and returns the names of the compression codecs
+// of its column chunks that the Parquet library cannot decompress (see CodecSupported), without reading the data.
+// Such files would otherwise fail with an obscure page decoding error in the middle of the table.
+func UnsupportedCodecs(file FileInfo) ([]string, error) {
+	reader, size, closer, err := file.open()
+	if err != nil {
+		return nil, err
+	}
+	defer func(closer io.Closer) {
+		_ = closer.Close()
+	}(closer)
+
+	parquetFile, err := parquet.OpenFile(reader, size, parquet.SkipPageIndex(true), parquet.SkipBloomFilters(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Parquet footer of '%s': %w", file.Name(), err)
+	}
+	var codecs []format.CompressionCodec
+	for _, rowGroup := range parquetFile.Metadata().RowGroups {
+		for _, chunk := range rowGroup.Columns {
+			if !slices.Contains(codecs, chunk.MetaData.Codec) {
+				codecs = append(codecs, chunk.MetaData.Codec)
+			}
+		}
+	}
+	var ret []string
+	for _, codec := range codecs {
+		if !CodecSupported(codec) {
+			ret = append(ret, codec.String())
+		}
+	}
+	return ret, nil
+}
+
+// CodecSupported checks at runtime whether the Parquet library can decompress the codec, with a round trip
+// of a small sample; the library returns a placeholder failing all calls for the codecs it does not implement.
+func CodecSupported(codec format.CompressionCodec) bool {
+	compressor := parquet.LookupCompressionCodec(codec)
+	if compressor.CompressionCodec() != codec {
+		return false
+	}
+	sample := []byte("dbrestore compression codec probe")
+	encoded, err := compressor.Encode(nil, sample)
+	if err != nil {
+		return false
+	}
+	decoded, err := compressor.Decode(nil, encoded)
+	return err == nil && bytes.Equal(decoded, sample)
+}
+
+// uncompressedSize returns the total uncompressed size in bytes of the row groups of the open Parquet file,
+// as recorded in its footer.
+func uncompressedSize(f *parquet.File) (ret int64) {
+	for _, rowGroup := range f.Metadata().RowGroups {
+		ret += rowGroup.TotalByteSize
+	}
+	return ret
+}
+
+// averageRowSize returns the average size of a row in bytes, or 0 without rows.
+func averageRowSize(size int64, rows int64) float64 {
+	if rows <= 0 {
+		return 0
+	}
+	return float64(size) / float64(rows)
+}
@@ -0,0 +1,149 @@
+package source
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/encoding/thrift"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// statsRow is a Parquet fixture row with one short and one long string column.
+type statsRow struct {
+	ID    int64  `parquet:"id"`
+	Short string `parquet:"short"`
+	Long  string `parquet:"long"`
+}
+
+func TestMaxStatisticsLengths(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "part-00000.parquet")
+	err := parquet.WriteFile(fileName, []statsRow{
+		{ID: 1, Short: "abc", Long: strings.Repeat("x", 50)},
+		{ID: 2, Short: "ab", Long: "y"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("Failed to stat the Parquet fixture: %v", err)
+	}
+
+	lengths, err := MaxStatisticsLengths(FileInfo{LocalPath: fileName, Size: info.Size()})
+	if err != nil {
+		t.Fatalf("MaxStatisticsLengths() error: %v", err)
+	}
+	if _, exists := lengths[0]; exists {
+		t.Errorf("MaxStatisticsLengths() returned a length for the integer column: %v", lengths)
+	}
+	if lengths[1] != 3 {
+		t.Errorf("MaxStatisticsLengths()[short] = %d; want 3", lengths[1])
+	}
+	if lengths[2] != 50 {
+		t.Errorf("MaxStatisticsLengths()[long] = %d; want 50", lengths[2])
+	}
+}
+
+// writeCompressedFixture writes the statsRow fixture compressed with the codec and returns the file.
+func writeCompressedFixture(t *testing.T, codec compress.Codec) FileInfo {
+	fileName := filepath.Join(t.TempDir(), "part-00000.parquet")
+	rows := []statsRow{{ID: 1, Short: "abc", Long: strings.Repeat("x", 500)}, {ID: 2, Short: "ab", Long: "y"}}
+	if err := parquet.WriteFile(fileName, rows, parquet.Compression(codec)); err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("Failed to stat the Parquet fixture: %v", err)
+	}
+	return FileInfo{LocalPath: fileName, Size: info.Size()}
+}
+
+// setFooterCodec rewrites the codec of all column chunks in the footer of the Parquet file,
+// producing a file that the Parquet library cannot write itself.
+func setFooterCodec(t *testing.T, file FileInfo, codec format.CompressionCodec) FileInfo {
+	content, err := os.ReadFile(file.LocalPath)
+	if err != nil {
+		t.Fatalf("Failed to read the Parquet fixture: %v", err)
+	}
+	footerEnd := len(content) - 8
+	footerStart := footerEnd - int(binary.LittleEndian.Uint32(content[footerEnd:]))
+	protocol := &thrift.CompactProtocol{}
+	var metadata format.FileMetaData
+	if err := thrift.Unmarshal(protocol, content[footerStart:footerEnd], &metadata); err != nil {
+		t.Fatalf("Failed to decode the footer: %v", err)
+	}
+	for i := range metadata.RowGroups {
+		for j := range metadata.RowGroups[i].Columns {
+			metadata.RowGroups[i].Columns[j].MetaData.Codec = codec
+		}
+	}
+	footer, err := thrift.Marshal(protocol, &metadata)
+	if err != nil {
+		t.Fatalf("Failed to encode the footer: %v", err)
+	}
+	content = append(content[:footerStart:footerStart], footer...)
+	content = binary.LittleEndian.AppendUint32(content, uint32(len(footer)))
+	content = append(content, "PAR1"...)
+	if err := os.WriteFile(file.LocalPath, content, 0644); err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	return FileInfo{LocalPath: file.LocalPath, Size: int64(len(content))}
+}
+
+func TestCompressionCodecs(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec compress.Codec
+	}{
+		{name: "SNAPPY", codec: &parquet.Snappy},
+		{name: "GZIP", codec: &parquet.Gzip},
+		{name: "ZSTD", codec: &parquet.Zstd},
+		{name: "LZ4_RAW", codec: &parquet.Lz4Raw},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeCompressedFixture(t, tt.codec)
+			codecs, err := UnsupportedCodecs(file)
+			if err != nil || len(codecs) > 0 {
+				t.Fatalf("UnsupportedCodecs() = %v, %v; want none", codecs, err)
+			}
+			// the codec works end to end with the reader used for loading
+			reader := NewParquetReader(context.Background(), file, &passThrough{})
+			var rows [][]any
+			for reader.Next() {
+				values, err := reader.Values()
+				if err != nil {
+					t.Fatalf("Values() error: %v", err)
+				}
+				rows = append(rows, values)
+			}
+			if reader.Err() != nil {
+				t.Fatalf("Err() = %v", reader.Err())
+			}
+			expected := [][]any{{int64(1), "abc", strings.Repeat("x", 500)}, {int64(2), "ab", "y"}}
+			if !reflect.DeepEqual(rows, expected) {
+				t.Errorf("Loaded rows = %v; want %v", rows, expected)
+			}
+		})
+	}
+}
+
+func TestUnsupportedCodecs(t *testing.T) {
+	// the Hadoop-framed LZ4 is deprecated and not implemented by the Parquet library
+	file := setFooterCodec(t, writeCompressedFixture(t, &parquet.Snappy), format.Lz4)
+	codecs, err := UnsupportedCodecs(file)
+	if err != nil {
+		t.Fatalf("UnsupportedCodecs() error: %v", err)
+	}
+	if !reflect.DeepEqual(codecs, []string{"LZ4"}) {
+		t.Errorf("UnsupportedCodecs() = %v; want [LZ4]", codecs)
+	}
+}
@@ -0,0 +1,65 @@
+package source
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// PartManifestFileName the name of the manifest of the part files in the folder of a table, written by newer exports
+const PartManifestFileName = "_manifest"
+
+// PartManifest lists every part file of a table in the export with its size and row count. When an export has it,
+// it is the authoritative list of the parts, which detects the missing parts that a listing of the folder cannot.
+type PartManifest struct {
+	// Files the part files of the table
+	Files []PartManifestEntry `json:"files"`
+}
+
+// PartManifestEntry describes a single part file in a PartManifest.
+type PartManifestEntry struct {
+	// Path the path of the part file relative to the folder of the table, for example "1/part-00000.parquet"
+	Path string `json:"path"`
+	// Size the size of the part file in bytes
+	Size int64 `json:"size"`
+	// Rows the number of rows in the part file
+	Rows int64 `json:"rows"`
+}
+
+// IsPartManifest checks whether the file is the manifest of the part files of a table (see PartManifest).
+func IsPartManifest(file string) bool {
+	return path.Base(file) == PartManifestFileName
+}
+
+// ReadPartManifest reads the manifest of the part files from the file of the source (see PartManifest),
+// rejecting the entries with paths outside the folder of the table.
+func ReadPartManifest(src Source, relativePath string) (ret PartManifest, err error) {
+	file := src.GetFile(relativePath)
+	if !file.IsValid() {
+		return ret, fmt.Errorf("failed to get the file '%s'", relativePath)
+	}
+	defer src.Dispose(file)
+	reader, size, closer, err := file.open()
+	if err != nil {
+		return ret, err
+	}
+	defer func(closer io.Closer) {
+		_ = closer.Close()
+	}(closer)
+	content, err := io.ReadAll(io.NewSectionReader(reader, 0, size))
+	if err != nil {
+		return ret, fmt.Errorf("failed to read the manifest of the parts '%s': %w", relativePath, err)
+	}
+	if err = json.Unmarshal(content, &ret); err != nil {
+		return ret, fmt.Errorf("failed to parse the manifest of the parts '%s': %w", relativePath, err)
+	}
+	for _, entry := range ret.Files {
+		if entry.Path == "" || path.IsAbs(entry.Path) || strings.Contains(entry.Path, "..") {
+			return ret, fmt.Errorf("invalid path of a part '%s' in the manifest of the parts '%s'",
+				entry.Path, relativePath)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,50 @@
+package source
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// openReadersLimit is the semaphore limiting the number of concurrently open ParquetReaders;
+// it holds nil when there is no limit (see SetMaxOpenReaders).
+var openReadersLimit atomic.Pointer[chan struct{}]
+
+// openReaders the number of currently open ParquetReaders.
+var openReaders atomic.Int64
+
+// SetMaxOpenReaders limits the number of concurrently open ParquetReaders in the whole program, which caps
+// the memory used for buffering Parquet data regardless of the parallelism settings; 0 means no limit.
+// It is meant to be called once at startup - the readers open at that moment keep their previous limit.
+func SetMaxOpenReaders(limit int) {
+	if limit > 0 {
+		semaphore := make(chan struct{}, limit)
+		openReadersLimit.Store(&semaphore)
+	} else {
+		openReadersLimit.Store(nil)
+	}
+}
+
+// OpenReaders returns the number of currently open ParquetReaders.
+func OpenReaders() int {
+	return int(openReaders.Load())
+}
+
+// acquireReader blocks until a reader may be opened without exceeding the limit, or until the context is cancelled,
+// which returns its error; the returned function releases the acquired slot and must be called exactly once.
+func acquireReader(ctx context.Context) (release func(), err error) {
+	semaphore := openReadersLimit.Load()
+	if semaphore != nil {
+		select {
+		case *semaphore <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	openReaders.Add(1)
+	return func() {
+		openReaders.Add(-1)
+		if semaphore != nil {
+			<-*semaphore
+		}
+	}, nil
+}
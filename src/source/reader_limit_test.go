@@ -0,0 +1,109 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// observingTransformer is a pass-through Transformer recording the maximal number of open readers it observed.
+type observingTransformer struct {
+	passThrough
+	maxOpen atomic.Int64
+}
+
+func (o *observingTransformer) Transform(x parquet.Value) (any, error) {
+	open := int64(OpenReaders())
+	for {
+		current := o.maxOpen.Load()
+		if open <= current || o.maxOpen.CompareAndSwap(current, open) {
+			break
+		}
+	}
+	return o.passThrough.Transform(x)
+}
+
+func TestMaxOpenReaders(t *testing.T) {
+	const limit = 2
+	SetMaxOpenReaders(limit)
+	t.Cleanup(func() { SetMaxOpenReaders(0) })
+
+	root, relativePaths := writeParallelFixture(t, 12, 40)
+	transformer := &observingTransformer{}
+
+	// several tables loaded at once, each with many concurrent readers
+	var wg sync.WaitGroup
+	var rows atomic.Int64
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reader := NewParallelReader(context.Background(), NewLocalSource(root), relativePaths, transformer, 8)
+			reader.BatchSize = 3
+			defer reader.Close()
+			for reader.Next() {
+				rows.Add(1)
+			}
+			if reader.Err() != nil {
+				t.Errorf("Err() = %v", reader.Err())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if rows.Load() != 3*12*40 {
+		t.Errorf("Read %d rows; want %d", rows.Load(), 3*12*40)
+	}
+	if transformer.maxOpen.Load() > limit || transformer.maxOpen.Load() == 0 {
+		t.Errorf("Observed up to %d open readers; want at most %d", transformer.maxOpen.Load(), limit)
+	}
+	if OpenReaders() != 0 {
+		t.Errorf("OpenReaders() = %d after all readers were closed; want 0", OpenReaders())
+	}
+}
+
+func TestParquetReaderCancelReleasesLimit(t *testing.T) {
+	SetMaxOpenReaders(1)
+	t.Cleanup(func() { SetMaxOpenReaders(0) })
+	root, relativePaths := writeParallelFixture(t, 2, 50)
+
+	for _, relativePath := range relativePaths {
+		reader := NewParquetReader(context.Background(), NewLocalSource(root).GetFile(relativePath), &passThrough{})
+		reader.BatchSize = 1
+		if !reader.Next() {
+			t.Fatalf("Next() = false; want the first row, error: %v", reader.Err())
+		}
+		// the consumer stops early - the second reader could not be opened without releasing the first one
+		reader.Cancel()
+	}
+}
+
+func TestAcquireReaderCancelled(t *testing.T) {
+	SetMaxOpenReaders(1)
+	t.Cleanup(func() { SetMaxOpenReaders(0) })
+	release, err := acquireReader(context.Background())
+	if err != nil {
+		t.Fatalf("acquireReader() error: %v", err)
+	}
+	defer release()
+
+	// the only slot is taken, so the reader waits until its context is cancelled
+	root, relativePaths := writeParallelFixture(t, 1, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	reader := NewParquetReader(ctx, NewLocalSource(root).GetFile(relativePaths[0]), &passThrough{})
+	if reader.Next() {
+		t.Fatalf("Next() = true; want false while the limit is exhausted")
+	}
+	if !errors.Is(reader.Err(), context.DeadlineExceeded) {
+		t.Errorf("Err() = %v; want %v", reader.Err(), context.DeadlineExceeded)
+	}
+	if OpenReaders() != 1 {
+		t.Errorf("OpenReaders() = %d; want only the slot acquired by the test", OpenReaders())
+	}
+}
@@ -0,0 +1,141 @@
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Receipt records every file read from the source during a restore, with its size and SHA-256,
+// so that an audit can prove which files of the export were restored (see --receipt).
+// It is safe for concurrent use.
+type Receipt struct {
+	// Snapshot the name of the exported snapshot
+	Snapshot string `json:"snapshot"`
+	// Files the processed files, ordered by the relative path
+	Files []ReceiptFile `json:"files"`
+	// Incomplete indicates that some processed files could not be hashed, and are missing in Files
+	Incomplete bool `json:"incomplete,omitempty"`
+
+	// mutex guards the fields against the parallel readers
+	mutex sync.Mutex
+}
+
+// ReceiptFile is a single processed file in the Receipt.
+type ReceiptFile struct {
+	// Path the path of the file relative to the export
+	Path string `json:"path"`
+	// Size the file size in bytes
+	Size int64 `json:"size"`
+	// SHA256 the hex-encoded SHA-256 of the file content
+	SHA256 string `json:"sha256"`
+}
+
+// NewReceipt creates an empty receipt.
+func NewReceipt() *Receipt {
+	return &Receipt{Files: []ReceiptFile{}}
+}
+
+// Wrap returns a source recording every file returned by GetFile of the given source in the receipt.
+func (r *Receipt) Wrap(src Source) Source {
+	return &receiptSource{Source: src, receipt: r}
+}
+
+// add records the file; a file processed more than once (for example, its footer is read before loading it)
+// is hashed and recorded once. The hash calculated while downloading the file is used when available,
+// otherwise the file is read (a file streamed from S3 with ranged reads is read once more for that).
+func (r *Receipt) add(snapshot string, file FileInfo) error {
+	path := filepath.ToSlash(file.RelativePath)
+	search := func() (int, bool) {
+		return slices.BinarySearchFunc(r.Files, path, func(f ReceiptFile, path string) int {
+			return strings.Compare(f.Path, path)
+		})
+	}
+	r.mutex.Lock()
+	r.Snapshot = snapshot
+	index, found := search()
+	recorded := found && r.Files[index].Size == file.Size
+	r.mutex.Unlock()
+	if recorded {
+		return nil
+	}
+
+	hash := file.SHA256
+	if hash == "" {
+		var err error
+		if hash, err = fileHash(file); err != nil {
+			r.mutex.Lock()
+			r.Incomplete = true
+			r.mutex.Unlock()
+			return err
+		}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	entry := ReceiptFile{Path: path, Size: file.Size, SHA256: hash}
+	if index, found = search(); found {
+		r.Files[index] = entry
+	} else {
+		r.Files = slices.Insert(r.Files, index, entry)
+	}
+	return nil
+}
+
+// fileHash calculates the hex-encoded SHA-256 of the file content.
+func fileHash(file FileInfo) (string, error) {
+	reader, size, closer, err := file.open()
+	if err != nil {
+		return "", err
+	}
+	defer func(closer io.Closer) {
+		_ = closer.Close()
+	}(closer)
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(reader, 0, size)); err != nil {
+		return "", fmt.Errorf("failed to read the file '%s': %w", file.Name(), err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Write writes the receipt to a JSON file.
+func (r *Receipt) Write(fileName string) error {
+	r.mutex.Lock()
+	content, err := json.MarshalIndent(r, "", "  ")
+	r.mutex.Unlock()
+	if err == nil {
+		err = os.WriteFile(fileName, append(content, '\n'), 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the receipt to '%s': %w", fileName, err)
+	}
+	return nil
+}
+
+// receiptSource is a Source recording the returned files in a Receipt (see Receipt.Wrap).
+type receiptSource struct {
+	Source
+
+	// receipt the receipt of the processed files
+	receipt *Receipt
+}
+
+// GetFile returns the file of the wrapped source and records it in the receipt.
+func (s *receiptSource) GetFile(relativePath string) FileInfo {
+	file := s.Source.GetFile(relativePath)
+	if !file.IsValid() {
+		return file
+	}
+	if err := s.receipt.add(s.Source.getSnapshotName(), file); err != nil {
+		// the restore goes on, the receipt is incomplete
+		log.Error("Failed to record the file in the receipt", zap.String("file", file.Name()), zap.Error(err))
+	}
+	return file
+}
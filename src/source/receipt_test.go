@@ -0,0 +1,92 @@
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReceipt(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "export-1")
+	files := map[string]string{
+		"export_info_export-1.json":                `{"exportTaskIdentifier": "export-1"}`,
+		"db/public.users/1/part-00000.parquet":     "first part",
+		"db/public.users/1/part-00001.parquet":     "second part",
+		"db/public.orders/1/part-00000.parquet":    "orders",
+		"db/public.orders/1/not-processed.parquet": "not processed",
+	}
+	for name, content := range files {
+		fileName := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			t.Fatalf("Failed to create the fixture: %v", err)
+		}
+		if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create the fixture: %v", err)
+		}
+	}
+
+	receipt := NewReceipt()
+	src := receipt.Wrap(NewLocalSource(root))
+	processed := []string{"export_info_export-1.json", "db/public.users/1/part-00001.parquet",
+		"db/public.users/1/part-00000.parquet", "db/public.orders/1/part-00000.parquet",
+		"db/public.users/1/part-00000.parquet"} // processed twice, recorded once
+	for _, name := range processed {
+		file := src.GetFile(filepath.FromSlash(name))
+		if !file.IsValid() {
+			t.Fatalf("GetFile(%s) returned an invalid file", name)
+		}
+		src.Dispose(file)
+	}
+	if file := src.GetFile("db/missing.parquet"); file.IsValid() {
+		t.Fatalf("GetFile() of a missing file returned a valid file")
+	}
+
+	fileName := filepath.Join(t.TempDir(), "receipt.json")
+	if err := receipt.Write(fileName); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Failed to read the receipt: %v", err)
+	}
+	var result Receipt
+	if err := json.Unmarshal(content, &result); err != nil {
+		t.Fatalf("Failed to parse the receipt: %v", err)
+	}
+
+	var expected []ReceiptFile
+	for _, name := range []string{"db/public.orders/1/part-00000.parquet", "db/public.users/1/part-00000.parquet",
+		"db/public.users/1/part-00001.parquet", "export_info_export-1.json"} {
+		hash := sha256.Sum256([]byte(files[name]))
+		expected = append(expected, ReceiptFile{Path: name, Size: int64(len(files[name])),
+			SHA256: hex.EncodeToString(hash[:])})
+	}
+	if result.Snapshot != "export-1" || result.Incomplete {
+		t.Errorf("Snapshot, Incomplete = %s, %v; want export-1, false", result.Snapshot, result.Incomplete)
+	}
+	if !reflect.DeepEqual(result.Files, expected) {
+		t.Errorf("Files = %+v; want %+v", result.Files, expected)
+	}
+}
+
+func TestReceiptUsesDownloadHash(t *testing.T) {
+	receipt := NewReceipt()
+	// the file is not read when its hash was calculated while downloading it
+	file := FileInfo{RelativePath: "db/t/1/part-00000.parquet", LocalPath: "/missing/part-00000.parquet",
+		Size: 10, SHA256: "abc"}
+	if err := receipt.add("export-1", file); err != nil {
+		t.Fatalf("add() error: %v", err)
+	}
+	if len(receipt.Files) != 1 || receipt.Files[0].SHA256 != "abc" {
+		t.Errorf("Files = %+v; want the hash of the download", receipt.Files)
+	}
+
+	if err := receipt.add("export-1", FileInfo{RelativePath: "db/t/1/part-00001.parquet",
+		LocalPath: "/missing/part-00001.parquet", Size: 10}); err == nil || !receipt.Incomplete {
+		t.Errorf("add() of an unreadable file = %v, incomplete %v; want an error", err, receipt.Incomplete)
+	}
+}
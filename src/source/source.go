@@ -1,5 +1,11 @@
 package source
 
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
 // FileInfo represents a file to be processed - may be temporary
 type FileInfo struct {
 	// RelativePath specifies the file path relative to Source. Used for addressing files in the remote data source.
@@ -10,6 +16,42 @@ type FileInfo struct {
 	Size int64
 	// Temp indicates that the file is temporary and must be removed by this program at the end (downloaded from S3)
 	Temp bool
+	// ReaderAt provides ranged reads of a remote file that is not downloaded (streamed from S3);
+	// LocalPath is empty in this case and Size is mandatory
+	ReaderAt io.ReaderAt
+	// SHA256 the hex-encoded SHA-256 of the file content, calculated while downloading the file;
+	// empty for the files that are not downloaded (see Receipt)
+	SHA256 string
+}
+
+// IsValid checks whether the file was found - either as a local file or as a remote file with ranged reads.
+func (f FileInfo) IsValid() bool {
+	return f.LocalPath != "" || f.ReaderAt != nil
+}
+
+// Name returns the file name for messages: the local path, or the relative path of a remote file.
+func (f FileInfo) Name() string {
+	if f.LocalPath != "" {
+		return f.LocalPath
+	}
+	return f.RelativePath
+}
+
+// open returns the reader of the file content and its size; the returned closer must be closed by the caller.
+func (f FileInfo) open() (reader io.ReaderAt, size int64, closer io.Closer, err error) {
+	if f.LocalPath == "" && f.ReaderAt != nil {
+		return f.ReaderAt, f.Size, io.NopCloser(nil), nil
+	}
+	file, err := os.Open(f.LocalPath)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to open file %s: %w", f.LocalPath, err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, nil, fmt.Errorf("failed to get file info for %s: %w", f.LocalPath, err)
+	}
+	return file, stat.Size(), file, nil
 }
 
 type Source interface {
@@ -24,6 +66,11 @@ type Source interface {
 	// for duration of the program execution only.
 	GetFile(relativePath string) FileInfo
 
+	// GetFileRanged returns a file structure for reading only a part of the file, such as the footer
+	// of a Parquet file. A remote file is read with ranged requests instead of being downloaded
+	// if the storage supports it; otherwise it is the same as GetFile. The returned file must be disposed as well.
+	GetFileRanged(relativePath string) FileInfo
+
 	// Dispose this method must be called for every returned file when it is not needed anymore.
 	// It will make sure all temporary files are removed and not use disk space when not needed.
 	// If the file is not a temporary file, this method does nothing.
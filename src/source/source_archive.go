@@ -0,0 +1,341 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// archiveEntry describes a file inside an archive.
+type archiveEntry struct {
+	// size the size of the file content in bytes
+	size int64
+	// zipFile the entry of a zip archive (nil for tar archives)
+	zipFile *zip.File
+	// offset the offset of the file content in an uncompressed tar archive
+	offset int64
+}
+
+// ArchiveSource implementation of a data source with an AWS RDS database export packed into a single
+// tar, tar.gz (tgz) or zip archive. The archive must contain a single top-level directory named after the snapshot.
+// The archive is indexed once, and GetFile extracts only the requested entry to a temporary local file.
+// Entries of zip and uncompressed tar archives are accessed directly, while a tar.gz archive has to be
+// decompressed from the beginning up to the requested entry.
+type ArchiveSource struct {
+	// archivePath the path to the archive file
+	archivePath string
+	// zipReader the open zip archive (nil for tar archives)
+	zipReader *zip.ReadCloser
+	// compressed indicates a gzip-compressed tar archive
+	compressed bool
+	// snapshotName the name of the snapshot associated with the source (the top-level directory in the archive)
+	snapshotName string
+	// files the index of the files in the archive by their paths relative to the top-level directory
+	files map[string]archiveEntry
+	// dirs the set of directories in the archive (relative to the top-level directory), including implicit ones
+	dirs map[string]struct{}
+	// tempDir the local directory for extracted files
+	tempDir string
+	// minFreeSpace the minimal free space in bytes that must remain on the temp volume after every extraction
+	minFreeSpace uint64
+}
+
+// NewArchiveSource opens and indexes the archive (tar, tar.gz, tgz or zip, detected by the file extension).
+// Files are extracted into tempDir, or into the system temp directory if it is empty.
+func NewArchiveSource(archivePath string, tempDir string, minFreeSpace uint64) (*ArchiveSource, error) {
+	ret := &ArchiveSource{
+		archivePath:  archivePath,
+		files:        make(map[string]archiveEntry),
+		dirs:         make(map[string]struct{}),
+		tempDir:      TempDir(tempDir),
+		minFreeSpace: minFreeSpace,
+	}
+	lowerPath := strings.ToLower(archivePath)
+	var err error
+	switch {
+	case strings.HasSuffix(lowerPath, ".zip"):
+		err = ret.indexZip()
+	case strings.HasSuffix(lowerPath, ".tar.gz") || strings.HasSuffix(lowerPath, ".tgz"):
+		ret.compressed = true
+		err = ret.indexTar()
+	case strings.HasSuffix(lowerPath, ".tar"):
+		err = ret.indexTar()
+	default:
+		err = fmt.Errorf("unsupported archive type '%s', expected .tar, .tar.gz, .tgz or .zip", archivePath)
+	}
+	if err != nil {
+		ret.Close()
+		return nil, err
+	}
+	if ret.snapshotName == "" {
+		ret.Close()
+		return nil, fmt.Errorf("the archive '%s' is empty", archivePath)
+	}
+	log.Debug("Indexed the archive", zap.String("archive", archivePath),
+		zap.String("snapshot", ret.snapshotName), zap.Int("files", len(ret.files)))
+	return ret, nil
+}
+
+// Close releases the archive.
+func (l *ArchiveSource) Close() {
+	if l.zipReader != nil {
+		_ = l.zipReader.Close()
+		l.zipReader = nil
+	}
+}
+
+// indexZip reads the central directory of a zip archive.
+func (l *ArchiveSource) indexZip() (err error) {
+	l.zipReader, err = zip.OpenReader(l.archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open the archive '%s': %w", l.archivePath, err)
+	}
+	for _, file := range l.zipReader.File {
+		err = l.addEntry(file.Name, file.FileInfo().IsDir(), archiveEntry{size: int64(file.UncompressedSize64),
+			zipFile: file})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexTar reads all headers of a tar archive, remembering the offsets of the entries.
+func (l *ArchiveSource) indexTar() error {
+	return l.scanTar(func(header *tar.Header, offset int64, _ io.Reader) (bool, error) {
+		isDir := header.Typeflag == tar.TypeDir
+		if !isDir && header.Typeflag != tar.TypeReg {
+			return true, nil // links and special files are not expected in an export
+		}
+		return true, l.addEntry(header.Name, isDir, archiveEntry{size: header.Size, offset: offset})
+	})
+}
+
+// scanTar iterates over the entries of the tar archive, calling fn with the header, the offset of the content
+// in the uncompressed stream and the content reader, until fn returns false.
+func (l *ArchiveSource) scanTar(fn func(header *tar.Header, offset int64, content io.Reader) (bool, error)) error {
+	file, err := os.Open(l.archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open the archive '%s': %w", l.archivePath, err)
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	var stream io.Reader = file
+	if l.compressed {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to decompress the archive '%s': %w", l.archivePath, err)
+		}
+		defer func(gzipReader *gzip.Reader) {
+			_ = gzipReader.Close()
+		}(gzipReader)
+		stream = gzipReader
+	}
+	counter := &countingReader{reader: stream}
+	tarReader := tar.NewReader(counter)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the archive '%s': %w", l.archivePath, err)
+		}
+		// the tar reader reads whole blocks, so the content starts right after the consumed headers
+		next, err := fn(header, counter.count, tarReader)
+		if err != nil || !next {
+			return err
+		}
+	}
+}
+
+// addEntry adds a file or a directory of the archive to the index.
+func (l *ArchiveSource) addEntry(name string, isDir bool, entry archiveEntry) error {
+	name = strings.Trim(path.Clean("/"+filepath.ToSlash(name)), "/")
+	if name == "" {
+		return nil
+	}
+	topDir, relativePath, _ := strings.Cut(name, "/")
+	if l.snapshotName == "" {
+		l.snapshotName = topDir
+	} else if topDir != l.snapshotName {
+		return fmt.Errorf("the archive '%s' must contain a single top-level directory, found '%s' and '%s'",
+			l.archivePath, l.snapshotName, topDir)
+	}
+	if relativePath == "" {
+		return nil // the top-level directory itself
+	}
+	if isDir {
+		l.dirs[relativePath] = struct{}{}
+	} else {
+		l.files[relativePath] = entry
+	}
+	for dir := path.Dir(relativePath); dir != "."; dir = path.Dir(dir) {
+		l.dirs[dir] = struct{}{}
+	}
+	return nil
+}
+
+func (l *ArchiveSource) getSnapshotName() string {
+	return l.snapshotName
+}
+
+// GetFileRanged extracts the archive entry like GetFile - the entries of an archive are not read at an offset.
+func (l *ArchiveSource) GetFileRanged(relativePath string) FileInfo {
+	return l.GetFile(relativePath)
+}
+
+// GetFile extracts the archive entry to a temporary local file.
+// The extraction is refused if it would leave less than the configured minimal free space on the temp volume.
+func (l *ArchiveSource) GetFile(relativePath string) FileInfo {
+	name := strings.Trim(path.Clean("/"+filepath.ToSlash(relativePath)), "/")
+	entry, exists := l.files[name]
+	if !exists {
+		log.Error("File does not exist in the archive", zap.String("archive", l.archivePath),
+			zap.String("file", name))
+		return FileInfo{}
+	}
+	if err := checkTempFreeSpace(l.tempDir, uint64(entry.size), l.minFreeSpace); err != nil {
+		log.Error("Cannot extract the archive entry", zap.String("file", name), zap.Error(err))
+		return FileInfo{}
+	}
+
+	file, err := os.CreateTemp(l.tempDir, tempFilePrefix()+"*-"+path.Base(name))
+	if err != nil {
+		log.Error("Failed to create a temporary file", zap.String("dir", l.tempDir), zap.Error(err))
+		return FileInfo{}
+	}
+	ret := FileInfo{RelativePath: relativePath, LocalPath: file.Name(), Size: entry.size, Temp: true}
+	hash := sha256.New()
+	written, err := l.extract(name, entry, io.MultiWriter(file, hash))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written != entry.size {
+		err = fmt.Errorf("extracted %d bytes, expected %d bytes", written, entry.size)
+	}
+	if err != nil {
+		log.Error("Failed to extract the archive entry", zap.String("file", name), zap.Error(err))
+		l.Dispose(ret)
+		return FileInfo{}
+	}
+	ret.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	log.Debug("Extracted the archive entry", zap.String("file", name), zap.String("localPath", ret.LocalPath),
+		zap.Int64("size", entry.size))
+	return ret
+}
+
+// extract copies the content of the archive entry to the writer.
+func (l *ArchiveSource) extract(name string, entry archiveEntry, w io.Writer) (written int64, err error) {
+	if entry.zipFile != nil {
+		reader, err := entry.zipFile.Open()
+		if err != nil {
+			return 0, err
+		}
+		defer func(reader io.ReadCloser) {
+			_ = reader.Close()
+		}(reader)
+		return io.Copy(w, reader)
+	}
+	if !l.compressed {
+		file, err := os.Open(l.archivePath)
+		if err != nil {
+			return 0, err
+		}
+		defer func(file *os.File) {
+			_ = file.Close()
+		}(file)
+		return io.Copy(w, io.NewSectionReader(file, entry.offset, entry.size))
+	}
+	found := false
+	err = l.scanTar(func(header *tar.Header, _ int64, content io.Reader) (bool, error) {
+		if strings.Trim(path.Clean("/"+header.Name), "/") != l.snapshotName+"/"+name {
+			return true, nil
+		}
+		found = true
+		written, err = io.Copy(w, content)
+		return false, err
+	})
+	if err == nil && !found {
+		err = fmt.Errorf("the entry '%s' is not found in the archive", name)
+	}
+	return written, err
+}
+
+func (l *ArchiveSource) Dispose(file FileInfo) {
+	if file.Temp {
+		err := os.Remove(file.LocalPath) // Delete the file
+		if err != nil {
+			log.Error("Failed to delete file", zap.String("file", file.LocalPath), zap.Error(err))
+		}
+	}
+}
+
+func (l *ArchiveSource) listFiles(relativePath string, fileMask string, foldersOnly bool) ([]string, error) {
+	dir := strings.Trim(path.Clean("/"+filepath.ToSlash(relativePath)), "/")
+	if _, exists := l.dirs[dir]; !exists && dir != "" {
+		return []string{}, fmt.Errorf("LocalPath not found: %s", relativePath)
+	}
+	prefix, suffix := splitMask(fileMask)
+	var files []string
+	addChild := func(name string) {
+		if path.Dir(name) != dir && !(dir == "" && !strings.Contains(name, "/")) {
+			return
+		}
+		base := path.Base(name)
+		if strings.HasPrefix(base, prefix) && strings.HasSuffix(base, suffix) {
+			files = append(files, filepath.FromSlash(name))
+		}
+	}
+	for name := range l.dirs {
+		addChild(name)
+	}
+	if !foldersOnly {
+		for name := range l.files {
+			addChild(name)
+		}
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+func (l *ArchiveSource) ListFilesRecursively(relativePath string) (ret []string, err error) {
+	dir := strings.Trim(path.Clean("/"+filepath.ToSlash(relativePath)), "/")
+	if _, exists := l.dirs[dir]; !exists && dir != "" {
+		return []string{}, fmt.Errorf("LocalPath not found: %s", relativePath)
+	}
+	for name := range l.files {
+		if dir == "" || strings.HasPrefix(name, dir+"/") {
+			ret = append(ret, filepath.FromSlash(name))
+		}
+	}
+	slices.Sort(ret)
+	return ret, nil
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	// reader the underlying reader
+	reader io.Reader
+	// count the number of bytes read so far
+	count int64
+}
+
+// Read implements the interface io.Reader
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,151 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// archiveFixture the files of the test archives (inside the top-level directory "snap")
+var archiveFixture = map[string]string{
+	"snap/export_info_snap.json":                       `{"exportTaskIdentifier": "snap"}`,
+	"snap/export_tables_info_snap_from_1_to_2.json":    `{}`,
+	"snap/db/public.t/1/part-00000-1.gz.parquet":       "parquet data 1",
+	"snap/db/public.t/1/_SUCCESS":                      "",
+	"snap/db/public.t/2/part-00000-2.gz.parquet":       "parquet data 2",
+	"snap/other_db/public.u/1/part-00000-3.gz.parquet": "parquet data 3",
+}
+
+// archiveFixtureNames the fixture file names in a stable order
+var archiveFixtureNames = []string{
+	"snap/export_info_snap.json",
+	"snap/export_tables_info_snap_from_1_to_2.json",
+	"snap/db/public.t/1/part-00000-1.gz.parquet",
+	"snap/db/public.t/1/_SUCCESS",
+	"snap/db/public.t/2/part-00000-2.gz.parquet",
+	"snap/other_db/public.u/1/part-00000-3.gz.parquet",
+}
+
+// writeTarFixture writes the fixture files into a tar archive, optionally compressed.
+func writeTarFixture(t *testing.T, fileName string, compressed bool) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Failed to create the archive: %v", err)
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	var w io.Writer = file
+	if compressed {
+		gzipWriter := gzip.NewWriter(file)
+		defer func(gzipWriter *gzip.Writer) {
+			_ = gzipWriter.Close()
+		}(gzipWriter)
+		w = gzipWriter
+	}
+	tarWriter := tar.NewWriter(w)
+	defer func(tarWriter *tar.Writer) {
+		_ = tarWriter.Close()
+	}(tarWriter)
+	if err := tarWriter.WriteHeader(&tar.Header{Name: "snap/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatalf("Failed to write the archive: %v", err)
+	}
+	for _, name := range archiveFixtureNames {
+		content := archiveFixture[name]
+		header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to write the archive: %v", err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write the archive: %v", err)
+		}
+	}
+}
+
+// writeZipFixture writes the fixture files into a zip archive.
+func writeZipFixture(t *testing.T, fileName string) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Failed to create the archive: %v", err)
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	zipWriter := zip.NewWriter(file)
+	defer func(zipWriter *zip.Writer) {
+		_ = zipWriter.Close()
+	}(zipWriter)
+	for _, name := range archiveFixtureNames {
+		w, err := zipWriter.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to write the archive: %v", err)
+		}
+		if _, err := w.Write([]byte(archiveFixture[name])); err != nil {
+			t.Fatalf("Failed to write the archive: %v", err)
+		}
+	}
+}
+
+func TestArchiveSource(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name  string
+		file  string
+		write func(t *testing.T, fileName string)
+	}{
+		{name: "tar", file: "export.tar", write: func(t *testing.T, f string) { writeTarFixture(t, f, false) }},
+		{name: "tar.gz", file: "export.tar.gz", write: func(t *testing.T, f string) { writeTarFixture(t, f, true) }},
+		{name: "zip", file: "export.zip", write: writeZipFixture},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(dir, tt.file)
+			tt.write(t, fileName)
+			src, err := NewArchiveSource(fileName, t.TempDir(), 0)
+			if err != nil {
+				t.Fatalf("NewArchiveSource() error: %v", err)
+			}
+			defer src.Close()
+
+			if src.getSnapshotName() != "snap" {
+				t.Errorf("getSnapshotName() = %s; want snap", src.getSnapshotName())
+			}
+			databases, err := src.listFiles("", "*", true)
+			if err != nil || !reflect.DeepEqual(databases, []string{"db", "other_db"}) {
+				t.Errorf("listFiles(folders) = %v, %v", databases, err)
+			}
+			tableLists, err := src.listFiles("", "export_tables_info_snap_from_*.json", false)
+			if err != nil || !reflect.DeepEqual(tableLists, []string{"export_tables_info_snap_from_1_to_2.json"}) {
+				t.Errorf("listFiles(mask) = %v, %v", tableLists, err)
+			}
+			files, err := src.ListFilesRecursively(filepath.Join("db", "public.t"))
+			expected := []string{
+				filepath.Join("db", "public.t", "1", "_SUCCESS"),
+				filepath.Join("db", "public.t", "1", "part-00000-1.gz.parquet"),
+				filepath.Join("db", "public.t", "2", "part-00000-2.gz.parquet"),
+			}
+			if err != nil || !reflect.DeepEqual(files, expected) {
+				t.Errorf("ListFilesRecursively() = %v, %v; want %v", files, err, expected)
+			}
+
+			file := src.GetFile(filepath.Join("db", "public.t", "2", "part-00000-2.gz.parquet"))
+			if !file.Temp || file.Size != int64(len("parquet data 2")) {
+				t.Fatalf("GetFile() = %+v", file)
+			}
+			content, err := os.ReadFile(file.LocalPath)
+			if err != nil || string(content) != "parquet data 2" {
+				t.Errorf("Extracted content = %q, %v", content, err)
+			}
+			src.Dispose(file)
+			if _, err := os.Stat(file.LocalPath); !os.IsNotExist(err) {
+				t.Errorf("Dispose() did not remove the extracted file")
+			}
+		})
+	}
+}
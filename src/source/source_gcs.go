@@ -0,0 +1,236 @@
+package source
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gcsAPI is the subset of the GCS bucket used by GCSSource - it allows replacing the bucket in unit tests.
+type gcsAPI interface {
+	// Objects lists the objects (and the synthetic folders if the delimiter is set) matching the query
+	Objects(ctx context.Context, query *storage.Query) gcsObjectIterator
+	// NewReader opens the object for reading and returns its size
+	NewReader(ctx context.Context, name string) (io.ReadCloser, int64, error)
+}
+
+// gcsObjectIterator iterates over the listed objects; Next returns iterator.Done after the last one.
+type gcsObjectIterator interface {
+	Next() (*storage.ObjectAttrs, error)
+}
+
+// gcsBucket implements gcsAPI with the handle of a GCS bucket.
+type gcsBucket struct {
+	handle *storage.BucketHandle
+}
+
+func (b gcsBucket) Objects(ctx context.Context, query *storage.Query) gcsObjectIterator {
+	return b.handle.Objects(ctx, query)
+}
+
+func (b gcsBucket) NewReader(ctx context.Context, name string) (io.ReadCloser, int64, error) {
+	reader, err := b.handle.Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reader, reader.Attrs.Size, nil
+}
+
+// GCSSource implementation of a remote data source with an AWS RDS database export copied to a Google Cloud Storage
+// bucket. Files are downloaded to temporary local files on demand and removed by Dispose.
+type GCSSource struct {
+	// bucket the GCS bucket
+	bucket gcsAPI
+	// ctx the context of the GCS requests; its cancellation interrupts listings and downloads
+	ctx context.Context
+	// bucketName the name of the GCS bucket
+	bucketName string
+	// prefix the object name prefix of the exported snapshot inside the bucket (without the trailing "/")
+	prefix string
+	// snapshotName the name of the snapshot associated with the source (the last element of the prefix).
+	snapshotName string
+	// tempDir the local directory for downloaded files
+	tempDir string
+	// minFreeSpace the minimal free space in bytes that must remain on the temp volume after every download
+	minFreeSpace uint64
+}
+
+// NewGCSSource creates a new GCSSource for the given bucket path in the form "gs://bucket/path/to/snapshot".
+// The last element of the path must be the snapshot (export) name.
+// Files are downloaded into tempDir, or into the system temp directory if it is empty.
+// All GCS requests are made with the given context.
+func NewGCSSource(ctx context.Context, client *storage.Client, bucketPath string, tempDir string,
+	minFreeSpace uint64) (*GCSSource, error) {
+	bucketName, prefix, err := parseGCSBucketPath(bucketPath)
+	if err != nil {
+		return nil, err
+	}
+	return newGCSSource(ctx, gcsBucket{handle: client.Bucket(bucketName)}, bucketName, prefix, tempDir,
+		minFreeSpace), nil
+}
+
+// newGCSSource creates a GCSSource reading the snapshot under the prefix from the bucket (see NewGCSSource).
+func newGCSSource(ctx context.Context, bucket gcsAPI, bucketName string, prefix string, tempDir string,
+	minFreeSpace uint64) *GCSSource {
+	return &GCSSource{
+		bucket:       bucket,
+		ctx:          ctx,
+		bucketName:   bucketName,
+		prefix:       prefix,
+		snapshotName: path.Base(prefix),
+		tempDir:      TempDir(tempDir),
+		minFreeSpace: minFreeSpace,
+	}
+}
+
+// parseGCSBucketPath splits a GCS URI into the bucket name and the object name prefix.
+func parseGCSBucketPath(bucketPath string) (bucket string, prefix string, err error) {
+	s := strings.Trim(strings.TrimPrefix(strings.TrimSpace(bucketPath), "gs://"), "/")
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid GCS bucket path '%s', expected 'gs://bucket/path/to/snapshot'", bucketPath)
+	}
+	return parts[0], parts[1], nil
+}
+
+// objectName converts a path relative to the snapshot into the GCS object name.
+func (l *GCSSource) objectName(relativePath string) string {
+	relativePath = strings.Trim(filepath.ToSlash(relativePath), "/")
+	if relativePath == "" || relativePath == "." {
+		return l.prefix
+	}
+	return l.prefix + "/" + relativePath
+}
+
+// relativePath converts a GCS object name into the path relative to the snapshot.
+func (l *GCSSource) relativePath(name string) string {
+	return strings.Trim(strings.TrimPrefix(name, l.prefix), "/")
+}
+
+func (l *GCSSource) getSnapshotName() string {
+	return l.snapshotName
+}
+
+// GetFileRanged downloads the GCS object like GetFile - the bucket is not read with ranged requests.
+func (l *GCSSource) GetFileRanged(relativePath string) FileInfo {
+	return l.GetFile(relativePath)
+}
+
+// GetFile downloads the GCS object to a temporary local file.
+// The download is refused if it would leave less than the configured minimal free space on the temp volume.
+func (l *GCSSource) GetFile(relativePath string) FileInfo {
+	name := l.objectName(relativePath)
+	reader, size, err := l.bucket.NewReader(l.ctx, name)
+	if err != nil {
+		log.Error("Failed to get GCS object", zap.String("bucket", l.bucketName), zap.String("object", name),
+			zap.Error(err))
+		return FileInfo{}
+	}
+	defer func(reader io.ReadCloser) {
+		if err := reader.Close(); err != nil {
+			log.Error("Failed to close GCS object", zap.String("object", name), zap.Error(err))
+		}
+	}(reader)
+
+	if err := checkTempFreeSpace(l.tempDir, uint64(size), l.minFreeSpace); err != nil {
+		log.Error("Cannot download GCS object", zap.String("object", name), zap.Error(err))
+		return FileInfo{}
+	}
+
+	file, err := os.CreateTemp(l.tempDir, tempFilePrefix()+"*-"+path.Base(name))
+	if err != nil {
+		log.Error("Failed to create a temporary file", zap.String("dir", l.tempDir), zap.Error(err))
+		return FileInfo{}
+	}
+	ret := FileInfo{RelativePath: relativePath, LocalPath: file.Name(), Size: size, Temp: true}
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(file, hash), reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written != size {
+		err = fmt.Errorf("downloaded %d bytes, expected %d bytes", written, size)
+	}
+	if err != nil {
+		log.Error("Failed to download GCS object", zap.String("object", name), zap.Error(err))
+		l.Dispose(ret)
+		return FileInfo{}
+	}
+	ret.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	log.Debug("Downloaded GCS object", zap.String("object", name), zap.String("file", ret.LocalPath),
+		zap.Int64("size", size))
+	return ret
+}
+
+func (l *GCSSource) Dispose(file FileInfo) {
+	if file.Temp {
+		err := os.Remove(file.LocalPath) // Delete the file
+		if err != nil {
+			log.Error("Failed to delete file", zap.String("file", file.LocalPath), zap.Error(err))
+		}
+	}
+}
+
+// listObjects iterates over all objects (and synthetic folders if the delimiter is set) with the given prefix.
+func (l *GCSSource) listObjects(query *storage.Query, fn func(attrs *storage.ObjectAttrs)) error {
+	it := l.bucket.Objects(l.ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("listing GCS objects with prefix '%s' failed: %w", query.Prefix, err)
+		}
+		fn(attrs)
+	}
+}
+
+func (l *GCSSource) listFiles(relativePath string, fileMask string, foldersOnly bool) ([]string, error) {
+	var files []string
+	prefix, suffix := splitMask(fileMask)
+	dirName := l.objectName(relativePath) + "/"
+
+	err := l.listObjects(&storage.Query{Prefix: dirName, Delimiter: "/"}, func(attrs *storage.ObjectAttrs) {
+		var name string
+		if attrs.Prefix != "" { // a synthetic folder
+			name = strings.TrimSuffix(attrs.Prefix, "/")
+		} else if !foldersOnly {
+			name = attrs.Name
+		} else {
+			return
+		}
+		base := path.Base(name)
+		if strings.HasPrefix(base, prefix) && strings.HasSuffix(base, suffix) {
+			files = append(files, l.relativePath(name))
+		}
+	})
+	if err != nil {
+		return []string{}, err
+	}
+	return files, nil
+}
+
+func (l *GCSSource) ListFilesRecursively(relativePath string) (ret []string, err error) {
+	dirName := l.objectName(relativePath) + "/"
+	err = l.listObjects(&storage.Query{Prefix: dirName}, func(attrs *storage.ObjectAttrs) {
+		if strings.HasSuffix(attrs.Name, "/") {
+			return // a folder placeholder object
+		}
+		ret = append(ret, l.relativePath(attrs.Name))
+	})
+	if err != nil {
+		return []string{}, err
+	}
+	return ret, nil
+}
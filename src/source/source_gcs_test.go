@@ -0,0 +1,197 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// fakeGCS is an in-memory replacement of the GCS bucket.
+type fakeGCS struct {
+	// objects the content of the objects by their names
+	objects map[string][]byte
+}
+
+func (f *fakeGCS) Objects(_ context.Context, query *storage.Query) gcsObjectIterator {
+	var names []string
+	for name := range f.objects {
+		if !strings.HasPrefix(name, query.Prefix) {
+			continue
+		}
+		if i := strings.Index(name[len(query.Prefix):], query.Delimiter); query.Delimiter != "" && i >= 0 {
+			name = name[:len(query.Prefix)+i+len(query.Delimiter)]
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	ret := &fakeObjectIterator{}
+	for _, name := range names {
+		if query.Delimiter != "" && strings.HasSuffix(name, query.Delimiter) {
+			ret.attrs = append(ret.attrs, &storage.ObjectAttrs{Prefix: name})
+		} else {
+			ret.attrs = append(ret.attrs, &storage.ObjectAttrs{Name: name, Size: int64(len(f.objects[name]))})
+		}
+	}
+	return ret
+}
+
+func (f *fakeGCS) NewReader(_ context.Context, name string) (io.ReadCloser, int64, error) {
+	content, exists := f.objects[name]
+	if !exists {
+		return nil, 0, fmt.Errorf("object not found: %s", name)
+	}
+	return io.NopCloser(bytes.NewReader(content)), int64(len(content)), nil
+}
+
+// fakeObjectIterator returns the listed objects of fakeGCS one by one.
+type fakeObjectIterator struct {
+	attrs []*storage.ObjectAttrs
+}
+
+func (it *fakeObjectIterator) Next() (*storage.ObjectAttrs, error) {
+	if len(it.attrs) == 0 {
+		return nil, iterator.Done
+	}
+	ret := it.attrs[0]
+	it.attrs = it.attrs[1:]
+	return ret, nil
+}
+
+// gcsFixture the files of the export in the tests, relative to the snapshot
+var gcsFixture = map[string]string{
+	"export_info_snap.json":                    "{}",
+	"export_tables_info_snap_from_1_to_2.json": "{}",
+	"db/public.t1/1/part-00000.parquet":        "a",
+	"db/public.t1/1/part-00001.parquet":        "bb",
+	"db/public.t1/1/_SUCCESS":                  "",
+	"db/public.t2/1/part-00000.parquet":        "ccc",
+	"other/public.t3/1/part-00000.parquet":     "d",
+}
+
+// newGCSFixture creates a GCSSource with the fixture files in a fake bucket under "exports/snap".
+func newGCSFixture(t *testing.T) *GCSSource {
+	bucket := &fakeGCS{objects: map[string][]byte{}}
+	for name, content := range gcsFixture {
+		bucket.objects["exports/snap/"+name] = []byte(content)
+	}
+	bucketName, prefix, err := parseGCSBucketPath("gs://bucket/exports/snap/")
+	if err != nil {
+		t.Fatalf("parseGCSBucketPath() error: %v", err)
+	}
+	return newGCSSource(context.Background(), bucket, bucketName, prefix, t.TempDir(), 0)
+}
+
+func TestGCSSourceListFiles(t *testing.T) {
+	src := newGCSFixture(t)
+	if src.bucketName != "bucket" || src.getSnapshotName() != "snap" {
+		t.Fatalf("newGCSSource() = %+v; want the bucket 'bucket' and the snapshot 'snap'", src)
+	}
+	// the same files in a local directory, so that the listings are compared with LocalSource
+	localDir := filepath.Join(t.TempDir(), "snap")
+	for name, content := range gcsFixture {
+		fileName := filepath.Join(localDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			t.Fatalf("Failed to create the fixture: %v", err)
+		}
+		if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create the fixture: %v", err)
+		}
+	}
+	local := NewLocalSource(localDir)
+
+	tests := []struct {
+		name         string
+		relativePath string
+		fileMask     string
+		foldersOnly  bool
+		expected     []string
+	}{
+		{"databases", "", "*", true, []string{"db", "other"}},
+		{"all entries", "", "*", false, []string{"db", "export_info_snap.json",
+			"export_tables_info_snap_from_1_to_2.json", "other"}},
+		{"prefix and suffix", "", "export_tables_info_snap_from_*.json", false,
+			[]string{"export_tables_info_snap_from_1_to_2.json"}},
+		{"tables", "db", "public.*", true, []string{"db/public.t1", "db/public.t2"}},
+		{"parts", "db/public.t1/1", "part-*.parquet", false,
+			[]string{"db/public.t1/1/part-00000.parquet", "db/public.t1/1/part-00001.parquet"}},
+		{"mask without a star is a prefix", "db/public.t1/1", "part-00001", false,
+			[]string{"db/public.t1/1/part-00001.parquet"}},
+		{"no files in folders only", "db/public.t1/1", "*", true, nil},
+		{"no match", "db", "private.*", true, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files, err := src.listFiles(test.relativePath, test.fileMask, test.foldersOnly)
+			if err != nil {
+				t.Fatalf("listFiles() error: %v", err)
+			}
+			if !slices.Equal(files, test.expected) {
+				t.Errorf("listFiles() = %v; want %v", files, test.expected)
+			}
+			localFiles, err := local.listFiles(test.relativePath, test.fileMask, test.foldersOnly)
+			if err != nil {
+				t.Fatalf("LocalSource.listFiles() error: %v", err)
+			}
+			if !slices.Equal(localFiles, files) {
+				t.Errorf("LocalSource.listFiles() = %v; GCSSource.listFiles() = %v", localFiles, files)
+			}
+		})
+	}
+
+	files, err := src.ListFilesRecursively("db/public.t1")
+	expected := []string{"db/public.t1/1/_SUCCESS", "db/public.t1/1/part-00000.parquet",
+		"db/public.t1/1/part-00001.parquet"}
+	if err != nil || !reflect.DeepEqual(files, expected) {
+		t.Errorf("ListFilesRecursively() = %v, %v; want %v", files, err, expected)
+	}
+}
+
+func TestGCSSourceGetFile(t *testing.T) {
+	src := newGCSFixture(t)
+
+	tests := []struct {
+		name         string
+		relativePath string
+		content      string
+		valid        bool
+	}{
+		{"part", "db/public.t2/1/part-00000.parquet", "ccc", true},
+		{"empty marker", "db/public.t1/1/_SUCCESS", "", true},
+		{"missing", "db/public.t2/1/part-00001.parquet", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := src.GetFile(test.relativePath)
+			if file.IsValid() != test.valid {
+				t.Fatalf("GetFile() = %+v; want valid = %v", file, test.valid)
+			}
+			if !test.valid {
+				return
+			}
+			if !file.Temp || file.RelativePath != test.relativePath || file.Size != int64(len(test.content)) ||
+				file.SHA256 == "" {
+				t.Errorf("GetFile() = %+v; want a temporary file of %d bytes", file, len(test.content))
+			}
+			content, err := os.ReadFile(file.LocalPath)
+			if err != nil || string(content) != test.content {
+				t.Errorf("the downloaded file = %q, %v; want %q", content, err, test.content)
+			}
+			src.Dispose(file)
+			if _, err := os.Stat(file.LocalPath); !os.IsNotExist(err) {
+				t.Errorf("Dispose() left the file %s: %v", file.LocalPath, err)
+			}
+		})
+	}
+}
@@ -38,6 +38,11 @@ func NewLocalSource(localDir string) *LocalSource {
 	return &LocalSource{localDir: localDir, snapshotName: lastSubfolder}
 }
 
+// GetFileRanged returns the local file like GetFile - it is read in place anyway.
+func (l *LocalSource) GetFileRanged(path string) FileInfo {
+	return l.GetFile(path)
+}
+
 func (l *LocalSource) GetFile(path string) FileInfo {
 	// Concatenate localDir with the given LocalPath using correct file LocalPath delimiters
 	fullPath := filepath.Join(l.localDir, path)
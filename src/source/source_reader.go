@@ -1,11 +1,21 @@
 package source
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	config2 "dbrestore/config"
+	"dbrestore/utils"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -31,8 +41,20 @@ type ColumnInfo struct {
 	// OriginalNumPrecision defines the numeric precision of the column as specified in the source database.
 	OriginalNumPrecision int `json:"originalNumPrecision"`
 
+	// OriginalNumScale defines the numeric scale (the digits after the decimal point) of the column
+	// as specified in the source database, or 0 if the export does not contain it.
+	OriginalNumScale int `json:"originalNumScale"`
+
 	// OriginalDateTimePrecision defines the precision of datetime values in the source database for this column.
 	OriginalDateTimePrecision int `json:"originalDateTimePrecision"`
+
+	// OriginalElementType defines the type of the elements of an ARRAY column (for example "integer"),
+	// or empty if the export does not contain it.
+	OriginalElementType string `json:"originalElementType"`
+
+	// OrdinalPosition defines the position of the column in the source table (starting from 1),
+	// or 0 if the export does not contain it.
+	OrdinalPosition int `json:"ordinalPosition"`
 }
 
 // ParquetFileInfo holds metadata about a Parquet file, including its associated table, file name, and column definitions.
@@ -62,6 +84,13 @@ type Reader struct {
 
 	// config holds the application configuration, important for the parsing process.
 	config *config2.Config
+
+	// systemTables the tables of the destination database excluded from the restore (see IgnoreSystemTables)
+	systemTables map[string]struct{}
+
+	// selectedTables the tables of the destination database to which the restore is restricted, all tables if nil
+	// (see SelectTables)
+	selectedTables map[string]struct{}
 }
 
 // NewSourceReader initializes a SourceReader with the given Source instance.
@@ -69,6 +98,11 @@ func NewSourceReader(config *config2.Config, source Source) Reader {
 	return Reader{config: config, source: source}
 }
 
+// SnapshotName returns the name of the exported snapshot.
+func (r *Reader) SnapshotName() string {
+	return r.source.getSnapshotName()
+}
+
 // IterateOverTables validates export metadata and ensures all conditions on snapshot name, status, and progress are met.
 func (r *Reader) IterateOverTables(databaseTables []string) (ret ParquetFileInfoList, err error) {
 	err = r.validateExportInfo()
@@ -117,6 +151,8 @@ func (r *Reader) IterateOverTables(databaseTables []string) (ret ParquetFileInfo
 	return
 }
 
+// processFile parses a single export_tables_info JSON file and returns the tables described in it.
+// When tableMap is not nil, every table is validated against it and marked as present there.
 func (r *Reader) processFile(relativePath string, tableMap *map[string]bool) (ret ParquetFileInfoList, err error) {
 	fileInfo := r.source.GetFile(relativePath)
 	defer r.source.Dispose(fileInfo)
@@ -135,7 +171,11 @@ func (r *Reader) processFile(relativePath string, tableMap *map[string]bool) (re
 		}
 	}(file)
 
-	decoder := jstream.NewDecoder(file, 2)
+	content, err := metadataReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("processFile(): failed to read the file '%s': %w", fileInfo.LocalPath, err)
+	}
+	decoder := jstream.NewDecoder(content, 2)
 
 	ret = make(ParquetFileInfoList, 0)
 	errorCount := 0
@@ -204,9 +244,22 @@ func (r *Reader) processFile(relativePath string, tableMap *map[string]bool) (re
 			if err != nil {
 				return nil, fmt.Errorf("processFile(): error parsing the file '%s': %w", file.Name(), err)
 			}
+			if r.systemTable(targetStr) {
+				log.Debug("processFile() skipping the system table", zap.String("table name", targetStr))
+				continue
+			}
+			if !r.tableSelected(targetStr) {
+				log.Debug("processFile() skipping the table not selected", zap.String("table name", targetStr))
+				continue
+			}
 
 			ret = append(ret, NewParquetFileInfo(targetStr, fileInfo.LocalPath, columns))
 
+			if tableMap == nil {
+				// no target database to validate against
+				log.Debug("processFile()", zap.String("table name", targetStr), zap.Int("column count", columnCount))
+				continue
+			}
 			exists, ignore := r.tableFound(targetStr, tableMap)
 			if exists {
 				if (*tableMap)[targetStr] {
@@ -235,6 +288,30 @@ func (r *Reader) processFile(relativePath string, tableMap *map[string]bool) (re
 	return ret, nil
 }
 
+// ReadExportTables parses the export metadata and returns all tables found in the export,
+// without validating them against a target database.
+func (r *Reader) ReadExportTables() (ret ParquetFileInfoList, err error) {
+	err = r.validateExportInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := r.listTableListFiles()
+	if err != nil {
+		return nil, fmt.Errorf("ReadExportTables(): %w", err)
+	}
+
+	ret = make(ParquetFileInfoList, 0)
+	for _, file := range files {
+		moreTables, err := r.processFile(file, nil)
+		if err != nil {
+			return nil, fmt.Errorf("ReadExportTables(): error reading the file %s: %w", file, err)
+		}
+		ret = append(ret, moreTables...)
+	}
+	return ret, nil
+}
+
 func (r *Reader) readColumns(originalTypeMappingsMap []interface{}) (ret []ColumnInfo, err error) {
 	columns := make([]ColumnInfo, 0)
 
@@ -270,10 +347,61 @@ func (r *Reader) readColumns(originalTypeMappingsMap []interface{}) (ret []Colum
 		if err != nil {
 			return nil, err
 		}
+		if _, exists := columnMap["originalNumScale"]; exists {
+			// optional - not all exports contain it
+			columnInfo.OriginalNumScale, err = r.readIntField(columnMap, index, "originalNumScale")
+			if err != nil {
+				return nil, err
+			}
+		}
+		if _, exists := columnMap["originalElementType"]; exists {
+			// optional - not all exports contain it
+			columnInfo.OriginalElementType, err = r.readField(columnMap, index, "originalElementType")
+			if err != nil {
+				return nil, err
+			}
+		}
+		if _, exists := columnMap["ordinalPosition"]; exists {
+			// optional - not all exports contain it
+			columnInfo.OrdinalPosition, err = r.readIntField(columnMap, index, "ordinalPosition")
+			if err != nil {
+				return nil, err
+			}
+		}
 
 		columns = append(columns, columnInfo)
 	}
 
+	return sortColumnsByOrdinal(columns)
+}
+
+// sortColumnsByOrdinal orders the columns by their ordinal positions if the export provides them,
+// instead of trusting the order of elements in 'originalTypeMappings'.
+// The ordinal positions must be either present for all columns or for none, and must be unique.
+func sortColumnsByOrdinal(columns []ColumnInfo) ([]ColumnInfo, error) {
+	withOrdinals := 0
+	positions := make(map[int]string)
+	for _, column := range columns {
+		if column.OrdinalPosition <= 0 {
+			continue
+		}
+		withOrdinals++
+		if other, duplicate := positions[column.OrdinalPosition]; duplicate {
+			return nil, fmt.Errorf("sortColumnsByOrdinal(): columns '%s' and '%s' have the same ordinal position %d",
+				other, column.ColumnName, column.OrdinalPosition)
+		}
+		positions[column.OrdinalPosition] = column.ColumnName
+	}
+	if withOrdinals == 0 {
+		return columns, nil
+	}
+	if withOrdinals != len(columns) {
+		return nil, fmt.Errorf("sortColumnsByOrdinal(): 'ordinalPosition' is present only in %d of %d columns",
+			withOrdinals, len(columns))
+	}
+	sort.SliceStable(columns, func(i, j int) bool {
+		return columns[i].OrdinalPosition < columns[j].OrdinalPosition
+	})
 	return columns, nil
 }
 
@@ -294,6 +422,45 @@ func (r *Reader) tableFound(tableName string, tableMap *map[string]bool) (exists
 	return exists, ignore
 }
 
+// IgnoreSystemTables marks the tables of the destination database that are excluded from the restore,
+// for example the tables owned by extensions, so that their data in the export is skipped instead of being
+// reported as a table missing in the database. The tables of config.Config.SystemSchemas are skipped anyway.
+func (r *Reader) IgnoreSystemTables(tables []string) {
+	r.systemTables = make(map[string]struct{}, len(tables))
+	for _, table := range tables {
+		r.systemTables[table] = struct{}{}
+	}
+}
+
+// systemTable checks if the table of the export is excluded from the restore (see IgnoreSystemTables).
+func (r *Reader) systemTable(tableName string) bool {
+	if _, found := r.systemTables[tableName]; found {
+		return true
+	}
+	schema, _ := utils.SplitFullTableName(tableName)
+	_, found := r.config.SystemSchemas[schema]
+	return found && schema != ""
+}
+
+// SelectTables restricts the restore to the given tables of the destination database (see
+// config.Config.TablesOnly), so that the data of the other tables in the export is skipped instead of being
+// reported as a table missing in the database.
+func (r *Reader) SelectTables(tables []string) {
+	r.selectedTables = make(map[string]struct{}, len(tables))
+	for _, table := range tables {
+		r.selectedTables[table] = struct{}{}
+	}
+}
+
+// tableSelected checks if the table of the export is a part of the restore (see SelectTables).
+func (r *Reader) tableSelected(tableName string) bool {
+	if r.selectedTables == nil {
+		return true
+	}
+	_, found := r.selectedTables[tableName]
+	return found
+}
+
 // tableIgnored checks if this missing table should be ignored
 func (r *Reader) tableIgnored(tableName string) bool {
 	// check if this missing table should be ignored
@@ -320,31 +487,163 @@ func (r *Reader) ListDatabases() error {
 	}
 	log.Info(fmt.Sprintf("Found %d database folder(s)", len(folders)))
 	for _, folder := range folders {
-		log.Info(folder)
+		if r.config.DatabaseSelected(folder) {
+			log.Info(folder)
+		} else {
+			log.Info(folder + " (excluded)")
+		}
 	}
 	return nil
 }
 
+// Databases returns the names of the databases (subfolders) in the snapshot that pass the database filters.
+func (r *Reader) Databases() ([]string, error) {
+	folders, err := r.source.listFiles("", "*", true)
+	if err != nil || len(folders) <= 0 {
+		return nil, fmt.Errorf("error reading the database subfolders: %w", err)
+	}
+	return FilterDatabases(r.config, folders), nil
+}
+
+// FilterDatabases returns the database names that pass the IncludeDatabases and ExcludeDatabases filters.
+func FilterDatabases(conf *config2.Config, names []string) []string {
+	ret := make([]string, 0, len(names))
+	for _, name := range names {
+		if conf.DatabaseSelected(name) {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+// exportInfoFileName returns the name of the export info file of the snapshot.
+func exportInfoFileName(snapshotName string) string {
+	return fmt.Sprintf("export_info_%s.json", snapshotName)
+}
+
+// gzipMagic the first bytes of a gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// metadataReader returns the reader of the content of a JSON metadata file of the export, which some pipelines
+// compress with gzip: a file starting with the gzip magic bytes (usually named *.json.gz) is decompressed
+// transparently, and other files are read as they are.
+func metadataReader(file io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(file)
+	magic, err := buffered.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return buffered, nil
+	}
+	return gzip.NewReader(buffered)
+}
+
+// readMetadataFile reads the complete content of a JSON metadata file of the export (see metadataReader).
+func readMetadataFile(fileName string) ([]byte, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	content, err := metadataReader(file)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(content)
+}
+
+// findExportInfoFile returns the name of the export info file of the snapshot: the JSON file, or the gzipped one
+// ("export_info_<snapshot>.json.gz") when only that one exists.
+func (r *Reader) findExportInfoFile() string {
+	name := exportInfoFileName(r.source.getSnapshotName())
+	candidates, err := r.source.listFiles("", name+"*", false)
+	if err == nil && !slices.Contains(candidates, name) && slices.Contains(candidates, name+".gz") {
+		return name + ".gz"
+	}
+	return name
+}
+
+// tableListFilePattern returns the regular expression matching exactly the names of the table list files
+// of the snapshot, for example "export_tables_info_export-test-01_from_1_to_96.json" (or the gzipped
+// "....json.gz"), and capturing the range of table numbers. The snapshot name is matched literally as a whole
+// segment, so names with dots or spaces, or names of other exports in the same folder that share a prefix with it,
+// are never confused.
+func tableListFilePattern(snapshotName string) *regexp.Regexp {
+	return regexp.MustCompile(`^export_tables_info_` + regexp.QuoteMeta(snapshotName) +
+		`_from_(\d+)_to_(\d+)\.json(\.gz)?$`)
+}
+
+// tableListFile is a table list file of the snapshot with the range of table numbers parsed from its name.
+type tableListFile struct {
+	// name the path of the file relative to the snapshot
+	name string
+	// from the number of the first table in the file
+	from int
+	// to the number of the last table in the file
+	to int
+}
+
+// listTableListFiles returns the table list files of the snapshot ordered by their ranges of tables.
+// The listing is narrowed by the file mask, and every candidate must match tableListFilePattern exactly;
+// gaps between the ranges (missing files) are reported as warnings.
 func (r *Reader) listTableListFiles() (files []string, err error) {
-	// for example "export_tables_info_export-test-01_from_1_to_96.json"
-	tablesMask := fmt.Sprintf("export_tables_info_%s_from_*.json", r.source.getSnapshotName())
-	files, err = r.source.listFiles("", tablesMask, false)
-	if err != nil || len(files) <= 0 {
-		err = fmt.Errorf("error reading the table list: %w", err)
-	} else {
-		log.Debug("listTableListFiles()", zap.Int("files.len", len(files)))
+	snapshotName := r.source.getSnapshotName()
+	tablesMask := fmt.Sprintf("export_tables_info_%s_from_*.json", snapshotName)
+	// the mask ends with "*", so that the gzipped files match too
+	candidates, err := r.source.listFiles("", strings.TrimSuffix(tablesMask, ".json"), false)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the table list: %w", err)
 	}
-	return
+	pattern := tableListFilePattern(snapshotName)
+	parsed := make([]tableListFile, 0, len(candidates))
+	for _, candidate := range candidates {
+		match := pattern.FindStringSubmatch(path.Base(filepath.ToSlash(candidate)))
+		if match == nil {
+			log.Debug("Skipping a file of another export", zap.String("file", candidate))
+			continue
+		}
+		from, _ := strconv.Atoi(match[1])
+		to, _ := strconv.Atoi(match[2])
+		parsed = append(parsed, tableListFile{name: candidate, from: from, to: to})
+	}
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("error reading the table list: no files '%s' found", tablesMask)
+	}
+	// the plain file sorts before the gzipped file of the same range
+	sort.Slice(parsed, func(i, j int) bool {
+		if parsed[i].from != parsed[j].from {
+			return parsed[i].from < parsed[j].from
+		}
+		return parsed[i].name < parsed[j].name
+	})
+	next := 1
+	for i, file := range parsed {
+		if i > 0 && file.from == parsed[i-1].from && file.to == parsed[i-1].to {
+			log.Warn("Skipping a duplicate of a table list file", zap.String("file", file.name),
+				zap.String("used", parsed[i-1].name))
+			continue
+		}
+		if file.from != next {
+			log.Warn("The table list files of the export are not contiguous", zap.String("file", file.name),
+				zap.Int("expected_from", next), zap.Int("from", file.from))
+		}
+		next = file.to + 1
+		files = append(files, file.name)
+	}
+	log.Debug("listTableListFiles()", zap.Int("files.len", len(files)))
+	return files, nil
 }
 
 func (r *Reader) validateExportInfo() (err error) {
-	info := fmt.Sprintf("export_info_%s.json", r.source.getSnapshotName())
-	exportInfoFile := r.source.GetFile(info)
+	exportInfoFile := r.source.GetFile(r.findExportInfoFile())
 	log.Debug("IterateOverTables()", zap.String("exportInfoFile.LocalPath", exportInfoFile.LocalPath))
 	defer r.source.Dispose(exportInfoFile)
 
 	// Read the complete file to a string in memory
-	content, err := os.ReadFile(exportInfoFile.LocalPath)
+	content, err := readMetadataFile(exportInfoFile.LocalPath)
 	if err != nil {
 		return fmt.Errorf("failed to read the file '%s': %w", exportInfoFile.LocalPath, err)
 	}
@@ -364,7 +663,7 @@ func (r *Reader) validateExportInfo() (err error) {
 		return fmt.Errorf("key 'exportTaskIdentifier' not found in JSON data")
 	}
 
-	if exportTaskIdentifier != snapshotName {
+	if identifier, isString := exportTaskIdentifier.(string); !isString || identifier != snapshotName {
 		return fmt.Errorf("value of 'exportTaskIdentifier' does not match snapshotName: expected '%s', got '%v'",
 			snapshotName, exportTaskIdentifier)
 	}
@@ -385,7 +684,8 @@ func (r *Reader) validateExportInfo() (err error) {
 	}
 
 	const percentProgress100 = 100
-	if math.Abs(percentProgress.(float64)-float64(percentProgress100)) > 0.000001 {
+	if value, isNumber := percentProgress.(float64); !isNumber ||
+		math.Abs(value-float64(percentProgress100)) > 0.000001 {
 		return fmt.Errorf("value of 'percentProgress' does not match the expected '%d', got '%v'",
 			percentProgress100, percentProgress)
 	}
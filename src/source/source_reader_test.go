@@ -0,0 +1,300 @@
+package source
+
+import (
+	"bytes"
+	"compress/gzip"
+	"dbrestore/config"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testColumn creates a column element of 'originalTypeMappings' as it is decoded from the export JSON.
+func testColumn(name string, ordinalPosition float64) map[string]interface{} {
+	ret := map[string]interface{}{
+		"columnName":                name,
+		"originalType":              "integer",
+		"expectedExportedType":      "int32",
+		"originalCharMaxLength":     float64(0),
+		"originalNumPrecision":      float64(32),
+		"originalDateTimePrecision": float64(0),
+	}
+	if ordinalPosition > 0 {
+		ret["ordinalPosition"] = ordinalPosition
+	}
+	return ret
+}
+
+func TestReadColumnsOrdinalPositions(t *testing.T) {
+	tests := []struct {
+		name          string
+		mappings      []interface{}
+		expectedNames []string
+		expectError   bool
+	}{
+		{
+			name:          "Out-of-order entries are sorted by ordinal position",
+			mappings:      []interface{}{testColumn("c", 3), testColumn("a", 1), testColumn("b", 2)},
+			expectedNames: []string{"a", "b", "c"},
+		},
+		{
+			name:          "Without ordinal positions the metadata order is kept",
+			mappings:      []interface{}{testColumn("c", 0), testColumn("a", 0)},
+			expectedNames: []string{"c", "a"},
+		},
+		{
+			name:        "Duplicate ordinal positions",
+			mappings:    []interface{}{testColumn("a", 1), testColumn("b", 1)},
+			expectError: true,
+		},
+		{
+			name:        "Ordinal positions only for some columns",
+			mappings:    []interface{}{testColumn("a", 1), testColumn("b", 0)},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Reader{}
+			columns, err := r.readColumns(tt.mappings)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("readColumns() error = %v; expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+			names := make([]string, 0, len(columns))
+			for _, column := range columns {
+				names = append(names, column.ColumnName)
+			}
+			if !reflect.DeepEqual(names, tt.expectedNames) {
+				t.Errorf("readColumns() = %v; want %v", names, tt.expectedNames)
+			}
+		})
+	}
+}
+
+func TestFilterDatabases(t *testing.T) {
+	databases := []string{"db1", "db2", "db3", "db4"}
+	tests := []struct {
+		name           string
+		include        map[string]struct{}
+		exclude        map[string]struct{}
+		expectedResult []string
+	}{
+		{name: "No filters", expectedResult: databases},
+		{name: "Include", include: map[string]struct{}{"db2": {}, "db4": {}},
+			expectedResult: []string{"db2", "db4"}},
+		{name: "Exclude", exclude: map[string]struct{}{"db1": {}},
+			expectedResult: []string{"db2", "db3", "db4"}},
+		{name: "Include and exclude", include: map[string]struct{}{"db1": {}, "db2": {}},
+			exclude: map[string]struct{}{"db2": {}}, expectedResult: []string{"db1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &config.Config{IncludeDatabases: tt.include, ExcludeDatabases: tt.exclude}
+			result := FilterDatabases(conf, databases)
+			if !reflect.DeepEqual(result, tt.expectedResult) {
+				t.Errorf("FilterDatabases() = %v; want %v", result, tt.expectedResult)
+			}
+		})
+	}
+}
+
+func TestSystemTable(t *testing.T) {
+	conf := &config.Config{SystemSchemas: map[string]struct{}{"partman": {}, "topology": {}}}
+	r := NewSourceReader(conf, NewLocalSource(t.TempDir()))
+	r.IgnoreSystemTables([]string{"public.spatial_ref_sys"})
+	tests := []struct {
+		table          string
+		expectedResult bool
+	}{
+		{table: "partman.part_config", expectedResult: true},
+		{table: "topology.layer", expectedResult: true},
+		{table: "public.spatial_ref_sys", expectedResult: true},
+		{table: "public.users", expectedResult: false},
+		{table: "partman_data.users", expectedResult: false},
+		{table: "users", expectedResult: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.table, func(t *testing.T) {
+			if result := r.systemTable(tt.table); result != tt.expectedResult {
+				t.Errorf("systemTable(%s) = %v; want %v", tt.table, result, tt.expectedResult)
+			}
+		})
+	}
+}
+
+func TestTableSelected(t *testing.T) {
+	r := NewSourceReader(&config.Config{}, NewLocalSource(t.TempDir()))
+	if !r.tableSelected("public.users") {
+		t.Errorf("tableSelected() = false without a selection; want true")
+	}
+	r.SelectTables([]string{"public.users", "public.orders"})
+	if !r.tableSelected("public.orders") {
+		t.Errorf("tableSelected(public.orders) = false; want true")
+	}
+	if r.tableSelected("public.logs") {
+		t.Errorf("tableSelected(public.logs) = true; want false")
+	}
+}
+
+func TestListTableListFiles(t *testing.T) {
+	// the table list files of several exports whose names share prefixes, in the same folder
+	files := []string{
+		"export_tables_info_export.2024.06.01-prod_from_1_to_2.json",
+		"export_tables_info_export.2024.06.01-prod_from_3_to_9.json",
+		"export_tables_info_export.2024.06.01-prod_from_10_to_12.json",
+		"export_tables_info_export.2024.06.01-prod_from_archive_from_1_to_5.json",
+		"export_tables_info_export.2024.06.01-prod-2_from_1_to_2.json",
+		"export_tables_info_exportX2024X06X01-prod_from_1_to_2.json",
+		"export_tables_info_export 2024 (copy)_from_1_to_4.json",
+		"export_tables_info_export-gz_from_1_to_2.json.gz",
+		"export_tables_info_export-gz_from_3_to_4.json",
+		"export_tables_info_export-gz_from_3_to_4.json.gz",
+	}
+	tests := []struct {
+		name     string
+		snapshot string
+		expected []string
+	}{
+		{name: "dots", snapshot: "export.2024.06.01-prod", expected: []string{
+			"export_tables_info_export.2024.06.01-prod_from_1_to_2.json",
+			"export_tables_info_export.2024.06.01-prod_from_3_to_9.json",
+			"export_tables_info_export.2024.06.01-prod_from_10_to_12.json",
+		}},
+		{name: "shared prefix", snapshot: "export.2024.06.01-prod_from_archive", expected: []string{
+			"export_tables_info_export.2024.06.01-prod_from_archive_from_1_to_5.json",
+		}},
+		{name: "spaces", snapshot: "export 2024 (copy)", expected: []string{
+			"export_tables_info_export 2024 (copy)_from_1_to_4.json",
+		}},
+		{name: "gzipped", snapshot: "export-gz", expected: []string{
+			"export_tables_info_export-gz_from_1_to_2.json.gz",
+			"export_tables_info_export-gz_from_3_to_4.json",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := filepath.Join(t.TempDir(), tt.snapshot)
+			if err := os.MkdirAll(root, 0755); err != nil {
+				t.Fatalf("Failed to create the fixture: %v", err)
+			}
+			for _, file := range files {
+				if err := os.WriteFile(filepath.Join(root, file), []byte("{}"), 0644); err != nil {
+					t.Fatalf("Failed to create the fixture: %v", err)
+				}
+			}
+			r := NewSourceReader(&config.Config{}, NewLocalSource(root))
+			result, err := r.listTableListFiles()
+			if err != nil {
+				t.Fatalf("listTableListFiles() error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("listTableListFiles() = %v; want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateExportInfo(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		expectError bool
+	}{
+		{name: "matching identifier",
+			content: `{"exportTaskIdentifier": "export.2024.06.01-prod", "status": "COMPLETE", "percentProgress": 100}`},
+		{name: "other export",
+			content:     `{"exportTaskIdentifier": "export.2024.06.01", "status": "COMPLETE", "percentProgress": 100}`,
+			expectError: true},
+		{name: "not a string",
+			content: `{"exportTaskIdentifier": 2024, "status": "COMPLETE", "percentProgress": 100}`, expectError: true},
+		{name: "incomplete export",
+			content:     `{"exportTaskIdentifier": "export.2024.06.01-prod", "status": "FAILED", "percentProgress": 100}`,
+			expectError: true},
+		{name: "partial progress",
+			content:     `{"exportTaskIdentifier": "export.2024.06.01-prod", "status": "COMPLETE", "percentProgress": 99}`,
+			expectError: true},
+		{name: "progress not a number",
+			content:     `{"exportTaskIdentifier": "export.2024.06.01-prod", "status": "COMPLETE", "percentProgress": "100"}`,
+			expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := filepath.Join(t.TempDir(), "export.2024.06.01-prod")
+			if err := os.MkdirAll(root, 0755); err != nil {
+				t.Fatalf("Failed to create the fixture: %v", err)
+			}
+			err := os.WriteFile(filepath.Join(root, exportInfoFileName("export.2024.06.01-prod")), []byte(tt.content), 0644)
+			if err != nil {
+				t.Fatalf("Failed to create the fixture: %v", err)
+			}
+			r := NewSourceReader(&config.Config{}, NewLocalSource(root))
+			if err := r.validateExportInfo(); (err != nil) != tt.expectError {
+				t.Errorf("validateExportInfo() error = %v; expectError %v", err, tt.expectError)
+			}
+		})
+	}
+}
+
+// gzipBytes returns the content compressed with gzip.
+func gzipBytes(t *testing.T, content string) []byte {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write([]byte(content)); err != nil {
+		t.Fatalf("Failed to compress the fixture: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to compress the fixture: %v", err)
+	}
+	return buffer.Bytes()
+}
+
+func TestMetadataReader(t *testing.T) {
+	content := `{"exportTaskIdentifier": "export-gz"}`
+	for name, input := range map[string][]byte{
+		"plain":   []byte(content),
+		"gzipped": gzipBytes(t, content),
+	} {
+		t.Run(name, func(t *testing.T) {
+			reader, err := metadataReader(bytes.NewReader(input))
+			if err != nil {
+				t.Fatalf("metadataReader() error: %v", err)
+			}
+			result, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("ReadAll() error: %v", err)
+			}
+			if string(result) != content {
+				t.Errorf("metadataReader() read %q; want %q", result, content)
+			}
+		})
+	}
+	if _, err := metadataReader(bytes.NewReader(nil)); err != nil {
+		t.Errorf("metadataReader() of an empty file error: %v", err)
+	}
+}
+
+func TestValidateExportInfoGzipped(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "export-gz")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatalf("Failed to create the fixture: %v", err)
+	}
+	content := `{"exportTaskIdentifier": "export-gz", "status": "COMPLETE", "percentProgress": 100}`
+	err := os.WriteFile(filepath.Join(root, exportInfoFileName("export-gz")+".gz"), gzipBytes(t, content), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create the fixture: %v", err)
+	}
+	r := NewSourceReader(&config.Config{}, NewLocalSource(root))
+	if err := r.validateExportInfo(); err != nil {
+		t.Errorf("validateExportInfo() error: %v", err)
+	}
+}
@@ -1,28 +1,431 @@
 package source
 
 import (
+	"context"
+	"crypto/sha256"
 	"dbrestore/utils"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"go.uber.org/zap"
+	"io"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 // log a convenience wrapper to shorten code lines
 var log = &utils.Logger
 
+// s3API is the subset of the S3 client used by S3Source - it allows replacing the client in unit tests.
+type s3API interface {
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+}
+
+// S3Source implementation of a remote data source with an AWS RDS database export stored in an S3 bucket.
+// Parquet files are read with ranged requests (see StreamParquet), other files are downloaded
+// to temporary local files on demand and removed by Dispose.
 type S3Source struct {
-	path string
+	// StreamParquet enables reading Parquet files with ranged GetObject requests instead of downloading them
+	StreamParquet bool
+	// client the S3 client
+	client s3API
+	// ctx the context of the S3 requests; its cancellation interrupts listings, downloads and ranged reads
+	ctx context.Context
+	// bucket the name of the S3 bucket
+	bucket string
+	// prefix the key prefix of the exported snapshot inside the bucket (without the trailing "/")
+	prefix string
+	// snapshotName the name of the snapshot associated with the source (the last element of the prefix).
+	snapshotName string
+	// tempDir the local directory for downloaded files
+	tempDir string
+	// minFreeSpace the minimal free space in bytes that must remain on the temp volume after every download
+	minFreeSpace uint64
+	// listings the cache of the listings by their prefix and delimiter (see listObjects)
+	listings map[listingKey][]*s3.ListObjectsV2Output
+	// listingsMutex protects listings, which are shared by the tables loaded concurrently
+	listingsMutex sync.Mutex
+}
+
+// listingKey identifies a cached S3 listing.
+type listingKey struct {
+	prefix    string
+	delimiter string
+}
+
+// NewS3Source creates a new S3Source for the given bucket path, which can be either an S3 ARN
+// ("arn:aws:s3:::bucket/path/to/snapshot") or an S3 URI ("s3://bucket/path/to/snapshot").
+// The last element of the path must be the snapshot (export) name.
+// Files are downloaded into tempDir, or into the system temp directory if it is empty.
+// All S3 requests are made with the given context.
+func NewS3Source(ctx context.Context, client s3API, bucketPath string, tempDir string,
+	minFreeSpace uint64) (*S3Source, error) {
+	bucket, prefix, err := parseBucketPath(bucketPath)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Source{
+		StreamParquet: true,
+		client:        client,
+		ctx:           ctx,
+		bucket:        bucket,
+		prefix:        prefix,
+		snapshotName:  path.Base(prefix),
+		tempDir:       TempDir(tempDir),
+		minFreeSpace:  minFreeSpace,
+		listings:      make(map[listingKey][]*s3.ListObjectsV2Output),
+	}, nil
+}
+
+// TempDir returns the directory for downloaded files: the configured one, or the system temp directory.
+func TempDir(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	return os.TempDir()
+}
+
+// tempFilePrefix returns the name prefix of the temporary files downloaded by this process,
+// which allows removing the leftovers of this run without touching files of other runs.
+func tempFilePrefix() string {
+	return "dbrestore-" + strconv.Itoa(os.Getpid()) + "-"
+}
+
+// CleanupTempFiles removes the leftovers of files downloaded by this process into the given directory
+// (the configured one, or the system temp directory if it is empty).
+// Normally Dispose removes every file, but leftovers are possible after a failure or an interruption.
+func CleanupTempFiles(tempDir string) {
+	pattern := filepath.Join(TempDir(tempDir), tempFilePrefix()+"*")
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		log.Error("Failed to list temporary files", zap.String("pattern", pattern), zap.Error(err))
+		return
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Error("Failed to delete file", zap.String("file", file), zap.Error(err))
+		} else {
+			log.Debug("Deleted leftover temporary file", zap.String("file", file))
+		}
+	}
+}
+
+// parseBucketPath splits an S3 ARN or URI into the bucket name and the key prefix.
+func parseBucketPath(bucketPath string) (bucket string, prefix string, err error) {
+	s := strings.TrimSpace(bucketPath)
+	if strings.HasPrefix(s, "arn:aws:s3:::") {
+		s = strings.TrimPrefix(s, "arn:aws:s3:::")
+	} else if strings.HasPrefix(s, "s3://") {
+		s = strings.TrimPrefix(s, "s3://")
+	}
+	s = strings.Trim(s, "/")
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid S3 bucket path '%s', expected 's3://bucket/path/to/snapshot' "+
+			"or 'arn:aws:s3:::bucket/path/to/snapshot'", bucketPath)
+	}
+	return parts[0], parts[1], nil
+}
+
+// objectKey converts a path relative to the snapshot into the S3 object key.
+func (l *S3Source) objectKey(relativePath string) string {
+	relativePath = strings.Trim(filepath.ToSlash(relativePath), "/")
+	if relativePath == "" || relativePath == "." {
+		return l.prefix
+	}
+	return l.prefix + "/" + relativePath
+}
+
+// relativePath converts an S3 object key into the path relative to the snapshot.
+func (l *S3Source) relativePath(key string) string {
+	return strings.Trim(strings.TrimPrefix(key, l.prefix), "/")
+}
+
+func (l *S3Source) getSnapshotName() string {
+	return l.snapshotName
+}
+
+// GetFile downloads the S3 object to a temporary local file, or returns a file with ranged reads for Parquet files
+// if StreamParquet is enabled.
+// The download is refused if it would leave less than the configured minimal free space on the temp volume.
+func (l *S3Source) GetFile(relativePath string) FileInfo {
+	key := l.objectKey(relativePath)
+	if l.StreamParquet && strings.HasSuffix(key, ".parquet") {
+		return l.streamFile(relativePath, key)
+	}
+	output, err := l.client.GetObject(l.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(l.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		log.Error("Failed to get S3 object", zap.String("key", key), zap.Error(err))
+		return FileInfo{}
+	}
+	defer func(body io.ReadCloser) {
+		if err := body.Close(); err != nil {
+			log.Error("Failed to close S3 object", zap.String("key", key), zap.Error(err))
+		}
+	}(output.Body)
+
+	size := aws.ToInt64(output.ContentLength)
+	if err := l.checkFreeSpace(uint64(size)); err != nil {
+		log.Error("Cannot download S3 object", zap.String("key", key), zap.Error(err))
+		return FileInfo{}
+	}
+
+	file, err := os.CreateTemp(l.tempDir, tempFilePrefix()+"*-"+path.Base(key))
+	if err != nil {
+		log.Error("Failed to create a temporary file", zap.String("dir", l.tempDir), zap.Error(err))
+		return FileInfo{}
+	}
+	ret := FileInfo{RelativePath: relativePath, LocalPath: file.Name(), Size: size, Temp: true}
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(file, hash), output.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written != size {
+		err = fmt.Errorf("downloaded %d bytes, expected %d bytes", written, size)
+	}
+	if err != nil {
+		log.Error("Failed to download S3 object", zap.String("key", key), zap.Error(err))
+		l.Dispose(ret)
+		return FileInfo{}
+	}
+	ret.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	log.Debug("Downloaded S3 object", zap.String("key", key), zap.String("file", ret.LocalPath),
+		zap.Int64("size", size))
+	return ret
+}
+
+// GetFileRanged returns a file that is read with ranged GetObject requests, even if StreamParquet is disabled,
+// so that reading the footer of a Parquet file does not download the whole file.
+func (l *S3Source) GetFileRanged(relativePath string) FileInfo {
+	return l.streamFile(relativePath, l.objectKey(relativePath))
+}
+
+// streamFile returns a file that is read with ranged GetObject requests, without downloading it.
+func (l *S3Source) streamFile(relativePath string, key string) FileInfo {
+	output, err := l.client.HeadObject(l.ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(l.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		log.Error("Failed to get S3 object", zap.String("key", key), zap.Error(err))
+		return FileInfo{}
+	}
+	size := aws.ToInt64(output.ContentLength)
+	log.Debug("Streaming S3 object", zap.String("key", key), zap.Int64("size", size))
+	return FileInfo{
+		RelativePath: relativePath,
+		Size:         size,
+		ReaderAt:     &s3ReaderAt{client: l.client, ctx: l.ctx, bucket: l.bucket, key: key, size: size},
+	}
+}
+
+// s3ReaderAt implements io.ReaderAt over an S3 object with ranged GetObject requests.
+type s3ReaderAt struct {
+	// client the S3 client
+	client s3API
+	// ctx the context of the requests
+	ctx context.Context
+	// bucket the name of the S3 bucket
+	bucket string
+	// key the key of the S3 object
+	key string
+	// size the size of the S3 object in bytes
+	size int64
 }
 
-func (l S3Source) getFile(path string) FileInfo {
-	//TODO implement me
-	panic("implement me")
+// ReadAt implements the interface io.ReaderAt
+func (r *s3ReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d reading S3 object '%s'", off, r.key)
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	end := min(off+int64(len(p)), r.size) // exclusive
+	output, err := r.client.GetObject(r.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(r.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", off, end-1)),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("ranged read of S3 object '%s' failed: %w", r.key, err)
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(output.Body)
+	n, err = io.ReadFull(output.Body, p[:end-off])
+	if err != nil {
+		return n, fmt.Errorf("ranged read of S3 object '%s' failed: %w", r.key, err)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
 }
 
-func (l S3Source) Dispose(file FileInfo) {
+func (l *S3Source) Dispose(file FileInfo) {
 	if file.Temp {
 		err := os.Remove(file.LocalPath) // Delete the file
 		if err != nil {
-			log.Error("Failed to delete file: %v", zap.Error(err))
+			log.Error("Failed to delete file", zap.String("file", file.LocalPath), zap.Error(err))
+		}
+	}
+}
+
+// checkFreeSpace verifies that downloading the given number of bytes leaves at least minFreeSpace bytes
+// on the temp volume. The check is skipped on platforms where the free space cannot be determined.
+func (l *S3Source) checkFreeSpace(size uint64) error {
+	return checkTempFreeSpace(l.tempDir, size, l.minFreeSpace)
+}
+
+// checkTempFreeSpace verifies that downloading the given number of bytes into tempDir leaves at least
+// minFreeSpace bytes on the volume. The check is skipped on platforms where the free space cannot be determined.
+func checkTempFreeSpace(tempDir string, size uint64, minFreeSpace uint64) error {
+	free, supported, err := utils.FreeDiskSpace(tempDir)
+	if !supported {
+		return nil
+	}
+	if err != nil {
+		log.Warn("Cannot determine the free disk space, skipping the check",
+			zap.String("dir", tempDir), zap.Error(err))
+		return nil
+	}
+	if free < size+minFreeSpace {
+		return fmt.Errorf("not enough free disk space in '%s': needed %s (%s for the file plus %s reserved), "+
+			"available %s", tempDir, utils.FormatByteSize(size+minFreeSpace), utils.FormatByteSize(size),
+			utils.FormatByteSize(minFreeSpace), utils.FormatByteSize(free))
+	}
+	return nil
+}
+
+// CheckFreeSpace is the startup check of the temp volume: it fails if there is not enough space
+// for the largest object of the export, and warns if the whole export would not fit
+// (this is normally fine because downloaded files are removed after loading).
+func (l *S3Source) CheckFreeSpace() error {
+	var total, largest uint64
+	err := l.listObjects(l.prefix+"/", "", func(output *s3.ListObjectsV2Output) {
+		for _, object := range output.Contents {
+			size := uint64(aws.ToInt64(object.Size))
+			total += size
+			largest = max(largest, size)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to calculate the export size: %w", err)
+	}
+	if err := l.checkFreeSpace(largest); err != nil {
+		return err
+	}
+	if free, supported, err := utils.FreeDiskSpace(l.tempDir); supported && err == nil && free < total+l.minFreeSpace {
+		log.Warn("The complete export does not fit into the temp directory, relying on removal of loaded files",
+			zap.String("dir", l.tempDir), zap.String("export_size", utils.FormatByteSize(total)),
+			zap.String("available", utils.FormatByteSize(free)))
+	}
+	log.Info("Checked free disk space for S3 downloads", zap.String("dir", l.tempDir),
+		zap.String("export_size", utils.FormatByteSize(total)),
+		zap.String("largest_object", utils.FormatByteSize(largest)))
+	return nil
+}
+
+// listObjects iterates over all pages of the S3 listing with the given key prefix and delimiter.
+// The export does not change during the run, so every listing is cached for the whole run: the pre-scans
+// and the loading of a table list the same prefixes, and a large export has many of them.
+func (l *S3Source) listObjects(prefix string, delimiter string, fn func(output *s3.ListObjectsV2Output)) error {
+	key := listingKey{prefix: prefix, delimiter: delimiter}
+	l.listingsMutex.Lock()
+	pages, cached := l.listings[key]
+	l.listingsMutex.Unlock()
+	if !cached {
+		var err error
+		if pages, err = l.listPages(prefix, delimiter); err != nil {
+			return err
+		}
+		l.listingsMutex.Lock()
+		l.listings[key] = pages
+		l.listingsMutex.Unlock()
+	}
+	for _, output := range pages {
+		fn(output)
+	}
+	return nil
+}
+
+// listPages requests all pages of the S3 listing; the throttling of the requests is retried by the S3 client.
+func (l *S3Source) listPages(prefix string, delimiter string) ([]*s3.ListObjectsV2Output, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(l.bucket),
+		Prefix: aws.String(prefix),
+	}
+	if delimiter != "" {
+		input.Delimiter = aws.String(delimiter)
+	}
+	var pages []*s3.ListObjectsV2Output
+	paginator := s3.NewListObjectsV2Paginator(l.client, input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(l.ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing S3 objects with prefix '%s' failed: %w", prefix, err)
+		}
+		pages = append(pages, output)
+	}
+	return pages, nil
+}
+
+func (l *S3Source) listFiles(relativePath string, fileMask string, foldersOnly bool) ([]string, error) {
+	var files []string
+	prefix, suffix := splitMask(fileMask)
+	dirKey := l.objectKey(relativePath) + "/"
+
+	err := l.listObjects(dirKey, "/", func(output *s3.ListObjectsV2Output) {
+		var names []string
+		for _, commonPrefix := range output.CommonPrefixes {
+			names = append(names, strings.TrimSuffix(aws.ToString(commonPrefix.Prefix), "/"))
+		}
+		if !foldersOnly {
+			for _, object := range output.Contents {
+				names = append(names, aws.ToString(object.Key))
+			}
+		}
+		for _, key := range names {
+			name := path.Base(key)
+			if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
+				files = append(files, l.relativePath(key))
+			}
+		}
+	})
+	if err != nil {
+		return []string{}, err
+	}
+	return files, nil
+}
+
+func (l *S3Source) ListFilesRecursively(relativePath string) (ret []string, err error) {
+	dirKey := l.objectKey(relativePath) + "/"
+	err = l.listObjects(dirKey, "", func(output *s3.ListObjectsV2Output) {
+		for _, object := range output.Contents {
+			key := aws.ToString(object.Key)
+			if strings.HasSuffix(key, "/") {
+				continue // a folder placeholder object
+			}
+			ret = append(ret, l.relativePath(key))
 		}
+	})
+	if err != nil {
+		return []string{}, err
 	}
+	return ret, nil
 }
@@ -0,0 +1,217 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/parquet-go/parquet-go"
+)
+
+// fakeS3 is an in-memory replacement of the S3 client that supports ranged reads.
+type fakeS3 struct {
+	// objects the content of the objects by their keys
+	objects map[string][]byte
+	// rangedReads the number of ranged GetObject requests
+	rangedReads int
+	// listCalls the number of ListObjectsV2 requests (a request per page)
+	listCalls int
+	// pageSize the number of keys in a page of the listing; 0 means all keys in one page
+	pageSize int
+}
+
+func (f *fakeS3) ListObjectsV2(_ context.Context, params *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.listCalls++
+	prefix, delimiter := aws.ToString(params.Prefix), aws.ToString(params.Delimiter)
+	var keys []string
+	for key := range f.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			key = key[:len(prefix)+i+len(delimiter)]
+		}
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	start, _ := strconv.Atoi(aws.ToString(params.ContinuationToken))
+	end := len(keys)
+	if f.pageSize > 0 {
+		end = min(start+f.pageSize, end)
+	}
+	output := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(end < len(keys))}
+	if end < len(keys) {
+		output.NextContinuationToken = aws.String(strconv.Itoa(end))
+	}
+	for _, key := range keys[start:end] {
+		if delimiter != "" && strings.HasSuffix(key, delimiter) {
+			output.CommonPrefixes = append(output.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(key)})
+		} else {
+			output.Contents = append(output.Contents,
+				types.Object{Key: aws.String(key), Size: aws.Int64(int64(len(f.objects[key])))})
+		}
+	}
+	return output, nil
+}
+
+func (f *fakeS3) GetObject(_ context.Context, params *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	content, exists := f.objects[aws.ToString(params.Key)]
+	if !exists {
+		return nil, fmt.Errorf("object not found: %s", aws.ToString(params.Key))
+	}
+	if params.Range != nil {
+		f.rangedReads++
+		var start, end int
+		if _, err := fmt.Sscanf(aws.ToString(params.Range), "bytes=%d-%d", &start, &end); err != nil {
+			return nil, err
+		}
+		content = content[start : end+1]
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(content)),
+		ContentLength: aws.Int64(int64(len(content))),
+	}, nil
+}
+
+func (f *fakeS3) HeadObject(_ context.Context, params *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	content, exists := f.objects[aws.ToString(params.Key)]
+	if !exists {
+		return nil, fmt.Errorf("object not found: %s", aws.ToString(params.Key))
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(content)))}, nil
+}
+
+// streamRow is a Parquet fixture row.
+type streamRow struct {
+	ID   int64  `parquet:"id"`
+	Name string `parquet:"name"`
+}
+
+// passThrough is a Transformer that returns the values as they are.
+type passThrough struct{}
+
+func (p *passThrough) Transform(x parquet.Value) (any, error) {
+	if x.Kind() == parquet.Int64 {
+		return x.Int64(), nil
+	}
+	return x.String(), nil
+}
+
+func TestS3SourceStreamsParquet(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := parquet.Write(buf, []streamRow{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}); err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	client := &fakeS3{objects: map[string][]byte{"exports/snap/db/t/1/part-00000.parquet": buf.Bytes()}}
+	src, err := NewS3Source(context.Background(), client, "s3://bucket/exports/snap", t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewS3Source() error: %v", err)
+	}
+
+	file := src.GetFile("db/t/1/part-00000.parquet")
+	if !file.IsValid() || file.LocalPath != "" || file.Temp || file.Size != int64(buf.Len()) {
+		t.Fatalf("GetFile() = %+v; want a streamed file of %d bytes", file, buf.Len())
+	}
+	reader := NewParquetReader(context.Background(), file, &passThrough{})
+	var rows [][]any
+	for reader.Next() {
+		values, err := reader.Values()
+		if err != nil {
+			t.Fatalf("Values() error: %v", err)
+		}
+		rows = append(rows, values)
+	}
+	if reader.Err() != nil {
+		t.Fatalf("Err() = %v", reader.Err())
+	}
+	if len(rows) != 2 || rows[1][0] != int64(2) || rows[1][1] != "b" {
+		t.Errorf("Loaded rows = %v", rows)
+	}
+	if client.rangedReads == 0 {
+		t.Errorf("Expected ranged reads of the S3 object")
+	}
+}
+
+func TestS3SourceGetFileRangedWithoutStreaming(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := parquet.Write(buf, []streamRow{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}); err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	client := &fakeS3{objects: map[string][]byte{"exports/snap/db/t/1/part-00000.parquet": buf.Bytes()}}
+	src, err := NewS3Source(context.Background(), client, "s3://bucket/exports/snap", t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewS3Source() error: %v", err)
+	}
+	src.StreamParquet = false
+
+	// the footer is read with ranged requests, although the file is downloaded for loading
+	file := src.GetFileRanged("db/t/1/part-00000.parquet")
+	if !file.IsValid() || file.LocalPath != "" || file.Temp || file.Size != int64(buf.Len()) {
+		t.Fatalf("GetFileRanged() = %+v; want a streamed file of %d bytes", file, buf.Len())
+	}
+	if rows, err := ParquetRowCount(file); err != nil || rows != 2 {
+		t.Errorf("ParquetRowCount() = %d, %v; want 2", rows, err)
+	}
+	src.Dispose(file)
+	if client.rangedReads == 0 {
+		t.Errorf("Expected ranged reads of the S3 object")
+	}
+	if downloaded := src.GetFile("db/t/1/part-00000.parquet"); downloaded.LocalPath == "" || !downloaded.Temp {
+		t.Errorf("GetFile() = %+v; want a downloaded file", downloaded)
+	} else {
+		src.Dispose(downloaded)
+	}
+}
+
+func TestS3SourceCachesListings(t *testing.T) {
+	client := &fakeS3{pageSize: 2, objects: map[string][]byte{
+		"exports/snap/db/t1/1/part-00000.parquet": []byte("a"),
+		"exports/snap/db/t1/1/part-00001.parquet": []byte("bb"),
+		"exports/snap/db/t1/1/part-00002.parquet": []byte("ccc"),
+		"exports/snap/db/t2/1/part-00000.parquet": []byte("d"),
+	}}
+	src, err := NewS3Source(context.Background(), client, "s3://bucket/exports/snap", t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewS3Source() error: %v", err)
+	}
+
+	expected := []string{"db/t1/1/part-00000.parquet", "db/t1/1/part-00001.parquet", "db/t1/1/part-00002.parquet"}
+	for i := 0; i < 3; i++ {
+		files, err := src.listFiles("db/t1/1", "part-*.parquet", false)
+		if err != nil {
+			t.Fatalf("listFiles() error: %v", err)
+		}
+		if !reflect.DeepEqual(files, expected) {
+			t.Errorf("listFiles() = %v; want %v", files, expected)
+		}
+	}
+	// the two pages of the prefix are requested once
+	if client.listCalls != 2 {
+		t.Errorf("ListObjectsV2 calls = %d; want 2", client.listCalls)
+	}
+
+	// another prefix, and the same prefix without the delimiter, are separate listings
+	if files, err := src.ListFilesRecursively("db/t2"); err != nil || len(files) != 1 {
+		t.Errorf("ListFilesRecursively() = %v, %v; want 1 file", files, err)
+	}
+	if files, err := src.ListFilesRecursively("db/t1"); err != nil || len(files) != 3 {
+		t.Errorf("ListFilesRecursively() = %v, %v; want 3 files", files, err)
+	}
+	if _, err := src.ListFilesRecursively("db/t1"); err != nil {
+		t.Errorf("ListFilesRecursively() error: %v", err)
+	}
+	if client.listCalls != 5 {
+		t.Errorf("ListObjectsV2 calls = %d; want 5", client.listCalls)
+	}
+}
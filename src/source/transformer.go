@@ -9,3 +9,24 @@ type Transformer interface {
 	// returning the transformed value or an error.
 	Transform(x parquet.Value) (value any, err error)
 }
+
+// SchemaValidator is an optional interface of a Transformer: when implemented, ParquetReader calls it
+// for every opened Parquet file (part) before reading any rows, so that the Transformer can reconcile
+// the actual Parquet schema with the export metadata or reject the file.
+type SchemaValidator interface {
+
+	// ValidateSchema checks the schema of the Parquet file and returns the Transformer of the values of the file,
+	// which may keep what it learned from the schema; it returns an error if the file cannot be loaded.
+	ValidateSchema(schema *parquet.Schema) (Transformer, error)
+}
+
+// ListTransformer is an optional interface of a Transformer for the repeated (LIST) columns of Parquet files:
+// when implemented, ParquetReader passes all values of a repeated column in a row at once instead of calling
+// Transform for every element.
+type ListTransformer interface {
+
+	// TransformList converts the elements of a repeated column in a row into a single value. The column is
+	// the index of the column, elements are the values of the elements (a null value is a NULL element),
+	// and null reports that the list itself is NULL (there are no elements then).
+	TransformList(column int, elements []parquet.Value, null bool) (value any, err error)
+}
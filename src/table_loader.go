@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	config2 "dbrestore/config"
+	source2 "dbrestore/source"
+	"dbrestore/target"
+	"dbrestore/utils"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// tableLoader loads the tables of a single attempt of the restore (see run), one by one or concurrently
+// (see config.Config.Jobs). The decisions about the tables and the bookkeeping of the results are made by the caller
+// goroutine (prepare, finish); only the loading itself (write) runs on the workers.
+type tableLoader struct {
+	// ctx the context of the attempt
+	ctx context.Context
+	// conf the configuration of the restore
+	conf *config2.Config
+	// source the export being restored
+	source source2.Source
+	// writer the connection of the caller goroutine, with the graph of the foreign keys
+	writer *target.DbWriter
+	// parquetTableMap the tables in the export by their names
+	parquetTableMap map[string]source2.ParquetFileInfo
+	// progress the results of the attempts
+	progress *checkpoint
+	// failedTables the tables that failed in this attempt with --continue-on-error, in the order of loading
+	failedTables []string
+	// failures the errors of failedTables by the table names
+	failures map[string]string
+	// indexFailures the failures to build indexes concurrently on the connections of the workers
+	indexFailures []error
+}
+
+// tableLoad is a table to be loaded and the result of loading it.
+type tableLoad struct {
+	// table the name of the table
+	table string
+	// info the Parquet files of the table
+	info source2.ParquetFileInfo
+	// mapper the strategy of loading the table
+	mapper *target.FieldMapper
+	// writer the connection the table was loaded with
+	writer *target.DbWriter
+	// rowsBefore the number of rows in the table before loading it
+	rowsBefore int64
+	// accounting the rows of the loaded table
+	accounting target.RowAccounting
+	// countErr the error counting the rows before loading the table
+	countErr error
+	// err the error loading the table
+	err error
+	// duration the time spent loading the table
+	duration time.Duration
+}
+
+// newTableLoader creates a loader of the tables of the export.
+func newTableLoader(ctx context.Context, conf *config2.Config, source source2.Source, writer *target.DbWriter,
+	parquetTableMap map[string]source2.ParquetFileInfo, progress *checkpoint) *tableLoader {
+	return &tableLoader{ctx: ctx, conf: conf, source: source, writer: writer, parquetTableMap: parquetTableMap,
+		progress: progress, failures: make(map[string]string)}
+}
+
+// loadSequentially loads the tables one by one in the given order with the connection of the loader.
+func (l *tableLoader) loadSequentially(tables []string) error {
+	for _, table := range tables {
+		if err := l.ctx.Err(); err != nil {
+			return err
+		}
+		load := l.prepare(table)
+		if load == nil {
+			continue
+		}
+		l.write(l.ctx, l.writer, load)
+		if err := l.finish(load); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepare decides whether the table is loaded and constructs its field mapper; it returns nil when the table
+// is not loaded: it is missing in the export, it was restored by a previous attempt, or it is skipped.
+// A panic fails the table like an error of the field mapper.
+func (l *tableLoader) prepare(table string) (ret *tableLoad) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			l.failTable(table, recoverTable(table, recovered))
+			ret = nil
+		}
+	}()
+	parquetInfo, exists := l.parquetTableMap[table]
+	if !exists {
+		return nil
+	}
+	if l.progress.isCompleted(table) {
+		log.Info("Skipping table restored by a previous attempt", zap.String("table", table))
+		return nil
+	}
+	if l.conf.SkipDependents {
+		if parent, failed := failedReference(l.writer.ReferencedTables(table), l.failures); failed {
+			reason := fmt.Sprintf("references the failed table '%s'", parent)
+			log.Warn("Skipping the table that references a failed table", zap.String("table", table),
+				zap.String("failed_table", parent))
+			l.progress.report.setTable(tableReport{Table: table, Status: tableSkipped, Reason: reason})
+			// the restore fails at the end like for the failed tables
+			l.failedTables = append(l.failedTables, table)
+			l.failures[table] = reason
+			return nil
+		}
+	}
+
+	// Construct the field mapper that defines the strategy of loading this table
+	mapper, err := l.writer.GetFieldMapper(parquetInfo, l.conf)
+	if err != nil {
+		log.Error("Error mapping fields for table", zap.String("table", table), zap.Error(err))
+		l.failTable(table, err)
+		return nil
+	}
+
+	if reason, skip := mapper.ShouldSkip(); skip {
+		log.Info("Skipping table", zap.String("table", table), zap.String("reason", reason))
+		l.progress.report.setTable(tableReport{Table: table, Status: tableSkipped, Reason: reason})
+		return nil
+	}
+	return &tableLoad{table: table, info: parquetInfo, mapper: mapper}
+}
+
+// failTable records the table that failed before loading it; the other tables are still loaded,
+// but the restore fails at the end.
+func (l *tableLoader) failTable(table string, err error) {
+	l.progress.report.setTable(tableReport{Table: table, Status: tableFailed, Error: err.Error()})
+	l.failedTables = append(l.failedTables, table)
+	l.failures[table] = err.Error()
+}
+
+// write loads the table with the given connection, recording the result in the load. A panic is recorded
+// as the error of loading the table, so that it is handled according to --continue-on-error.
+func (l *tableLoader) write(ctx context.Context, writer *target.DbWriter, load *tableLoad) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			load.err = recoverTable(load.table, recovered)
+		}
+	}()
+	load.writer = writer
+	load.rowsBefore, load.countErr = writer.TableRowCount(load.table)
+	if load.countErr != nil {
+		return
+	}
+	// Write data to the corresponding database table
+	tableStartTime := time.Now()
+	load.accounting, load.err = writer.WriteTable(ctx, l.source, load.mapper)
+	load.duration = time.Since(tableStartTime)
+}
+
+// finish records the result of loading the table; it returns an error if the restore must stop.
+func (l *tableLoader) finish(load *tableLoad) error {
+	table := load.table
+	if load.countErr != nil {
+		return targetError(load.countErr)
+	}
+	if err := load.err; err != nil {
+		l.progress.report.setTable(tableReport{Table: table, Status: tableFailed, Error: err.Error(),
+			DurationSeconds: load.duration.Seconds()})
+		if l.conf.ContinueOnError && l.ctx.Err() == nil {
+			// the transaction of the table was rolled back
+			log.Error("Failed to load the table, continuing with the next table",
+				zap.String("table", table), zap.Error(err))
+			l.failedTables = append(l.failedTables, table)
+			l.failures[table] = err.Error()
+			if err := load.writer.EnsureConnected(); err != nil {
+				return targetError(fmt.Errorf("error connecting to the database: %w", err))
+			}
+			return nil
+		}
+		if errors.Is(err, target.ErrTableTimeout) && l.ctx.Err() == nil {
+			if l.conf.TableTimeoutAction == config2.TableTimeoutSkip {
+				log.Warn("Continuing with the next table", zap.String("timed_out_table", table))
+				return nil
+			}
+			// the same table would exceed the timeout again in the next attempt
+			return targetError(utils.NewFatalError(
+				fmt.Errorf("error writing data for table '%s': %w", table, err)))
+		}
+		return targetError(fmt.Errorf("error writing data for table '%s': %w", table, err))
+	}
+	if err := l.progress.markCompleted(table); err != nil {
+		// the table is committed, but a restarted restore would load it again
+		return utils.NewFatalError(err)
+	}
+	accounting := load.accounting
+	l.progress.rowsBefore[table] = load.rowsBefore
+	l.progress.rowsDropped[table] = accounting.Dropped + accounting.Rejected
+	recordCount := accounting.Inserted
+	l.progress.manifestTables = append(l.progress.manifestTables,
+		source2.NewManifestTable(load.info, recordCount))
+	duration := load.duration
+	recordsPerSecond := 0.0
+	if duration.Seconds() > 0 {
+		recordsPerSecond = float64(recordCount) / duration.Seconds()
+	} else if duration.Microseconds() > 0 {
+		recordsPerSecond = (float64(recordCount) * 1000000.0) / float64(duration.Microseconds())
+	}
+	log.Info("Loaded table data", zap.String("table", table),
+		zap.Int64("records", recordCount), zap.Int64("dropped", accounting.Dropped),
+		zap.Int64("rejected", accounting.Rejected), zap.Duration("time", duration),
+		zap.Float64("records/sec", recordsPerSecond))
+	l.progress.report.setTable(tableReport{Table: table, Status: tableLoaded, Rows: recordCount,
+		Files: accounting.Files, Accounting: &accounting, DurationSeconds: duration.Seconds(),
+		RecordsPerSecond: recordsPerSecond})
+	return nil
+}
+
+// allIndexFailures returns the failures to build indexes concurrently on all connections of the loader.
+func (l *tableLoader) allIndexFailures() error {
+	return errors.Join(append([]error{l.writer.IndexFailures()}, l.indexFailures...)...)
+}
+
+// recoverTable converts a panic while processing the table into a fatal error (the same data panics again
+// in the next attempt), logging the stack of the panic.
+func recoverTable(table string, recovered any) error {
+	panicErr := utils.NewPanicError(recovered)
+	log.Error("Recovered from a panic processing the table", zap.String("table", table), zap.Error(panicErr),
+		zap.String("stack", panicErr.Stack))
+	return utils.NewFatalError(fmt.Errorf("panic processing the table '%s': %w", table, panicErr))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	config2 "dbrestore/config"
+	"dbrestore/testdb"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestRunSurvivesPanic(t *testing.T) {
+	testdb.WithTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE golden_parent (id BIGINT, name TEXT);
+			CREATE TABLE golden_child (id BIGINT PRIMARY KEY, parent_id BIGINT);`)
+		if err != nil {
+			t.Fatalf("Failed to create the tables: %v", err)
+		}
+		// the converter of golden_parent.name panics on the unknown type with the fallback "panic"
+		root := writeExportFixture(t, t.TempDir())
+		tablesInfo := filepath.Join(root, fmt.Sprintf("export_tables_info_%s_from_1_to_2.json", goldenSnapshot))
+		content, err := os.ReadFile(tablesInfo)
+		if err == nil {
+			content = []byte(strings.Replace(string(content), `"originalType":"text"`, `"originalType":"mystery"`, 1))
+			err = os.WriteFile(tablesInfo, content, 0644)
+		}
+		if err != nil {
+			t.Fatalf("Failed to change the fixture: %v", err)
+		}
+
+		conf := &config2.Config{LocalDir: root, SourceDatabase: "db", DBURL: connectionString,
+			ParquetBatchSize: 1000, UnknownTypeFallback: config2.UnknownTypePanic,
+			CopyCountMismatch: config2.CopyCountMismatchError, TableTimeoutAction: config2.TableTimeoutAbort,
+			ContinueOnError: true}
+		progress := newCheckpoint()
+		err = run(context.Background(), conf, progress)
+		if err == nil || !strings.Contains(err.Error(), "public.golden_parent") {
+			t.Fatalf("run() error = %v; want the failed table", err)
+		}
+		for _, table := range progress.report.Tables {
+			switch table.Table {
+			case "public.golden_parent":
+				if table.Status != tableFailed || !strings.Contains(table.Error, "panic") {
+					t.Errorf("golden_parent: %s, %s; want failed with the panic", table.Status, table.Error)
+				}
+			case "public.golden_child":
+				if table.Status != tableLoaded {
+					t.Errorf("golden_child: %s; want loaded after the panic", table.Status)
+				}
+			}
+		}
+	})
+}
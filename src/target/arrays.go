@@ -0,0 +1,271 @@
+package target
+
+import (
+	"bytes"
+	"dbrestore/source"
+	"encoding/json"
+	"fmt"
+	"github.com/parquet-go/parquet-go"
+	"strconv"
+	"strings"
+)
+
+// arrayElementTypes maps the element type names of the destination tables (the names of the array types
+// in information_schema.columns.udt_name without the leading underscore) to the type names of the export metadata.
+var arrayElementTypes = map[string]string{
+	"int2":    "smallint",
+	"int4":    "integer",
+	"int8":    "bigint",
+	"text":    "text",
+	"varchar": "character varying",
+	"bpchar":  "character",
+	"bool":    "boolean",
+	"float4":  "real",
+	"float8":  "double precision",
+}
+
+// arrayElementType returns the element type of an array column of the destination table by its udt_name
+// (for example "_int4" is "integer").
+func arrayElementType(udtName string) string {
+	name := strings.TrimPrefix(udtName, "_")
+	if elementType, exists := arrayElementTypes[name]; exists {
+		return elementType
+	}
+	return name
+}
+
+// arrayValue converts the value of an ARRAY column - a PostgreSQL array literal like {1,2,NULL} or a JSON array
+// like [1,2,null] - into a Go slice of the element type (see typedArray), which pgx encodes natively in the binary
+// COPY. The string is passed as is for the element types without a conversion, for multidimensional arrays,
+// and for the CSV COPY, which needs the array literal anyway.
+func (m *FieldMapper) arrayValue(x parquet.Value, column source.ColumnInfo) (any, error) {
+	stringValue := x.String()
+	if m.csvCopy() || !isSupportedElementType(column.OriginalElementType) {
+		return stringValue, nil
+	}
+	elements, ok := parseArray(stringValue)
+	if !ok {
+		return stringValue, nil
+	}
+	ret, err := typedArray(column.OriginalElementType, elements)
+	if err != nil {
+		return nil, fmt.Errorf("column '%s': invalid array %s: %w", column.ColumnName, stringValue, err)
+	}
+	return ret, nil
+}
+
+// TransformList converts the elements of a repeated (LIST) column of a Parquet file into a Go slice of the element
+// type of the column, or into a PostgreSQL array literal for the element types without a conversion and for
+// the CSV COPY.
+// It implements the interface source.ListTransformer
+func (m *FieldMapper) TransformList(columnIndex int, elements []parquet.Value, null bool) (any, error) {
+	if null {
+		return nil, nil
+	}
+	column := m.Info.Columns[columnIndex]
+	strs := make([]*string, len(elements))
+	for i, x := range elements {
+		if !x.IsNull() {
+			s := x.String()
+			strs[i] = &s
+		}
+	}
+	if m.csvCopy() || !isSupportedElementType(column.OriginalElementType) {
+		return formatArrayLiteral(strs), nil
+	}
+	ret, err := typedArray(column.OriginalElementType, strs)
+	if err != nil {
+		return nil, fmt.Errorf("column '%s': invalid list element: %w", column.ColumnName, err)
+	}
+	return ret, nil
+}
+
+// csvCopy checks whether the rows of the table are loaded with the CSV COPY (see copyFrom and copyResilient),
+// which needs the text representation of the values.
+func (m *FieldMapper) csvCopy() bool {
+	return m.rawStrings() || m.hasTextOnlyColumn() || m.Config.ResilientLoad
+}
+
+// isSupportedElementType checks whether typedArray converts the arrays of the element type.
+func isSupportedElementType(elementType string) bool {
+	switch elementType {
+	case "smallint", "integer", "bigint", "text", "character varying", "character", "boolean", "real",
+		"double precision":
+		return true
+	}
+	return false
+}
+
+// typedArray converts the text of the array elements (nil for NULL elements) into a slice of the element type:
+// []int64, []string, []bool or []float64, or a slice of pointers if some elements are NULL.
+func typedArray(elementType string, elements []*string) (any, error) {
+	switch elementType {
+	case "smallint", "integer", "bigint":
+		return convertElements(elements, func(s string) (int64, error) {
+			return strconv.ParseInt(s, 10, 64)
+		})
+	case "boolean":
+		return convertElements(elements, strconv.ParseBool)
+	case "real", "double precision":
+		return convertElements(elements, func(s string) (float64, error) {
+			return strconv.ParseFloat(s, 64)
+		})
+	default:
+		return convertElements(elements, func(s string) (string, error) {
+			return s, nil
+		})
+	}
+}
+
+// convertElements converts the elements with the parse function into []T, or into []*T if some elements are NULL.
+func convertElements[T any](elements []*string, parse func(string) (T, error)) (any, error) {
+	values := make([]T, len(elements))
+	hasNulls := false
+	for i, element := range elements {
+		if element == nil {
+			hasNulls = true
+			continue
+		}
+		value, err := parse(*element)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	if !hasNulls {
+		return values, nil
+	}
+	ret := make([]*T, len(elements))
+	for i, element := range elements {
+		if element != nil {
+			ret[i] = &values[i]
+		}
+	}
+	return ret, nil
+}
+
+// parseArray parses a one-dimensional array, either a PostgreSQL array literal or a JSON array, into the text
+// of its elements (nil for NULL elements). It returns false for the values it cannot parse, for example
+// multidimensional arrays or arrays with explicit bounds.
+func parseArray(value string) ([]*string, bool) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "[") {
+		return parseJSONArray(value)
+	}
+	return parseArrayLiteral(value)
+}
+
+// parseArrayLiteral parses a one-dimensional PostgreSQL array literal like {1,"a b",NULL}.
+func parseArrayLiteral(value string) ([]*string, bool) {
+	if len(value) < 2 || value[0] != '{' || value[len(value)-1] != '}' {
+		return nil, false
+	}
+	body := value[1 : len(value)-1]
+	ret := make([]*string, 0)
+	if strings.TrimSpace(body) == "" {
+		return ret, true
+	}
+	for i := 0; ; {
+		for i < len(body) && body[i] == ' ' {
+			i++
+		}
+		var element *string
+		if i < len(body) && body[i] == '"' {
+			buf := &strings.Builder{}
+			i++
+			for i < len(body) && body[i] != '"' {
+				if body[i] == '\\' && i+1 < len(body) {
+					i++
+				}
+				buf.WriteByte(body[i])
+				i++
+			}
+			if i >= len(body) {
+				return nil, false
+			}
+			i++
+			s := buf.String()
+			element = &s
+		} else {
+			end := i
+			for end < len(body) && body[end] != ',' {
+				if body[end] == '{' || body[end] == '"' {
+					return nil, false
+				}
+				end++
+			}
+			s := strings.TrimSpace(body[i:end])
+			if strings.EqualFold(s, "NULL") {
+				element = nil
+			} else {
+				element = &s
+			}
+			i = end
+		}
+		ret = append(ret, element)
+		for i < len(body) && body[i] == ' ' {
+			i++
+		}
+		if i >= len(body) {
+			return ret, true
+		}
+		if body[i] != ',' {
+			return nil, false
+		}
+		i++
+	}
+}
+
+// parseJSONArray parses a JSON array of scalar values like [1,"a b",null].
+func parseJSONArray(value string) ([]*string, bool) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	var values []any
+	if err := decoder.Decode(&values); err != nil {
+		return nil, false
+	}
+	ret := make([]*string, len(values))
+	for i, v := range values {
+		var s string
+		switch element := v.(type) {
+		case nil:
+			continue
+		case string:
+			s = element
+		case json.Number:
+			s = element.String()
+		case bool:
+			s = strconv.FormatBool(element)
+		default:
+			return nil, false
+		}
+		ret[i] = &s
+	}
+	return ret, true
+}
+
+// formatArrayLiteral formats the text of the array elements (nil for NULL elements) as a PostgreSQL array literal,
+// quoting every element.
+func formatArrayLiteral(elements []*string) string {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, element := range elements {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		if element == nil {
+			buf.WriteString("NULL")
+			continue
+		}
+		buf.WriteByte('"')
+		for _, c := range []byte(*element) {
+			if c == '"' || c == '\\' {
+				buf.WriteByte('\\')
+			}
+			buf.WriteByte(c)
+		}
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
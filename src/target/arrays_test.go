@@ -0,0 +1,153 @@
+package target
+
+import (
+	"context"
+	"dbrestore/source"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// ptr returns a pointer to the value.
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func TestTransformArrays(t *testing.T) {
+	tests := []struct {
+		name        string
+		elementType string
+		value       string
+		expected    any
+	}{
+		{name: "integer[]", elementType: "integer", value: "{1,-2,3}", expected: []int64{1, -2, 3}},
+		{name: "integer[] with NULL", elementType: "integer", value: "{1,NULL,3}",
+			expected: []*int64{ptr(int64(1)), nil, ptr(int64(3))}},
+		{name: "Empty integer[]", elementType: "integer", value: "{}", expected: []int64{}},
+		{name: "integer[] as JSON", elementType: "integer", value: "[1, 2, null]",
+			expected: []*int64{ptr(int64(1)), ptr(int64(2)), nil}},
+		{name: "text[]", elementType: "text", value: `{plain,"with space","a,b","quote \" and \\",""}`,
+			expected: []string{"plain", "with space", "a,b", `quote " and \`, ""}},
+		{name: "text[] with a NULL and a quoted NULL", elementType: "text", value: `{NULL,"NULL"}`,
+			expected: []*string{nil, ptr("NULL")}},
+		{name: "text[] as JSON", elementType: "text", value: `["a", "b \"c\""]`, expected: []string{"a", `b "c"`}},
+		{name: "boolean[]", elementType: "boolean", value: "{t,f,true}", expected: []bool{true, false, true}},
+		{name: "double precision[]", elementType: "double precision", value: "{1.5,-2,NaN}", expected: "NaN"},
+		{name: "Unsupported element type", elementType: "uuid", value: "{a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11}",
+			expected: "{a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11}"},
+		{name: "Unknown element type", elementType: "", value: "{1,2}", expected: "{1,2}"},
+		{name: "Multidimensional array", elementType: "integer", value: "{{1,2},{3,4}}", expected: "{{1,2},{3,4}}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := newTestMapper("public.t", source.ColumnInfo{ColumnName: "a", OriginalType: "ARRAY",
+				ExpectedExportedType: "binary (UTF8)", OriginalElementType: tt.elementType})
+			result, err := mapper.Transform(parquet.ValueOf(tt.value).Level(0, 1, 0))
+			if err != nil {
+				t.Fatalf("Transform() error: %v", err)
+			}
+			if floats, ok := result.([]float64); ok && tt.expected == "NaN" {
+				// NaN is not equal to itself
+				if len(floats) != 3 || floats[0] != 1.5 || floats[1] != -2 || floats[2] == floats[2] {
+					t.Errorf("Transform() = %v; want [1.5 -2 NaN]", floats)
+				}
+				return
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Transform() = %#v; want %#v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTransformArraysCSV(t *testing.T) {
+	mapper := newTestMapper("public.t", source.ColumnInfo{ColumnName: "a", OriginalType: "ARRAY",
+		ExpectedExportedType: "binary (UTF8)", OriginalElementType: "integer"})
+	mapper.Config.ResilientLoad = true
+	result, err := mapper.Transform(parquet.ValueOf("{1,2}").Level(0, 1, 0))
+	if err != nil || result != "{1,2}" {
+		t.Errorf("Transform() = %v, %v; want the array literal for the CSV COPY", result, err)
+	}
+
+	mapper = newTestMapper("public.t", source.ColumnInfo{ColumnName: "a", OriginalType: "ARRAY",
+		ExpectedExportedType: "binary (UTF8)", OriginalElementType: "integer"})
+	if _, err := mapper.Transform(parquet.ValueOf("{1,x}").Level(0, 1, 0)); err == nil {
+		t.Errorf("Transform() accepted an invalid element of integer[]")
+	}
+}
+
+// listRow is a Parquet fixture row with the arrays stored as LIST columns.
+type listRow struct {
+	ID   int64    `parquet:"id"`
+	Nums []int64  `parquet:"nums,list"`
+	Tags []string `parquet:"tags,list"`
+}
+
+func TestTransformListColumns(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "part-00000.parquet")
+	err := parquet.WriteFile(fileName, []listRow{
+		{ID: 1, Nums: []int64{1, 2, 3}, Tags: []string{"a", "", "b c"}},
+		{ID: 2, Nums: []int64{}, Tags: nil},
+		{ID: 3, Nums: []int64{42}, Tags: []string{`"x"`}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	columns := []source.ColumnInfo{
+		{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"},
+		{ColumnName: "nums", OriginalType: "ARRAY", ExpectedExportedType: "binary (UTF8)",
+			OriginalElementType: "integer"},
+		{ColumnName: "tags", OriginalType: "ARRAY", ExpectedExportedType: "binary (UTF8)",
+			OriginalElementType: "text"},
+	}
+
+	tests := []struct {
+		name        string
+		resilient   bool
+		expectedRow [][]any
+	}{
+		{name: "Binary COPY", expectedRow: [][]any{
+			{int64(1), []int64{1, 2, 3}, []string{"a", "", "b c"}},
+			{int64(2), []int64{}, []string{}},
+			{int64(3), []int64{42}, []string{`"x"`}},
+		}},
+		{name: "CSV COPY", resilient: true, expectedRow: [][]any{
+			{int64(1), `{"1","2","3"}`, `{"a","","b c"}`},
+			{int64(2), `{}`, `{}`},
+			{int64(3), `{"42"}`, `{"\"x\""}`},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := newTestMapper("public.t", columns...)
+			mapper.Config.ResilientLoad = tt.resilient
+			reader := source.NewParquetReader(context.Background(), source.FileInfo{LocalPath: fileName}, &mapper)
+			defer reader.Cancel()
+			for i, expected := range tt.expectedRow {
+				if !reader.Next() {
+					t.Fatalf("Next() = false at the row %d; error: %v", i, reader.LastError())
+				}
+				values, err := reader.Values()
+				if err != nil {
+					t.Fatalf("Values() error: %v", err)
+				}
+				if !reflect.DeepEqual(values, expected) {
+					t.Errorf("Values() = %#v; want %#v", values, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestArrayElementType(t *testing.T) {
+	for udtName, expected := range map[string]string{"_int4": "integer", "_varchar": "character varying",
+		"_float8": "double precision", "_uuid": "uuid"} {
+		if result := arrayElementType(udtName); result != expected {
+			t.Errorf("arrayElementType(%s) = %s; want %s", udtName, result, expected)
+		}
+	}
+}
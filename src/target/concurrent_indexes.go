@@ -0,0 +1,88 @@
+package target
+
+import (
+	"context"
+	"dbrestore/utils"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	"regexp"
+)
+
+// createIndexPrefix matches the beginning of an index definition of pg_indexes, for example "CREATE UNIQUE INDEX "
+var createIndexPrefix = regexp.MustCompile(`^CREATE (UNIQUE )?INDEX `)
+
+// concurrentIndexDef rewrites the definition of an index from pg_indexes into its concurrent form,
+// for example "CREATE INDEX CONCURRENTLY name ON public.t USING btree (c)".
+func concurrentIndexDef(def string) (string, error) {
+	prefix := createIndexPrefix.FindStringIndex(def)
+	if prefix == nil {
+		return "", fmt.Errorf("unexpected definition of an index: %s", def)
+	}
+	return def[:prefix[1]] + "CONCURRENTLY " + def[prefix[1]:], nil
+}
+
+// buildIndexesConcurrently recreates the dropped indexes of the table with CREATE INDEX CONCURRENTLY after
+// the data was committed (see config.Config.ConcurrentIndexes). CONCURRENTLY cannot run in a transaction,
+// so the indexes are built one by one on a separate connection, without blocking the writes to the table.
+// A failed build leaves an invalid index behind, which is dropped; the failure is recorded (see IndexFailures)
+// instead of failing the table, whose data is already committed. Returns false if any index failed.
+func (w *DbWriter) buildIndexesConcurrently(tableName string, indexInfos []IndexInfo) bool {
+	var dropped []IndexInfo
+	for _, indexInfo := range indexInfos {
+		// the same indexes as restoreIndexes recreates; the other ones were not dropped
+		if !indexInfo.keptDuringLoad() {
+			dropped = append(dropped, indexInfo)
+		}
+	}
+	if len(dropped) == 0 {
+		return true
+	}
+	conn, err := w.openConnection(w.dbContext())
+	if err != nil {
+		w.indexFailure(fmt.Errorf("connecting to build the indexes of the table '%s' failed: %w", tableName, err))
+		return false
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	// the index is in the schema of its table
+	schema, _ := utils.SplitFullTableName(tableName)
+	ok := true
+	for _, indexInfo := range dropped {
+		def, err := concurrentIndexDef(indexInfo.Def)
+		if err == nil {
+			log.Info(def)
+			_, err = conn.Exec(w.dbContext(), def)
+		}
+		if err == nil {
+			continue
+		}
+		ok = false
+		// the cleanup completes after the cancellation
+		indexName := pgx.Identifier{schema, indexInfo.Name}
+		if schema == "" {
+			indexName = pgx.Identifier{indexInfo.Name}
+		}
+		dropSql := fmt.Sprintf(dropIndexConcurrently, indexName.Sanitize())
+		if _, dropErr := conn.Exec(context.Background(), dropSql); dropErr != nil {
+			err = errors.Join(err, fmt.Errorf("dropping the invalid index failed: %w", dropErr))
+		}
+		w.indexFailure(fmt.Errorf("building the index '%s' of the table '%s' concurrently failed: %w",
+			indexInfo.Name, tableName, err))
+	}
+	return ok
+}
+
+// indexFailure records and logs an index that failed to build concurrently.
+func (w *DbWriter) indexFailure(err error) {
+	log.Error("The table was loaded without an index", zap.Error(err))
+	w.indexFailures = append(w.indexFailures, err)
+}
+
+// IndexFailures returns the errors of the indexes that failed to build concurrently after their tables were loaded
+// (see config.Config.ConcurrentIndexes), or nil.
+func (w *DbWriter) IndexFailures() error {
+	return errors.Join(w.indexFailures...)
+}
@@ -0,0 +1,82 @@
+package target
+
+import (
+	"context"
+	"dbrestore/source"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestConcurrentIndexDef(t *testing.T) {
+	tests := []struct {
+		name          string
+		def           string
+		expected      string
+		expectedError bool
+	}{
+		{name: "Index", def: "CREATE INDEX events_ts ON public.events USING btree (ts)",
+			expected: "CREATE INDEX CONCURRENTLY events_ts ON public.events USING btree (ts)"},
+		{name: "Unique index", def: "CREATE UNIQUE INDEX events_key ON public.events USING btree (key)",
+			expected: "CREATE UNIQUE INDEX CONCURRENTLY events_key ON public.events USING btree (key)"},
+		{name: "Not an index", def: "ALTER TABLE public.events ADD CHECK (ts > 0)", expectedError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := concurrentIndexDef(tt.def)
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("concurrentIndexDef() error = %v; expectedError %v", err, tt.expectedError)
+			}
+			if result != tt.expected {
+				t.Errorf("concurrentIndexDef() = %q; want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWriteTableConcurrentIndexes(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		// the unique index cannot be built over the duplicate values of the Parquet file
+		_, err := db.Exec(context.Background(), `CREATE TABLE concurrent_t (id BIGINT, name TEXT);
+			CREATE INDEX concurrent_t_name ON concurrent_t (name);
+			CREATE UNIQUE INDEX concurrent_t_unique_name ON concurrent_t (name);`)
+		if err != nil {
+			t.Fatalf("Failed to create the table: %v", err)
+		}
+		root := writeTableFixture(t, "concurrent_t", map[string][]conflictRow{
+			"1/part-00000.parquet": {{ID: 1, Name: "same"}, {ID: 2, Name: "same"}},
+		})
+
+		writer := NewDatabaseWriterWithURL(connectionString)
+		if err := writer.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error: %v", err)
+		}
+		defer writer.Close()
+		mapper := newTestMapper("public.concurrent_t",
+			source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"},
+			source.ColumnInfo{ColumnName: "name", OriginalType: "text", ExpectedExportedType: "binary (UTF8)"})
+		mapper.Config.SourceDatabase = "db"
+		mapper.Config.ConcurrentIndexes = true
+		rows, err := writer.WriteTable(context.Background(), source.NewLocalSource(root), &mapper)
+		if err != nil || rows.Inserted != 2 {
+			t.Fatalf("WriteTable() = %d, %v; want 2 rows committed despite the failed index", rows.Inserted, err)
+		}
+		if writer.IndexFailures() == nil {
+			t.Errorf("IndexFailures() = nil; want the failure of the unique index")
+		}
+
+		// the plain index is valid, and the invalid unique index was dropped
+		var valid bool
+		err = db.QueryRow(context.Background(), `SELECT x.indisvalid FROM pg_index x
+			WHERE x.indexrelid = 'concurrent_t_name'::regclass`).Scan(&valid)
+		if err != nil || !valid {
+			t.Errorf("The index concurrent_t_name is valid = %v, %v; want a valid index", valid, err)
+		}
+		var exists bool
+		err = db.QueryRow(context.Background(), indexExists, "public.concurrent_t", "concurrent_t_unique_name").
+			Scan(&exists)
+		if err != nil || exists {
+			t.Errorf("The invalid index exists = %v, %v; want it dropped", exists, err)
+		}
+	})
+}
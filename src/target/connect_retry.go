@@ -0,0 +1,76 @@
+package target
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"time"
+)
+
+// connectRetryDelay the delay before the first retry of connecting to the database; it doubles with every retry
+// up to maxConnectRetryDelay (a variable, so that the tests do not wait)
+var connectRetryDelay = time.Second
+
+// maxConnectRetryDelay the maximal delay between the retries of connecting to the database
+const maxConnectRetryDelay = 30 * time.Second
+
+// connectWithRetries opens the pool of connections of the writer, retrying up to ConnectRetries times with
+// an exponential backoff while the database does not accept connections. All attempts together are limited
+// by ConnectTimeout, so that a database that never comes up does not hang the program.
+func (w *DbWriter) connectWithRetries(ctx context.Context) (*pgxpool.Pool, error) {
+	if w.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.ConnectTimeout)
+		defer cancel()
+	}
+	delay := connectRetryDelay
+	for attempt := 1; ; attempt++ {
+		if attempt == 1 {
+			log.Debug("Connecting to the database")
+		} else {
+			log.Info("Connecting to the database", zap.Int("attempt", attempt),
+				zap.Int("max_attempts", w.ConnectRetries+1))
+		}
+		pool, err := w.openPool(ctx)
+		if err == nil {
+			return pool, nil
+		}
+		if w.ConnectTimeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, w.connectDeadlineError(attempt, err)
+		}
+		if attempt > w.ConnectRetries || !retryableConnectError(err) {
+			return nil, err
+		}
+		log.Warn("Failed to connect to the database, retrying", zap.Int("attempt", attempt),
+			zap.Duration("delay", delay), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			if w.ConnectTimeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, w.connectDeadlineError(attempt, err)
+			}
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxConnectRetryDelay)
+	}
+}
+
+// connectDeadlineError reports the error of the last attempt to connect when the deadline ended the retries.
+func (w *DbWriter) connectDeadlineError(attempts int, err error) error {
+	return fmt.Errorf("gave up connecting to the database after %d attempt(s) within %v: %w", attempts,
+		w.ConnectTimeout, err)
+}
+
+// retryableConnectError checks whether a failed connection is worth retrying: the connection string
+// and the credentials do not get better with time, and a cancelled context (or the deadline of ConnectTimeout)
+// ends the retries.
+func retryableConnectError(err error) bool {
+	var parseErr *pgconn.ParseConfigError
+	if errors.As(err, &parseErr) || isAuthenticationError(err) {
+		return false
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
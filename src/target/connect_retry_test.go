@@ -0,0 +1,76 @@
+package target
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closedPortURL returns the connection string of a local port that refuses connections.
+func closedPortURL(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	address := listener.Addr().String()
+	_ = listener.Close()
+	return fmt.Sprintf("postgres://restore@%s/app?sslmode=disable", address)
+}
+
+func TestConnectRetries(t *testing.T) {
+	defer func(delay time.Duration) { connectRetryDelay = delay }(connectRetryDelay)
+	connectRetryDelay = time.Millisecond
+
+	writer := NewDatabaseWriterWithURL(closedPortURL(t))
+	writer.ConnectRetries = 3
+	if err := writer.Connect(context.Background()); err == nil || strings.Contains(err.Error(), "gave up") {
+		t.Errorf("Connect() = %v; want the error of the last attempt", err)
+	}
+
+	// the deadline ends the retries long before they are exhausted
+	writer.ConnectRetries = 1000000
+	writer.ConnectTimeout = 100 * time.Millisecond
+	connectRetryDelay = 10 * time.Millisecond
+	start := time.Now()
+	err := writer.Connect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "gave up connecting") {
+		t.Errorf("Connect() = %v; want the deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Connect() took %v; want it to stop at the deadline", elapsed)
+	}
+
+	// a malformed connection string is not retried
+	connectRetryDelay = time.Hour
+	writer = NewDatabaseWriterWithURL("postgres://restore@localhost:port/app")
+	writer.ConnectRetries = 3
+	if err := writer.Connect(context.Background()); err == nil {
+		t.Errorf("Connect() with a malformed connection string did not fail")
+	}
+}
+
+func TestRetryableConnectError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "refused", err: errors.New("dial tcp 127.0.0.1:5432: connect: connection refused"), expected: true},
+		{name: "starting up", err: &pgconn.PgError{Code: "57P03"}, expected: true},
+		{name: "wrong password", err: &pgconn.PgError{Code: "28P01"}},
+		{name: "malformed", err: &pgconn.ParseConfigError{}},
+		{name: "cancelled", err: fmt.Errorf("connect: %w", context.Canceled)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := retryableConnectError(tt.err); actual != tt.expected {
+				t.Errorf("retryableConnectError(%v) = %v; want %v", tt.err, actual, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package target
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// dbConn runs the statements of the writer; it is implemented by pgxpool.Pool, which runs every statement
+// on any idle connection, and by pgx.Conn.
+type dbConn interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string,
+		rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+// configureConnection applies the settings of the writer to the configuration of a new connection.
+func (w *DbWriter) configureConnection(connConfig *pgx.ConnConfig) {
+	if w.PgBouncerCompat {
+		// named prepared statements do not survive between transactions in PgBouncer transaction pooling mode,
+		// so use unnamed statements only (COPY still works - it describes the table with an unnamed statement)
+		connConfig.DefaultQueryExecMode = pgx.QueryExecModeExec
+		connConfig.StatementCacheCapacity = 0
+		connConfig.DescriptionCacheCapacity = 0
+	}
+}
+
+// openPool creates the pool of connections with the settings of the writer and checks that the database accepts
+// connections by acquiring the first one, which is also probed for a connection pooler (see probeCapabilities).
+func (w *DbWriter) openPool(ctx context.Context) (*pgxpool.Pool, error) {
+	poolConfig, err := w.poolConfig()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	w.probeCapabilities(conn.Conn().PgConn().ParameterStatus)
+	conn.Release()
+	return pool, nil
+}
+
+// poolConfig builds the configuration of the pool of connections from the connection string and the settings
+// of the writer.
+func (w *DbWriter) poolConfig() (*pgxpool.Config, error) {
+	poolConfig, err := pgxpool.ParseConfig(w.ConnectionString)
+	if err != nil {
+		return nil, err
+	}
+	w.configureConnection(poolConfig.ConnConfig)
+	poolConfig.MaxConns = max(poolConfig.MaxConns, int32(w.PoolSize))
+	if w.MaxConns > 0 {
+		poolConfig.MaxConns = int32(w.MaxConns)
+	}
+	if w.TokenProvider != nil {
+		// every connection of the pool gets a new token, because the tokens expire
+		poolConfig.BeforeConnect = func(ctx context.Context, connConfig *pgx.ConnConfig) error {
+			token, err := w.TokenProvider(ctx, connConfig.Host, connConfig.Port, connConfig.User)
+			if err != nil {
+				return fmt.Errorf("failed to generate the authentication token: %w", err)
+			}
+			connConfig.Password = token
+			return nil
+		}
+	}
+	return poolConfig, nil
+}
+
+// acquire binds a connection of the pool to the writer for a table operation, whose statements must run
+// in the same session (its transaction, temporary tables and COPY); the returned function releases
+// the connection back to the pool, which discards it if it was broken. A connection that turns out to be dead
+// (for example, closed by the server or a proxy since the previous table) is discarded and acquired again once.
+// A writer without a pool, or with a connection already acquired, keeps its connection.
+func (w *DbWriter) acquire() (release func(), err error) {
+	if w.pool == nil || w.acquired != nil {
+		return func() {}, nil
+	}
+	conn, err := w.acquireAlive()
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire a database connection: %w", err)
+	}
+	w.acquired, w.db = conn, conn.Conn()
+	return func() {
+		w.acquired, w.db = nil, w.pool
+		conn.Release()
+	}, nil
+}
+
+// acquireAlive acquires a connection of the pool and checks that it is alive; a dead connection is closed,
+// so that the pool discards it on release, and the acquisition is retried once.
+func (w *DbWriter) acquireAlive() (*pgxpool.Conn, error) {
+	conn, err := w.pool.Acquire(w.dbContext())
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.Ping(w.dbContext()); err == nil {
+		return conn, nil
+	}
+	if w.dbContext().Err() != nil {
+		conn.Release()
+		return nil, err
+	}
+	log.Warn("The database connection is dead, acquiring another one", zap.Error(err))
+	_ = conn.Conn().Close(context.Background())
+	conn.Release()
+	return w.pool.Acquire(w.dbContext())
+}
+
+// pgConn returns the low-level connection of the current table operation (see acquire).
+func (w *DbWriter) pgConn() (*pgconn.PgConn, error) {
+	conn, ok := w.db.(*pgx.Conn)
+	if !ok {
+		return nil, errors.New("no database connection is acquired")
+	}
+	return conn.PgConn(), nil
+}
+
+// connectionConfig returns the configuration of the connections of the writer.
+func (w *DbWriter) connectionConfig() *pgx.ConnConfig {
+	if w.pool != nil {
+		return w.pool.Config().ConnConfig
+	}
+	return w.db.(*pgx.Conn).Config()
+}
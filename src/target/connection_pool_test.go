@@ -0,0 +1,223 @@
+package target
+
+import (
+	"context"
+	"dbrestore/source"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"strings"
+	"testing"
+)
+
+func TestAcquireWithoutPool(t *testing.T) {
+	writer := DbWriter{}
+	release, err := writer.acquire()
+	if err != nil {
+		t.Fatalf("acquire() error: %v", err)
+	}
+	release()
+	if writer.db != nil || writer.acquired != nil {
+		t.Errorf("acquire() changed the connection of a writer without a pool")
+	}
+	if _, err := writer.pgConn(); err == nil {
+		t.Errorf("pgConn() did not fail without a connection")
+	}
+}
+
+func TestCloneSharesPool(t *testing.T) {
+	// the pool connects lazily, so the closed port fails only the acquisition
+	pool, err := pgxpool.New(context.Background(), closedPortURL(t))
+	if err != nil {
+		t.Fatalf("pgxpool.New() error: %v", err)
+	}
+	writer := DbWriter{pool: pool, db: pool, PoolSize: 5, MaxConns: 8}
+	defer writer.Close()
+	clone, err := writer.Clone(context.Background())
+	if err != nil {
+		t.Fatalf("Clone() error: %v", err)
+	}
+	if clone.pool != pool || clone.db != dbConn(pool) || clone.PoolSize != 5 || clone.MaxConns != 8 {
+		t.Errorf("Clone() does not share the pool")
+	}
+
+	// a failed acquisition leaves the pool in place
+	if _, err := clone.acquire(); err == nil || !strings.Contains(err.Error(), "failed to acquire") {
+		t.Errorf("acquire() = %v; want the connection error", err)
+	}
+	if clone.db != dbConn(pool) || clone.acquired != nil {
+		t.Errorf("the failed acquire() changed the connection of the writer")
+	}
+
+	// closing the clone keeps the pool of the writer open
+	clone.Close()
+	if _, err := pool.Acquire(context.Background()); err == nil || strings.Contains(err.Error(), "closed pool") {
+		t.Errorf("Acquire() after closing the clone = %v; want the connection error", err)
+	}
+	writer.Close()
+	if _, err := pool.Acquire(context.Background()); err == nil || !strings.Contains(err.Error(), "closed pool") {
+		t.Errorf("Acquire() after closing the writer = %v; want the closed pool", err)
+	}
+}
+
+func TestAcquireBindsConnection(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		writer := NewDatabaseWriterWithURL(connectionString)
+		if err := writer.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error: %v", err)
+		}
+		defer writer.Close()
+		clone, err := writer.Clone(context.Background())
+		if err != nil {
+			t.Fatalf("Clone() error: %v", err)
+		}
+		defer clone.Close()
+
+		backend := func(w *DbWriter) (pid int) {
+			if err := w.db.QueryRow(context.Background(), "SELECT pg_backend_pid()").Scan(&pid); err != nil {
+				t.Fatalf("Query error: %v", err)
+			}
+			return pid
+		}
+		release, err := writer.acquire()
+		if err != nil {
+			t.Fatalf("acquire() error: %v", err)
+		}
+		// the statements of the operation share the session
+		first := backend(&writer)
+		if second := backend(&writer); second != first {
+			t.Errorf("the statements ran on the connections %d and %d; want one", first, second)
+		}
+		// the clone loads its table on another connection at the same time
+		releaseClone, err := clone.acquire()
+		if err != nil {
+			t.Fatalf("acquire() of the clone error: %v", err)
+		}
+		if other := backend(clone); other == first {
+			t.Errorf("the clone got the connection %d acquired by the writer", other)
+		}
+		releaseClone()
+		release()
+		if writer.acquired != nil || writer.db != dbConn(writer.pool) {
+			t.Errorf("release() did not return the connection to the pool")
+		}
+	})
+}
+
+func TestAcquireReplacesDeadConnection(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		writer := NewDatabaseWriterWithURL(connectionString)
+		writer.PoolSize = 4
+		writer.MaxConns = 1
+		if err := writer.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error: %v", err)
+		}
+		defer writer.Close()
+		if maxConns := writer.pool.Config().MaxConns; maxConns != 1 {
+			t.Errorf("the pool has %d connections; want MaxConns to override PoolSize", maxConns)
+		}
+
+		backend := func() (pid int) {
+			release, err := writer.acquire()
+			if err != nil {
+				t.Fatalf("acquire() error: %v", err)
+			}
+			defer release()
+			if err := writer.db.QueryRow(context.Background(), "SELECT pg_backend_pid()").Scan(&pid); err != nil {
+				t.Fatalf("Query error: %v", err)
+			}
+			return pid
+		}
+		first := backend()
+		// the connection dies between two tables
+		if _, err := db.Exec(context.Background(), "SELECT pg_terminate_backend($1)", first); err != nil {
+			t.Fatalf("Failed to terminate the connection: %v", err)
+		}
+		if second := backend(); second == first {
+			t.Errorf("acquire() returned the terminated connection %d", first)
+		}
+	})
+}
+
+func TestPoolConfigPgBouncerCompat(t *testing.T) {
+	writer := NewDatabaseWriterWithURL("postgres://user@localhost:5432/db")
+	poolConfig, err := writer.poolConfig()
+	if err != nil {
+		t.Fatalf("poolConfig() error: %v", err)
+	}
+	if poolConfig.ConnConfig.DefaultQueryExecMode != pgx.QueryExecModeCacheStatement {
+		t.Errorf("DefaultQueryExecMode = %v without --pgbouncer-compat; want the default",
+			poolConfig.ConnConfig.DefaultQueryExecMode)
+	}
+
+	writer.PgBouncerCompat = true
+	if poolConfig, err = writer.poolConfig(); err != nil {
+		t.Fatalf("poolConfig() error: %v", err)
+	}
+	connConfig := poolConfig.ConnConfig
+	if connConfig.DefaultQueryExecMode != pgx.QueryExecModeExec || connConfig.StatementCacheCapacity != 0 ||
+		connConfig.DescriptionCacheCapacity != 0 {
+		t.Errorf("DefaultQueryExecMode = %v, StatementCacheCapacity = %d, DescriptionCacheCapacity = %d; "+
+			"want QueryExecModeExec without caches", connConfig.DefaultQueryExecMode,
+			connConfig.StatementCacheCapacity, connConfig.DescriptionCacheCapacity)
+	}
+}
+
+// treeRow is a Parquet fixture row of a table referencing itself.
+type treeRow struct {
+	ID       int64 `parquet:"id"`
+	ParentID int64 `parquet:"parent_id"`
+}
+
+func TestWriteTablePgBouncerCompat(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		// the trigger fails every insert, and the rows reference the rows that come later in the file,
+		// so the table loads only if the triggers are disabled and the constraints deferred in the transaction
+		// of the COPY - in PgBouncer transaction pooling mode a statement outside it may run on another backend
+		_, err := db.Exec(context.Background(), `CREATE TABLE tree_table (id BIGINT PRIMARY KEY,
+				parent_id BIGINT REFERENCES tree_table (id) DEFERRABLE INITIALLY IMMEDIATE);
+			CREATE FUNCTION tree_table_reject() RETURNS trigger LANGUAGE plpgsql AS
+				$$ BEGIN RAISE EXCEPTION 'the trigger is enabled'; END $$;
+			CREATE TRIGGER tree_table_trigger BEFORE INSERT ON tree_table
+				FOR EACH ROW EXECUTE FUNCTION tree_table_reject();`)
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		root := writeTableFixture(t, "tree_table", map[string][]treeRow{
+			"1/part-00000.parquet": {{ID: 1, ParentID: 3}, {ID: 2, ParentID: 3}, {ID: 3, ParentID: 3}},
+		})
+
+		writer := NewDatabaseWriterWithURL(connectionString)
+		writer.PgBouncerCompat = true
+		if err := writer.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error: %v", err)
+		}
+		defer writer.Close()
+		if mode := writer.pool.Config().ConnConfig.DefaultQueryExecMode; mode != pgx.QueryExecModeExec {
+			t.Errorf("DefaultQueryExecMode = %v; want QueryExecModeExec", mode)
+		}
+		mapper := newTestMapper("public.tree_table",
+			source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"},
+			source.ColumnInfo{ColumnName: "parent_id", OriginalType: "bigint", ExpectedExportedType: "int64"})
+		mapper.Config.SourceDatabase = "db"
+		if _, err := writer.WriteTable(context.Background(), source.NewLocalSource(root), &mapper); err != nil {
+			t.Fatalf("WriteTable() error: %v", err)
+		}
+
+		var count int
+		if err := db.QueryRow(context.Background(), "SELECT COUNT(*) FROM tree_table").Scan(&count); err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if count != 3 {
+			t.Errorf("%d rows were loaded; want 3", count)
+		}
+		var enabled string
+		err = db.QueryRow(context.Background(),
+			"SELECT tgenabled::text FROM pg_trigger WHERE tgname = 'tree_table_trigger'").Scan(&enabled)
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if enabled != "O" {
+			t.Errorf("The trigger is disabled after the load (tgenabled = %s); want O", enabled)
+		}
+	})
+}
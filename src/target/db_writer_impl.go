@@ -4,9 +4,13 @@ import (
 	"context"
 	"dbrestore/source"
 	"dbrestore/utils"
+	"errors"
 	"fmt"
+	"github.com/jackc/pgx/v5"
 	"go.uber.org/zap"
 	"io"
+	"maps"
+	"path"
 	"path/filepath"
 	"slices"
 	"strings"
@@ -14,112 +18,353 @@ import (
 )
 
 // WriteTable writes data to a database table using the provided source and field mapper for mapping fields.
-func (w *DbWriter) WriteTable(source source.Source, mapper *FieldMapper) (ret int, err error) {
+// Returns the accounting of the rows of all Parquet files of the table (see RowAccounting).
+// With config.Config.TableTimeout, the whole sequence is interrupted and rolled back when the timeout is exceeded,
+// and the returned error wraps ErrTableTimeout. Cancelling the context interrupts and rolls back the table the same way.
+// A table that fails with a transient error (see isTransientError) before its commit is rolled back and loaded again
+// from the start on another connection, up to MaxAttempts times; its dropped indexes are restored by the rollback
+// and dropped again by the next attempt. A failed commit is not retried, because the table may be committed.
+func (w *DbWriter) WriteTable(ctx context.Context, source source.Source, mapper *FieldMapper) (ret RowAccounting,
+	err error) {
+	for attempt := 1; ; attempt++ {
+		committing := false
+		ret, err = w.writeTableAttempt(ctx, source, mapper, &committing)
+		if committing || !w.waitTransientRetry(ctx, attempt, err, zap.String("table", mapper.Info.TableName)) {
+			return ret, err
+		}
+		if err = w.EnsureConnected(); err != nil {
+			return RowAccounting{}, err
+		}
+	}
+}
+
+// writeTableAttempt implements a single attempt of WriteTable; committing is set when the commit of the table
+// starts, after which the attempt must not be repeated.
+func (w *DbWriter) writeTableAttempt(ctx context.Context, source source.Source, mapper *FieldMapper,
+	committing *bool) (ret RowAccounting, err error) {
+	defer w.bindContext(ctx)()
+	// the transaction, the temporary tables and the COPY of the table run on a single connection of the pool
+	release, err := w.acquire()
+	if err != nil {
+		return
+	}
+	defer release()
+	if len(mapper.primaryKeyColumns) > 0 {
+		// the keys copied by a rolled-back attempt are not in the table
+		mapper.seenKeys = make(map[string]struct{})
+	}
 	start := time.Now()
 	tableName := mapper.Info.TableName
-	indexInfos, err := w.getIndexList(tableName)
+	// the rows of the table change (or the loading is rolled back), so it is checked again when needed
+	defer w.emptiness.forget(tableName)
+	if mapper.Config.TableTimeout > 0 {
+		stopTimeout := w.startTableTimeout(mapper.Config.TableTimeout)
+		defer func() {
+			err = stopTimeout(tableName, start, err)
+		}()
+	}
+	// when the indexes are kept, the lists stay empty, so dropping and restoring them does nothing
+	var indexInfos []IndexInfo
+	var constraints []ConstraintInfo
+	keepIndexes, err := w.keepIndexes(mapper)
 	if err != nil {
 		return
 	}
-	constraints, err := w.getConstraintList(tableName)
+	if keepIndexes {
+		log.Info("Loading the table with its indexes in place", zap.String("table", tableName))
+	} else {
+		if indexInfos, err = w.getIndexList(tableName); err != nil {
+			return
+		}
+		if constraints, err = w.getConstraintList(tableName); err != nil {
+			return
+		}
+	}
+	if err = w.checkParquetFiles(source, mapper); err != nil {
+		return
+	}
+	var staged *stagedParts
+	if mapper.Config.PartsJobs > 1 {
+		staged, err = w.stageTableParts(source, mapper)
+		if staged != nil {
+			defer w.dropStagedParts(staged)
+		}
+		if err != nil {
+			return
+		}
+	}
+	sanitizedTable, err := utils.SanitizeTableName(tableName)
 	if err != nil {
+		err = fmt.Errorf("failed to write the table: %w", err)
 		return
 	}
 	// Begin a transaction
-	tx, err := w.db.Begin(context.Background())
+	tx, err := w.db.Begin(w.dbContext())
 	if err != nil {
 		return
 	}
 	defer closeTransactionInPanic(tx)
 
-	rows, err := w.db.Query(context.Background(), deferConstraints)
+	// all state changes are executed inside the explicit transaction (they are transaction-scoped),
+	// which is also required when running behind PgBouncer in transaction pooling mode
+	tag, err := tx.Exec(w.dbContext(), deferConstraints)
 	if err != nil {
 		_ = tx.Rollback(context.Background())
 		return
 	}
-	log.Debug("deferConstraints query executed", zap.Any("rows", rows))
-	rows.Close()
+	log.Debug("deferConstraints query executed", zap.String("result", tag.String()))
 
-	rows, err = w.db.Query(context.Background(), fmt.Sprintf(disableTriggers, utils.SanitizeTableName(tableName)))
+	tag, err = tx.Exec(w.dbContext(), fmt.Sprintf(disableTriggers, sanitizedTable))
 	if err != nil {
 		_ = tx.Rollback(context.Background())
 		return
 	}
-	log.Debug("Disabled triggers for table", zap.String("table", tableName), zap.Any("rows", rows))
-	rows.Close()
+	log.Debug("Disabled triggers for table", zap.String("table", tableName), zap.String("result", tag.String()))
 
 	err = w.dropIndexes(tableName, constraints, err, tx, indexInfos)
 	if err != nil {
 		_ = tx.Rollback(context.Background())
 		return
 	}
-	ret, err = w.writeTableData(source, mapper)
+	if staged != nil {
+		ret, err = w.insertStagedParts(mapper, staged)
+	} else {
+		ret, err = w.writeTableData(source, mapper)
+	}
 	if err != nil {
 		_ = tx.Rollback(context.Background())
 		return
 	}
-	err = w.restoreIndexes(tableName, indexInfos, err, tx, constraints)
+	err = w.markIndexesPending(tableName, indexInfos, constraints)
+	if err != nil {
+		_ = tx.Rollback(context.Background())
+		return
+	}
+	// the indexes that failed to build concurrently stay pending, so that finish-indexes can create them
+	indexesBuilt := true
+	defer func() {
+		if indexesBuilt {
+			w.clearPendingIndexes(tableName)
+		}
+	}()
+	txIndexes := indexInfos
+	if mapper.Config.ConcurrentIndexes {
+		// built after the commit (see buildIndexesConcurrently)
+		txIndexes = nil
+	}
+	err = w.restoreIndexes(tableName, txIndexes, err, tx, constraints)
+	if err != nil {
+		_ = tx.Rollback(context.Background())
+		return
+	}
+	err = w.resetSequences(tableName, tx)
 	if err != nil {
 		_ = tx.Rollback(context.Background())
 		return
 	}
 
-	rows, err = w.db.Query(context.Background(), fmt.Sprintf(enableTriggers, utils.SanitizeTableName(tableName)))
+	tag, err = tx.Exec(w.dbContext(), fmt.Sprintf(enableTriggers, sanitizedTable))
 	if err != nil {
 		_ = tx.Rollback(context.Background())
 		return
 	}
-	log.Debug("Enabled triggers for table", zap.String("table", tableName), zap.Any("rows", rows))
-	rows.Close()
+	log.Debug("Enabled triggers for table", zap.String("table", tableName), zap.String("result", tag.String()))
 
-	err = tx.Commit(context.Background())
+	*committing = true
+	err = tx.Commit(w.dbContext())
+	if err == nil && mapper.Config.ConcurrentIndexes {
+		indexesBuilt = w.buildIndexesConcurrently(tableName, indexInfos)
+	}
 
 	recordsPerSecond := 0.0
 	secondsPassed := time.Since(start).Seconds()
 	if secondsPassed > 0 {
-		recordsPerSecond = float64(ret) / secondsPassed
+		recordsPerSecond = float64(ret.Inserted) / secondsPassed
 	} else if microsecondsPassed := time.Since(start).Milliseconds(); microsecondsPassed > 0 {
-		x := ret * 1000000
+		x := ret.Inserted * 1000000
 		recordsPerSecond = float64(x) / float64(microsecondsPassed)
 	}
 
 	log.Debug("COPY TO command executed successfully",
 		zap.String("table", mapper.Info.TableName),
-		zap.Int("rows_copied", ret),
+		zap.Int64("rows_copied", ret.Inserted),
 		zap.Duration("execution_time", time.Since(start)),
 		zap.Int64("records_per_second", int64(recordsPerSecond)))
 
+	if err == nil && mapper.Config.Analyze {
+		err = w.analyzeTable(tableName)
+	}
 	return
 }
 
+// ErrTableTimeout is reported by WriteTable when loading the table exceeded config.Config.TableTimeout.
+var ErrTableTimeout = errors.New("the table timeout is exceeded")
+
+// startTableTimeout limits the database calls of a table with the timeout (see dbContext). The returned function
+// ends the limit: it restores the context of the writer, and for a table that exceeded the timeout it wraps
+// the error with ErrTableTimeout and reconnects, because pgx closes the connection interrupted by the timeout.
+func (w *DbWriter) startTableTimeout(timeout time.Duration) func(tableName string, start time.Time, err error) error {
+	parent := w.ctx
+	ctx, cancel := context.WithTimeout(w.dbContext(), timeout)
+	w.ctx = ctx
+	return func(tableName string, start time.Time, err error) error {
+		timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+		cancel()
+		w.ctx = parent
+		if err == nil || !timedOut {
+			return err
+		}
+		elapsed := time.Since(start)
+		log.Error("Table timeout exceeded, the table was rolled back", zap.String("table", tableName),
+			zap.Duration("elapsed", elapsed), zap.Duration("timeout", timeout))
+		err = fmt.Errorf("%w: loading the table '%s' was interrupted after %s: %w", ErrTableTimeout, tableName,
+			elapsed.Round(time.Millisecond), err)
+		if connectErr := w.EnsureConnected(); connectErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to reconnect after the table timeout: %w", connectErr))
+		}
+		return err
+	}
+}
+
+// analyzeTable updates the planner statistics of the table after loading it; it runs after the commit,
+// so that the statistics cover the restored indexes as well.
+func (w *DbWriter) analyzeTable(tableName string) error {
+	start := time.Now()
+	sanitizedTable, err := utils.SanitizeTableName(tableName)
+	if err != nil {
+		return fmt.Errorf("failed to analyze the table: %w", err)
+	}
+	tag, err := w.db.Exec(w.dbContext(), fmt.Sprintf(analyzeTable, sanitizedTable))
+	if err != nil {
+		return fmt.Errorf("failed to analyze the table '%s': %w", tableName, err)
+	}
+	log.Info("Analyzed table", zap.String("table", tableName), zap.String("result", tag.String()),
+		zap.Duration("execution_time", time.Since(start)))
+	return nil
+}
+
 // writeTableData writes data from a source into table parts based on a field mapper, processing files in grouped subfolders.
 // It verifies the presence of success marker files in each subfolder before processing Parquet files and skips unsupported files.
-// Returns the total size of written data or an error if processing fails.
-func (w *DbWriter) writeTableData(source source.Source, mapper *FieldMapper) (ret int, err error) {
-	if mapper.Config.SourceDatabase == "" {
+// Returns the accounting of the rows of all parts (see RowAccounting) or an error if processing fails.
+func (w *DbWriter) writeTableData(source source.Source, mapper *FieldMapper) (ret RowAccounting, err error) {
+	allFiles, groupedFiles, err := groupTableFiles(source, mapper.Config.SourceDatabase, mapper.Info.TableName)
+	if err != nil {
+		return RowAccounting{}, err
+	}
+
+	err = w.checkOverlongValues(source, mapper, allFiles)
+	if err != nil {
+		return RowAccounting{}, err
+	}
+
+	if mapper.Config.ParquetReaders > 1 {
+		return w.writeTableDataParallel(source, mapper, groupedFiles)
+	}
+
+	// Process each group in the order of subfolders, so that repeated runs load the parts in the same order
+	for _, subfolder := range sortedSubfolders(groupedFiles) {
+		files := groupedFiles[subfolder]
+		log.Debug("Processing files in subfolder", zap.String("subfolder", subfolder))
+
+		// Ensure the files list contains the success marker file
+		if err := checkSuccessMarker(subfolder, files); err != nil {
+			return RowAccounting{}, err
+		}
+
+		// Process files in the subfolder group
+		for _, file := range files {
+			if isSuccessMarker(file) {
+				log.Debug("Skipping the success marker file", zap.String("file", file))
+			} else if strings.HasSuffix(file, ".parquet") {
+				log.Debug("Processing file", zap.String("file", file))
+
+				// Add specific file processing logic here
+				part, err := w.writeTablePart(source, mapper, file)
+				if err != nil {
+					return RowAccounting{}, fmt.Errorf("writing table part failed: %w", err)
+				}
+				ret.Add(part)
+				ret.Files++
+			} else {
+				log.Warn("Skipping file with unsupported extension", zap.String("file", file))
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// writeTableDataParallel writes all Parquet files of the table with a single COPY fed by several concurrent readers,
+// after verifying the presence of success marker files in every subfolder like writeTableData.
+func (w *DbWriter) writeTableDataParallel(source source.Source, mapper *FieldMapper,
+	groupedFiles map[string][]string) (ret RowAccounting, err error) {
+	parquetFiles, err := tableParquetFiles(groupedFiles)
+	if err != nil {
+		return RowAccounting{}, err
+	}
+
+	ret, err = w.writeTableParts(source, mapper, parquetFiles)
+	if err != nil {
+		return RowAccounting{}, fmt.Errorf("writing table parts failed: %w", err)
+	}
+	ret.Files = int64(len(parquetFiles))
+	return ret, nil
+}
+
+// tableParquetFiles returns the Parquet files of the table in the order of the subfolders, verifying the presence
+// of success marker files in every subfolder like writeTableData.
+func tableParquetFiles(groupedFiles map[string][]string) ([]string, error) {
+	var parquetFiles []string
+	for _, subfolder := range sortedSubfolders(groupedFiles) {
+		files := groupedFiles[subfolder]
+		if err := checkSuccessMarker(subfolder, files); err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, ".parquet") {
+				parquetFiles = append(parquetFiles, file)
+			} else if !isSuccessMarker(file) {
+				log.Warn("Skipping file with unsupported extension", zap.String("file", file))
+			}
+		}
+	}
+	return parquetFiles, nil
+}
+
+// groupTableFiles lists all files of the table in the source database of the export
+// and groups them by their subfolders; the manifest of the parts replaces the listed parts (see manifestFiles).
+func groupTableFiles(src source.Source, sourceDatabase string, tableName string) (allFiles []string,
+	groupedFiles map[string][]string, err error) {
+	if sourceDatabase == "" {
 		// TODO: replace the database name with a name read from the configuration
-		return -1, fmt.Errorf("source database is not set")
+		return nil, nil, fmt.Errorf("source database is not set")
 	}
 	// Validate database name and table name to prevent path traversal
-	if utils.FindFilePathCharacters(mapper.Config.SourceDatabase) || utils.FindFilePathCharacters(mapper.Info.TableName) {
-		return -1, fmt.Errorf("invalid database or table name containing path traversal sequences")
+	if utils.FindFilePathCharacters(sourceDatabase) || utils.FindFilePathCharacters(tableName) {
+		return nil, nil, fmt.Errorf("invalid database or table name containing path traversal sequences")
 	}
 
 	// Sanitize database and table names by removing any potentially dangerous characters
-	sanitizedDB := filepath.Clean(mapper.Config.SourceDatabase)
-	sanitizedTable := filepath.Clean(mapper.Info.TableName)
+	sanitizedDB := filepath.Clean(sourceDatabase)
+	sanitizedTable := filepath.Clean(tableName)
 
 	relativePath := fmt.Sprintf("%s/%s", sanitizedDB, sanitizedTable)
 	log.Debug("Using relative path for file access", zap.String("path", relativePath))
 
-	allFiles, err := source.ListFilesRecursively(relativePath)
+	allFiles, err = src.ListFilesRecursively(relativePath)
 	if err != nil {
-		return -1, fmt.Errorf("failed to list files: %w", err)
+		return nil, nil, fmt.Errorf("failed to list files: %w", err)
 	}
-	slices.Sort(allFiles)
+	if manifestPath := relativePath + "/" + source.PartManifestFileName; slices.Contains(allFiles, manifestPath) {
+		if allFiles, err = manifestFiles(src, relativePath, manifestPath, allFiles); err != nil {
+			return nil, nil, err
+		}
+	}
+	// the numbered subfolders and part files are loaded in their numeric order (see utils.NaturalCompare)
+	slices.SortFunc(allFiles, utils.NaturalCompare)
 
 	// Group files by their subfolders
-	groupedFiles := make(map[string][]string) // map[subfolder][]files
+	groupedFiles = make(map[string][]string) // map[subfolder][]files
 	for _, file := range allFiles {
 		// Validate file path to prevent path traversal
 		if strings.Contains(file, "..") {
@@ -130,95 +375,423 @@ func (w *DbWriter) writeTableData(source source.Source, mapper *FieldMapper) (re
 		subfolder := filepath.Clean(filepath.Dir(file)) // Get the sanitized subfolder path
 		groupedFiles[subfolder] = append(groupedFiles[subfolder], file)
 	}
+	return allFiles, groupedFiles, nil
+}
 
-	// Process each group
-	for subfolder, files := range groupedFiles {
-		log.Debug("Processing files in subfolder", zap.String("subfolder", subfolder))
-
-		// Ensure the files list contains the "_success" file
-		successFileFound := false
-		for _, file := range files {
-			s := filepath.Base(file)
-			if s == "_success" || s == "_SUCCESS" {
-				successFileFound = true
-				break
-			}
+// manifestFiles replaces the listed part files of the table with the parts of its manifest (see source.PartManifest),
+// verifying that every part of the manifest exists with the expected size; the success markers of the listing
+// are kept, and the listed part files missing in the manifest are skipped.
+func manifestFiles(src source.Source, tableFolder string, manifestPath string, listed []string) ([]string, error) {
+	manifest, err := source.ReadPartManifest(src, manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("Using the manifest of the parts", zap.String("manifest", manifestPath),
+		zap.Int("parts", len(manifest.Files)))
+	var ret []string
+	for _, file := range listed {
+		if isSuccessMarker(file) {
+			ret = append(ret, file)
 		}
-		if !successFileFound {
-			return -1, fmt.Errorf("missing _success file in subfolder: %s", subfolder)
+	}
+	inManifest := make(map[string]struct{}, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		file := tableFolder + "/" + path.Clean(entry.Path)
+		if !slices.Contains(listed, file) {
+			return nil, fmt.Errorf("the part '%s' listed in the manifest '%s' is missing in the export",
+				file, manifestPath)
 		}
+		size, _, err := partSizeAndRows(src, file, false)
+		if err != nil {
+			return nil, err
+		}
+		if size != entry.Size {
+			return nil, fmt.Errorf("the part '%s' has %d bytes, but the manifest '%s' lists %d bytes",
+				file, size, manifestPath, entry.Size)
+		}
+		inManifest[file] = struct{}{}
+		ret = append(ret, file)
+	}
+	for _, file := range listed {
+		if _, found := inManifest[file]; !found && strings.HasSuffix(file, ".parquet") {
+			log.Warn("Skipping the part file not listed in the manifest", zap.String("file", file),
+				zap.String("manifest", manifestPath))
+		}
+	}
+	return ret, nil
+}
 
-		// Process files in the subfolder group
-		for _, file := range files {
-			s := filepath.Base(file)
-			if s == "_success" || s == "_SUCCESS" {
-				log.Debug("Skipping the _success file")
-			} else if strings.HasSuffix(s, ".parquet") {
-				log.Debug("Processing file", zap.String("file", file))
+// sortedSubfolders returns the subfolders of the grouped files of a table in the numeric order ("2" before "10"),
+// which is the order of the export, so that the rows of append-only tables are loaded in their export order.
+func sortedSubfolders(groupedFiles map[string][]string) []string {
+	return slices.SortedFunc(maps.Keys(groupedFiles), utils.NaturalCompare)
+}
 
-				// Add specific file processing logic here
-				size, err := w.writeTablePart(source, mapper, file)
-				if err != nil {
-					return -1, fmt.Errorf("writing table part failed: %w", err)
-				}
-				ret += size
-			} else {
-				log.Warn("Skipping file with unsupported extension", zap.String("file", file))
+// TablePart describes a Parquet part file of a table in the export (see ListTableParts).
+type TablePart struct {
+	// Subfolder the subfolder of the part file
+	Subfolder string
+	// RelativePath the path of the part file relative to the export
+	RelativePath string
+	// Rows the number of rows in the part file
+	Rows int64
+	// Size the size of the part file in bytes
+	Size int64
+	// SuccessMarker indicates that the subfolder contains the success marker file
+	SuccessMarker bool
+}
+
+// ListTableParts enumerates the Parquet part files of the table the same way as loading does, but read-only:
+// it returns every part with its row count (from the Parquet footer), size, and the presence of the success marker
+// in its subfolder. Subfolders without part files are returned as entries with an empty RelativePath.
+func ListTableParts(src source.Source, sourceDatabase string, tableName string) (ret []TablePart, err error) {
+	return listTableParts(src, sourceDatabase, tableName, true)
+}
+
+// ListTableFiles enumerates the Parquet part files of the table like ListTableParts, but without reading
+// their footers: the Rows of the parts are left empty.
+func ListTableFiles(src source.Source, sourceDatabase string, tableName string) (ret []TablePart, err error) {
+	return listTableParts(src, sourceDatabase, tableName, false)
+}
+
+// listTableParts implements ListTableParts and ListTableFiles; the footers of the part files are read
+// only when readRows is set.
+func listTableParts(src source.Source, sourceDatabase string, tableName string,
+	readRows bool) (ret []TablePart, err error) {
+	_, groupedFiles, err := groupTableFiles(src, sourceDatabase, tableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, subfolder := range sortedSubfolders(groupedFiles) {
+		files := groupedFiles[subfolder]
+		successMarker := slices.ContainsFunc(files, isSuccessMarker)
+		partCount := 0
+		for _, file := range files {
+			if !strings.HasSuffix(file, ".parquet") {
+				continue
+			}
+			partCount++
+			part := TablePart{Subfolder: subfolder, RelativePath: file, SuccessMarker: successMarker}
+			if part.Size, part.Rows, err = partSizeAndRows(src, file, readRows); err != nil {
+				return nil, err
 			}
+			ret = append(ret, part)
+		}
+		if partCount == 0 {
+			ret = append(ret, TablePart{Subfolder: subfolder, SuccessMarker: successMarker})
 		}
 	}
-
 	return ret, nil
 }
 
+// partSizeAndRows returns the size of the part file and, if readRows is set, its row count from the Parquet footer.
+func partSizeAndRows(src source.Source, file string, readRows bool) (size int64, rows int64, err error) {
+	info := src.GetFileRanged(filepath.Clean(file))
+	if !info.IsValid() {
+		return 0, 0, fmt.Errorf("failed to get the file '%s'", file)
+	}
+	defer src.Dispose(info)
+	if readRows {
+		if rows, err = source.ParquetRowCount(info); err != nil {
+			return 0, 0, err
+		}
+	}
+	return info.Size, rows, nil
+}
+
 // writeTablePart processes a Parquet file and writes its data to a database table using either CSV or binary protocols.
 // It validates the table size before and after the operation to ensure data consistency.
-// Returns the number of rows written and an error if any issues occur during the process.
-func (w *DbWriter) writeTablePart(src source.Source, mapper *FieldMapper, relativePath string) (ret int, err error) {
+// Returns the accounting of the rows (see RowAccounting) and an error if any issues occur during the process.
+func (w *DbWriter) writeTablePart(src source.Source, mapper *FieldMapper,
+	relativePath string) (ret RowAccounting, err error) {
 	// Validate the relative path to prevent path traversal
 	if strings.Contains(relativePath, "..") {
-		return 0, fmt.Errorf("invalid relative path containing path traversal sequences: %s", relativePath)
+		return RowAccounting{}, fmt.Errorf("invalid relative path containing path traversal sequences: %s", relativePath)
 	}
 
 	// Use filepath.Clean to normalize the path
 	cleanPath := filepath.Clean(relativePath)
 
 	file := src.GetFile(cleanPath)
-	copyFromSource := source.NewParquetReader(file, mapper)
+	if !file.IsValid() {
+		return RowAccounting{}, fmt.Errorf("failed to get the file '%s'", cleanPath)
+	}
+	defer src.Dispose(file)
+	copyFromSource := source.NewParquetReader(w.dbContext(), file, mapper)
+	if mapper.Config.ParquetBatchSize > 0 {
+		copyFromSource.BatchSize = mapper.Config.ParquetBatchSize
+	}
+	defer copyFromSource.Cancel() // releases the reader if COPY stops early
+	started := time.Now()
 	if copyFromSource.IsEmpty() {
 		log.Debug("Skipping empty Parquet file", zap.String("file", cleanPath))
 		if copyFromSource.LastError() != nil && copyFromSource.LastError() != io.EOF {
 			err = fmt.Errorf("skipping empty Parquet file '%s': %w", cleanPath, copyFromSource.LastError())
 		}
 	} else {
-		var oldTableSize, newBatchCopySize, newTableSize int64
-		oldTableSize = int64(w.getTableSize(mapper.Info.TableName))
-		newBatchCopySize = copyFromSource.RowCount()
 		log.Debug("Writing table part", zap.String("file", relativePath),
-			zap.String("table", mapper.Info.TableName), zap.Int64("old_table_size", oldTableSize),
-			zap.Int64("newBatchCopySize", newBatchCopySize))
-		var copied int64
-		if mapper.hasUserDefinedColumn() {
-			// HSTORE format does not work in the binary COPY FROM protocol by some reason, so using CSV instead
-			copied, err = w.copyFromCSV(mapper, copyFromSource)
-		} else {
-			// by default, we prefer the binary format - it is the standard format in pgx
-			copied, err = w.copyFromBinary(mapper, copyFromSource)
-		}
-		if err != nil && err != io.EOF {
-			err = fmt.Errorf("writing the table '%s' failed for %d rows: %w",
-				mapper.Info.TableName, copyFromSource.RowCount(), err)
-		} else {
-			ret += int(copied)
-			err = nil // to erase possible io.EOF
-		}
-		if err == nil { // validate that all rows from Parquet were written to the table
-			newTableSize = int64(w.getTableSize(mapper.Info.TableName))
-			if newTableSize != (oldTableSize + newBatchCopySize) {
-				err = fmt.Errorf("table size mismatch: expected = %d, new actual size = %d",
-					oldTableSize, newTableSize)
+			zap.String("table", mapper.Info.TableName), zap.Int64("newBatchCopySize", copyFromSource.RowCount()))
+		ret, err = w.copyRows(mapper, copyFromSource)
+	}
+	if err == nil {
+		// the empty files are recorded too, so that the table counts as restored
+		err = w.recordLoadedFiles(mapper.Info.TableName, []string{cleanPath}, &ret.Copied, started)
+	}
+	return
+}
+
+// rowSource is a source of rows for COPY that knows how many rows are in the Parquet files
+// and how many of them were actually read.
+type rowSource interface {
+	pgx.CopyFromSource
+	RowCount() int64
+	RowsRead() int64
+}
+
+// copyRows copies all rows of the source into the table (see copyFrom, copyStaged and copyResilient), and validates
+// the accounting of the rows (see RowAccounting) and the table size before and after the operation
+// to ensure data consistency. Returns the accounting of the rows.
+func (w *DbWriter) copyRows(mapper *FieldMapper, copyFromSource rowSource) (ret RowAccounting, err error) {
+	if len(mapper.primaryKeyColumns) > 0 {
+		copyFromSource = &duplicateKeyChecker{rowSource: copyFromSource, mapper: mapper}
+	}
+	sizer, _ := copyFromSource.(rowSizer)
+	counter := newRowCounter(copyFromSource)
+	throttled := w.throttle(mapper.Info.TableName, counter, sizer)
+	oldTableSize := int64(w.getTableSize(mapper.Info.TableName))
+	var copied, written, rejected int64
+	if mapper.Config.ResilientLoad {
+		copied, written, rejected, err = w.copyResilient(mapper, throttled)
+	} else if mapper.Config.OnConflictSkip {
+		copied, written, err = w.copyStaged(mapper, throttled)
+		log.Info("Inserted rows skipping conflicts", zap.String("table", mapper.Info.TableName),
+			zap.Int64("rows_inserted", written), zap.Int64("rows_skipped", copied-written))
+	} else if len(mapper.identityAlwaysColumns) > 0 {
+		copied, written, err = w.copyStaged(mapper, throttled)
+	} else {
+		copied, err = w.copyFrom(mapper.Info.TableName, mapper, throttled)
+		written = copied
+	}
+	if err != nil && err != io.EOF {
+		return RowAccounting{}, fmt.Errorf("writing the table '%s' failed for %d rows: %w",
+			mapper.Info.TableName, copyFromSource.RowCount(), err)
+	}
+	ret = counter.accounting(copied, written)
+	ret.Rejected = rejected
+	err = ret.check(mapper.Info.TableName, mapper.Config.CopyCountMismatch) // also erases possible io.EOF
+	if err != nil {
+		return RowAccounting{}, err
+	}
+	// with OnConflictSkip the rows conflicting with the existing ones are not inserted, and the table grows less
+	err = ret.checkTableSize(oldTableSize, int64(w.getTableSize(mapper.Info.TableName)))
+	if err != nil {
+		return RowAccounting{}, err
+	}
+	return ret, nil
+}
+
+// writeTableParts writes all Parquet files of the table with a single COPY fed by several concurrent readers
+// (see config.Config.ParquetReaders), validating the result like writeTablePart.
+// Returns the accounting of the rows.
+func (w *DbWriter) writeTableParts(src source.Source, mapper *FieldMapper,
+	relativePaths []string) (RowAccounting, error) {
+	cleanPaths := make([]string, 0, len(relativePaths))
+	for _, relativePath := range relativePaths {
+		// Validate the relative path to prevent path traversal
+		if strings.Contains(relativePath, "..") {
+			return RowAccounting{}, fmt.Errorf("invalid relative path containing path traversal sequences: %s", relativePath)
+		}
+		cleanPaths = append(cleanPaths, filepath.Clean(relativePath))
+	}
+	reader := source.NewParallelReader(w.dbContext(), src, cleanPaths, mapper, mapper.Config.ParquetReaders)
+	if mapper.Config.ParquetBatchSize > 0 {
+		reader.BatchSize = mapper.Config.ParquetBatchSize
+	}
+	defer reader.Close()
+	log.Debug("Writing table parts in parallel", zap.String("table", mapper.Info.TableName),
+		zap.Int("files", len(cleanPaths)), zap.Int("readers", reader.Producers))
+	started := time.Now()
+	ret, err := w.copyRows(mapper, reader)
+	if err != nil {
+		return ret, err
+	}
+	return ret, w.recordLoadedFiles(mapper.Info.TableName, cleanPaths, nil, started)
+}
+
+// copyFrom copies the rows of the Parquet file into the table (the destination table or a temporary table
+// with the same columns) using either CSV or binary COPY protocol.
+func (w *DbWriter) copyFrom(tableName string, mapper *FieldMapper, copyFromSource pgx.CopyFromSource) (int64, error) {
+	if mapper.hasTextOnlyColumn() || mapper.rawStrings() {
+		// HSTORE and money do not work in the binary COPY FROM protocol, so using CSV instead;
+		// and the raw strings are parsed by PostgreSQL only in the text formats
+		return w.copyFromCSV(tableName, mapper, copyFromSource)
+	}
+	// by default, we prefer the binary format - it is the standard format in pgx
+	return w.copyFromBinary(tableName, mapper, copyFromSource)
+}
+
+// copyStaged copies the rows of the Parquet file into a temporary table with the columns of the destination table,
+// and inserts them into the destination table with INSERT ... SELECT. It is slower than a direct COPY, but INSERT
+// can skip the rows that conflict with the existing ones (ON CONFLICT DO NOTHING, see config.Config.OnConflictSkip)
+// and can write explicit values into GENERATED ALWAYS identity columns (OVERRIDING SYSTEM VALUE), which COPY cannot.
+// Returns the number of copied rows and the number of actually inserted rows.
+func (w *DbWriter) copyStaged(mapper *FieldMapper, copyFromSource pgx.CopyFromSource) (copied int64,
+	inserted int64, err error) {
+	tableName, err := utils.SanitizeTableName(mapper.Info.TableName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to stage the rows: %w", err)
+	}
+	tempTable := pgx.Identifier{stagingTempTable}.Sanitize()
+	quotedColumnNames := quoteColumnNames(mapper.getFieldNames())
+
+	_, err = w.db.Exec(w.dbContext(), fmt.Sprintf(dropTempTable, tempTable))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to drop the temporary table: %w", err)
+	}
+	_, err = w.db.Exec(w.dbContext(), fmt.Sprintf(createTempTableAs, tempTable, quotedColumnNames, tableName))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create the temporary table for '%s': %w", mapper.Info.TableName, err)
+	}
+	defer func() {
+		if _, dropErr := w.db.Exec(context.Background(), fmt.Sprintf(dropTempTable, tempTable)); dropErr != nil {
+			log.Warn("Failed to drop the temporary table", zap.String("table", stagingTempTable),
+				zap.Error(dropErr))
+		}
+	}()
+
+	copied, err = w.copyFrom(stagingTempTable, mapper, copyFromSource)
+	if err != nil && err != io.EOF {
+		return copied, 0, err
+	}
+	inserted, insertErr := w.insertStaged(mapper, tempTable)
+	if insertErr != nil {
+		return copied, 0, insertErr
+	}
+	return copied, inserted, err
+}
+
+// quoteColumnNames returns the sanitized names of the columns, separated by commas, for the SQL statements.
+func quoteColumnNames(names []string) string {
+	columns := make([]string, 0, len(names))
+	for _, name := range names {
+		columns = append(columns, pgx.Identifier{name}.Sanitize())
+	}
+	return strings.Join(columns, ", ")
+}
+
+// insertStaged inserts the rows of the staging table (a sanitized name) into the destination table of the mapper,
+// skipping the conflicting rows with config.Config.OnConflictSkip and writing explicit values
+// into the GENERATED ALWAYS identity columns. Returns the number of inserted rows.
+func (w *DbWriter) insertStaged(mapper *FieldMapper, stagingTable string) (int64, error) {
+	tableName, err := utils.SanitizeTableName(mapper.Info.TableName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert the staged rows: %w", err)
+	}
+	quotedColumnNames := quoteColumnNames(mapper.getFieldNames())
+	overriding, onConflict := "", ""
+	if len(mapper.identityAlwaysColumns) > 0 {
+		overriding = overridingSystemValue
+	}
+	if mapper.Config.OnConflictSkip {
+		onConflict = onConflictDoNothing
+	}
+	tag, err := w.db.Exec(w.dbContext(), fmt.Sprintf(insertFromStaging, tableName, quotedColumnNames,
+		overriding, quotedColumnNames, stagingTable, onConflict))
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert rows into '%s': %w", mapper.Info.TableName, err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// checkOverlongValues is the pre-scan of character columns that are shorter in the destination table than
+// in the source database: it reads the statistics from the footers of the Parquet files (without the data)
+// and fails before loading if the statistics prove that some values do not fit, listing the affected part files.
+func (w *DbWriter) checkOverlongValues(src source.Source, mapper *FieldMapper, files []string) error {
+	limits := mapper.overlongRiskColumns()
+	if len(limits) == 0 {
+		return nil
+	}
+	var failures []string
+	for _, relativePath := range files {
+		if !strings.HasSuffix(relativePath, ".parquet") {
+			continue
+		}
+		file := src.GetFileRanged(filepath.Clean(relativePath))
+		if !file.IsValid() {
+			return fmt.Errorf("failed to get the file '%s'", relativePath)
+		}
+		lengths, err := source.MaxStatisticsLengths(file)
+		src.Dispose(file)
+		if err != nil {
+			return err
+		}
+		for _, column := range overlongColumns(mapper.Info.Columns, limits, lengths) {
+			failures = append(failures, fmt.Sprintf("%s in %s", column, relativePath))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("loading the table '%s' will fail, values are longer than the destination columns: %s",
+			mapper.Info.TableName, strings.Join(failures, "; "))
+	}
+	log.Debug("Parquet statistics do not show overlong values", zap.String("table", mapper.Info.TableName),
+		zap.Int("columns", len(limits)))
+	return nil
+}
+
+// keepIndexes checks whether the table is loaded with its indexes and constraints in place
+// (see config.Config.KeepIndexes): only the tables that are not empty keep them.
+func (w *DbWriter) keepIndexes(mapper *FieldMapper) (bool, error) {
+	if !mapper.Config.KeepIndexes {
+		return false, nil
+	}
+	return w.TableNotEmpty(mapper.Info.TableName)
+}
+
+// checkParquetFiles is the pre-scan of the Parquet files of the table before the indexes are dropped:
+// it reads the footers (without the data) and fails if a file uses a codec that the Parquet library cannot decompress
+// (retrying cannot help, so the error is fatal), and with --verify-checksums it also verifies the integrity
+// of every file (see source.VerifyParquetFile), which is not fatal - the file may be fine after a new download.
+func (w *DbWriter) checkParquetFiles(src source.Source, mapper *FieldMapper) error {
+	files, _, err := groupTableFiles(src, mapper.Config.SourceDatabase, mapper.Info.TableName)
+	if err != nil {
+		return err
+	}
+	for _, relativePath := range files {
+		if !strings.HasSuffix(relativePath, ".parquet") {
+			continue
+		}
+		// the footer, and with --verify-checksums the pages, are read without downloading the file for the load twice
+		file := src.GetFileRanged(filepath.Clean(relativePath))
+		if !file.IsValid() {
+			return fmt.Errorf("failed to get the file '%s'", relativePath)
+		}
+		codecs, err := source.UnsupportedCodecs(file)
+		if err == nil && len(codecs) == 0 && mapper.Config.VerifyChecksums {
+			if err = source.VerifyParquetFile(file); err != nil {
+				err = fmt.Errorf("the table '%s' failed the checksum verification: %w", mapper.Info.TableName, err)
 			}
 		}
+		src.Dispose(file)
+		if err != nil {
+			return err
+		}
+		if len(codecs) > 0 {
+			return utils.NewFatalError(fmt.Errorf("the Parquet file '%s' of the table '%s' is compressed with %s, "+
+				"which is not supported by the Parquet library", relativePath, mapper.Info.TableName,
+				strings.Join(codecs, ", ")))
+		}
 	}
-	return
+	return nil
+}
+
+// overlongColumns describes the columns whose maximal value length from the Parquet statistics
+// exceeds the destination limit.
+func overlongColumns(columns []source.ColumnInfo, limits map[int]int, lengths map[int]int) (ret []string) {
+	for i, column := range columns {
+		limit, limited := limits[i]
+		if length := lengths[i]; limited && length > limit {
+			ret = append(ret, fmt.Sprintf("column '%s' (value length %d > %d)", column.ColumnName, length, limit))
+		}
+	}
+	return ret
 }
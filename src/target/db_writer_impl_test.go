@@ -0,0 +1,833 @@
+package target
+
+import (
+	"context"
+	"dbrestore/source"
+	"dbrestore/utils"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/parquet-go/parquet-go"
+)
+
+// overlongRow is a Parquet fixture row with one safe and one overlong character column.
+type overlongRow struct {
+	Code string `parquet:"code"`
+	Name string `parquet:"name"`
+}
+
+func TestOverlongColumns(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "part-00000.parquet")
+	err := parquet.WriteFile(fileName, []overlongRow{
+		{Code: "AB", Name: strings.Repeat("n", 30)},
+		{Code: "CD", Name: "short"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("Failed to stat the Parquet fixture: %v", err)
+	}
+
+	mapper := newTestMapper("public.t",
+		source.ColumnInfo{ColumnName: "code", OriginalType: "character varying", OriginalCharMaxLength: 10},
+		source.ColumnInfo{ColumnName: "name", OriginalType: "character varying", OriginalCharMaxLength: 100})
+	mapper.targetCharMaxLengths = map[string]int{"code": 5, "name": 20}
+
+	limits := mapper.overlongRiskColumns()
+	if !reflect.DeepEqual(limits, map[int]int{0: 5, 1: 20}) {
+		t.Fatalf("overlongRiskColumns() = %v", limits)
+	}
+	lengths, err := source.MaxStatisticsLengths(source.FileInfo{LocalPath: fileName, Size: info.Size()})
+	if err != nil {
+		t.Fatalf("MaxStatisticsLengths() error: %v", err)
+	}
+	result := overlongColumns(mapper.Info.Columns, limits, lengths)
+	expected := []string{"column 'name' (value length 30 > 20)"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("overlongColumns() = %v; want %v", result, expected)
+	}
+}
+
+// partRow is a Parquet fixture row for the part listing.
+type partRow struct {
+	ID int64 `parquet:"id"`
+}
+
+// writeTableFixture writes the export of the table public.<table> of the database "db" into a new directory
+// and returns its root. The parts are the rows of the Parquet files by their paths relative to the folder
+// of the table (for example "1/part-00000.parquet"); every subfolder gets a _SUCCESS marker.
+func writeTableFixture[R any](t *testing.T, table string, parts map[string][]R) string {
+	root := t.TempDir()
+	addTableFixture(t, root, table, parts)
+	return root
+}
+
+// addTableFixture writes the Parquet files of the table public.<table> into the export at root,
+// like writeTableFixture.
+func addTableFixture[R any](t *testing.T, root string, table string, parts map[string][]R) {
+	for relativePath, rows := range parts {
+		fileName := filepath.Join(root, "db", "public."+table, filepath.FromSlash(relativePath))
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			t.Fatalf("Failed to create the fixture: %v", err)
+		}
+		if err := parquet.WriteFile(fileName, rows); err != nil {
+			t.Fatalf("Failed to write the Parquet fixture: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(filepath.Dir(fileName), "_SUCCESS"), nil, 0644); err != nil {
+			t.Fatalf("Failed to create the fixture: %v", err)
+		}
+	}
+}
+
+func TestListTableParts(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "snap")
+	tableDir := filepath.Join(root, "db", "public.t")
+	writePart := func(subfolder string, name string, rows int) {
+		data := make([]partRow, rows)
+		if err := os.MkdirAll(filepath.Join(tableDir, subfolder), 0755); err != nil {
+			t.Fatalf("Failed to create the fixture: %v", err)
+		}
+		if err := parquet.WriteFile(filepath.Join(tableDir, subfolder, name), data); err != nil {
+			t.Fatalf("Failed to write the Parquet fixture: %v", err)
+		}
+	}
+	writePart("1", "part-00000.parquet", 3)
+	writePart("1", "part-00001.parquet", 2)
+	writePart("2", "part-00000.parquet", 4)
+	if err := os.WriteFile(filepath.Join(tableDir, "1", "_SUCCESS"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create the fixture: %v", err)
+	}
+
+	parts, err := ListTableParts(source.NewLocalSource(root), "db", "public.t")
+	if err != nil {
+		t.Fatalf("ListTableParts() error: %v", err)
+	}
+	type part struct {
+		subfolder string
+		file      string
+		rows      int64
+		marker    bool
+	}
+	var result []part
+	for _, p := range parts {
+		if p.Size <= 0 {
+			t.Errorf("ListTableParts() returned an empty size for %s", p.RelativePath)
+		}
+		result = append(result, part{p.Subfolder, filepath.Base(p.RelativePath), p.Rows, p.SuccessMarker})
+	}
+	expected := []part{
+		{filepath.Join("db", "public.t", "1"), "part-00000.parquet", 3, true},
+		{filepath.Join("db", "public.t", "1"), "part-00001.parquet", 2, true},
+		{filepath.Join("db", "public.t", "2"), "part-00000.parquet", 4, false},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("ListTableParts() = %v; want %v", result, expected)
+	}
+
+	files, err := ListTableFiles(source.NewLocalSource(root), "db", "public.t")
+	if err != nil {
+		t.Fatalf("ListTableFiles() error: %v", err)
+	}
+	for i := range parts {
+		parts[i].Rows = 0
+	}
+	if !reflect.DeepEqual(files, parts) {
+		t.Errorf("ListTableFiles() = %v; want %v", files, parts)
+	}
+}
+
+// conflictRow is a Parquet fixture row for loading with ON CONFLICT DO NOTHING.
+type conflictRow struct {
+	ID   int64  `parquet:"id"`
+	Name string `parquet:"name"`
+}
+
+func TestWriteTablePartOnConflictSkip(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE conflict_table (id BIGINT PRIMARY KEY, name TEXT);
+			INSERT INTO conflict_table VALUES (1, 'existing');`)
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		root := writeTableFixture(t, "conflict_table", map[string][]conflictRow{
+			"1/part-00000.parquet": {{ID: 1, Name: "new"}, {ID: 2, Name: "b"}, {ID: 3, Name: "c"}},
+		})
+
+		mapper := newTestMapper("public.conflict_table",
+			source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"},
+			source.ColumnInfo{ColumnName: "name", OriginalType: "text", ExpectedExportedType: "binary (UTF8)"})
+		mapper.Config.OnConflictSkip = true
+		writer := DbWriter{db: db}
+		written, err := writer.writeTablePart(source.NewLocalSource(root), &mapper,
+			filepath.Join("db", "public.conflict_table", "1", "part-00000.parquet"))
+		if err != nil {
+			t.Fatalf("writeTablePart() error: %v", err)
+		}
+		if written.Inserted != 2 {
+			t.Errorf("writeTablePart() = %d; want 2 inserted rows", written.Inserted)
+		}
+		var name string
+		var count int
+		err = db.QueryRow(context.Background(),
+			"SELECT (SELECT name FROM conflict_table WHERE id = 1), COUNT(*) FROM conflict_table").Scan(&name, &count)
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if name != "existing" || count != 3 {
+			t.Errorf("Table content: name of id 1 = %s, count = %d; want existing, 3", name, count)
+		}
+	})
+}
+
+func TestAnalyzeTable(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE analyzed_table (id BIGINT PRIMARY KEY);
+			INSERT INTO analyzed_table SELECT generate_series(1, 100);`)
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		writer := DbWriter{db: db}
+		if err := writer.analyzeTable("public.analyzed_table"); err != nil {
+			t.Fatalf("analyzeTable() error: %v", err)
+		}
+		var tuples float64
+		err = db.QueryRow(context.Background(),
+			"SELECT reltuples FROM pg_class WHERE oid = 'public.analyzed_table'::regclass").Scan(&tuples)
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if tuples != 100 {
+			t.Errorf("reltuples after ANALYZE = %v; want 100", tuples)
+		}
+	})
+}
+
+func TestWriteTablePartIdentityColumns(t *testing.T) {
+	tests := []struct {
+		name       string
+		generation string
+		staged     bool
+	}{
+		{name: "generated always", generation: "ALWAYS", staged: true},
+		{name: "generated by default", generation: "BY DEFAULT", staged: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+				_, err := db.Exec(context.Background(), fmt.Sprintf(
+					"CREATE TABLE identity_table (id BIGINT GENERATED %s AS IDENTITY PRIMARY KEY, name TEXT);",
+					tt.generation))
+				if err != nil {
+					t.Fatalf("Failed to create table: %v", err)
+				}
+				root := writeTableFixture(t, "identity_table", map[string][]conflictRow{
+					"1/part-00000.parquet": {{ID: 10, Name: "a"}, {ID: 20, Name: "b"}},
+				})
+
+				testMapper := newTestMapper("public.identity_table",
+					source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"},
+					source.ColumnInfo{ColumnName: "name", OriginalType: "text", ExpectedExportedType: "binary (UTF8)"})
+				writer := DbWriter{db: db}
+				mapper, err := writer.GetFieldMapper(testMapper.Info, testMapper.Config)
+				if err != nil {
+					t.Fatalf("GetFieldMapper() error: %v", err)
+				}
+				if staged := len(mapper.identityAlwaysColumns) > 0; staged != tt.staged {
+					t.Errorf("GetFieldMapper() staged = %v; want %v", staged, tt.staged)
+				}
+				written, err := writer.writeTablePart(source.NewLocalSource(root), mapper,
+					filepath.Join("db", "public.identity_table", "1", "part-00000.parquet"))
+				if err != nil {
+					t.Fatalf("writeTablePart() error: %v", err)
+				}
+				if written.Inserted != 2 {
+					t.Errorf("writeTablePart() = %d; want 2", written.Inserted)
+				}
+
+				tx, err := db.Begin(context.Background())
+				if err != nil {
+					t.Fatalf("Begin() error: %v", err)
+				}
+				if err := writer.resetSequences("public.identity_table", tx); err != nil {
+					t.Fatalf("resetSequences() error: %v", err)
+				}
+				if err := tx.Commit(context.Background()); err != nil {
+					t.Fatalf("Commit() error: %v", err)
+				}
+				var id int64
+				err = db.QueryRow(context.Background(),
+					"INSERT INTO identity_table (name) VALUES ('new') RETURNING id").Scan(&id)
+				if err != nil {
+					t.Fatalf("Insert after the restore error: %v", err)
+				}
+				if id != 21 {
+					t.Errorf("The identity of a new row = %d; want 21", id)
+				}
+			})
+		})
+	}
+}
+
+// rawStringsRow is a Parquet fixture row with the types loaded through the raw-string CSV path.
+type rawStringsRow struct {
+	ID     int32  `parquet:"id"`
+	Amount string `parquet:"amount"`
+	Doc    string `parquet:"doc"`
+}
+
+func TestWriteTablePartRawStrings(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), "CREATE TABLE raw_table (id INTEGER, amount NUMERIC(10, 2), doc JSONB);")
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		root := writeTableFixture(t, "raw_table", map[string][]rawStringsRow{"1/part-00000.parquet": {
+			{ID: 1, Amount: "12.50", Doc: `{"a": [1, 2]}`},
+			{ID: 2, Amount: "-0.01", Doc: `{"b": "x, \"y\""}`},
+		}})
+
+		mapper := newTestMapper("public.raw_table",
+			source.ColumnInfo{ColumnName: "id", OriginalType: "integer", ExpectedExportedType: "int32"},
+			source.ColumnInfo{ColumnName: "amount", OriginalType: "numeric", ExpectedExportedType: "binary (UTF8)"},
+			source.ColumnInfo{ColumnName: "doc", OriginalType: "jsonb", ExpectedExportedType: "binary (UTF8)"})
+		mapper.Config.RawStrings = true
+		writer := DbWriter{db: db}
+		written, err := writer.writeTablePart(source.NewLocalSource(root), &mapper,
+			filepath.Join("db", "public.raw_table", "1", "part-00000.parquet"))
+		if err != nil {
+			t.Fatalf("writeTablePart() error: %v", err)
+		}
+		if written.Inserted != 2 {
+			t.Errorf("writeTablePart() = %d; want 2", written.Inserted)
+		}
+		var sum string
+		var b string
+		err = db.QueryRow(context.Background(),
+			"SELECT SUM(id * amount)::text, (SELECT doc->>'b' FROM raw_table WHERE id = 2) FROM raw_table").
+			Scan(&sum, &b)
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if sum != "12.48" || b != `x, "y"` {
+			t.Errorf("Table content: sum = %s, doc->>'b' = %s; want 12.48, x, \"y\"", sum, b)
+		}
+	})
+}
+
+func TestWriteTablePartCopyCSVOptions(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), "CREATE TABLE csv_table (code TEXT, name TEXT);")
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		rows := []overlongRow{{Code: "a\tb", Name: "x,y"}, {Code: "", Name: `\N`}, {Code: `"q"`, Name: "two\nlines"}}
+		root := writeTableFixture(t, "csv_table", map[string][]overlongRow{"1/part-00000.parquet": rows})
+
+		mapper := newTestMapper("public.csv_table",
+			source.ColumnInfo{ColumnName: "code", OriginalType: "text", ExpectedExportedType: "binary (UTF8)"},
+			source.ColumnInfo{ColumnName: "name", OriginalType: "text", ExpectedExportedType: "binary (UTF8)"})
+		mapper.Config.RawStrings = true
+		mapper.Config.CopyCSVTables = map[string]utils.CopyCSVOptions{
+			"csv_table": {Delimiter: "\t", Null: `\N`, Header: true},
+		}
+		writer := DbWriter{db: db}
+		written, err := writer.writeTablePart(source.NewLocalSource(root), &mapper,
+			filepath.Join("db", "public.csv_table", "1", "part-00000.parquet"))
+		if err != nil {
+			t.Fatalf("writeTablePart() error: %v", err)
+		}
+		if written.Inserted != int64(len(rows)) {
+			t.Errorf("writeTablePart() = %d; want %d", written.Inserted, len(rows))
+		}
+		loaded, err := db.Query(context.Background(), "SELECT code, name FROM csv_table ORDER BY convert_to(code, 'UTF8')")
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		actual, err := pgx.CollectRows(loaded, pgx.RowToStructByPos[overlongRow])
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		expected := []overlongRow{rows[1], rows[2], rows[0]}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Table content = %q; want %q", actual, expected)
+		}
+	})
+}
+
+// specialTypesRow is a Parquet fixture row with the values of the types converted by special_types.go,
+// as the export writes them.
+type specialTypesRow struct {
+	ID       int32  `parquet:"id"`
+	Duration string `parquet:"duration"`
+	Flags    []byte `parquet:"flags"`
+	Price    string `parquet:"price"`
+}
+
+// specialBinaryRow is specialTypesRow without the money column, which forces the CSV COPY.
+type specialBinaryRow struct {
+	ID       int32  `parquet:"id"`
+	Duration string `parquet:"duration"`
+	Flags    []byte `parquet:"flags"`
+}
+
+func TestWriteTablePartSpecialTypes(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		// the money column forces the CSV COPY, so the other columns are loaded with the binary COPY in another table
+		_, err := db.Exec(context.Background(), `CREATE TABLE binary_special (id INTEGER, duration INTERVAL,
+				flags BIT VARYING(64));
+			CREATE TABLE csv_special (id INTEGER, duration INTERVAL, flags BIT VARYING(64), price MONEY);`)
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		root := t.TempDir()
+		rows := []specialTypesRow{
+			{ID: 1, Duration: "P1Y2M3DT4H5M6.5S", Flags: []byte{0xA5}, Price: "$1,234.56"},
+			{ID: 2, Duration: "-1 days +02:00:00", Flags: []byte("0101"), Price: "($0.50)"},
+		}
+		columns := []source.ColumnInfo{
+			{ColumnName: "id", OriginalType: "integer", ExpectedExportedType: "int32"},
+			{ColumnName: "duration", OriginalType: "interval", ExpectedExportedType: "binary (UTF8)"},
+			{ColumnName: "flags", OriginalType: "bit varying", ExpectedExportedType: "binary"},
+			{ColumnName: "price", OriginalType: "money", ExpectedExportedType: "binary (UTF8)"},
+		}
+		writer := DbWriter{db: db}
+		for _, table := range []string{"binary_special", "csv_special"} {
+			tableColumns := columns
+			if table == "binary_special" {
+				tableColumns = columns[:3]
+				binaryRows := make([]specialBinaryRow, len(rows))
+				for i, row := range rows {
+					binaryRows[i] = specialBinaryRow{ID: row.ID, Duration: row.Duration, Flags: row.Flags}
+				}
+				addTableFixture(t, root, table, map[string][]specialBinaryRow{"1/part-00000.parquet": binaryRows})
+			} else {
+				addTableFixture(t, root, table, map[string][]specialTypesRow{"1/part-00000.parquet": rows})
+			}
+			mapper := newTestMapper("public."+table, tableColumns...)
+			written, err := writer.writeTablePart(source.NewLocalSource(root), &mapper,
+				filepath.Join("db", "public."+table, "1", "part-00000.parquet"))
+			if err != nil {
+				t.Fatalf("writeTablePart(%s) error: %v", table, err)
+			}
+			if written.Inserted != 2 {
+				t.Errorf("writeTablePart(%s) = %d; want 2", table, written.Inserted)
+			}
+		}
+
+		for _, table := range []string{"binary_special", "csv_special"} {
+			var durations, flags string
+			err = db.QueryRow(context.Background(), fmt.Sprintf("SELECT string_agg(duration::text, '|' ORDER BY id), "+
+				"string_agg(flags::text, '|' ORDER BY id) FROM %s", table)).Scan(&durations, &flags)
+			if err != nil {
+				t.Fatalf("Query error: %v", err)
+			}
+			if expected := "1 year 2 mons 3 days 04:05:06.5|-1 days +02:00:00"; durations != expected {
+				t.Errorf("%s durations = %s; want %s", table, durations, expected)
+			}
+			if expected := "10100101|0101"; flags != expected {
+				t.Errorf("%s flags = %s; want %s", table, flags, expected)
+			}
+		}
+		var prices string
+		err = db.QueryRow(context.Background(),
+			"SELECT string_agg(price::numeric::text, '|' ORDER BY id) FROM csv_special").Scan(&prices)
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if expected := "1234.56|-0.50"; prices != expected {
+			t.Errorf("prices = %s; want %s", prices, expected)
+		}
+	})
+}
+
+// cancellingSource is a source that cancels the context when the given file is requested,
+// simulating an interruption in the middle of loading a table.
+type cancellingSource struct {
+	source.Source
+	cancelOn string
+	cancel   context.CancelFunc
+}
+
+func (s *cancellingSource) GetFile(relativePath string) source.FileInfo {
+	if filepath.Base(filepath.Dir(relativePath)) == s.cancelOn {
+		s.cancel()
+	}
+	return s.Source.GetFile(relativePath)
+}
+
+func TestWriteTableCancelled(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE cancelled_table (id BIGINT PRIMARY KEY);
+			CREATE INDEX cancelled_table_id_desc ON cancelled_table (id DESC);
+			CREATE FUNCTION cancelled_table_noop() RETURNS trigger LANGUAGE plpgsql AS $$ BEGIN RETURN NEW; END $$;
+			CREATE TRIGGER cancelled_table_trigger BEFORE INSERT ON cancelled_table
+				FOR EACH ROW EXECUTE FUNCTION cancelled_table_noop();`)
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		root := writeTableFixture(t, "cancelled_table", map[string][]partRow{
+			"1/part-00000.parquet": {{ID: 1}, {ID: 2}, {ID: 3}},
+			"2/part-00000.parquet": {{ID: 4}, {ID: 5}, {ID: 6}},
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		writer := NewDatabaseWriterWithURL(connectionString)
+		if err := writer.Connect(ctx); err != nil {
+			t.Fatalf("Connect() error: %v", err)
+		}
+		defer writer.Close()
+		// the first part is copied, and the restore is interrupted when the second part is requested
+		src := &cancellingSource{Source: source.NewLocalSource(root), cancelOn: "2", cancel: cancel}
+		mapper := newTestMapper("public.cancelled_table",
+			source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"})
+		mapper.Config.SourceDatabase = "db"
+		if _, err := writer.WriteTable(ctx, src, &mapper); err == nil {
+			t.Fatalf("WriteTable() did not fail after the cancellation")
+		}
+
+		var count int
+		if err := db.QueryRow(context.Background(), "SELECT COUNT(*) FROM cancelled_table").Scan(&count); err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if count != 0 {
+			t.Errorf("%d rows of the interrupted table were committed; want none", count)
+		}
+		// the indexes dropped and the triggers disabled for loading are restored by the rollback
+		rows, err := db.Query(context.Background(),
+			"SELECT indexname FROM pg_indexes WHERE tablename = 'cancelled_table' ORDER BY indexname")
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		indexes, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if expected := []string{"cancelled_table_id_desc", "cancelled_table_pkey"}; !reflect.DeepEqual(indexes,
+			expected) {
+			t.Errorf("Indexes of the interrupted table = %v; want %v", indexes, expected)
+		}
+		var enabled string
+		err = db.QueryRow(context.Background(),
+			"SELECT tgenabled::text FROM pg_trigger WHERE tgname = 'cancelled_table_trigger'").Scan(&enabled)
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if enabled != "O" {
+			t.Errorf("The trigger of the interrupted table is disabled (tgenabled = %s); want O", enabled)
+		}
+	})
+}
+
+func TestStartTableTimeout(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer := DbWriter{ctx: parent}
+	failure := errors.New("statement failed")
+
+	stop := writer.startTableTimeout(time.Hour)
+	if _, limited := writer.dbContext().Deadline(); !limited {
+		t.Errorf("dbContext() has no deadline while the table timeout is active")
+	}
+	if err := stop("public.fast", time.Now(), failure); err != failure || writer.ctx != parent {
+		t.Errorf("stop() = %v; want the error unchanged and the context restored", err)
+	}
+
+	stop = writer.startTableTimeout(time.Millisecond)
+	<-writer.dbContext().Done()
+	// the writer is not connected, so the reconnection fails as well
+	err := stop("public.slow", time.Now(), failure)
+	if !errors.Is(err, ErrTableTimeout) || !errors.Is(err, failure) || writer.ctx != parent {
+		t.Errorf("stop() = %v; want ErrTableTimeout wrapping the error and the context restored", err)
+	}
+}
+
+// slowSource delays returning the files of the given subfolder.
+type slowSource struct {
+	source.Source
+	delayOn string
+	delay   time.Duration
+}
+
+func (s *slowSource) GetFile(relativePath string) source.FileInfo {
+	if filepath.Base(filepath.Dir(relativePath)) == s.delayOn {
+		time.Sleep(s.delay)
+	}
+	return s.Source.GetFile(relativePath)
+}
+
+func TestWriteTableTimeout(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), "CREATE TABLE slow_table (id BIGINT PRIMARY KEY);")
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		root := writeTableFixture(t, "slow_table", map[string][]partRow{
+			"1/part-00000.parquet": {{ID: 1}, {ID: 2}, {ID: 3}},
+			"2/part-00000.parquet": {{ID: 4}, {ID: 5}, {ID: 6}},
+		})
+
+		writer := NewDatabaseWriterWithURL(connectionString)
+		if err := writer.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error: %v", err)
+		}
+		defer writer.Close()
+		// the first part is copied, and the second part is returned after the timeout
+		src := &slowSource{Source: source.NewLocalSource(root), delayOn: "2", delay: 500 * time.Millisecond}
+		mapper := newTestMapper("public.slow_table",
+			source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"})
+		mapper.Config.SourceDatabase = "db"
+		mapper.Config.TableTimeout = 200 * time.Millisecond
+		if _, err := writer.WriteTable(context.Background(), src, &mapper); !errors.Is(err, ErrTableTimeout) {
+			t.Fatalf("WriteTable() error = %v; want ErrTableTimeout", err)
+		}
+
+		// the writer is connected again and can load the next table
+		count, err := writer.TableRowCount("public.slow_table")
+		if err != nil {
+			t.Fatalf("TableRowCount() after the timeout error: %v", err)
+		}
+		if count != 0 {
+			t.Errorf("%d rows of the timed out table were committed; want none", count)
+		}
+	})
+}
+
+func TestWriteTableAfterFailure(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE failing_table (id BIGINT PRIMARY KEY);
+			CREATE TABLE next_table (id BIGINT PRIMARY KEY);`)
+		if err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+		root := writeTableFixture(t, "next_table", map[string][]partRow{"1/part-00000.parquet": {{ID: 1}, {ID: 2}}})
+		addTableFixture(t, root, "failing_table", map[string][]partRow{"1/part-00000.parquet": {{ID: 1}, {ID: 2}}})
+		// the failing table has no _SUCCESS marker, so it fails after its indexes are dropped
+		if err := os.Remove(filepath.Join(root, "db", "public.failing_table", "1", "_SUCCESS")); err != nil {
+			t.Fatalf("Failed to create the fixture: %v", err)
+		}
+
+		writer := NewDatabaseWriterWithURL(connectionString)
+		if err := writer.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error: %v", err)
+		}
+		defer writer.Close()
+		src := source.NewLocalSource(root)
+		column := source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"}
+		failing := newTestMapper("public.failing_table", column)
+		failing.Config.SourceDatabase = "db"
+		if _, err := writer.WriteTable(context.Background(), src, &failing); err == nil {
+			t.Fatalf("WriteTable() of the table without _SUCCESS did not fail")
+		}
+
+		// the failed table was rolled back, and the connection is usable for the next table
+		if err := writer.EnsureConnected(); err != nil {
+			t.Fatalf("EnsureConnected() error: %v", err)
+		}
+		next := newTestMapper("public.next_table", column)
+		next.Config.SourceDatabase = "db"
+		if rows, err := writer.WriteTable(context.Background(), src, &next); err != nil || rows.Inserted != 2 {
+			t.Fatalf("WriteTable() of the next table = %d, %v; want 2 rows", rows.Inserted, err)
+		}
+		var indexes int
+		err = db.QueryRow(context.Background(),
+			"SELECT COUNT(*) FROM pg_indexes WHERE tablename = 'failing_table'").Scan(&indexes)
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if indexes != 1 {
+			t.Errorf("The failed table has %d indexes; want its primary key restored by the rollback", indexes)
+		}
+	})
+}
+
+func TestWriteTableKeepIndexes(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE kept_table (id BIGINT PRIMARY KEY);
+			CREATE INDEX kept_table_id_desc ON kept_table (id DESC);
+			INSERT INTO kept_table VALUES (100);
+			CREATE TABLE rebuilt_table (id BIGINT PRIMARY KEY);
+			CREATE INDEX rebuilt_table_id_desc ON rebuilt_table (id DESC);`)
+		if err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+		root := t.TempDir()
+		for _, table := range []string{"kept_table", "rebuilt_table"} {
+			addTableFixture(t, root, table, map[string][]partRow{"1/part-00000.parquet": {{ID: 1}, {ID: 2}}})
+		}
+		indexOID := func(index string) (oid uint32) {
+			if err := db.QueryRow(context.Background(), "SELECT $1::regclass::oid", index).Scan(&oid); err != nil {
+				t.Fatalf("Query error: %v", err)
+			}
+			return oid
+		}
+		keptBefore, rebuiltBefore := indexOID("kept_table_id_desc"), indexOID("rebuilt_table_id_desc")
+
+		writer := NewDatabaseWriterWithURL(connectionString)
+		if err := writer.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error: %v", err)
+		}
+		defer writer.Close()
+		src := source.NewLocalSource(root)
+		column := source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"}
+		for _, table := range []string{"public.kept_table", "public.rebuilt_table"} {
+			mapper := newTestMapper(table, column)
+			mapper.Config.SourceDatabase = "db"
+			mapper.Config.KeepIndexes = true
+			if rows, err := writer.WriteTable(context.Background(), src, &mapper); err != nil || rows.Inserted != 2 {
+				t.Fatalf("WriteTable(%s) = %d, %v; want 2 rows", table, rows.Inserted, err)
+			}
+		}
+
+		// the index of the non-empty table is the same object, and the index of the empty table was recreated
+		if after := indexOID("kept_table_id_desc"); after != keptBefore {
+			t.Errorf("The index of the non-empty table was rebuilt with --keep-indexes")
+		}
+		if after := indexOID("rebuilt_table_id_desc"); after == rebuiltBefore {
+			t.Errorf("The index of the empty table was not rebuilt with --keep-indexes")
+		}
+	})
+}
+
+func TestKeepIndexesDisabled(t *testing.T) {
+	// without the option, the table is not even queried
+	mapper := newTestMapper("public.t")
+	if keep, err := (&DbWriter{}).keepIndexes(&mapper); keep || err != nil {
+		t.Errorf("keepIndexes() = %v, %v; want false without --keep-indexes", keep, err)
+	}
+}
+
+func TestUniqueIndexesKept(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		// none of the unique indexes is on a column named "id"
+		_, err := db.Exec(context.Background(), `CREATE TABLE coded_table (code TEXT PRIMARY KEY, name TEXT);
+			CREATE UNIQUE INDEX coded_table_name_key ON coded_table (name);
+			CREATE INDEX coded_table_name_code ON coded_table (name, code);`)
+		if err != nil {
+			t.Fatalf("Failed to create the table: %v", err)
+		}
+		writer := NewDatabaseWriterWithURL(connectionString)
+		if err := writer.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error: %v", err)
+		}
+		defer writer.Close()
+		indexes, err := writer.getIndexList("public.coded_table")
+		if err != nil {
+			t.Fatalf("getIndexList() error: %v", err)
+		}
+		kept := make(map[string]bool)
+		for _, index := range indexes {
+			kept[index.Name] = index.keptDuringLoad()
+		}
+		expected := map[string]bool{"coded_table_name_code": false, "coded_table_name_key": true, "coded_table_pkey": true}
+		if !reflect.DeepEqual(kept, expected) {
+			t.Fatalf("keptDuringLoad() of the indexes = %v; want %v", kept, expected)
+		}
+
+		root := writeTableFixture(t, "coded_table", map[string][]overlongRow{
+			"1/part-00000.parquet": {{Code: "AB", Name: "first"}, {Code: "CD", Name: "second"}},
+		})
+		indexOID := func(index string) (oid uint32) {
+			if err := db.QueryRow(context.Background(), "SELECT $1::regclass::oid", index).Scan(&oid); err != nil {
+				t.Fatalf("Query error: %v", err)
+			}
+			return oid
+		}
+		before := map[string]uint32{}
+		for name := range expected {
+			before[name] = indexOID(name)
+		}
+		mapper := newTestMapper("public.coded_table",
+			source.ColumnInfo{ColumnName: "code", OriginalType: "text", ExpectedExportedType: "binary (UTF8)"},
+			source.ColumnInfo{ColumnName: "name", OriginalType: "text", ExpectedExportedType: "binary (UTF8)"})
+		mapper.Config.SourceDatabase = "db"
+		if rows, err := writer.WriteTable(context.Background(), source.NewLocalSource(root), &mapper); err != nil ||
+			rows.Inserted != 2 {
+			t.Fatalf("WriteTable() = %d, %v; want 2 rows", rows.Inserted, err)
+		}
+
+		// the unique indexes are the same objects, and the other index was dropped and recreated
+		for name, keptDuringLoad := range expected {
+			if rebuilt := indexOID(name) != before[name]; rebuilt == keptDuringLoad {
+				t.Errorf("The index %s was rebuilt = %v; want %v", name, rebuilt, !keptDuringLoad)
+			}
+		}
+	})
+}
+
+func TestPartManifest(t *testing.T) {
+	root := t.TempDir()
+	tableDir := filepath.Join(root, "db", "public.t")
+	if err := os.MkdirAll(filepath.Join(tableDir, "1"), 0755); err != nil {
+		t.Fatalf("Failed to create the fixture: %v", err)
+	}
+	sizes := make(map[string]int64)
+	for _, name := range []string{"part-00000.parquet", "part-00001.parquet"} {
+		fileName := filepath.Join(tableDir, "1", name)
+		if err := parquet.WriteFile(fileName, []partRow{{ID: 1}}); err != nil {
+			t.Fatalf("Failed to write the Parquet fixture: %v", err)
+		}
+		stat, err := os.Stat(fileName)
+		if err != nil {
+			t.Fatalf("Stat() error: %v", err)
+		}
+		sizes[name] = stat.Size()
+	}
+	if err := os.WriteFile(filepath.Join(tableDir, "1", "_SUCCESS"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create the fixture: %v", err)
+	}
+	writeManifest := func(manifest string) {
+		if err := os.WriteFile(filepath.Join(tableDir, source.PartManifestFileName), []byte(manifest), 0644); err != nil {
+			t.Fatalf("Failed to create the fixture: %v", err)
+		}
+	}
+	src := source.NewLocalSource(root)
+
+	// the part not listed in the manifest is skipped
+	writeManifest(fmt.Sprintf(`{"files": [{"path": "1/part-00001.parquet", "size": %d, "rows": 1}]}`,
+		sizes["part-00001.parquet"]))
+	allFiles, _, err := groupTableFiles(src, "db", "public.t")
+	expected := []string{"db/public.t/1/_SUCCESS", "db/public.t/1/part-00001.parquet"}
+	if err != nil || !reflect.DeepEqual(allFiles, expected) {
+		t.Errorf("groupTableFiles() = %v, %v; want %v", allFiles, err, expected)
+	}
+
+	writeManifest(fmt.Sprintf(`{"files": [{"path": "1/part-00001.parquet", "size": %d, "rows": 1}]}`,
+		sizes["part-00001.parquet"]+1))
+	if _, _, err := groupTableFiles(src, "db", "public.t"); err == nil || !strings.Contains(err.Error(), "bytes") {
+		t.Errorf("groupTableFiles() error = %v; want the size mismatch", err)
+	}
+
+	// a missing part fails the table before anything is written: the writer has no database connection
+	writeManifest(fmt.Sprintf(`{"files": [{"path": "1/part-00000.parquet", "size": %d, "rows": 1},
+		{"path": "1/part-00002.parquet", "size": 100, "rows": 1}]}`, sizes["part-00000.parquet"]))
+	mapper := newTestMapper("public.t", source.ColumnInfo{ColumnName: "id", OriginalType: "bigint"})
+	mapper.Config.SourceDatabase = "db"
+	_, err = (&DbWriter{}).writeTableData(src, &mapper)
+	if err == nil || !strings.Contains(err.Error(), "part-00002.parquet") || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("writeTableData() error = %v; want the missing part", err)
+	}
+
+	writeManifest(`{"files": [{"path": "../other/part-00000.parquet", "size": 1, "rows": 1}]}`)
+	if _, _, err := groupTableFiles(src, "db", "public.t"); err == nil {
+		t.Errorf("groupTableFiles() accepted a path outside the folder of the table")
+	}
+}
+
+func TestSortedSubfolders(t *testing.T) {
+	grouped := map[string][]string{"db/public.events/10": nil, "db/public.events/2": nil, "db/public.events/1": nil}
+	expected := []string{"db/public.events/1", "db/public.events/2", "db/public.events/10"}
+	if result := sortedSubfolders(grouped); !reflect.DeepEqual(result, expected) {
+		t.Errorf("sortedSubfolders() = %v; want %v", result, expected)
+	}
+}
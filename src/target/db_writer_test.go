@@ -3,14 +3,21 @@ package target
 import (
 	"context"
 	"dbrestore/config"
+	"dbrestore/dag"
 	"dbrestore/source"
+	"dbrestore/testdb"
 	"dbrestore/utils"
 	"fmt"
 	"io"
 	"math/rand"
 	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/parquet-go/parquet-go"
 	"gopkg.in/yaml.v3"
 	_ "gopkg.in/yaml.v3"
 
@@ -51,6 +58,13 @@ func loadTestConfig() map[string]interface{} {
 	return data
 }
 
+// withTestDatabase creates a temporary test database in the local PostgreSQL, connects to it,
+// runs the test function and drops the database at the end (see testdb.WithTestDatabase).
+// The test is skipped when the local test configuration file is missing.
+func withTestDatabase(t *testing.T, fn func(t *testing.T, db *pgx.Conn, connectionString string)) {
+	testdb.WithTestDatabase(t, fn)
+}
+
 func TestCreateTestDatabase(t *testing.T) {
 	conf := loadTestConfig()
 
@@ -164,7 +178,7 @@ func runTestInAnotherDatabase(t *testing.T, testDatabaseName string, pwd string)
 	var copied int64
 	copied, err = db.CopyFrom(
 		context.Background(),
-		utils.CreatePgxIdentifier("test_table"),
+		pgx.Identifier{"test_table"},
 		mapper.getFieldNames(), //[]string{"first_name", "last_name", "age"},
 		&testData,              // pgx.CopyFromRows(rows),
 	)
@@ -225,3 +239,464 @@ func (t *TestCopyFromSource) Values() ([]any, error) {
 func (t *TestCopyFromSource) Err() error {
 	return t.err
 }
+
+func TestColumnOrderMismatches(t *testing.T) {
+	columns := func(names ...string) []source.ColumnInfo {
+		ret := make([]source.ColumnInfo, 0, len(names))
+		for _, name := range names {
+			ret = append(ret, source.ColumnInfo{ColumnName: name})
+		}
+		return ret
+	}
+	destination := map[string]int{"a": 1, "b": 2, "c": 3}
+
+	tests := []struct {
+		name           string
+		columns        []source.ColumnInfo
+		expectedResult []string
+	}{
+		{name: "Same order", columns: columns("a", "b", "c"), expectedResult: nil},
+		{name: "Subset in the same order", columns: columns("a", "c"), expectedResult: nil},
+		{name: "Reordered", columns: columns("b", "a", "c"), expectedResult: []string{"a"}},
+		{name: "Missing column", columns: columns("a", "x"), expectedResult: []string{"x (missing)"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := columnOrderMismatches(tt.columns, destination)
+			if !reflect.DeepEqual(result, tt.expectedResult) {
+				t.Errorf("columnOrderMismatches() = %v; want %v", result, tt.expectedResult)
+			}
+		})
+	}
+}
+
+func TestOrderTablesDeterministic(t *testing.T) {
+	newFKeys := func() *dag.FKeysGraph[Relation] {
+		fkMap := dag.NewFKeysGraph[Relation](10)
+		for parent, children := range map[string][]string{
+			"public.orders":      {"public.users", "public.products"},
+			"public.order_items": {"public.products", "public.orders"},
+		} {
+			node, err := fkMap.AddNode(parent)
+			if err != nil {
+				t.Fatalf("AddNode() error: %v", err)
+			}
+			for _, child := range children {
+				node.AddChild(child, Relation{})
+			}
+		}
+		fkMap.CalculateInDegree()
+		return &fkMap
+	}
+	tables := []string{"public.zeta", "public.orders", "public.audit", "public.users", "public.order_items",
+		"public.config", "public.products"}
+	expected := []string{"public.products", "public.users", "public.orders", "public.order_items",
+		"public.audit", "public.config", "public.zeta"}
+
+	// the second run gets the tables from the database in a different order
+	reversed := slices.Clone(tables)
+	slices.Reverse(reversed)
+	for i, list := range [][]string{tables, reversed} {
+		result, err := orderTables(newFKeys(), list)
+		if err != nil {
+			t.Fatalf("orderTables() error: %v", err)
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("Run %d: orderTables() = %v; want %v", i+1, result, expected)
+		}
+	}
+}
+
+func TestFormatCycles(t *testing.T) {
+	tests := []struct {
+		name     string
+		cycles   [][]string
+		expected string
+	}{
+		{name: "No cycles", expected: "no cycle path was found"},
+		{name: "Single cycle", cycles: [][]string{{"a.b", "a.c", "a.b"}},
+			expected: "the foreign keys form a cycle a.b -> a.c -> a.b"},
+		{name: "Two cycles", cycles: [][]string{{"a.b", "a.c", "a.b"}, {"x.y", "x.y2", "x.y"}},
+			expected: "the foreign keys form 2 cycles: a.b -> a.c -> a.b; x.y -> x.y2 -> x.y"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := formatCycles(tt.cycles); result != tt.expected {
+				t.Errorf("formatCycles() = %s; want %s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSelectTables(t *testing.T) {
+	fkMap := dag.NewFKeysGraph[Relation](10)
+	orders, _ := fkMap.AddNode("public.orders")
+	orders.AddChild("public.users", Relation{constraintName: "orders_user_fk"})
+	items, _ := fkMap.AddNode("public.items")
+	items.AddChild("public.orders", Relation{constraintName: "items_order_fk"})
+	fkMap.CalculateInDegree()
+	tables := []string{"public.items", "public.logs", "public.orders", "public.users"}
+
+	subgraph, selected, err := selectTables(&fkMap, tables, []string{"public.orders", "public.logs"})
+	if err != nil {
+		t.Fatalf("selectTables() error: %v", err)
+	}
+	ordered, err := orderTables(subgraph, selected)
+	if err != nil {
+		t.Fatalf("orderTables() error: %v", err)
+	}
+	expected := []string{"public.logs", "public.users", "public.orders"}
+	if !reflect.DeepEqual(ordered, expected) {
+		t.Errorf("orderTables() = %v; want %v", ordered, expected)
+	}
+
+	_, _, err = selectTables(&fkMap, tables, []string{"public.orders", "public.missing"})
+	if err == nil || !strings.Contains(err.Error(), "public.missing") || !utils.IsFatalError(err) {
+		t.Errorf("selectTables() error = %v; want a fatal error about public.missing", err)
+	}
+}
+
+func TestReferencedTables(t *testing.T) {
+	fkMap := dag.NewFKeysGraph[Relation](10)
+	orders, _ := fkMap.AddNode("public.orders")
+	orders.AddChild("public.users", Relation{constraintName: "orders_user_fk"})
+	orders.AddChild("public.shops", Relation{constraintName: "orders_shop_fk"})
+	orders.AddChild("public.orders", Relation{constraintName: "orders_parent_fk"})
+	writer := DbWriter{}
+	if referenced := writer.ReferencedTables("public.orders"); referenced != nil {
+		t.Errorf("ReferencedTables() before GetTablesOrdered = %v; want nil", referenced)
+	}
+	writer.fkGraph = &fkMap
+	expected := []string{"public.shops", "public.users"}
+	if referenced := writer.ReferencedTables("public.orders"); !reflect.DeepEqual(referenced, expected) {
+		t.Errorf("ReferencedTables() = %v; want %v without the self-reference", referenced, expected)
+	}
+	if referenced := writer.ReferencedTables("public.logs"); len(referenced) != 0 {
+		t.Errorf("ReferencedTables() of a table without foreign keys = %v; want none", referenced)
+	}
+}
+
+func TestRelationValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		relation      Relation
+		expectedError string
+	}{
+		{name: "Single column", relation: Relation{selfColumns: []string{"user_id"}, foreignColumns: []string{"id"}}},
+		{name: "Composite key", relation: Relation{selfColumns: []string{"order_id", "line"},
+			foreignColumns: []string{"id", "line"}}},
+		{name: "Column counts differ", relation: Relation{constraintName: "fk", selfSchema: "public", selfTable: "t",
+			selfColumns: []string{"a", "b"}, foreignColumns: []string{"id"}},
+			expectedError: "'public.t' has 2 columns (a, b), but references 1 columns (id)"},
+		{name: "No columns", relation: Relation{constraintName: "fk", selfSchema: "public", selfTable: "t"},
+			expectedError: "has no columns"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.relation.validate()
+			if tt.expectedError == "" {
+				if err != nil {
+					t.Errorf("validate() error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("validate() error = %v; want %q", err, tt.expectedError)
+			}
+		})
+	}
+}
+
+func TestGetFKeysComposite(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE fk_orders (id BIGINT, line INT, PRIMARY KEY (line, id));
+			CREATE TABLE fk_items (item_line INT, item_order BIGINT,
+				CONSTRAINT fk_items_orders FOREIGN KEY (item_order, item_line) REFERENCES fk_orders (id, line));`)
+		if err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+		writer := DbWriter{db: db}
+		fkMap, err := writer.getFKeys()
+		if err != nil {
+			t.Fatalf("getFKeys() error: %v", err)
+		}
+		children := fkMap.GetNodeChildren("public.fk_items")
+		if children == nil || len((*children)["public.fk_orders"]) != 1 {
+			t.Fatalf("getFKeys() children of fk_items = %v; want one relation to fk_orders", children)
+		}
+		relation := (*children)["public.fk_orders"][0]
+		if !reflect.DeepEqual(relation.selfColumns, []string{"item_order", "item_line"}) ||
+			!reflect.DeepEqual(relation.foreignColumns, []string{"id", "line"}) {
+			t.Errorf("getFKeys() columns = %v -> %v; want [item_order item_line] -> [id line]",
+				relation.selfColumns, relation.foreignColumns)
+		}
+	})
+}
+
+func TestGetFKeysPrimaryKeyOnly(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE pk_only (id BIGINT PRIMARY KEY);
+			CREATE TABLE no_keys (id BIGINT UNIQUE);`)
+		if err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+		writer := DbWriter{db: db}
+		fkMap, err := writer.getFKeys()
+		if err != nil {
+			t.Fatalf("getFKeys() error: %v", err)
+		}
+		if node := fkMap.GetNode("public.pk_only"); node == nil || len(node.Children) != 0 {
+			t.Errorf("getFKeys() node of pk_only = %v; want a node without children", node)
+		}
+		if node := fkMap.GetNode("public.no_keys"); node != nil {
+			t.Errorf("getFKeys() node of no_keys = %v; want no node for a table without a primary key", node)
+		}
+	})
+}
+
+func TestConstraintKeptDuringLoad(t *testing.T) {
+	tests := []struct {
+		name       string
+		constraint ConstraintInfo
+		expected   bool
+	}{
+		{name: "Primary key", constraint: ConstraintInfo{Command: "PRIMARY KEY (code)", Type: "p"}, expected: true},
+		{name: "Unique", constraint: ConstraintInfo{Command: "UNIQUE (email)", Type: "u"}, expected: true},
+		{name: "Exclusion", constraint: ConstraintInfo{Command: "EXCLUDE USING gist (during WITH &&)", Type: "x"},
+			expected: true},
+		{name: "Foreign key to a table named like a keyword", constraint: ConstraintInfo{
+			Command: "FOREIGN KEY (x_id) REFERENCES unique_x(id)", Type: "f"}},
+		{name: "Check mentioning the keywords", constraint: ConstraintInfo{
+			Command: "CHECK ((kind <> 'UNIQUE'::text) AND (kind <> 'PRIMARY KEY'::text))", Type: "c"}},
+		{name: "Unknown type", constraint: ConstraintInfo{Command: "PRIMARY KEY (id)"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if kept := tt.constraint.keptDuringLoad(); kept != tt.expected {
+				t.Errorf("keptDuringLoad() = %v; want %v", kept, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDropAndRestoreConstraints(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE unique_x (id BIGINT PRIMARY KEY);
+			CREATE TABLE constrained (id BIGINT CONSTRAINT constrained_pkey PRIMARY KEY,
+				code TEXT CONSTRAINT constrained_code_key UNIQUE,
+				x_id BIGINT CONSTRAINT constrained_x_fk REFERENCES unique_x (id),
+				kind TEXT CONSTRAINT constrained_kind_check CHECK (kind <> 'UNIQUE' AND kind <> 'PRIMARY KEY'));`)
+		if err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+		writer := DbWriter{db: db}
+		constraints, err := writer.getConstraintList("public.constrained")
+		if err != nil {
+			t.Fatalf("getConstraintList() error: %v", err)
+		}
+		types := make(map[string]string)
+		for _, constraint := range constraints {
+			types[constraint.Name] = constraint.Type
+		}
+		expected := map[string]string{"constrained_pkey": "p", "constrained_code_key": "u", "constrained_x_fk": "f",
+			"constrained_kind_check": "c"}
+		if !reflect.DeepEqual(types, expected) {
+			t.Fatalf("getConstraintList() types = %v; want %v", types, expected)
+		}
+
+		tx, err := db.Begin(context.Background())
+		if err != nil {
+			t.Fatalf("Begin() error: %v", err)
+		}
+		defer func() {
+			_ = tx.Rollback(context.Background())
+		}()
+		existing := func() []string {
+			rows, err := tx.Query(context.Background(), `SELECT conname FROM pg_constraint
+				WHERE conrelid = 'constrained'::regclass ORDER BY conname`)
+			if err != nil {
+				t.Fatalf("Query error: %v", err)
+			}
+			names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+			if err != nil {
+				t.Fatalf("Query error: %v", err)
+			}
+			return names
+		}
+		if err := writer.dropIndexes("public.constrained", constraints, nil, tx, nil); err != nil {
+			t.Fatalf("dropIndexes() error: %v", err)
+		}
+		if names := existing(); !reflect.DeepEqual(names, []string{"constrained_code_key", "constrained_pkey"}) {
+			t.Errorf("the constraints after dropIndexes() = %v; want only the primary key and the unique one", names)
+		}
+		if err := writer.restoreIndexes("public.constrained", nil, nil, tx, constraints); err != nil {
+			t.Fatalf("restoreIndexes() error: %v", err)
+		}
+		all := []string{"constrained_code_key", "constrained_kind_check", "constrained_pkey", "constrained_x_fk"}
+		if names := existing(); !reflect.DeepEqual(names, all) {
+			t.Errorf("the constraints after restoreIndexes() = %v; want %v", names, all)
+		}
+	})
+}
+
+func TestNewDatabaseWriterEscaping(t *testing.T) {
+	writer := NewDatabaseWriter("db.example.com", 6432, "my db", "user@corp", "p@ss/w:rd?#%", SSLOptions{Mode: "require"})
+	connConfig, err := pgx.ParseConfig(writer.ConnectionString)
+	if err != nil {
+		t.Fatalf("ParseConfig(%s) error: %v", writer.ConnectionString, err)
+	}
+	if connConfig.Host != "db.example.com" || connConfig.Port != 6432 || connConfig.Database != "my db" ||
+		connConfig.User != "user@corp" || connConfig.Password != "p@ss/w:rd?#%" {
+		t.Errorf("ParseConfig() = host %s, port %d, database %s, user %s, password %s", connConfig.Host,
+			connConfig.Port, connConfig.Database, connConfig.User, connConfig.Password)
+	}
+}
+
+func TestBindContext(t *testing.T) {
+	connected, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer := DbWriter{ctx: connected}
+	call, cancelCall := context.WithCancel(context.Background())
+	restore := writer.bindContext(call)
+	cancelCall()
+	if writer.dbContext().Err() == nil {
+		t.Errorf("dbContext() is not the bound context")
+	}
+	restore()
+	if writer.dbContext() != connected {
+		t.Errorf("dbContext() is not the context of Connect after the call")
+	}
+}
+
+func TestProbeCapabilities(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   bool
+	}{
+		{name: "PostgreSQL", parameters: map[string]string{"server_version": "16.4", "is_superuser": "off",
+			"session_authorization": "app"}, expected: false},
+		{name: "Only is_superuser", parameters: map[string]string{"is_superuser": "on"}, expected: false},
+		{name: "Only session_authorization", parameters: map[string]string{"session_authorization": "app"},
+			expected: false},
+		{name: "PgBouncer", parameters: map[string]string{"server_version": "16.4", "client_encoding": "UTF8",
+			"TimeZone": "UTC", "application_name": "dbrestore"}, expected: true},
+		{name: "No parameters", parameters: map[string]string{}, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := DbWriter{PgBouncerCompat: true}
+			writer.probeCapabilities(func(name string) string {
+				return tt.parameters[name]
+			})
+			if writer.behindPooler != tt.expected {
+				t.Errorf("behindPooler = %v; want %v", writer.behindPooler, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSSLOptionsQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  SSLOptions
+		expected string
+	}{
+		{name: "default", options: SSLOptions{}, expected: "sslmode=disable"},
+		{name: "require", options: SSLOptions{Mode: "require"}, expected: "sslmode=require"},
+		{name: "verify-full", options: SSLOptions{Mode: "verify-full", RootCert: "/certs/rds ca.pem",
+			Cert: "/certs/client.crt", Key: "/certs/client.key"},
+			expected: "sslcert=%2Fcerts%2Fclient.crt&sslkey=%2Fcerts%2Fclient.key&sslmode=verify-full&sslrootcert=%2Fcerts%2Frds+ca.pem"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if query := tt.options.query().Encode(); query != tt.expected {
+				t.Errorf("query() = %s; want %s", query, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSystemTablesExcluded(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		// the extension and the schema stand in for an extension like PostGIS or pg_partman
+		_, err := db.Exec(context.Background(), `CREATE TABLE ext_owned (id INT PRIMARY KEY);
+			ALTER EXTENSION plpgsql ADD TABLE ext_owned;
+			CREATE SCHEMA stub_partman;
+			CREATE TABLE stub_partman.part_config (id INT PRIMARY KEY);
+			CREATE TABLE user_table (id INT PRIMARY KEY, ext_id INT REFERENCES ext_owned (id),
+				config_id INT REFERENCES stub_partman.part_config (id));`)
+		if err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+		writer := DbWriter{db: db, SystemSchemas: []string{"stub_partman"}}
+		tables, err := writer.GetTablesOrdered(context.Background())
+		if err != nil {
+			t.Fatalf("GetTablesOrdered() error: %v", err)
+		}
+		if !reflect.DeepEqual(tables, []string{"public.user_table"}) {
+			t.Errorf("GetTablesOrdered() = %v; want only public.user_table", tables)
+		}
+		extensionTables, err := writer.GetExtensionTables()
+		if err != nil {
+			t.Fatalf("GetExtensionTables() error: %v", err)
+		}
+		if !slices.Contains(extensionTables, "public.ext_owned") {
+			t.Errorf("GetExtensionTables() = %v; want public.ext_owned", extensionTables)
+		}
+		results, err := writer.TruncateAllTables(context.Background(), tables)
+		if err != nil || len(results) != 1 {
+			t.Errorf("TruncateAllTables() = %v, %v; want only public.user_table", results, err)
+		}
+	})
+}
+
+func TestLoadTimestampWithTimeZoneAcrossDST(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE ts_table (id INTEGER PRIMARY KEY, ts TIMESTAMPTZ)`)
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		// 2024-03-10 is the DST switch in America/New_York: the offset changes from -05 to -04
+		values := []string{"2024-03-10 01:59:59-05", "2024-03-10 03:00:00-04", "2024-03-10 03:30:00-04"}
+		mapper := newTestMapper("public.ts_table",
+			source.ColumnInfo{ColumnName: "id", OriginalType: "integer"},
+			source.ColumnInfo{ColumnName: "ts", OriginalType: "timestamp with time zone"})
+
+		rows := make([][]any, 0, len(values))
+		for i, value := range values {
+			id, err := mapper.Transform(parquet.ValueOf(int32(i)).Level(0, 1, 0))
+			if err != nil {
+				t.Fatalf("Transform() error: %v", err)
+			}
+			ts, err := mapper.Transform(parquet.ValueOf(value).Level(0, 1, 1))
+			if err != nil {
+				t.Fatalf("Transform() error: %v", err)
+			}
+			rows = append(rows, []any{id, ts})
+		}
+		_, err = db.CopyFrom(context.Background(), pgx.Identifier{"ts_table"}, mapper.getFieldNames(),
+			pgx.CopyFromRows(rows))
+		if err != nil {
+			t.Fatalf("CopyFrom() error: %v", err)
+		}
+
+		for i, value := range values {
+			expected, err := time.Parse("2006-01-02 15:04:05-07", value)
+			if err != nil {
+				t.Fatalf("time.Parse() error: %v", err)
+			}
+			var actual time.Time
+			err = db.QueryRow(context.Background(), "SELECT ts FROM ts_table WHERE id = $1", i).Scan(&actual)
+			if err != nil {
+				t.Fatalf("Query error: %v", err)
+			}
+			if !actual.Equal(expected) {
+				t.Errorf("Loaded timestamp = %v; want %v", actual.UTC(), expected.UTC())
+			}
+		}
+	})
+}
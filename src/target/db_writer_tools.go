@@ -1,30 +1,50 @@
 package target
 
 import (
-	"context"
 	"database/sql"
 	"dbrestore/dag"
 	"dbrestore/utils"
 	"fmt"
 	"github.com/jackc/pgx/v5"
 	"go.uber.org/zap"
+	"strings"
 	"time"
 )
 
 // IndexInfo represents metadata about a table index.
 type IndexInfo struct {
 	// Name is the name of the index.
-	Name string
+	Name string `json:"name"`
 	// Def is the definition or creation statement of the index.
-	Def string
+	Def string `json:"definition"`
+	// Unique indicates a unique index, including the indexes of the primary keys and the unique constraints.
+	Unique bool `json:"unique,omitempty"`
+	// Primary indicates the index of the primary key.
+	Primary bool `json:"primary,omitempty"`
+}
+
+// keptDuringLoad checks whether the index stays in place while the table is loaded: the unique indexes
+// and the primary keys are neither dropped nor recreated, so that they keep enforcing the uniqueness.
+func (i IndexInfo) keptDuringLoad() bool {
+	return i.Unique || i.Primary
 }
 
 // ConstraintInfo represents information about a database constraint, including its name and the command to define it.
 type ConstraintInfo struct {
 	// Name represents the identifier of the table constraint.
-	Name string
+	Name string `json:"name"`
 	// Command represents the SQL definition or statement used to define the table constraint.
-	Command string
+	Command string `json:"definition"`
+	// Type is the type code of the constraint (pg_constraint.contype): "p" for a primary key, "u" for a unique
+	// constraint, "f" for a foreign key, "c" for a check constraint, "x" for an exclusion constraint, etc.
+	Type string `json:"type,omitempty"`
+}
+
+// keptDuringLoad checks whether the constraint stays in place while the table is loaded: the primary keys,
+// the unique and the exclusion constraints are backed by their indexes, which are kept (see IndexInfo.keptDuringLoad);
+// the other constraints are dropped and recreated.
+func (c ConstraintInfo) keptDuringLoad() bool {
+	return c.Type == "p" || c.Type == "u" || c.Type == "x"
 }
 
 // Relation represents a database relationship between two tables, including its details and associated schemas/tables.
@@ -34,19 +54,44 @@ type Relation struct {
 	constraintType string
 	selfSchema     string
 	selfTable      string
-	selfColumns    string
-	foreignSchema  string
-	foreignTable   string
-	foreignColumns string
+	// selfColumns the referencing columns, in the order of the key
+	selfColumns   []string
+	foreignSchema string
+	foreignTable  string
+	// foreignColumns the referenced columns, in the order of the key: foreignColumns[i] is referenced by selfColumns[i]
+	foreignColumns []string
 	definition     string
 }
 
+// String returns the name of the constraint; it labels the edges of the dumped graph (see DbWriter.GraphFile).
+func (r Relation) String() string {
+	return r.constraintName
+}
+
+// validate checks that the foreign key maps every referencing column to a referenced column,
+// which matters for the composite (multi-column) keys.
+func (r Relation) validate() error {
+	if len(r.selfColumns) == 0 {
+		return fmt.Errorf("the foreign key '%s' of the table '%s.%s' has no columns",
+			r.constraintName, r.selfSchema, r.selfTable)
+	}
+	if len(r.selfColumns) != len(r.foreignColumns) {
+		return fmt.Errorf("the foreign key '%s' of the table '%s.%s' has %d columns (%s), but references %d columns (%s)",
+			r.constraintName, r.selfSchema, r.selfTable, len(r.selfColumns), strings.Join(r.selfColumns, ", "),
+			len(r.foreignColumns), strings.Join(r.foreignColumns, ", "))
+	}
+	return nil
+}
+
 // getIndexList retrieves a list of indexes for the specified table from the database.
 // It returns a slice of IndexInfo containing index details or an error in case of failure.
 func (w *DbWriter) getIndexList(tableName string) (ret []IndexInfo, err error) {
-	//const tableName = "entity_type"
+	sanitizedTable, err := utils.SanitizeTableName(tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the indexes: %w", err)
+	}
 	// Query for existing indexes on a specific table
-	rows, err := w.db.Query(context.Background(), findIndexes, tableName)
+	rows, err := w.db.Query(w.dbContext(), findIndexes, sanitizedTable)
 	if err != nil {
 		log.Error("ERROR: ", zap.Error(err))
 		return nil, err
@@ -60,16 +105,18 @@ func (w *DbWriter) getIndexList(tableName string) (ret []IndexInfo, err error) {
 	// Iterate over the rows and construct CREATE INDEX commands
 	for rows.Next() {
 		var indexName, indexDef string
-		err = rows.Scan(&indexName, &indexDef)
+		var unique, primary bool
+		err = rows.Scan(&indexName, &indexDef, &unique, &primary)
 		if err != nil {
 			log.Error("ERROR: ", zap.Error(err))
 			return nil, err
 		}
 
 		indexInfo := IndexInfo{
-			Name: indexName,
-			Def:  indexDef,
-			//Command: fmt.Sprintf("CREATE INDEX %s ON your_table_name %s;", indexName, indexDef),
+			Name:    indexName,
+			Def:     indexDef,
+			Unique:  unique,
+			Primary: primary,
 		}
 		indexInfos = append(indexInfos, indexInfo)
 	}
@@ -85,7 +132,11 @@ func (w *DbWriter) getIndexList(tableName string) (ret []IndexInfo, err error) {
 // getConstraintList retrieves a list of constraints for a specified table from the database.
 // It returns a slice of ConstraintInfo and an error if any operation fails during the query or iteration process.
 func (w *DbWriter) getConstraintList(tableName string) (ret []ConstraintInfo, err error) {
-	rows, err := w.db.Query(context.Background(), findConstrains, tableName)
+	sanitizedTable, err := utils.SanitizeTableName(tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the constraints: %w", err)
+	}
+	rows, err := w.db.Query(w.dbContext(), findConstrains, sanitizedTable)
 	if err != nil {
 		log.Error("ERROR: ", zap.Error(err))
 		return nil, err
@@ -95,8 +146,8 @@ func (w *DbWriter) getConstraintList(tableName string) (ret []ConstraintInfo, er
 	}(rows)
 	var constraints []ConstraintInfo
 	for rows.Next() {
-		var name, definition string
-		err = rows.Scan(&name, &definition)
+		var name, definition, constraintType string
+		err = rows.Scan(&name, &definition, &constraintType)
 		if err != nil {
 			log.Error("ERROR: ", zap.Error(err))
 			return nil, err
@@ -105,6 +156,7 @@ func (w *DbWriter) getConstraintList(tableName string) (ret []ConstraintInfo, er
 		constraints = append(constraints, ConstraintInfo{
 			Name:    name,
 			Command: definition,
+			Type:    constraintType,
 		})
 	}
 	if err := rows.Err(); err != nil {
@@ -115,14 +167,19 @@ func (w *DbWriter) getConstraintList(tableName string) (ret []ConstraintInfo, er
 }
 
 // restoreIndexes recreates database indexes and constraints for a specific table using the provided index and constraint info.
-// It skips unique and primary key constraints based on specific regex patterns and executes appropriate SQL commands in a transaction.
+// It skips the indexes and the constraints kept during the load (see IndexInfo.keptDuringLoad and
+// ConstraintInfo.keptDuringLoad) and executes appropriate SQL commands in a transaction.
 func (w *DbWriter) restoreIndexes(tableName string, indexInfos []IndexInfo, err error, tx pgx.Tx, constraints []ConstraintInfo) error {
+	sanitizedTable, nameErr := utils.SanitizeTableName(tableName)
+	if nameErr != nil {
+		return fmt.Errorf("failed to restore the indexes: %w", nameErr)
+	}
 	for _, indexInfo := range indexInfos {
-		if w.regExIdx.MatchString(indexInfo.Def) {
+		if indexInfo.keptDuringLoad() {
 			log.Debug("Skipping the unique index: ", zap.String("command", indexInfo.Def))
 		} else {
 			log.Info(indexInfo.Def)
-			_, err = tx.Exec(context.Background(), indexInfo.Def)
+			_, err = tx.Exec(w.dbContext(), indexInfo.Def)
 			if err != nil {
 				log.Error("ERROR: ", zap.Error(err))
 				break
@@ -131,13 +188,13 @@ func (w *DbWriter) restoreIndexes(tableName string, indexInfos []IndexInfo, err
 	}
 
 	for _, constraint := range constraints {
-		var createSql = fmt.Sprintf(addConstraint, utils.SanitizeTableName(tableName), utils.SanitizeTableName(constraint.Name),
+		var createSql = fmt.Sprintf(addConstraint, sanitizedTable, pgx.Identifier{constraint.Name}.Sanitize(),
 			constraint.Command)
-		if w.regExPrimary.MatchString(createSql) || w.regExCon.MatchString(constraint.Command) {
-			log.Debug("Skipping the primary key constraint: ", zap.String("command", constraint.Command))
+		if constraint.keptDuringLoad() {
+			log.Debug("Skipping the kept constraint: ", zap.String("command", constraint.Command))
 		} else {
 			log.Info(createSql)
-			_, err = tx.Exec(context.Background(), createSql)
+			_, err = tx.Exec(w.dbContext(), createSql)
 			if err != nil {
 				log.Error("ERROR: ", zap.Error(err))
 				break
@@ -149,13 +206,17 @@ func (w *DbWriter) restoreIndexes(tableName string, indexInfos []IndexInfo, err
 
 // dropIndexes removes constraints and indexes from the specified table using the provided transaction and error handling.
 func (w *DbWriter) dropIndexes(tableName string, constraints []ConstraintInfo, err error, tx pgx.Tx, indexInfos []IndexInfo) error {
+	sanitizedTable, nameErr := utils.SanitizeTableName(tableName)
+	if nameErr != nil {
+		return fmt.Errorf("failed to drop the indexes: %w", nameErr)
+	}
 	for _, constraint := range constraints {
-		var dropSql = fmt.Sprintf(dropConstraint, utils.SanitizeTableName(tableName), utils.SanitizeTableName(constraint.Name))
-		if w.regExPrimary.MatchString(constraint.Command) {
-			log.Debug("Skipping the primary key constraint: ", zap.String("command", constraint.Command))
+		var dropSql = fmt.Sprintf(dropConstraint, sanitizedTable, pgx.Identifier{constraint.Name}.Sanitize())
+		if constraint.keptDuringLoad() {
+			log.Debug("Skipping the kept constraint: ", zap.String("command", constraint.Command))
 		} else {
 			log.Info(dropSql)
-			_, err = tx.Exec(context.Background(), dropSql)
+			_, err = tx.Exec(w.dbContext(), dropSql)
 			if err != nil {
 				log.Error("ERROR: ", zap.Error(err), zap.String("command", constraint.Command))
 				break
@@ -164,12 +225,12 @@ func (w *DbWriter) dropIndexes(tableName string, constraints []ConstraintInfo, e
 	}
 
 	for _, indexInfo := range indexInfos {
-		var dropSql = fmt.Sprintf(dropIndex, utils.SanitizeTableName(indexInfo.Name))
-		if w.regExIdx.MatchString(indexInfo.Def) {
+		var dropSql = fmt.Sprintf(dropIndex, pgx.Identifier{indexInfo.Name}.Sanitize())
+		if indexInfo.keptDuringLoad() {
 			log.Debug("Skipping the unique index: ", zap.String("command", indexInfo.Def))
 		} else {
 			log.Info(dropSql)
-			_, err = tx.Exec(context.Background(), dropSql)
+			_, err = tx.Exec(w.dbContext(), dropSql)
 			if err != nil {
 				log.Error("ERROR: ", zap.Error(err), zap.String("command", indexInfo.Def))
 				break
@@ -179,12 +240,45 @@ func (w *DbWriter) dropIndexes(tableName string, constraints []ConstraintInfo, e
 	return err
 }
 
+// resetSequences moves the sequences owned by the columns of the table (of serial and identity columns alike)
+// to the maximal values loaded into these columns, so that new rows do not collide with the restored ones.
+// It needs only the privileges of the table owner, and does nothing for the sequences of empty columns.
+func (w *DbWriter) resetSequences(tableName string, tx pgx.Tx) error {
+	sanitizedTable, err := utils.SanitizeTableName(tableName)
+	if err != nil {
+		return fmt.Errorf("failed to reset the sequences: %w", err)
+	}
+	rows, err := tx.Query(w.dbContext(), selectOwnedSequences, sanitizedTable)
+	if err != nil {
+		return fmt.Errorf("failed to list the sequences of the table '%s': %w", tableName, err)
+	}
+	sequences, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) ([2]string, error) {
+		var ret [2]string
+		err := row.Scan(&ret[0], &ret[1])
+		return ret, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list the sequences of the table '%s': %w", tableName, err)
+	}
+	for _, sequence := range sequences {
+		sequenceName, column := sequence[0], pgx.Identifier{sequence[1]}.Sanitize()
+		tag, err := tx.Exec(w.dbContext(), fmt.Sprintf(resetSequence, column, sanitizedTable, column),
+			sequenceName)
+		if err != nil {
+			return fmt.Errorf("failed to reset the sequence '%s' of the table '%s': %w", sequenceName, tableName, err)
+		}
+		log.Debug("Reset the sequence", zap.String("table", tableName), zap.String("sequence", sequenceName),
+			zap.String("result", tag.String()))
+	}
+	return nil
+}
+
 // getTables retrieves a list of all table names from the database.
 // It returns a slice of table names and an error, if any occurs during the operation.
 func (w *DbWriter) getTables() (tables []string, err error) {
 	// get all tables
 	startTime := time.Now() // Start measuring time
-	rows, err := w.db.Query(context.Background(), listTables)
+	rows, err := w.db.Query(w.dbContext(), listTables, w.systemSchemas())
 	log.Debug("listTables query executed", zap.Duration("execution_time", time.Since(startTime)))
 	if err != nil {
 		return nil, fmt.Errorf("querying tables failed: %w", err)
@@ -209,6 +303,26 @@ func (w *DbWriter) getTables() (tables []string, err error) {
 	return tables, nil
 }
 
+// systemSchemas returns the parameter of the queries excluding the system schemas and the schema
+// of the restore log (see RestoreLog); it is never nil, because a NULL array would exclude all schemas.
+func (w *DbWriter) systemSchemas() []string {
+	return append([]string{restoreLogSchema}, w.SystemSchemas...)
+}
+
+// GetExtensionTables returns the tables owned by extensions (for example spatial_ref_sys of PostGIS),
+// which are excluded from the restore like the tables of the system schemas.
+func (w *DbWriter) GetExtensionTables() (tables []string, err error) {
+	rows, err := w.db.Query(w.dbContext(), listExtensionTables)
+	if err != nil {
+		return nil, fmt.Errorf("querying the tables of extensions failed: %w", err)
+	}
+	tables, err = pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("querying the tables of extensions failed: %w", err)
+	}
+	return tables, nil
+}
+
 // getFKeys retrieves foreign key constraints for all tables and constructs a directed graph representing these constraints.
 // Returns a graph of foreign key relationships or an error if the operation fails.
 func (w *DbWriter) getFKeys() (*dag.FKeysGraph[Relation], error) {
@@ -217,8 +331,9 @@ func (w *DbWriter) getFKeys() (*dag.FKeysGraph[Relation], error) {
 	if w.db == nil {
 		return nil, fmt.Errorf("database connection is nil")
 	}
-	log.Debug("Querying foreign keys...")                    //, zap.String("query", listFKeys))
-	rows, err := w.db.Query(context.Background(), listFKeys) // Execute the query
+	log.Debug("Querying foreign keys...") //, zap.String("query", listFKeys))
+	// Execute the query
+	rows, err := w.db.Query(w.dbContext(), listFKeys, w.systemSchemas())
 	log.Debug("listFKeys query executed", zap.Duration("execution_time", time.Since(startTime)))
 	if err != nil {
 		return nil, fmt.Errorf("querying foreign keys failed: %w", err)
@@ -232,10 +347,10 @@ func (w *DbWriter) getFKeys() (*dag.FKeysGraph[Relation], error) {
 	for rows.Next() {
 		count += 1
 		var r Relation
-		var foreignSchema, foreignTable, foreignColumns sql.NullString
+		var foreignSchema, foreignTable sql.NullString
 		var constraintType rune
 		err := rows.Scan(&r.constraintName, &constraintType, &r.selfSchema, &r.selfTable, &r.selfColumns,
-			&foreignSchema, &foreignTable, &foreignColumns, &r.definition)
+			&foreignSchema, &foreignTable, &r.foreignColumns, &r.definition)
 		if err != nil {
 			return nil, fmt.Errorf("scanning foreign key rows failed: %w", err)
 		}
@@ -245,22 +360,28 @@ func (w *DbWriter) getFKeys() (*dag.FKeysGraph[Relation], error) {
 		if foreignTable.Valid {
 			r.foreignTable = foreignTable.String
 		}
-		if foreignColumns.Valid {
-			r.foreignColumns = foreignColumns.String
-		}
 		r.constraintType = string(constraintType)
 
-		if r.constraintType != "f" {
-			continue // for now skip all constraints which are not foreign keys
-		}
-
 		parentName := fmt.Sprintf("%s.%s", r.selfSchema, r.selfTable)
-		node := fkMap.GetNode(parentName)
-		if node == nil {
-			node, err = fkMap.AddNode(parentName)
-			if err != nil {
+		if r.constraintType == "p" {
+			// a table with a primary key is a node even without foreign keys, so that its position
+			// is validated with the graph rather than appended after the ordered tables (see orderTables)
+			if _, err = fkMap.GetOrAddNode(parentName); err != nil {
 				return nil, fmt.Errorf("adding node failed: %w", err)
 			}
+			continue
+		}
+		if r.constraintType != "f" {
+			continue // for now skip all other constraints which are not foreign keys
+		}
+		if err := r.validate(); err != nil {
+			// the table order does not depend on the columns, so the relation is still added
+			log.Warn("Malformed foreign key", zap.Error(err), zap.String("definition", r.definition))
+		}
+
+		node, err := fkMap.GetOrAddNode(parentName)
+		if err != nil {
+			return nil, fmt.Errorf("adding node failed: %w", err)
 		}
 
 		childName := fmt.Sprintf("%s.%s", r.foreignSchema, r.foreignTable)
@@ -0,0 +1,98 @@
+package target
+
+import (
+	"context"
+	"dbrestore/source"
+	"dbrestore/utils"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// GenerateDDL generates best-effort DDL statements (CREATE SCHEMA and CREATE TABLE) for all the given tables,
+// using only the export metadata. The export does not describe indexes, constraints, defaults or nullability,
+// so the generated tables are only suitable for bootstrapping a destination database.
+// It fails on a table with an invalid name (see utils.SanitizeTableName).
+func GenerateDDL(tables source.ParquetFileInfoList) ([]string, error) {
+	ret := make([]string, 0, len(tables))
+	schemas := make(map[string]struct{})
+	for _, table := range tables {
+		schema, _ := utils.SplitFullTableName(table.TableName)
+		if _, exists := schemas[schema]; !exists && schema != "" {
+			schemas[schema] = struct{}{}
+			ret = append(ret, fmt.Sprintf(createSchema, pgx.Identifier{schema}.Sanitize()))
+		}
+	}
+	for _, table := range tables {
+		statement, err := GenerateCreateTable(table)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, statement)
+	}
+	return ret, nil
+}
+
+// GenerateCreateTable generates a best-effort CREATE TABLE statement for the table described by the export metadata.
+func GenerateCreateTable(info source.ParquetFileInfo) (string, error) {
+	tableName, err := utils.SanitizeTableName(info.TableName)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate CREATE TABLE: %w", err)
+	}
+	buf := &strings.Builder{}
+	for i, column := range info.Columns {
+		if i != 0 {
+			buf.WriteString(",\n")
+		}
+		buf.WriteString("    ")
+		buf.WriteString(pgx.Identifier{column.ColumnName}.Sanitize())
+		buf.WriteString(" ")
+		buf.WriteString(columnTypeDDL(column))
+	}
+	return fmt.Sprintf(createTable, tableName, buf.String()), nil
+}
+
+// columnTypeDDL converts the original column type from the export metadata into a PostgreSQL column type.
+// Types that cannot be restored from the metadata (arrays without the element type and user-defined types)
+// fall back to text.
+func columnTypeDDL(column source.ColumnInfo) string {
+	switch column.OriginalType {
+	case "character varying", "character":
+		if column.OriginalCharMaxLength > 0 {
+			return fmt.Sprintf("%s(%d)", column.OriginalType, column.OriginalCharMaxLength)
+		}
+		return column.OriginalType
+	case "ARRAY":
+		if column.OriginalElementType != "" {
+			return column.OriginalElementType + "[]"
+		}
+		// the element type is not present in the export metadata
+		return "text[] /* ARRAY */"
+	case "USER-DEFINED":
+		return "text /* USER-DEFINED */"
+	case "":
+		return "text"
+	default:
+		return column.OriginalType
+	}
+}
+
+// CreateTables executes the given DDL statements in the destination database in a single transaction.
+func (w *DbWriter) CreateTables(statements []string) (err error) {
+	tx, err := w.db.Begin(w.dbContext())
+	if err != nil {
+		return
+	}
+	defer closeTransactionInPanic(tx)
+
+	for _, statement := range statements {
+		log.Debug("Executing DDL", zap.String("statement", statement))
+		_, err = tx.Exec(w.dbContext(), statement)
+		if err != nil {
+			_ = tx.Rollback(context.Background())
+			return fmt.Errorf("executing '%s' failed: %w", statement, err)
+		}
+	}
+	return tx.Commit(w.dbContext())
+}
@@ -0,0 +1,111 @@
+package target
+
+import (
+	"dbrestore/source"
+	"strings"
+	"testing"
+)
+
+func TestGenerateCreateTable(t *testing.T) {
+	tests := []struct {
+		name           string
+		info           source.ParquetFileInfo
+		expectedResult string
+	}{
+		{
+			name: "Two-column table",
+			info: source.ParquetFileInfo{
+				TableName: "public.users",
+				Columns: []source.ColumnInfo{
+					{ColumnName: "id", OriginalType: "bigint"},
+					{ColumnName: "name", OriginalType: "character varying", OriginalCharMaxLength: 100},
+				},
+			},
+			expectedResult: "CREATE TABLE IF NOT EXISTS \"public\".\"users\" (\n" +
+				"    \"id\" bigint,\n" +
+				"    \"name\" character varying(100)\n" +
+				");",
+		},
+		{
+			name: "Types without enough metadata fall back to text",
+			info: source.ParquetFileInfo{
+				TableName: "s.t",
+				Columns: []source.ColumnInfo{
+					{ColumnName: "tags", OriginalType: "ARRAY"},
+					{ColumnName: "attrs", OriginalType: "USER-DEFINED"},
+					{ColumnName: "note", OriginalType: "character varying"},
+				},
+			},
+			expectedResult: "CREATE TABLE IF NOT EXISTS \"s\".\"t\" (\n" +
+				"    \"tags\" text[] /* ARRAY */,\n" +
+				"    \"attrs\" text /* USER-DEFINED */,\n" +
+				"    \"note\" character varying\n" +
+				");",
+		},
+		{
+			name: "Arrays with the element type",
+			info: source.ParquetFileInfo{
+				TableName: "s.t",
+				Columns: []source.ColumnInfo{
+					{ColumnName: "nums", OriginalType: "ARRAY", OriginalElementType: "integer"},
+				},
+			},
+			expectedResult: "CREATE TABLE IF NOT EXISTS \"s\".\"t\" (\n" +
+				"    \"nums\" integer[]\n" +
+				");",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := GenerateCreateTable(tt.info)
+			if err != nil {
+				t.Fatalf("GenerateCreateTable() error: %v", err)
+			}
+			if result != tt.expectedResult {
+				t.Errorf("GenerateCreateTable() = %v; want %v", result, tt.expectedResult)
+			}
+		})
+	}
+}
+
+func TestGenerateDDL(t *testing.T) {
+	tables := source.ParquetFileInfoList{
+		{TableName: "public.a", Columns: []source.ColumnInfo{{ColumnName: "id", OriginalType: "integer"}}},
+		{TableName: "public.b", Columns: []source.ColumnInfo{{ColumnName: "id", OriginalType: "integer"}}},
+		{TableName: "other.c", Columns: []source.ColumnInfo{{ColumnName: "id", OriginalType: "integer"}}},
+	}
+	result, err := GenerateDDL(tables)
+	if err != nil {
+		t.Fatalf("GenerateDDL() error: %v", err)
+	}
+	expected := []string{
+		`CREATE SCHEMA IF NOT EXISTS "public";`,
+		`CREATE SCHEMA IF NOT EXISTS "other";`,
+	}
+	for _, table := range tables {
+		statement, err := GenerateCreateTable(table)
+		if err != nil {
+			t.Fatalf("GenerateCreateTable() error: %v", err)
+		}
+		expected = append(expected, statement)
+	}
+	if len(result) != len(expected) {
+		t.Fatalf("GenerateDDL() returned %d statements; want %d: %v", len(result), len(expected), result)
+	}
+	for i := range expected {
+		if result[i] != expected[i] {
+			t.Errorf("GenerateDDL()[%d] = %v; want %v", i, result[i], expected[i])
+		}
+	}
+}
+
+func TestGenerateDDLInvalidTableName(t *testing.T) {
+	tables := source.ParquetFileInfoList{
+		{TableName: "db.public.a", Columns: []source.ColumnInfo{{ColumnName: "id", OriginalType: "integer"}}},
+	}
+	_, err := GenerateDDL(tables)
+	if err == nil || !strings.Contains(err.Error(), "'db.public.a'") {
+		t.Errorf("GenerateDDL() error = %v; want an error naming the table", err)
+	}
+}
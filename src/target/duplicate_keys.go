@@ -0,0 +1,97 @@
+package target
+
+import (
+	"dbrestore/source"
+	"dbrestore/utils"
+	"fmt"
+	"go.uber.org/zap"
+	"slices"
+	"strings"
+)
+
+// duplicateKeyChecker wraps a source of rows for COPY and fails on the first row whose primary key was already
+// loaded into the table from the same or another Parquet file (see config.Config.CheckDuplicateKeys).
+// Indexes are dropped while loading, so duplicates in a bad export would otherwise fail only when the indexes
+// are restored at the end of the table.
+type duplicateKeyChecker struct {
+	rowSource
+
+	// mapper the field mapper of the table, which keeps the primary key columns and the keys seen so far
+	mapper *FieldMapper
+}
+
+// Values returns the values of the current row, or an error if its primary key is a duplicate.
+// It implements the interface pgx.CopyFromSource
+func (c *duplicateKeyChecker) Values() ([]any, error) {
+	values, err := c.rowSource.Values()
+	if err != nil {
+		return values, err
+	}
+	parts := make([]string, len(c.mapper.primaryKeyColumns))
+	for i, index := range c.mapper.primaryKeyColumns {
+		parts[i] = fmt.Sprint(values[index])
+	}
+	key := strings.Join(parts, "\x00")
+	c.mapper.seenKeysMutex.Lock()
+	defer c.mapper.seenKeysMutex.Unlock()
+	if _, exists := c.mapper.seenKeys[key]; exists {
+		return nil, fmt.Errorf("duplicate primary key (%s) = (%s) in the export of the table '%s'",
+			strings.Join(c.mapper.primaryKeyNames(), ", "), strings.Join(parts, ", "), c.mapper.Info.TableName)
+	}
+	c.mapper.seenKeys[key] = struct{}{}
+	return values, nil
+}
+
+// readPrimaryKey reads the names of the primary key columns of the destination table; the list is empty
+// if the table has no primary key.
+func (w *DbWriter) readPrimaryKey(table string) ([]string, error) {
+	tableName, err := utils.SanitizeTableName(table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the primary key: %w", err)
+	}
+	rows, err := w.db.Query(w.dbContext(), selectPrimaryKeyColumns, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// setPrimaryKey prepares the mapper for checking duplicate keys with the primary key columns of the destination
+// table; the check is disabled if the table has no primary key or the export misses some of its columns.
+func (m *FieldMapper) setPrimaryKey(names []string) {
+	indexes := make([]int, 0, len(names))
+	for _, name := range names {
+		index := slices.IndexFunc(m.Info.Columns, func(column source.ColumnInfo) bool {
+			return column.ColumnName == name
+		})
+		if index < 0 {
+			log.Warn("The export misses a primary key column, not checking duplicate keys",
+				zap.String("table", m.Info.TableName), zap.String("column", name))
+			return
+		}
+		indexes = append(indexes, index)
+	}
+	if len(indexes) == 0 {
+		return
+	}
+	m.primaryKeyColumns = indexes
+	m.seenKeys = make(map[string]struct{})
+}
+
+// primaryKeyNames returns the names of the primary key columns.
+func (m *FieldMapper) primaryKeyNames() []string {
+	ret := make([]string, 0, len(m.primaryKeyColumns))
+	for _, index := range m.primaryKeyColumns {
+		ret = append(ret, m.Info.Columns[index].ColumnName)
+	}
+	return ret
+}
@@ -0,0 +1,135 @@
+package target
+
+import (
+	"context"
+	"dbrestore/source"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestDuplicateKeyCheckerAcrossParts(t *testing.T) {
+	root := writeTableFixture(t, "t", map[string][]conflictRow{
+		"1/part-00000.parquet": {{ID: 1, Name: "a"}, {ID: 2, Name: "b"}},
+		"1/part-00001.parquet": {{ID: 3, Name: "c"}, {ID: 2, Name: "duplicate"}},
+	})
+
+	mapper := newTestMapper("public.t",
+		source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"},
+		source.ColumnInfo{ColumnName: "name", OriginalType: "text", ExpectedExportedType: "binary (UTF8)"})
+	mapper.primaryKeyColumns = []int{0}
+	mapper.seenKeys = make(map[string]struct{})
+	src := source.NewLocalSource(root)
+
+	var lastError error
+	rowCount := 0
+	for _, name := range []string{"part-00000.parquet", "part-00001.parquet"} {
+		file := src.GetFile(filepath.Join("db", "public.t", "1", name))
+		reader := source.NewParquetReader(context.Background(), file, &mapper)
+		checker := &duplicateKeyChecker{rowSource: reader, mapper: &mapper}
+		for checker.Next() {
+			if _, lastError = checker.Values(); lastError != nil {
+				break
+			}
+			rowCount++
+		}
+		reader.Cancel()
+		if lastError != nil {
+			break
+		}
+	}
+	if lastError == nil || !strings.Contains(lastError.Error(), "(id) = (2)") {
+		t.Errorf("Values() error = %v; want the duplicate key (id) = (2)", lastError)
+	}
+	if rowCount != 3 {
+		t.Errorf("Rows before the duplicate = %d; want 3", rowCount)
+	}
+}
+
+func TestSetPrimaryKey(t *testing.T) {
+	mapper := newTestMapper("public.t",
+		source.ColumnInfo{ColumnName: "id", OriginalType: "bigint"},
+		source.ColumnInfo{ColumnName: "tenant", OriginalType: "bigint"},
+		source.ColumnInfo{ColumnName: "name", OriginalType: "text"})
+	mapper.setPrimaryKey([]string{"tenant", "id"})
+	if !reflect.DeepEqual(mapper.primaryKeyColumns, []int{1, 0}) || mapper.seenKeys == nil {
+		t.Errorf("setPrimaryKey() = %v; want the columns [1 0]", mapper.primaryKeyColumns)
+	}
+
+	// the check is disabled without a primary key, or when the export misses one of its columns
+	for _, names := range [][]string{nil, {"id", "missing"}} {
+		mapper := newTestMapper("public.t", source.ColumnInfo{ColumnName: "id", OriginalType: "bigint"})
+		mapper.setPrimaryKey(names)
+		if len(mapper.primaryKeyColumns) > 0 {
+			t.Errorf("setPrimaryKey(%v) = %v; want no check", names, mapper.primaryKeyColumns)
+		}
+	}
+}
+
+// terminatingSource terminates the connections of the writer when the file of the given subfolder is requested
+// for the copy, simulating a connection lost in the middle of loading a table; it happens once.
+type terminatingSource struct {
+	source.Source
+	db          *pgx.Conn
+	terminateOn string
+	requests    int
+}
+
+func (s *terminatingSource) GetFile(relativePath string) source.FileInfo {
+	if filepath.Base(filepath.Dir(relativePath)) == s.terminateOn {
+		s.requests++
+		// the first request reads the size of the part before the transaction, the second one copies it
+		if s.requests == 2 {
+			_, _ = s.db.Exec(context.Background(), `SELECT pg_terminate_backend(pid) FROM pg_stat_activity
+				WHERE datname = current_database() AND pid <> pg_backend_pid()`)
+		}
+	}
+	return s.Source.GetFile(relativePath)
+}
+
+func TestWriteTableRetryWithDuplicateKeyCheck(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		defer func(delay time.Duration) { transientRetryDelay = delay }(transientRetryDelay)
+		transientRetryDelay = time.Millisecond
+		_, err := db.Exec(context.Background(), "CREATE TABLE retried_table (id BIGINT PRIMARY KEY);")
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		root := writeTableFixture(t, "retried_table", map[string][]partRow{
+			"1/part-00000.parquet": {{ID: 1}, {ID: 2}, {ID: 3}},
+			"2/part-00000.parquet": {{ID: 4}, {ID: 5}, {ID: 6}},
+		})
+
+		writer := NewDatabaseWriterWithURL(connectionString)
+		writer.MaxAttempts = 2
+		if err := writer.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error: %v", err)
+		}
+		defer writer.Close()
+		// the keys of the first part are seen by the failed attempt, and the retry copies them again
+		src := &terminatingSource{Source: source.NewLocalSource(root), db: db, terminateOn: "2"}
+		mapper := newTestMapper("public.retried_table",
+			source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"})
+		mapper.Config.SourceDatabase = "db"
+		mapper.Config.CheckDuplicateKeys = true
+		mapper.setPrimaryKey([]string{"id"})
+		if _, err := writer.WriteTable(context.Background(), src, &mapper); err != nil {
+			t.Fatalf("WriteTable() error: %v", err)
+		}
+		if src.requests < 3 {
+			t.Fatalf("The second part was requested %d times; want a retry of the table", src.requests)
+		}
+
+		var count int
+		if err := db.QueryRow(context.Background(), "SELECT COUNT(*) FROM retried_table").Scan(&count); err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if count != 6 {
+			t.Errorf("%d rows were loaded; want 6", count)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package target
+
+import (
+	"context"
+	"dbrestore/source"
+	"dbrestore/utils"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// estimateMargin widens the range of the restore estimate beyond the spread of the sampled speeds,
+// because the samples are loaded without the indexes, constraints and triggers of the destination tables
+const estimateMargin = 0.25
+
+// TableSample is the result of loading a sample of a table into a temporary table (see DbWriter.LoadSample).
+type TableSample struct {
+	// Table the name of the sampled table including the schema name
+	Table string
+	// Rows the number of rows loaded
+	Rows int64
+	// Bytes the share of the Parquet part file corresponding to the loaded rows
+	Bytes int64
+	// Duration the time of loading the rows
+	Duration time.Duration
+}
+
+// RowsPerSecond returns the loading speed of the sample in rows.
+func (s TableSample) RowsPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Rows) / s.Duration.Seconds()
+}
+
+// BytesPerSecond returns the loading speed of the sample in bytes of Parquet files.
+func (s TableSample) BytesPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Duration.Seconds()
+}
+
+// RestoreEstimate is the extrapolated duration of the full restore (see EstimateRestore).
+type RestoreEstimate struct {
+	// Samples the loaded samples
+	Samples []TableSample
+	// TotalBytes the size of the Parquet files of all tables to restore
+	TotalBytes int64
+	// TotalRows the number of rows of all tables to restore
+	TotalRows int64
+	// Duration the expected duration of the restore, at the average speed of the samples
+	Duration time.Duration
+	// MinDuration the lower bound of the duration, at the speed of the fastest sample
+	MinDuration time.Duration
+	// MaxDuration the upper bound of the duration, at the speed of the slowest sample
+	MaxDuration time.Duration
+}
+
+// EstimateRestore extrapolates the duration of loading totalBytes of Parquet files from the speeds of the samples.
+// The expected duration uses the average speed of all samples, and the range between the fastest and the slowest
+// sample is widened by estimateMargin.
+func EstimateRestore(samples []TableSample, totalBytes int64, totalRows int64) (ret RestoreEstimate, err error) {
+	ret = RestoreEstimate{Samples: samples, TotalBytes: totalBytes, TotalRows: totalRows}
+	var bytes int64
+	var duration time.Duration
+	minSpeed, maxSpeed := 0.0, 0.0
+	for _, sample := range samples {
+		speed := sample.BytesPerSecond()
+		if speed <= 0 {
+			continue
+		}
+		bytes += sample.Bytes
+		duration += sample.Duration
+		if minSpeed == 0 || speed < minSpeed {
+			minSpeed = speed
+		}
+		if speed > maxSpeed {
+			maxSpeed = speed
+		}
+	}
+	if bytes == 0 {
+		return ret, fmt.Errorf("no rows were loaded from the samples, the speed cannot be measured")
+	}
+	seconds := func(speed float64) time.Duration {
+		return time.Duration(float64(totalBytes) / speed * float64(time.Second)).Round(time.Second)
+	}
+	ret.Duration = seconds(float64(bytes) / duration.Seconds())
+	ret.MinDuration = seconds(maxSpeed * (1 + estimateMargin))
+	ret.MaxDuration = seconds(minSpeed * (1 - estimateMargin))
+	return ret, nil
+}
+
+// String formats the estimate as a human-readable summary.
+func (e RestoreEstimate) String() string {
+	buf := &strings.Builder{}
+	for _, sample := range e.Samples {
+		_, _ = fmt.Fprintf(buf, "%s: %d rows, %s in %s, %.0f rows/sec, %s/sec\n", sample.Table, sample.Rows,
+			utils.FormatByteSize(uint64(sample.Bytes)), sample.Duration.Round(time.Millisecond),
+			sample.RowsPerSecond(), utils.FormatByteSize(uint64(sample.BytesPerSecond())))
+	}
+	_, _ = fmt.Fprintf(buf, "Estimated restore of %d rows (%s): %s (between %s and %s)\n", e.TotalRows,
+		utils.FormatByteSize(uint64(e.TotalBytes)), e.Duration, e.MinDuration, e.MaxDuration)
+	return buf.String()
+}
+
+// sampleLimiter wraps a source of rows for COPY and stops after the given number of rows.
+type sampleLimiter struct {
+	rowSource
+
+	// maxRows the maximal number of rows returned; 0 means no limit
+	maxRows int64
+	// rows the number of rows returned so far
+	rows int64
+}
+
+// Next advances to the next row unless the limit is reached.
+// It implements the interface pgx.CopyFromSource
+func (l *sampleLimiter) Next() bool {
+	if l.maxRows > 0 && l.rows >= l.maxRows {
+		return false
+	}
+	if !l.rowSource.Next() {
+		return false
+	}
+	l.rows++
+	return true
+}
+
+// LoadSample copies up to maxRows rows (0 means all rows) of the Parquet part file into a temporary table created
+// LIKE the destination table, and measures the speed. The destination table is not modified, and the temporary
+// table is dropped afterward (and by PostgreSQL at the end of the session, if dropping fails).
+func (w *DbWriter) LoadSample(src source.Source, mapper *FieldMapper, part TablePart,
+	maxRows int64) (ret TableSample, err error) {
+	ret.Table = mapper.Info.TableName
+	if strings.Contains(part.RelativePath, "..") {
+		return ret, fmt.Errorf("invalid relative path containing path traversal sequences: %s", part.RelativePath)
+	}
+	tableName, err := utils.SanitizeTableName(mapper.Info.TableName)
+	if err != nil {
+		return ret, fmt.Errorf("failed to load the sample: %w", err)
+	}
+	// the temporary table exists only in the session that created it
+	release, err := w.acquire()
+	if err != nil {
+		return ret, err
+	}
+	defer release()
+	tempTable := pgx.Identifier{estimateTempTable}.Sanitize()
+	_, err = w.db.Exec(w.dbContext(), fmt.Sprintf(dropTempTable, tempTable))
+	if err != nil {
+		return ret, fmt.Errorf("failed to drop the temporary table: %w", err)
+	}
+	_, err = w.db.Exec(w.dbContext(), fmt.Sprintf(createTempTableLike, tempTable, tableName))
+	if err != nil {
+		return ret, fmt.Errorf("failed to create the temporary table for '%s': %w", mapper.Info.TableName, err)
+	}
+	defer func() {
+		if _, dropErr := w.db.Exec(context.Background(), fmt.Sprintf(dropTempTable, tempTable)); dropErr != nil {
+			log.Warn("Failed to drop the temporary table", zap.String("table", estimateTempTable),
+				zap.Error(dropErr))
+		}
+	}()
+
+	file := src.GetFile(filepath.Clean(part.RelativePath))
+	if !file.IsValid() {
+		return ret, fmt.Errorf("failed to get the file '%s'", part.RelativePath)
+	}
+	defer src.Dispose(file)
+	reader := source.NewParquetReader(w.dbContext(), file, mapper)
+	if mapper.Config.ParquetBatchSize > 0 {
+		reader.BatchSize = mapper.Config.ParquetBatchSize
+	}
+	defer reader.Cancel() // releases the reader when the limit stops COPY early
+
+	start := time.Now()
+	ret.Rows, err = w.copyFrom(estimateTempTable, mapper, &sampleLimiter{rowSource: reader, maxRows: maxRows})
+	ret.Duration = time.Since(start)
+	if err != nil && err != io.EOF {
+		return ret, fmt.Errorf("loading the sample of the table '%s' failed: %w", mapper.Info.TableName, err)
+	}
+	if part.Rows > 0 {
+		ret.Bytes = part.Size * ret.Rows / part.Rows
+	}
+	log.Info("Loaded table sample", zap.String("table", ret.Table), zap.Int64("rows", ret.Rows),
+		zap.Duration("time", ret.Duration), zap.Float64("records/sec", ret.RowsPerSecond()))
+	return ret, nil
+}
@@ -0,0 +1,78 @@
+package target
+
+import (
+	"context"
+	"dbrestore/source"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestEstimateRestore(t *testing.T) {
+	samples := []TableSample{
+		{Table: "public.fast", Rows: 1000, Bytes: 4000, Duration: time.Second},
+		{Table: "public.slow", Rows: 1000, Bytes: 1000, Duration: time.Second},
+	}
+	estimate, err := EstimateRestore(samples, 25000, 10000)
+	if err != nil {
+		t.Fatalf("EstimateRestore() error: %v", err)
+	}
+	// the average speed is 2500 bytes per second, the fastest 4000 and the slowest 1000
+	if estimate.Duration != 10*time.Second {
+		t.Errorf("Duration = %s; want 10s", estimate.Duration)
+	}
+	if estimate.MinDuration != 5*time.Second || estimate.MaxDuration != 33*time.Second {
+		t.Errorf("MinDuration, MaxDuration = %s, %s; want 5s, 33s", estimate.MinDuration, estimate.MaxDuration)
+	}
+	if estimate.String() == "" {
+		t.Errorf("String() returned an empty summary")
+	}
+
+	if _, err := EstimateRestore([]TableSample{{Table: "public.empty"}}, 25000, 10000); err == nil {
+		t.Errorf("EstimateRestore() without loaded rows did not fail")
+	}
+}
+
+func TestLoadSample(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), "CREATE TABLE sampled_table (id BIGINT PRIMARY KEY);")
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		rows := make([]partRow, 50)
+		for i := range rows {
+			rows[i].ID = int64(i)
+		}
+		root := writeTableFixture(t, "sampled_table", map[string][]partRow{"1/part-00000.parquet": rows})
+		src := source.NewLocalSource(root)
+		parts, err := ListTableParts(src, "db", "public.sampled_table")
+		if err != nil || len(parts) != 1 {
+			t.Fatalf("ListTableParts() = %v, %v; want a single part", parts, err)
+		}
+
+		mapper := newTestMapper("public.sampled_table",
+			source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"})
+		writer := DbWriter{db: db}
+		sample, err := writer.LoadSample(src, &mapper, parts[0], 20)
+		if err != nil {
+			t.Fatalf("LoadSample() error: %v", err)
+		}
+		if sample.Rows != 20 || sample.Bytes <= 0 || sample.Bytes >= parts[0].Size {
+			t.Errorf("LoadSample() = %+v; want 20 rows and a share of %d bytes", sample, parts[0].Size)
+		}
+		if _, err := EstimateRestore([]TableSample{sample}, parts[0].Size, parts[0].Rows); err != nil {
+			t.Errorf("EstimateRestore() error: %v", err)
+		}
+
+		var tableRows, tempTables int
+		err = db.QueryRow(context.Background(), `SELECT (SELECT COUNT(*) FROM sampled_table),
+			(SELECT COUNT(*) FROM pg_class WHERE relname = $1)`, estimateTempTable).Scan(&tableRows, &tempTables)
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if tableRows != 0 || tempTables != 0 {
+			t.Errorf("After LoadSample(): %d rows in the table, %d temporary tables; want none", tableRows, tempTables)
+		}
+	})
+}
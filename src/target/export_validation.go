@@ -0,0 +1,76 @@
+package target
+
+import (
+	"dbrestore/source"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// TableValidation is the result of checking the export of a table without a database (see ValidateTableExport).
+type TableValidation struct {
+	// Table the name of the table including the schema name
+	Table string
+	// Parts the number of Parquet part files
+	Parts int
+	// Rows the number of rows in the readable part files
+	Rows int64
+	// Size the size of the part files in bytes
+	Size int64
+	// Problems the descriptions of everything broken in the export of the table
+	Problems []string
+}
+
+// ValidateTableExport checks the Parquet files of the table the same way as loading would, but read-only:
+// every subfolder must contain the success marker (unless it is optional, see SetSuccessMarkers), and the footer
+// of every part file must parse and describe the columns of the export metadata in the same order
+// (see schemaColumnMismatches). Unlike ListTableParts, it does not stop at the first broken file, so that all
+// problems of the table are reported.
+func ValidateTableExport(src source.Source, sourceDatabase string, table source.ParquetFileInfo) (ret TableValidation) {
+	ret.Table = table.TableName
+	_, groupedFiles, err := groupTableFiles(src, sourceDatabase, table.TableName)
+	if err != nil {
+		ret.Problems = append(ret.Problems, err.Error())
+		return ret
+	}
+	if len(groupedFiles) == 0 {
+		ret.Problems = append(ret.Problems, "the folder of the table is missing in the export")
+		return ret
+	}
+	for _, subfolder := range sortedSubfolders(groupedFiles) {
+		files := groupedFiles[subfolder]
+		if checkSuccessMarker(subfolder, files) != nil {
+			ret.Problems = append(ret.Problems, fmt.Sprintf("the success marker is missing in %s", subfolder))
+		}
+		for _, file := range files {
+			if !strings.HasSuffix(file, ".parquet") {
+				continue
+			}
+			ret.Parts++
+			if problem := ret.validatePart(src, file, table.Columns); problem != "" {
+				ret.Problems = append(ret.Problems, fmt.Sprintf("%s: %s", filepath.Base(file), problem))
+			}
+		}
+	}
+	return ret
+}
+
+// validatePart reads the footer of a part file and adds its rows and size to the validation;
+// it returns the description of the problem of the file, or an empty string.
+func (v *TableValidation) validatePart(src source.Source, file string, columns []source.ColumnInfo) string {
+	info := src.GetFile(filepath.Clean(file))
+	if !info.IsValid() {
+		return "failed to get the file"
+	}
+	defer src.Dispose(info)
+	footer, err := source.ReadParquetFooter(info)
+	if err != nil {
+		return err.Error()
+	}
+	v.Rows += footer.Rows
+	v.Size += info.Size
+	if mismatches := schemaColumnMismatches(footer.Columns, columns); len(mismatches) > 0 {
+		return "the columns do not match the export metadata: " + strings.Join(mismatches, "; ")
+	}
+	return ""
+}
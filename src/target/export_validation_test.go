@@ -0,0 +1,67 @@
+package target
+
+import (
+	"dbrestore/source"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestValidateTableExport(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "snap")
+	tableDir := filepath.Join(root, "db", "public.t")
+	for _, subfolder := range []string{"1", "2"} {
+		if err := os.MkdirAll(filepath.Join(tableDir, subfolder), 0755); err != nil {
+			t.Fatalf("Failed to create the fixture: %v", err)
+		}
+	}
+	if err := parquet.WriteFile(filepath.Join(tableDir, "1", "part-00000.parquet"), make([]partRow, 3)); err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	if err := parquet.WriteFile(filepath.Join(tableDir, "2", "part-00000.parquet"), make([]partRow, 4)); err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tableDir, "1", "_SUCCESS"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create the fixture: %v", err)
+	}
+	src := source.NewLocalSource(root)
+	id := source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"}
+
+	tests := []struct {
+		name             string
+		table            source.ParquetFileInfo
+		expectedRows     int64
+		expectedProblems []string
+	}{
+		{name: "Missing success marker", table: source.ParquetFileInfo{TableName: "public.t",
+			Columns: []source.ColumnInfo{id}}, expectedRows: 7,
+			expectedProblems: []string{"the success marker is missing in " + filepath.Join("db", "public.t", "2")}},
+		{name: "Columns do not match", table: source.ParquetFileInfo{TableName: "public.t",
+			Columns: []source.ColumnInfo{{ColumnName: "key"}}}, expectedRows: 7,
+			expectedProblems: []string{"part-00000.parquet: the columns do not match the export metadata: " +
+				"column 1 is 'id' in the file and 'key' in the metadata", "the success marker is missing"}},
+		{name: "Missing table folder", table: source.ParquetFileInfo{TableName: "public.other"},
+			expectedProblems: []string{"failed to list files"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ValidateTableExport(src, "db", tt.table)
+			if result.Rows != tt.expectedRows {
+				t.Errorf("ValidateTableExport() rows = %d; want %d", result.Rows, tt.expectedRows)
+			}
+			for _, expected := range tt.expectedProblems {
+				found := false
+				for _, problem := range result.Problems {
+					found = found || strings.Contains(problem, expected)
+				}
+				if !found {
+					t.Errorf("ValidateTableExport() problems = %v; want %q", result.Problems, expected)
+				}
+			}
+		})
+	}
+}
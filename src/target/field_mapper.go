@@ -4,8 +4,13 @@ import (
 	"dbrestore/config"
 	"dbrestore/source"
 	"dbrestore/utils"
+	"fmt"
 	"github.com/parquet-go/parquet-go"
 	"go.uber.org/zap"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 // log a convenience wrapper to shorten code lines
@@ -14,6 +19,7 @@ var log = &utils.Logger
 const ReasonNotEmpty = "Table is not empty"
 const ReasonSkippedByConfig1 = "Table is not listed in --include-tables configuration"
 const ReasonSkippedByConfig2 = "Table is listed in --exclude-tables configuration"
+const ReasonUnknownType = "Table has columns of unknown types (see --unknown-type-fallback)"
 
 // FieldMapper handles mapping between Parquet file data types and PostgreSQL data types.
 type FieldMapper struct {
@@ -21,26 +27,74 @@ type FieldMapper struct {
 	// Info contains metadata about the Parquet file, such as table name, file path, and column definitions.
 	Info source.ParquetFileInfo
 
-	// Writer is responsible for persisting mapped data to the target table.
-	Writer *DbWriter
-
 	// Config is a reference to the application configuration, influencing behavior such as table inclusion and exclusion.
 	Config *config.Config
+
+	// targetCharMaxLengths the maximal lengths of the limited character columns in the destination table.
+	targetCharMaxLengths map[string]int
+
+	// identityAlwaysColumns the loaded columns that are GENERATED ALWAYS AS IDENTITY in the destination table;
+	// COPY cannot write them, so such tables are loaded through a staging table (see DbWriter.copyStaged).
+	identityAlwaysColumns []string
+
+	// primaryKeyColumns the indexes of the columns of the primary key in Info.Columns, when the rows are checked
+	// for duplicate keys (see config.Config.CheckDuplicateKeys)
+	primaryKeyColumns []int
+
+	// seenKeys the primary key values of the rows loaded so far into the table (see duplicateKeyChecker)
+	seenKeys map[string]struct{}
+
+	// seenKeysMutex guards seenKeys - the Parquet files of a table can be copied concurrently
+	// (see config.Config.PartsJobs)
+	seenKeysMutex sync.Mutex
+
+	// warnedColumns the columns for which a type mismatch warning was already reported (once per table/column).
+	warnedColumns map[string]struct{}
+
+	// warnedUnknownTypes the unknown column types for which a warning was already reported (once per table/type).
+	warnedUnknownTypes map[string]struct{}
+
+	// warnedMutex guards warnedColumns and warnedUnknownTypes - schemas of several Parquet files of a table can be
+	// validated, and their values transformed, concurrently (see config.Config.ParquetReaders)
+	warnedMutex sync.Mutex
+
+	// targetNotEmpty whether the destination table has rows; it is known only with config.Config.SkipNotEmpty
+	// for the tables not skipped by the configuration (see DbWriter.GetFieldMapper)
+	targetNotEmpty bool
 }
 
-// ShouldSkip checks whether the current table should be skipped based on inclusion, exclusion, or non-empty constraints.
+// ShouldSkip checks whether the current table should be skipped based on inclusion, exclusion, unknown column types,
+// or, with config.Config.SkipNotEmpty, the rows of the destination table found by DbWriter.GetFieldMapper.
+// It does not query the database.
 func (m *FieldMapper) ShouldSkip() (reason string, skip bool) {
-	found, notEmpty := m.Config.TableNameInSet(m.Config.IncludeTables, m.Info.TableName)
+	if reason, skip := m.configSkipReason(); skip {
+		return reason, true
+	}
+	if m.Config.SkipNotEmpty && m.targetNotEmpty {
+		return ReasonNotEmpty, true
+	}
+	return "", false
+}
+
+// configSkipReason checks whether the table is skipped by the configuration or by its columns,
+// regardless of the destination table.
+func (m *FieldMapper) configSkipReason() (reason string, skip bool) {
+	found, notEmpty := m.Config.TableNameInSet(m.Config.IncludeTables, m.Info.TableName,
+		m.Config.IncludeTablesRegex)
 	if !found && notEmpty {
 		return ReasonSkippedByConfig1, true
 	}
-	found, notEmpty = m.Config.TableNameInSet(m.Config.ExcludeTables, m.Info.TableName)
+	found, notEmpty = m.Config.TableNameInSet(m.Config.ExcludeTables, m.Info.TableName,
+		m.Config.ExcludeTablesRegex)
 	if found && notEmpty {
 		return ReasonSkippedByConfig2, true
 	}
-	size := m.Writer.getTableSize(m.Info.TableName)
-	if size > 0 {
-		return ReasonNotEmpty, m.Config.SkipNotEmpty
+	if m.Config.UnknownTypeFallback == config.UnknownTypeSkipTable && !m.rawStrings() {
+		for _, column := range m.Info.Columns {
+			if !m.isKnownType(column) {
+				return ReasonUnknownType, true
+			}
+		}
 	}
 	return "", false
 }
@@ -54,8 +108,25 @@ func (m *FieldMapper) getFieldNames() []string {
 	return names
 }
 
-// Transform implements the interface source.Transformer
+// Transform implements the interface source.Transformer for the values of the files without DECIMAL columns;
+// ParquetReader transforms the values of every file with the transformer returned by ValidateSchema.
 func (m *FieldMapper) Transform(x parquet.Value) (value any, err error) {
+	return (&partMapper{FieldMapper: m}).Transform(x)
+}
+
+// partMapper transforms the values of a single Parquet file (part) of a table with the FieldMapper of the table,
+// keeping what ValidateSchema learned from the schema of the file; the files of a table can be read concurrently,
+// each with its own partMapper.
+type partMapper struct {
+	*FieldMapper
+
+	// decimalScales the scales of the DECIMAL columns of the file by the column index, which encode the values
+	// as unscaled integers (see numericValue)
+	decimalScales map[int]int
+}
+
+// Transform implements the interface source.Transformer
+func (m *partMapper) Transform(x parquet.Value) (value any, err error) {
 	columnIndex := x.Column()
 	column := m.Info.Columns[columnIndex]
 	stringValue := x.String()
@@ -65,66 +136,414 @@ func (m *FieldMapper) Transform(x parquet.Value) (value any, err error) {
 	if x.IsNull() {
 		return nil, nil
 	}
-	if column.OriginalType == "boolean" {
-		return x.Boolean(), nil
-	}
-	if column.OriginalType == "bigint" {
-		return x.Int64(), nil
-	}
-	if column.OriginalType == "integer" {
-		return x.Int32(), nil
-	}
-	if column.OriginalType == "smallint" {
-		// there is no way to return Int16, but we assume it should not be out of bounds
-		return x.Int32(), nil
-	}
-	if column.OriginalType == "double precision" {
-		return x.Double(), nil
-	}
-	if column.OriginalType == "real" {
-		return x.Float(), nil
-	}
-	if column.OriginalType == "numeric" {
+	if m.rawStrings() {
 		return stringValue, nil
 	}
-	if column.OriginalType == "character varying" {
-		return stringValue, nil
+	if handler, exists := m.Config.TypeOverrides[column.OriginalType]; exists {
+		return handlerValue(handler, x, column)
 	}
-	if column.OriginalType == "text" {
-		return stringValue, nil
+	// the conversions rely on the actual Parquet type of the value rather than on ExpectedExportedType,
+	// because the export metadata does not always match the Parquet files (see ValidateSchema)
+	if convert, exists := typeConversion(column); exists {
+		return convert(m, x, column)
 	}
-	if column.OriginalType == "timestamp without time zone" {
-		return stringValue, nil
+	if m.Config.UnknownTypeFallback == config.UnknownTypePanic {
+		log.Warn("transform", zap.Any("value", x), zap.String("string", stringValue),
+			zap.Any("type", x.Kind()), zap.Int("columnIndex", columnIndex),
+			zap.String("column", column.ColumnName), zap.String("originalType", column.OriginalType))
+		panic("unexpected column type: " + column.OriginalType)
 	}
-	if column.OriginalType == "date" {
-		return stringValue, nil
+	if m.firstUnknownTypeWarning(column.OriginalType) {
+		log.Warn("Unknown column type, loading its string representation",
+			zap.String("originalType", column.OriginalType),
+			zap.String("expectedExportedType", column.ExpectedExportedType),
+			zap.String("table", m.Info.TableName), zap.String("column", column.ColumnName))
 	}
-	if column.OriginalType == "jsonb" {
-		return stringValue, nil
+	return stringValue, nil
+}
+
+// firstUnknownTypeWarning records that the warning about the unknown column type was reported for the table;
+// it returns true only the first time, so that the warning is reported once per table and type.
+func (m *FieldMapper) firstUnknownTypeWarning(originalType string) bool {
+	m.warnedMutex.Lock()
+	defer m.warnedMutex.Unlock()
+	if m.warnedUnknownTypes == nil {
+		m.warnedUnknownTypes = make(map[string]struct{})
 	}
-	if column.OriginalType == "ARRAY" {
-		return stringValue, nil
+	_, warned := m.warnedUnknownTypes[originalType]
+	m.warnedUnknownTypes[originalType] = struct{}{}
+	return !warned
+}
+
+// isKnownType checks whether Transform has a dedicated conversion (or a configured override) for the column type.
+func (m *FieldMapper) isKnownType(column source.ColumnInfo) bool {
+	if _, exists := m.Config.TypeOverrides[column.OriginalType]; exists {
+		return true
 	}
-	if column.OriginalType == "USER-DEFINED" && column.ExpectedExportedType == "binary (UTF8)" {
+	_, exists := typeConversion(column)
+	return exists
+}
+
+// conversion converts a non-NULL Parquet value of a column into the value passed to COPY (see typeConversion).
+type conversion func(m *partMapper, x parquet.Value, column source.ColumnInfo) (any, error)
+
+// stringConversion passes the string representation of the value, which PostgreSQL parses during COPY.
+func stringConversion(_ *partMapper, x parquet.Value, _ source.ColumnInfo) (any, error) {
+	return x.String(), nil
+}
+
+// typeConversions the dedicated conversions of Transform by the original column types
+var typeConversions = map[string]conversion{
+	"boolean": func(_ *partMapper, x parquet.Value, column source.ColumnInfo) (any, error) {
+		return boolValue(x, column)
+	},
+	"bigint": func(_ *partMapper, x parquet.Value, column source.ColumnInfo) (any, error) {
+		return int64Value(x, column)
+	},
+	// there is no way to return Int16, but we assume it should not be out of bounds
+	"integer":  int32Conversion,
+	"smallint": int32Conversion,
+	"double precision": func(_ *partMapper, x parquet.Value, column source.ColumnInfo) (any, error) {
+		return doubleValue(x, column)
+	},
+	"real": func(_ *partMapper, x parquet.Value, column source.ColumnInfo) (any, error) {
+		v, err := doubleValue(x, column)
+		return float32(v), err
+	},
+	"numeric": func(m *partMapper, x parquet.Value, column source.ColumnInfo) (any, error) {
+		return m.numericValue(x, column)
+	},
+	"character varying":           stringConversion,
+	"text":                        stringConversion,
+	"timestamp without time zone": stringConversion,
+	// the exported value keeps its UTC offset, which PostgreSQL parses during COPY
+	"timestamp with time zone": stringConversion,
+	"time with time zone":      stringConversion,
+	"date":                     stringConversion,
+	"jsonb":                    stringConversion,
+	"interval":                 (*partMapper).intervalValue,
+	"money": func(_ *partMapper, x parquet.Value, column source.ColumnInfo) (any, error) {
+		return moneyValue(x, column)
+	},
+	"bit varying": bitStringConversion,
+	"bit":         bitStringConversion,
+	"ARRAY":       (*partMapper).arrayValue,
+	// the names are loaded as they are; regclass and regtype are resolved by the destination database
+	"name":     stringConversion,
+	"regclass": stringConversion,
+	"regtype":  stringConversion,
+	"oid":      (*partMapper).uint32Value,
+	"xid":      (*partMapper).uint32Value,
+	"cid":      (*partMapper).uint32Value,
+}
+
+// int32Conversion converts the value of an integer or smallint column.
+func int32Conversion(_ *partMapper, x parquet.Value, column source.ColumnInfo) (any, error) {
+	v, err := int64Value(x, column)
+	return int32(v), err
+}
+
+// bitStringConversion converts the value of a bit or bit varying column.
+func bitStringConversion(_ *partMapper, x parquet.Value, column source.ColumnInfo) (any, error) {
+	return bitStringValue(x, column)
+}
+
+// typeConversion returns the dedicated conversion of the column type used by Transform (see typeConversions),
+// or false if the type is unknown; isKnownType is derived from it, so that both always agree.
+func typeConversion(column source.ColumnInfo) (conversion, bool) {
+	if column.OriginalType == "USER-DEFINED" {
 		// IMPORTANT: this does not work with the binary format for HSTORE fields,
 		// even though sources in Internet say it should, and therefore we must use CSV format instead
-		return stringValue, nil
+		if column.ExpectedExportedType == "binary (UTF8)" {
+			return stringConversion, true
+		}
+		return nil, false
 	}
-	log.Warn("transform", zap.Any("value", x), zap.String("string", stringValue),
-		zap.Any("type", x.Kind()), zap.Int("columnIndex", columnIndex),
-		zap.String("column", column.ColumnName), zap.String("originalType", column.OriginalType))
-	panic("unexpected column type: " + column.OriginalType)
-	//return stringValue, nil
+	convert, exists := typeConversions[column.OriginalType]
+	return convert, exists
 }
 
-// hasUserDefinedColumn checks if any column in the Parquet file has an original type of "USER-DEFINED".
-// This format does not work with the binary COPY FROM by some reason, even though people say it should.
+// rawStrings checks whether the values of the table are loaded as strings without type-specific conversions
+// (see config.Config.RawStrings), which also requires the CSV COPY.
+func (m *FieldMapper) rawStrings() bool {
+	if m.Config.RawStrings {
+		return true
+	}
+	found, _ := m.Config.TableNameInSet(m.Config.RawStringsTables, m.Info.TableName)
+	return found
+}
+
+// copyCSVOptions returns the CSV options of the CSV COPY into the table (see config.Config.CopyCSVFor).
+func (m *FieldMapper) copyCSVOptions() utils.CopyCSVOptions {
+	return m.Config.CopyCSVFor(m.Info.TableName)
+}
+
+// hasTextOnlyColumn checks if any column in the Parquet file has an original type of "USER-DEFINED", "money",
+// "regclass" or "regtype". The "USER-DEFINED" format does not work with the binary COPY FROM by some reason,
+// even though people say it should, and pgx has no binary encoding of money and of the reg* types.
 // And it forces us to fall back to CSV.
-func (m *FieldMapper) hasUserDefinedColumn() bool {
+func (m *FieldMapper) hasTextOnlyColumn() bool {
 	for _, column := range m.Info.Columns {
-		if column.OriginalType == "USER-DEFINED" {
+		switch column.OriginalType {
+		case "USER-DEFINED", "money", "regclass", "regtype":
 			return true
 		}
 	}
 	return false
 }
+
+// overlongRiskColumns returns the character columns (by index) whose destination limit is smaller than
+// the length in the source database (or the source length is unknown), mapped to the destination limit.
+// Loading such columns fails if any value is longer than the destination limit.
+func (m *FieldMapper) overlongRiskColumns() map[int]int {
+	ret := make(map[int]int)
+	for i, column := range m.Info.Columns {
+		if column.OriginalType != "character varying" && column.OriginalType != "character" {
+			continue
+		}
+		limit, limited := m.targetCharMaxLengths[column.ColumnName]
+		if limited && (column.OriginalCharMaxLength <= 0 || column.OriginalCharMaxLength > limit) {
+			ret[i] = limit
+		}
+	}
+	return ret
+}
+
+// ValidateSchema implements the interface source.SchemaValidator - it is called for every Parquet part,
+// and returns the partMapper of the part with the scales of its DECIMAL columns.
+// The values of the Parquet rows are passed to COPY by their positions, so the column names of the file
+// must match the columns of the export metadata in the same order; otherwise the data would silently land
+// in the wrong columns, and an error is returned before any rows are copied.
+// It also compares the actual physical type of every column with ExpectedExportedType from the export metadata,
+// and reports a warning (once per table/column) when they differ. Transform always prefers the actual type.
+func (m *FieldMapper) ValidateSchema(schema *parquet.Schema) (source.Transformer, error) {
+	if mismatches := schemaColumnMismatches(schema.Columns(), m.Info.Columns); len(mismatches) > 0 {
+		return nil, fmt.Errorf("the columns of the Parquet file do not match the export metadata of the table '%s': %s",
+			m.Info.TableName, strings.Join(mismatches, ", "))
+	}
+	decimalScales := make(map[int]int)
+	for i, path := range schema.Columns() {
+		if i >= len(m.Info.Columns) {
+			break
+		}
+		leaf, ok := schema.Lookup(path...)
+		if !ok {
+			continue
+		}
+		if logicalType := leaf.Node.Type().LogicalType(); logicalType != nil && logicalType.Decimal != nil {
+			decimalScales[i] = int(logicalType.Decimal.Scale)
+		}
+		if leaf.MaxRepetitionLevel > 0 {
+			// the physical type of a LIST column is the type of its elements (see TransformList)
+			continue
+		}
+		column := m.Info.Columns[i]
+		expected := exportedPhysicalType(column.ExpectedExportedType)
+		actual := physicalTypeName(leaf.Node.Type().Kind())
+		if expected == "" || expected == actual {
+			continue
+		}
+		m.warnedMutex.Lock()
+		if m.warnedColumns == nil {
+			m.warnedColumns = make(map[string]struct{})
+		}
+		_, warned := m.warnedColumns[column.ColumnName]
+		m.warnedColumns[column.ColumnName] = struct{}{}
+		m.warnedMutex.Unlock()
+		if !warned {
+			log.Warn("The exported type in the metadata differs from the actual Parquet type, using the actual type",
+				zap.String("table", m.Info.TableName), zap.String("column", column.ColumnName),
+				zap.String("expectedExportedType", column.ExpectedExportedType),
+				zap.String("actualType", actual))
+		}
+	}
+	return &partMapper{FieldMapper: m, decimalScales: decimalScales}, nil
+}
+
+// schemaColumnMismatches compares the column paths of the Parquet schema with the columns of the export metadata
+// by their positions, and returns the description of every difference. The elements of a LIST column are nested
+// under the column (for example "tags.list.element"), so only the first name of a path is compared.
+func schemaColumnMismatches(paths [][]string, columns []source.ColumnInfo) (ret []string) {
+	for i := 0; i < max(len(paths), len(columns)); i++ {
+		switch {
+		case i >= len(paths):
+			ret = append(ret, fmt.Sprintf("column %d '%s' is missing in the file", i+1, columns[i].ColumnName))
+		case i >= len(columns):
+			ret = append(ret, fmt.Sprintf("column %d '%s' is missing in the metadata", i+1,
+				strings.Join(paths[i], ".")))
+		case paths[i][0] != columns[i].ColumnName:
+			ret = append(ret, fmt.Sprintf("column %d is '%s' in the file and '%s' in the metadata", i+1,
+				strings.Join(paths[i], "."), columns[i].ColumnName))
+		}
+	}
+	return ret
+}
+
+// exportedPhysicalType extracts the physical type from ExpectedExportedType,
+// for example "binary (UTF8)" becomes "binary".
+func exportedPhysicalType(expectedExportedType string) string {
+	s := strings.ToLower(strings.TrimSpace(expectedExportedType))
+	if i := strings.IndexAny(s, " ("); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+// physicalTypeName returns the name of the Parquet physical type in the notation of ExpectedExportedType.
+func physicalTypeName(kind parquet.Kind) string {
+	switch kind {
+	case parquet.Boolean:
+		return "boolean"
+	case parquet.Int32:
+		return "int32"
+	case parquet.Int64:
+		return "int64"
+	case parquet.Int96:
+		return "int96"
+	case parquet.Float:
+		return "float"
+	case parquet.Double:
+		return "double"
+	case parquet.ByteArray:
+		return "binary"
+	case parquet.FixedLenByteArray:
+		return "fixed_len_byte_array"
+	}
+	return strings.ToLower(kind.String())
+}
+
+// int64Value converts an integer value according to its actual Parquet type.
+func int64Value(x parquet.Value, column source.ColumnInfo) (int64, error) {
+	switch x.Kind() {
+	case parquet.Int32:
+		return int64(x.Int32()), nil
+	case parquet.ByteArray, parquet.FixedLenByteArray:
+		v, err := strconv.ParseInt(strings.TrimSpace(x.String()), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("column '%s': %w", column.ColumnName, err)
+		}
+		return v, nil
+	}
+	return x.Int64(), nil
+}
+
+// doubleValue converts a floating point value according to its actual Parquet type.
+func doubleValue(x parquet.Value, column source.ColumnInfo) (float64, error) {
+	switch x.Kind() {
+	case parquet.Float:
+		return float64(x.Float()), nil
+	case parquet.Int32:
+		return float64(x.Int32()), nil
+	case parquet.Int64:
+		return float64(x.Int64()), nil
+	case parquet.ByteArray, parquet.FixedLenByteArray:
+		v, err := strconv.ParseFloat(strings.TrimSpace(x.String()), 64)
+		if err != nil {
+			return 0, fmt.Errorf("column '%s': %w", column.ColumnName, err)
+		}
+		return v, nil
+	}
+	return x.Double(), nil
+}
+
+// numericValue converts a value of a numeric column according to its actual Parquet type: a DECIMAL value
+// (an unscaled integer or a big-endian two's complement byte array) is scaled by the scale of the Parquet file,
+// an integer or a floating point value is formatted as is, and a string is passed as is.
+// With the precision in the export metadata, a value that does not fit into numeric(precision, scale)
+// fails here with the column name, instead of failing COPY.
+func (m *partMapper) numericValue(x parquet.Value, column source.ColumnInfo) (string, error) {
+	scale, isDecimal := m.decimalScales[x.Column()]
+	var ret string
+	switch {
+	case isDecimal && (x.Kind() == parquet.Int32 || x.Kind() == parquet.Int64):
+		ret = decimalString(big.NewInt(x.Int64()), scale)
+	case isDecimal && (x.Kind() == parquet.ByteArray || x.Kind() == parquet.FixedLenByteArray):
+		ret = decimalString(twosComplementInt(x.ByteArray()), scale)
+	case x.Kind() == parquet.Int32 || x.Kind() == parquet.Int64:
+		ret = strconv.FormatInt(x.Int64(), 10)
+	case x.Kind() == parquet.Float:
+		ret = strconv.FormatFloat(float64(x.Float()), 'f', -1, 32)
+	case x.Kind() == parquet.Double:
+		ret = strconv.FormatFloat(x.Double(), 'f', -1, 64)
+	default:
+		return x.String(), nil
+	}
+	if column.OriginalNumPrecision > 0 && integerDigits(ret) > column.OriginalNumPrecision-column.OriginalNumScale {
+		return "", fmt.Errorf("column '%s': the value %s does not fit into numeric(%d,%d)", column.ColumnName,
+			ret, column.OriginalNumPrecision, column.OriginalNumScale)
+	}
+	return ret, nil
+}
+
+// twosComplementInt decodes a big-endian two's complement integer, the encoding of DECIMAL byte arrays in Parquet.
+func twosComplementInt(data []byte) *big.Int {
+	ret := new(big.Int).SetBytes(data)
+	if len(data) > 0 && data[0]&0x80 != 0 {
+		ret.Sub(ret, new(big.Int).Lsh(big.NewInt(1), uint(len(data))*8))
+	}
+	return ret
+}
+
+// decimalString formats the unscaled value of a decimal with the given scale, for example 123456 with the scale 4
+// becomes "12.3456", keeping all digits.
+func decimalString(unscaled *big.Int, scale int) string {
+	if scale <= 0 {
+		return new(big.Int).Mul(unscaled, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-scale)), nil)).String()
+	}
+	digits := new(big.Int).Abs(unscaled).String()
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	sign := ""
+	if unscaled.Sign() < 0 {
+		sign = "-"
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// integerDigits returns the number of significant digits before the decimal point of a formatted number.
+func integerDigits(value string) int {
+	value = strings.TrimLeft(value, "-")
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		value = value[:i]
+	}
+	return len(strings.TrimLeft(value, "0"))
+}
+
+// boolValue converts a boolean value according to its actual Parquet type.
+func boolValue(x parquet.Value, column source.ColumnInfo) (bool, error) {
+	switch x.Kind() {
+	case parquet.Int32, parquet.Int64:
+		return x.Int64() != 0, nil
+	case parquet.ByteArray, parquet.FixedLenByteArray:
+		v, err := strconv.ParseBool(strings.TrimSpace(x.String()))
+		if err != nil {
+			return false, fmt.Errorf("column '%s': %w", column.ColumnName, err)
+		}
+		return v, nil
+	}
+	return x.Boolean(), nil
+}
+
+// handlerValue converts a value with one of the handlers that can be assigned by config.TypeOverrides.
+func handlerValue(handler string, x parquet.Value, column source.ColumnInfo) (any, error) {
+	switch handler {
+	case "int64":
+		return int64Value(x, column)
+	case "int32":
+		v, err := int64Value(x, column)
+		return int32(v), err
+	case "double":
+		return doubleValue(x, column)
+	case "float":
+		v, err := doubleValue(x, column)
+		return float32(v), err
+	case "bool":
+		return boolValue(x, column)
+	case "bytea":
+		return x.Clone().ByteArray(), nil
+	case "string":
+		return x.String(), nil
+	}
+	return nil, fmt.Errorf("column '%s': unknown type handler '%s'", column.ColumnName, handler)
+}
@@ -0,0 +1,466 @@
+package target
+
+import (
+	"context"
+	"dbrestore/config"
+	"dbrestore/source"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// newTestConfig creates an empty configuration suitable for unit tests.
+func newTestConfig() *config.Config {
+	return &config.Config{
+		IncludeTables: make(map[string]struct{}),
+		ExcludeTables: make(map[string]struct{}),
+	}
+}
+
+// newTestMapper creates a FieldMapper for a table with the given columns and an empty configuration.
+func newTestMapper(tableName string, columns ...source.ColumnInfo) FieldMapper {
+	return FieldMapper{
+		Info: source.ParquetFileInfo{
+			TableName: tableName,
+			Columns:   columns,
+		},
+		Config: newTestConfig(),
+	}
+}
+
+func TestTransformTimeZones(t *testing.T) {
+	tests := []struct {
+		name           string
+		originalType   string
+		input          string
+		expectedResult string
+	}{
+		{
+			name:           "timestamp with time zone",
+			originalType:   "timestamp with time zone",
+			input:          "2024-03-10 01:30:00-05",
+			expectedResult: "2024-03-10 01:30:00-05",
+		},
+		{
+			name:           "time with time zone",
+			originalType:   "time with time zone",
+			input:          "13:45:00+02",
+			expectedResult: "13:45:00+02",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := newTestMapper("public.t", source.ColumnInfo{ColumnName: "c", OriginalType: tt.originalType})
+			result, err := mapper.Transform(parquet.ValueOf(tt.input).Level(0, 1, 0))
+			if err != nil {
+				t.Fatalf("Transform() error: %v", err)
+			}
+			if result != tt.expectedResult {
+				t.Errorf("Transform() = %v; want %v", result, tt.expectedResult)
+			}
+			result, err = mapper.Transform(parquet.NullValue().Level(0, 0, 0))
+			if err != nil || result != nil {
+				t.Errorf("Transform(NULL) = %v, %v; want nil, nil", result, err)
+			}
+		})
+	}
+}
+
+// mislabeledRow is a Parquet fixture row whose export metadata deliberately lies about some column types.
+type mislabeledRow struct {
+	ID    int64  `parquet:"id"`
+	Qty   string `parquet:"qty"`   // the metadata claims int64
+	Score int32  `parquet:"score"` // the metadata claims double
+}
+
+func TestTransformPrefersActualParquetType(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "part-00000.parquet")
+	err := parquet.WriteFile(fileName, []mislabeledRow{
+		{ID: 1, Qty: "42", Score: 7},
+		{ID: 2, Qty: "-5", Score: -3},
+	})
+	if err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+
+	mapper := newTestMapper("public.t",
+		source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"},
+		source.ColumnInfo{ColumnName: "qty", OriginalType: "bigint", ExpectedExportedType: "int64"},
+		source.ColumnInfo{ColumnName: "score", OriginalType: "double precision", ExpectedExportedType: "double"})
+
+	reader := source.NewParquetReader(context.Background(), source.FileInfo{LocalPath: fileName}, &mapper)
+	var rows [][]any
+	for reader.Next() {
+		values, err := reader.Values()
+		if err != nil {
+			t.Fatalf("Values() error: %v", err)
+		}
+		rows = append(rows, values)
+	}
+	if reader.Err() != nil {
+		t.Fatalf("Err() = %v", reader.Err())
+	}
+
+	expected := [][]any{{int64(1), int64(42), float64(7)}, {int64(2), int64(-5), float64(-3)}}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Loaded rows = %v; want %v", rows, expected)
+	}
+	if _, warned := mapper.warnedColumns["qty"]; !warned {
+		t.Errorf("Expected a type mismatch warning for the column 'qty'")
+	}
+	if _, warned := mapper.warnedColumns["id"]; warned {
+		t.Errorf("Unexpected type mismatch warning for the column 'id'")
+	}
+}
+
+func TestSchemaColumnMismatches(t *testing.T) {
+	columns := func(names ...string) []source.ColumnInfo {
+		ret := make([]source.ColumnInfo, 0, len(names))
+		for _, name := range names {
+			ret = append(ret, source.ColumnInfo{ColumnName: name})
+		}
+		return ret
+	}
+	tests := []struct {
+		name     string
+		paths    [][]string
+		columns  []source.ColumnInfo
+		expected []string
+	}{
+		{name: "Same columns", paths: [][]string{{"id"}, {"name"}}, columns: columns("id", "name")},
+		{name: "Swapped columns", paths: [][]string{{"name"}, {"id"}}, columns: columns("id", "name"),
+			expected: []string{"column 1 is 'name' in the file and 'id' in the metadata",
+				"column 2 is 'id' in the file and 'name' in the metadata"}},
+		{name: "Missing in the file", paths: [][]string{{"id"}}, columns: columns("id", "name"),
+			expected: []string{"column 2 'name' is missing in the file"}},
+		{name: "Missing in the metadata", paths: [][]string{{"id"}, {"address", "city"}}, columns: columns("id"),
+			expected: []string{"column 2 'address.city' is missing in the metadata"}},
+		{name: "LIST column", paths: [][]string{{"id"}, {"tags", "list", "element"}}, columns: columns("id", "tags")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := schemaColumnMismatches(tt.paths, tt.columns)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("schemaColumnMismatches() = %q; want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateSchemaColumnOrder(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "part-00000.parquet")
+	err := parquet.WriteFile(fileName, []mislabeledRow{{ID: 1, Qty: "42", Score: 7}})
+	if err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+
+	// the metadata lists the same columns in another order
+	mapper := newTestMapper("public.t",
+		source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"},
+		source.ColumnInfo{ColumnName: "score", OriginalType: "integer", ExpectedExportedType: "int32"},
+		source.ColumnInfo{ColumnName: "qty", OriginalType: "text", ExpectedExportedType: "binary (UTF8)"})
+	reader := source.NewParquetReader(context.Background(), source.FileInfo{LocalPath: fileName}, &mapper)
+	defer reader.Cancel()
+	if !reader.IsEmpty() || reader.LastError() == nil {
+		t.Fatalf("The reader accepted the file with the columns in another order")
+	}
+	if !strings.Contains(reader.LastError().Error(), "column 2 is 'qty' in the file and 'score' in the metadata") {
+		t.Errorf("LastError() = %v; want the mismatching columns", reader.LastError())
+	}
+}
+
+// decimalRow is a Parquet fixture row with a numeric(18,4) column in both DECIMAL encodings.
+type decimalRow struct {
+	Amount      int64   `parquet:"amount,decimal(4:18)"`
+	FixedAmount [8]byte `parquet:"fixed_amount,decimal(4:18)"`
+}
+
+// fixedDecimal encodes an unscaled decimal value as a big-endian two's complement byte array.
+func fixedDecimal(unscaled int64) (ret [8]byte) {
+	for i := range ret {
+		ret[len(ret)-1-i] = byte(unscaled >> (8 * i))
+	}
+	return ret
+}
+
+func TestTransformNumericPrecision(t *testing.T) {
+	tests := []struct {
+		name     string
+		unscaled int64
+		expected string
+	}{
+		{name: "All 18 digits", unscaled: 123456789012345678, expected: "12345678901234.5678"},
+		{name: "Negative", unscaled: -123456789012345678, expected: "-12345678901234.5678"},
+		{name: "Trailing zeros", unscaled: 10000, expected: "1.0000"},
+		{name: "Less than one", unscaled: -5, expected: "-0.0005"},
+		{name: "Zero", unscaled: 0, expected: "0.0000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "part-00000.parquet")
+			row := decimalRow{Amount: tt.unscaled, FixedAmount: fixedDecimal(tt.unscaled)}
+			if err := parquet.WriteFile(fileName, []decimalRow{row}); err != nil {
+				t.Fatalf("Failed to write the Parquet fixture: %v", err)
+			}
+			mapper := newTestMapper("public.t",
+				source.ColumnInfo{ColumnName: "amount", OriginalType: "numeric", ExpectedExportedType: "int64",
+					OriginalNumPrecision: 18, OriginalNumScale: 4},
+				source.ColumnInfo{ColumnName: "fixed_amount", OriginalType: "numeric",
+					ExpectedExportedType: "fixed_len_byte_array", OriginalNumPrecision: 18, OriginalNumScale: 4})
+			reader := source.NewParquetReader(context.Background(), source.FileInfo{LocalPath: fileName}, &mapper)
+			defer reader.Cancel()
+			if !reader.Next() {
+				t.Fatalf("Next() = false; error: %v", reader.LastError())
+			}
+			values, err := reader.Values()
+			if err != nil {
+				t.Fatalf("Values() error: %v", err)
+			}
+			if !reflect.DeepEqual(values, []any{tt.expected, tt.expected}) {
+				t.Errorf("Values() = %v; want %v in both columns", values, tt.expected)
+			}
+		})
+	}
+}
+
+// centsRow is a Parquet fixture row like decimalRow, but with the scale 2.
+type centsRow struct {
+	Amount      int64   `parquet:"amount,decimal(2:18)"`
+	FixedAmount [8]byte `parquet:"fixed_amount,decimal(2:18)"`
+}
+
+func TestTransformDecimalScalesPerPart(t *testing.T) {
+	dir := t.TempDir()
+	scale4, scale2 := filepath.Join(dir, "part-00000.parquet"), filepath.Join(dir, "part-00001.parquet")
+	if err := parquet.WriteFile(scale4, []decimalRow{{Amount: 12345, FixedAmount: fixedDecimal(12345)}}); err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	if err := parquet.WriteFile(scale2, []centsRow{{Amount: 12345, FixedAmount: fixedDecimal(12345)}}); err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	mapper := newTestMapper("public.t",
+		source.ColumnInfo{ColumnName: "amount", OriginalType: "numeric", ExpectedExportedType: "int64"},
+		source.ColumnInfo{ColumnName: "fixed_amount", OriginalType: "numeric",
+			ExpectedExportedType: "fixed_len_byte_array"})
+
+	// both parts are open before their rows are read, like the parts of a table read concurrently
+	readers := make([]*source.ParquetReader, 2)
+	for i, fileName := range []string{scale4, scale2} {
+		readers[i] = source.NewParquetReader(context.Background(), source.FileInfo{LocalPath: fileName}, &mapper)
+		defer readers[i].Cancel()
+		readers[i].OpenAndStartReadingIfNotDoneYet()
+	}
+	for i, expected := range []string{"1.2345", "123.45"} {
+		if !readers[i].Next() {
+			t.Fatalf("Next() = false; error: %v", readers[i].LastError())
+		}
+		values, err := readers[i].Values()
+		if err != nil || !reflect.DeepEqual(values, []any{expected, expected}) {
+			t.Errorf("Values() of the part %d = %v, %v; want %v in both columns", i, values, err, expected)
+		}
+	}
+}
+
+func TestTransformNumericOverflow(t *testing.T) {
+	mapper := newTestMapper("public.t", source.ColumnInfo{ColumnName: "amount", OriginalType: "numeric",
+		ExpectedExportedType: "int64", OriginalNumPrecision: 6, OriginalNumScale: 4})
+	part := &partMapper{FieldMapper: &mapper, decimalScales: map[int]int{0: 4}}
+	if result, err := part.Transform(parquet.Int64Value(99_9999).Level(0, 1, 0)); err != nil || result != "99.9999" {
+		t.Errorf("Transform() = %v, %v; want 99.9999, nil", result, err)
+	}
+	if _, err := part.Transform(parquet.Int64Value(100_0000).Level(0, 1, 0)); err == nil {
+		t.Errorf("Transform() accepted a value that does not fit into numeric(6,4)")
+	}
+}
+
+func TestTransformNumericKinds(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    parquet.Value
+		expected string
+	}{
+		{name: "FLOAT", value: parquet.ValueOf(float32(1.5)), expected: "1.5"},
+		{name: "FLOAT without the noise of float64", value: parquet.ValueOf(float32(0.1)), expected: "0.1"},
+		{name: "DOUBLE", value: parquet.ValueOf(0.1), expected: "0.1"},
+		{name: "INT64", value: parquet.ValueOf(int64(-42)), expected: "-42"},
+		{name: "String", value: parquet.ValueOf("12.50"), expected: "12.50"},
+	}
+
+	mapper := newTestMapper("public.t", source.ColumnInfo{ColumnName: "amount", OriginalType: "numeric"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result, err := mapper.Transform(tt.value.Level(0, 1, 0)); err != nil || result != tt.expected {
+				t.Errorf("Transform() = %v, %v; want %s, nil", result, err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTransformUnknownTypeFallback(t *testing.T) {
+	column := source.ColumnInfo{ColumnName: "c", OriginalType: "citext", ExpectedExportedType: "binary (UTF8)"}
+
+	mapper := newTestMapper("public.t", column)
+	mapper.Config.UnknownTypeFallback = config.UnknownTypeString
+	result, err := mapper.Transform(parquet.ValueOf("Hello").Level(0, 1, 0))
+	if err != nil || result != "Hello" {
+		t.Errorf("Transform() = %v, %v; want Hello, nil", result, err)
+	}
+	// the warning is reported once per table and type
+	if mapper.firstUnknownTypeWarning(column.OriginalType) {
+		t.Errorf("Transform() did not record the warning about the unknown type")
+	}
+	other := newTestMapper("public.other", column)
+	if !other.firstUnknownTypeWarning(column.OriginalType) {
+		t.Errorf("the warning about the unknown type of another table was already recorded")
+	}
+
+	mapper.Config.UnknownTypeFallback = config.UnknownTypePanic
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Transform() did not panic with the fallback '%s'", config.UnknownTypePanic)
+			}
+		}()
+		_, _ = mapper.Transform(parquet.ValueOf("Hello").Level(0, 1, 0))
+	}()
+
+	mapper.Config.UnknownTypeFallback = config.UnknownTypeSkipTable
+	reason, skip := mapper.ShouldSkip()
+	if !skip || reason != ReasonUnknownType {
+		t.Errorf("ShouldSkip() = %v, %v; want %v, true", reason, skip, ReasonUnknownType)
+	}
+}
+
+func TestKnownTypesAgreeWithTransform(t *testing.T) {
+	columns := []source.ColumnInfo{{OriginalType: "USER-DEFINED", ExpectedExportedType: "binary (UTF8)"},
+		{OriginalType: "USER-DEFINED", ExpectedExportedType: "int32"}, {OriginalType: "point"}}
+	for originalType := range typeConversions {
+		columns = append(columns, source.ColumnInfo{OriginalType: originalType})
+	}
+	for _, column := range columns {
+		column.ColumnName = "c"
+		mapper := newTestMapper("public.t", column)
+		mapper.Config.UnknownTypeFallback = config.UnknownTypePanic
+		// the fallback panics, while the conversions may only fail to convert the value
+		fellBack := func() (ret bool) {
+			defer func() {
+				ret = recover() != nil
+			}()
+			_, _ = mapper.Transform(parquet.ValueOf("1").Level(0, 1, 0))
+			return false
+		}()
+		if mapper.isKnownType(column) == fellBack {
+			t.Errorf("isKnownType(%s, %s) = %v, but Transform fell back = %v", column.OriginalType,
+				column.ExpectedExportedType, mapper.isKnownType(column), fellBack)
+		}
+	}
+}
+
+func TestTransformTypeOverrides(t *testing.T) {
+	mapper := newTestMapper("public.t",
+		source.ColumnInfo{ColumnName: "name", OriginalType: "citext", ExpectedExportedType: "binary (UTF8)"},
+		source.ColumnInfo{ColumnName: "qty", OriginalType: "positive_int", ExpectedExportedType: "int32"})
+	mapper.Config.UnknownTypeFallback = config.UnknownTypePanic
+	mapper.Config.TypeOverrides = map[string]string{"citext": "string", "positive_int": "int64"}
+
+	result, err := mapper.Transform(parquet.ValueOf("Hello").Level(0, 1, 0))
+	if err != nil || result != "Hello" {
+		t.Errorf("Transform(citext) = %v, %v; want Hello, nil", result, err)
+	}
+	result, err = mapper.Transform(parquet.ValueOf(int32(42)).Level(0, 1, 1))
+	if err != nil || result != int64(42) {
+		t.Errorf("Transform(positive_int) = %v, %v; want 42, nil", result, err)
+	}
+}
+
+func TestTransformRawStrings(t *testing.T) {
+	columns := []source.ColumnInfo{
+		{ColumnName: "id", OriginalType: "integer", ExpectedExportedType: "int32"},
+		{ColumnName: "flag", OriginalType: "boolean", ExpectedExportedType: "boolean"},
+		{ColumnName: "odd", OriginalType: "tsvector", ExpectedExportedType: "binary (UTF8)"},
+	}
+	tests := []struct {
+		name   string
+		global bool
+		tables map[string]struct{}
+		raw    bool
+	}{
+		{name: "global", global: true, raw: true},
+		{name: "selected table", tables: map[string]struct{}{"t": {}}, raw: true},
+		{name: "other table", tables: map[string]struct{}{"public.other": {}}, raw: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := newTestMapper("public.t", columns...)
+			mapper.Config.UnknownTypeFallback = config.UnknownTypePanic
+			mapper.Config.RawStrings = tt.global
+			mapper.Config.RawStringsTables = tt.tables
+			if mapper.rawStrings() != tt.raw {
+				t.Fatalf("rawStrings() = %v; want %v", mapper.rawStrings(), tt.raw)
+			}
+
+			result, err := mapper.Transform(parquet.ValueOf(int32(42)).Level(0, 1, 0))
+			expected := any(int32(42))
+			if tt.raw {
+				expected = "42"
+			}
+			if err != nil || result != expected {
+				t.Errorf("Transform(integer) = %v (%T), %v; want %v (%T)", result, result, err, expected, expected)
+			}
+			result, err = mapper.Transform(parquet.ValueOf(nil).Level(0, 0, 1))
+			if err != nil || result != nil {
+				t.Errorf("Transform(null) = %v, %v; want nil", result, err)
+			}
+			if tt.raw {
+				// an unknown type does not panic, because there is no type-specific conversion at all
+				result, err = mapper.Transform(parquet.ValueOf("'a' 'b'").Level(0, 1, 2))
+				if err != nil || result != "'a' 'b'" {
+					t.Errorf("Transform(tsvector) = %v, %v; want the raw string", result, err)
+				}
+			}
+		})
+	}
+}
+
+func TestShouldSkip(t *testing.T) {
+	column := source.ColumnInfo{ColumnName: "id", OriginalType: "integer", ExpectedExportedType: "int32"}
+	tests := []struct {
+		name           string
+		configure      func(c *config.Config)
+		targetNotEmpty bool
+		expectedReason string
+	}{
+		{name: "loaded", configure: func(c *config.Config) {}},
+		{name: "not included", configure: func(c *config.Config) {
+			c.IncludeTables = map[string]struct{}{"public.other": {}}
+		}, expectedReason: ReasonSkippedByConfig1},
+		{name: "excluded", configure: func(c *config.Config) {
+			c.ExcludeTables = map[string]struct{}{"public.t": {}}
+		}, expectedReason: ReasonSkippedByConfig2},
+		{name: "not empty without skip-not-empty", configure: func(c *config.Config) {}, targetNotEmpty: true},
+		{name: "not empty", configure: func(c *config.Config) {
+			c.SkipNotEmpty = true
+		}, targetNotEmpty: true, expectedReason: ReasonNotEmpty},
+		{name: "empty", configure: func(c *config.Config) {
+			c.SkipNotEmpty = true
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := newTestMapper("public.t", column)
+			tt.configure(mapper.Config)
+			mapper.targetNotEmpty = tt.targetNotEmpty
+			reason, skip := mapper.ShouldSkip()
+			if reason != tt.expectedReason || skip != (tt.expectedReason != "") {
+				t.Errorf("ShouldSkip() = %q, %v; want %q", reason, skip, tt.expectedReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package target
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/rds/auth"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+	"net"
+	"strconv"
+)
+
+// Error codes of PostgreSQL reported when the authentication fails
+const (
+	// invalidAuthorizationSpecification is reported by RDS for a rejected IAM token
+	invalidAuthorizationSpecification = "28000"
+	// invalidPassword is reported for a wrong password
+	invalidPassword = "28P01"
+)
+
+// TokenProvider generates the password of a new connection to the given host, port and user,
+// for example an IAM authentication token of RDS (see NewIAMTokenProvider).
+type TokenProvider func(ctx context.Context, host string, port uint16, user string) (string, error)
+
+// NewIAMTokenProvider returns a TokenProvider generating the IAM authentication tokens of RDS with the credentials
+// and the region of the AWS configuration. The tokens are valid for 15 minutes, so a new one is generated
+// for every connection.
+func NewIAMTokenProvider(awsConfig aws.Config) TokenProvider {
+	return func(ctx context.Context, host string, port uint16, user string) (string, error) {
+		if awsConfig.Region == "" {
+			return "", errors.New("the AWS region is required to generate the IAM authentication token")
+		}
+		endpoint := net.JoinHostPort(host, strconv.Itoa(int(port)))
+		return auth.BuildAuthToken(ctx, endpoint, awsConfig.Region, user, awsConfig.Credentials)
+	}
+}
+
+// connectWithToken connects with the password generated by TokenProvider. When the authentication fails
+// (for example, the token expired between its generation and the connection), the token is regenerated
+// and the connection is attempted once more.
+func (w *DbWriter) connectWithToken(ctx context.Context, connConfig *pgx.ConnConfig) (*pgx.Conn, error) {
+	for attempt := 1; ; attempt++ {
+		token, err := w.TokenProvider(ctx, connConfig.Host, connConfig.Port, connConfig.User)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate the authentication token: %w", err)
+		}
+		connConfig.Password = token
+		db, err := pgx.ConnectConfig(ctx, connConfig)
+		if err == nil || attempt > 1 || !isAuthenticationError(err) {
+			return db, err
+		}
+		log.Warn("Authentication with the token failed, regenerating the token", zap.Error(err))
+	}
+}
+
+// isAuthenticationError checks whether the connection was rejected by the authentication of the server.
+func isAuthenticationError(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) &&
+		(pgErr.Code == invalidAuthorizationSpecification || pgErr.Code == invalidPassword)
+}
@@ -0,0 +1,52 @@
+package target
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/jackc/pgx/v5/pgconn"
+	"strings"
+	"testing"
+)
+
+func TestIAMTokenProvider(t *testing.T) {
+	awsConfig := aws.Config{Region: "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKIDEXAMPLE", "secret", "")}
+	token, err := NewIAMTokenProvider(awsConfig)(context.Background(), "db.example.com", 5432, "restore")
+	if err != nil {
+		t.Fatalf("TokenProvider error: %v", err)
+	}
+	for _, expected := range []string{"db.example.com:5432?", "Action=connect", "DBUser=restore", "X-Amz-Signature="} {
+		if !strings.Contains(token, expected) {
+			t.Errorf("token = %s; want it to contain %s", token, expected)
+		}
+	}
+
+	awsConfig.Region = ""
+	if _, err := NewIAMTokenProvider(awsConfig)(context.Background(), "db.example.com", 5432, "restore"); err == nil {
+		t.Errorf("TokenProvider without the region did not fail")
+	}
+}
+
+func TestIsAuthenticationError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "rejected token", err: fmt.Errorf("connect: %w", &pgconn.PgError{Code: "28000"}), expected: true},
+		{name: "wrong password", err: &pgconn.PgError{Code: "28P01"}, expected: true},
+		{name: "missing database", err: &pgconn.PgError{Code: "3D000"}},
+		{name: "network", err: errors.New("connection refused")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := isAuthenticationError(tt.err); result != tt.expected {
+				t.Errorf("isAuthenticationError(%v) = %v; want %v", tt.err, result, tt.expected)
+			}
+		})
+	}
+}
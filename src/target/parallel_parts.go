@@ -0,0 +1,236 @@
+package target
+
+import (
+	"context"
+	"dbrestore/source"
+	"dbrestore/utils"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// partsStagingSequence numbers the staging tables of the program (see stagingTableName).
+var partsStagingSequence atomic.Int64
+
+// stagedParts are the Parquet files of a table copied concurrently into the staging tables (see stageTableParts).
+type stagedParts struct {
+	// tables the sanitized names of the staging tables, one per connection
+	tables []string
+	// files the Parquet files of the table, in the order of the export
+	files []string
+	// rows the number of rows copied from each of the files
+	rows []int64
+	// accounting the accounting of the rows of all files; Inserted is counted by insertStagedParts
+	accounting RowAccounting
+	// started the time when copying the files started
+	started time.Time
+	// mutex guards the fields above while the files are copied
+	mutex sync.Mutex
+}
+
+// stageTableParts copies the Parquet files of the table concurrently into unlogged staging tables
+// (see config.Config.PartsJobs), before the transaction of the table starts. A transaction cannot span
+// several connections, and the table is locked by its transaction once its indexes are dropped, so the files
+// are copied next to the table and inserted into it by insertStagedParts, and the table is still rolled back
+// as a unit. The first failed file cancels the others. The caller drops the staging tables (see dropStagedParts),
+// also when an error is returned.
+func (w *DbWriter) stageTableParts(src source.Source, mapper *FieldMapper) (*stagedParts, error) {
+	allFiles, groupedFiles, err := groupTableFiles(src, mapper.Config.SourceDatabase, mapper.Info.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err = w.checkOverlongValues(src, mapper, allFiles); err != nil {
+		return nil, err
+	}
+	files, err := tableParquetFiles(groupedFiles)
+	if err != nil {
+		return nil, err
+	}
+	staged := &stagedParts{files: files, rows: make([]int64, len(files)), started: time.Now()}
+	workers := min(mapper.Config.PartsJobs, len(files))
+	log.Debug("Copying table parts concurrently", zap.String("table", mapper.Info.TableName),
+		zap.Int("files", len(files)), zap.Int("connections", workers))
+
+	ctx, cancel := context.WithCancel(w.dbContext())
+	defer cancel()
+	var failure error
+	var failureOnce sync.Once
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.stageParts(ctx, src, mapper, staged, indexes); err != nil {
+				failureOnce.Do(func() {
+					failure = err
+					cancel()
+				})
+			}
+		}()
+	}
+feed:
+	for i := range files {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if failure == nil && ctx.Err() != nil {
+		failure = ctx.Err()
+	}
+	if failure != nil {
+		return staged, fmt.Errorf("writing table part failed: %w", failure)
+	}
+	return staged, nil
+}
+
+// stageParts is a worker of stageTableParts: it copies the files of the given indexes into its own staging table
+// on its own connection of the pool.
+func (w *DbWriter) stageParts(ctx context.Context, src source.Source, mapper *FieldMapper, staged *stagedParts,
+	indexes <-chan int) error {
+	worker, err := w.Clone(ctx)
+	if err != nil {
+		return err
+	}
+	defer worker.Close()
+	release, err := worker.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+	table, err := worker.createStagingTable(mapper)
+	if err != nil {
+		return err
+	}
+	staged.mutex.Lock()
+	staged.tables = append(staged.tables, table)
+	staged.mutex.Unlock()
+	for i := range indexes {
+		part, err := worker.stagePart(src, mapper, table, staged.files[i])
+		if err != nil {
+			return err
+		}
+		staged.mutex.Lock()
+		staged.rows[i] = part.Copied
+		staged.accounting.Add(part)
+		staged.mutex.Unlock()
+	}
+	return nil
+}
+
+// stagingTableName returns a new name of a staging table in the schema of the table, unique in the program
+// and among the programs running at the same time on the same host.
+func stagingTableName(tableName string) string {
+	schema, _ := utils.SplitFullTableName(tableName)
+	name := fmt.Sprintf("%s_%d_%d", partsStagingTable, os.Getpid(), partsStagingSequence.Add(1))
+	if schema == "" {
+		return name
+	}
+	return schema + "." + name
+}
+
+// createStagingTable creates an unlogged staging table with the columns of the destination table of the mapper,
+// and returns its sanitized name.
+func (w *DbWriter) createStagingTable(mapper *FieldMapper) (string, error) {
+	tableName, err := utils.SanitizeTableName(mapper.Info.TableName)
+	if err != nil {
+		return "", fmt.Errorf("failed to create the staging table: %w", err)
+	}
+	stagingTable, err := utils.SanitizeTableName(stagingTableName(mapper.Info.TableName))
+	if err != nil {
+		return "", fmt.Errorf("failed to create the staging table: %w", err)
+	}
+	_, err = w.db.Exec(w.dbContext(), fmt.Sprintf(createUnloggedTableAs, stagingTable,
+		quoteColumnNames(mapper.getFieldNames()), tableName))
+	if err != nil {
+		return "", fmt.Errorf("failed to create the staging table for '%s': %w", mapper.Info.TableName, err)
+	}
+	return stagingTable, nil
+}
+
+// stagePart copies a Parquet file into the staging table and validates the accounting of its rows like copyRows;
+// the table size is validated for the whole table by insertStagedParts.
+func (w *DbWriter) stagePart(src source.Source, mapper *FieldMapper, stagingTable string,
+	relativePath string) (RowAccounting, error) {
+	// Validate the relative path to prevent path traversal
+	if strings.Contains(relativePath, "..") {
+		return RowAccounting{}, fmt.Errorf("invalid relative path containing path traversal sequences: %s", relativePath)
+	}
+	cleanPath := filepath.Clean(relativePath)
+	file := src.GetFile(cleanPath)
+	if !file.IsValid() {
+		return RowAccounting{}, fmt.Errorf("failed to get the file '%s'", cleanPath)
+	}
+	defer src.Dispose(file)
+	reader := source.NewParquetReader(w.dbContext(), file, mapper)
+	if mapper.Config.ParquetBatchSize > 0 {
+		reader.BatchSize = mapper.Config.ParquetBatchSize
+	}
+	defer reader.Cancel() // releases the reader if COPY stops early
+
+	var copyFromSource rowSource = reader
+	if len(mapper.primaryKeyColumns) > 0 {
+		copyFromSource = &duplicateKeyChecker{rowSource: copyFromSource, mapper: mapper}
+	}
+	sizer, _ := copyFromSource.(rowSizer)
+	counter := newRowCounter(copyFromSource)
+	copied, err := w.copyFrom(stagingTable, mapper, w.throttle(mapper.Info.TableName, counter, sizer))
+	if err != nil && err != io.EOF {
+		return RowAccounting{}, fmt.Errorf("writing the file '%s' of the table '%s' failed for %d rows: %w",
+			cleanPath, mapper.Info.TableName, reader.RowCount(), err)
+	}
+	ret := counter.accounting(copied, 0)
+	ret.Files = 1
+	log.Debug("Copied table part", zap.String("table", mapper.Info.TableName), zap.String("file", cleanPath),
+		zap.Int64("rows_copied", copied))
+	return ret, ret.check(mapper.Info.TableName, mapper.Config.CopyCountMismatch)
+}
+
+// insertStagedParts inserts the rows of the staging tables into the destination table in the transaction
+// of the table, and validates that the table grew by the inserted rows of all files. Returns the accounting
+// of the rows of all files.
+func (w *DbWriter) insertStagedParts(mapper *FieldMapper, staged *stagedParts) (RowAccounting, error) {
+	ret := staged.accounting
+	oldTableSize := int64(w.getTableSize(mapper.Info.TableName))
+	for _, stagingTable := range staged.tables {
+		inserted, err := w.insertStaged(mapper, stagingTable)
+		if err != nil {
+			return RowAccounting{}, err
+		}
+		ret.Inserted += inserted
+	}
+	if mapper.Config.OnConflictSkip {
+		log.Info("Inserted rows skipping conflicts", zap.String("table", mapper.Info.TableName),
+			zap.Int64("rows_inserted", ret.Inserted), zap.Int64("rows_skipped", ret.Copied-ret.Inserted))
+	}
+	if err := ret.checkTableSize(oldTableSize, int64(w.getTableSize(mapper.Info.TableName))); err != nil {
+		return RowAccounting{}, err
+	}
+	for i, file := range staged.files {
+		if err := w.recordLoadedFiles(mapper.Info.TableName, []string{filepath.Clean(file)}, &staged.rows[i],
+			staged.started); err != nil {
+			return RowAccounting{}, err
+		}
+	}
+	return ret, nil
+}
+
+// dropStagedParts drops the staging tables of stageTableParts; it runs after the transaction of the table,
+// so it does not use the context of the table, which may be cancelled.
+func (w *DbWriter) dropStagedParts(staged *stagedParts) {
+	for _, stagingTable := range staged.tables {
+		if _, err := w.db.Exec(context.Background(), fmt.Sprintf(dropTempTable, stagingTable)); err != nil {
+			log.Warn("Failed to drop the staging table", zap.String("table", stagingTable), zap.Error(err))
+		}
+	}
+}
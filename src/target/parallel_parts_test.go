@@ -0,0 +1,105 @@
+package target
+
+import (
+	"context"
+	"dbrestore/source"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStagingTableName(t *testing.T) {
+	first, second := stagingTableName("sales.orders"), stagingTableName("orders")
+	if !strings.HasPrefix(first, "sales."+partsStagingTable+"_") {
+		t.Errorf("stagingTableName() = %s; want a table in the schema sales", first)
+	}
+	if strings.Contains(second, ".") || !strings.HasPrefix(second, partsStagingTable+"_") {
+		t.Errorf("stagingTableName() = %s; want a table without a schema", second)
+	}
+	if strings.TrimPrefix(first, "sales.") == second {
+		t.Errorf("stagingTableName() returned %s twice", second)
+	}
+}
+
+// twoRowParts returns the given number of Parquet files of two rows each for writeTableFixture.
+func twoRowParts(files int) map[string][]partRow {
+	ret := make(map[string][]partRow, files)
+	for i := 0; i < files; i++ {
+		ret[fmt.Sprintf("1/part-%05d.parquet", i)] = []partRow{{ID: int64(2*i + 1)}, {ID: int64(2*i + 2)}}
+	}
+	return ret
+}
+
+func TestWriteTablePartsJobs(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE parts_table (id BIGINT PRIMARY KEY);
+			CREATE TABLE broken_parts_table (id BIGINT PRIMARY KEY);`)
+		if err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+		writer := NewDatabaseWriterWithURL(connectionString)
+		writer.PoolSize = 4
+		if err := writer.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error: %v", err)
+		}
+		defer writer.Close()
+		column := source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"}
+		stagingTables := func() (count int) {
+			err := db.QueryRow(context.Background(), "SELECT COUNT(*) FROM pg_class WHERE relname LIKE $1",
+				partsStagingTable+"%").Scan(&count)
+			if err != nil {
+				t.Fatalf("Query error: %v", err)
+			}
+			return count
+		}
+
+		mapper := newTestMapper("public.parts_table", column)
+		mapper.Config.SourceDatabase = "db"
+		mapper.Config.PartsJobs = 3
+		src := source.NewLocalSource(writeTableFixture(t, "parts_table", twoRowParts(5)))
+		rows, err := writer.WriteTable(context.Background(), src, &mapper)
+		if err != nil {
+			t.Fatalf("WriteTable() error: %v", err)
+		}
+		if rows.Files != 5 || rows.Copied != 10 || rows.Inserted != 10 {
+			t.Errorf("WriteTable() = %+v; want 10 rows of 5 files", rows)
+		}
+		var count, sum int64
+		err = db.QueryRow(context.Background(), "SELECT COUNT(*), SUM(id) FROM parts_table").Scan(&count, &sum)
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if count != 10 || sum != 55 {
+			t.Errorf("The table has %d rows with the sum %d; want the ids 1-10", count, sum)
+		}
+		if tables := stagingTables(); tables != 0 {
+			t.Errorf("%d staging tables were left behind", tables)
+		}
+
+		// a broken file fails the table as a unit
+		broken := newTestMapper("public.broken_parts_table", column)
+		broken.Config.SourceDatabase = "db"
+		broken.Config.PartsJobs = 3
+		root := writeTableFixture(t, "broken_parts_table", twoRowParts(5))
+		err = os.WriteFile(filepath.Join(root, "db", "public.broken_parts_table", "1", "part-00003.parquet"),
+			[]byte("not a Parquet file"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to break the fixture: %v", err)
+		}
+		if _, err := writer.WriteTable(context.Background(), source.NewLocalSource(root), &broken); err == nil {
+			t.Fatalf("WriteTable() with a broken file did not fail")
+		}
+		if err := db.QueryRow(context.Background(), "SELECT COUNT(*) FROM broken_parts_table").Scan(&count); err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if count != 0 {
+			t.Errorf("The failed table has %d rows; want none", count)
+		}
+		if tables := stagingTables(); tables != 0 {
+			t.Errorf("%d staging tables were left behind by the failed table", tables)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package target
+
+import (
+	"dbrestore/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+// pendingIndexesMutex serializes the updates of the pending indexes file by the writers loading tables concurrently
+// (see config.Config.Jobs)
+var pendingIndexesMutex sync.Mutex
+
+// PendingIndexes are the indexes and constraints of the tables that are being rebuilt, recorded in
+// DbWriter.PendingIndexesFile so that an interrupted rebuild can be finished by FinishIndexes.
+type PendingIndexes struct {
+	// Tables the indexes and constraints by the name of the table including the schema name
+	Tables map[string]PendingTableIndexes `json:"tables"`
+}
+
+// PendingTableIndexes are the indexes and constraints of a table that are being rebuilt.
+type PendingTableIndexes struct {
+	// Indexes the indexes of the table with their definitions
+	Indexes []IndexInfo `json:"indexes"`
+	// Constraints the constraints of the table with their definitions
+	Constraints []ConstraintInfo `json:"constraints"`
+}
+
+// ReadPendingIndexes reads the pending indexes from the file; a missing file means there are none.
+func ReadPendingIndexes(fileName string) (ret PendingIndexes, err error) {
+	ret.Tables = make(map[string]PendingTableIndexes)
+	content, err := os.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return ret, nil
+	}
+	if err != nil {
+		return ret, fmt.Errorf("failed to read the pending indexes file '%s': %w", fileName, err)
+	}
+	if err = json.Unmarshal(content, &ret); err != nil {
+		return ret, fmt.Errorf("failed to parse the pending indexes file '%s': %w", fileName, err)
+	}
+	if ret.Tables == nil {
+		ret.Tables = make(map[string]PendingTableIndexes)
+	}
+	return ret, nil
+}
+
+// write replaces the file with the pending indexes, or removes it when nothing is pending. The content is written
+// to a temporary file first, so that a crash does not leave a truncated file behind.
+func (p PendingIndexes) write(fileName string) error {
+	if len(p.Tables) == 0 {
+		if err := os.Remove(fileName); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove the pending indexes file '%s': %w", fileName, err)
+		}
+		return nil
+	}
+	content, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize the pending indexes: %w", err)
+	}
+	tempName := fileName + ".tmp"
+	if err = os.WriteFile(tempName, content, 0644); err != nil {
+		return fmt.Errorf("failed to write the pending indexes file '%s': %w", tempName, err)
+	}
+	if err = os.Rename(tempName, fileName); err != nil {
+		return fmt.Errorf("failed to write the pending indexes file '%s': %w", fileName, err)
+	}
+	return nil
+}
+
+// markIndexesPending records the indexes and constraints of the table in PendingIndexesFile before they are rebuilt;
+// it does nothing without PendingIndexesFile.
+func (w *DbWriter) markIndexesPending(tableName string, indexInfos []IndexInfo, constraints []ConstraintInfo) error {
+	if w.PendingIndexesFile == "" {
+		return nil
+	}
+	pendingIndexesMutex.Lock()
+	defer pendingIndexesMutex.Unlock()
+	pending, err := ReadPendingIndexes(w.PendingIndexesFile)
+	if err != nil {
+		return err
+	}
+	pending.Tables[tableName] = PendingTableIndexes{Indexes: indexInfos, Constraints: constraints}
+	return pending.write(w.PendingIndexesFile)
+}
+
+// clearPendingIndexes removes the table from PendingIndexesFile once its indexes and constraints are in place
+// (or are restored by the rollback); a failure is only logged, because the file is used only for the recovery.
+func (w *DbWriter) clearPendingIndexes(tableName string) {
+	if w.PendingIndexesFile == "" {
+		return
+	}
+	pendingIndexesMutex.Lock()
+	defer pendingIndexesMutex.Unlock()
+	pending, err := ReadPendingIndexes(w.PendingIndexesFile)
+	if err == nil {
+		delete(pending.Tables, tableName)
+		err = pending.write(w.PendingIndexesFile)
+	}
+	if err != nil {
+		log.Warn("Failed to update the pending indexes", zap.String("table", tableName), zap.Error(err))
+	}
+}
+
+// FinishIndexes recreates the indexes and constraints recorded in the file (see PendingIndexes) that are missing
+// in the database, and removes the finished tables from the file. Every statement is committed on its own,
+// so a repeated run continues where the previous one stopped. Indexes are created before the constraints
+// of the table, which may depend on them. Returns the number of created indexes and constraints.
+func (w *DbWriter) FinishIndexes(fileName string) (created int, err error) {
+	pending, err := ReadPendingIndexes(fileName)
+	if err != nil {
+		return 0, err
+	}
+	for _, tableName := range slices.Sorted(maps.Keys(pending.Tables)) {
+		count, err := w.finishTableIndexes(tableName, pending.Tables[tableName])
+		created += count
+		if err != nil {
+			return created, err
+		}
+		delete(pending.Tables, tableName)
+		if err = pending.write(fileName); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// finishTableIndexes recreates the missing indexes and constraints of the table.
+func (w *DbWriter) finishTableIndexes(tableName string, table PendingTableIndexes) (created int, err error) {
+	sanitizedTable, err := utils.SanitizeTableName(tableName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to finish the indexes: %w", err)
+	}
+	for _, indexInfo := range table.Indexes {
+		var exists bool
+		if err = w.db.QueryRow(w.dbContext(), indexExists, sanitizedTable, indexInfo.Name).Scan(&exists); err != nil {
+			return created, fmt.Errorf("checking the index '%s' of the table '%s' failed: %w",
+				indexInfo.Name, tableName, err)
+		}
+		if exists {
+			continue
+		}
+		log.Info(indexInfo.Def)
+		if _, err = w.db.Exec(w.dbContext(), indexInfo.Def); err != nil {
+			return created, fmt.Errorf("creating the index '%s' of the table '%s' failed: %w",
+				indexInfo.Name, tableName, err)
+		}
+		created++
+	}
+	for _, constraint := range table.Constraints {
+		var exists bool
+		err = w.db.QueryRow(w.dbContext(), constraintExists, sanitizedTable, constraint.Name).Scan(&exists)
+		if err != nil {
+			return created, fmt.Errorf("checking the constraint '%s' of the table '%s' failed: %w",
+				constraint.Name, tableName, err)
+		}
+		if exists {
+			continue
+		}
+		createSql := fmt.Sprintf(addConstraint, sanitizedTable, pgx.Identifier{constraint.Name}.Sanitize(),
+			constraint.Command)
+		log.Info(createSql)
+		if _, err = w.db.Exec(w.dbContext(), createSql); err != nil {
+			return created, fmt.Errorf("creating the constraint '%s' of the table '%s' failed: %w",
+				constraint.Name, tableName, err)
+		}
+		created++
+	}
+	return created, nil
+}
@@ -0,0 +1,97 @@
+package target
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestPendingIndexesFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "pending.json")
+	writer := DbWriter{PendingIndexesFile: fileName}
+	indexes := []IndexInfo{{Name: "a_name_idx", Def: "CREATE INDEX a_name_idx ON public.a USING btree (name)"}}
+	constraints := []ConstraintInfo{{Name: "a_b_fk", Command: "FOREIGN KEY (b_id) REFERENCES b(id)"}}
+
+	if err := writer.markIndexesPending("public.a", indexes, constraints); err != nil {
+		t.Fatalf("markIndexesPending() error: %v", err)
+	}
+	if err := writer.markIndexesPending("public.b", nil, nil); err != nil {
+		t.Fatalf("markIndexesPending() error: %v", err)
+	}
+	pending, err := ReadPendingIndexes(fileName)
+	if err != nil {
+		t.Fatalf("ReadPendingIndexes() error: %v", err)
+	}
+	expected := PendingTableIndexes{Indexes: indexes, Constraints: constraints}
+	if len(pending.Tables) != 2 || !reflect.DeepEqual(pending.Tables["public.a"], expected) {
+		t.Errorf("ReadPendingIndexes() = %+v; want the indexes of public.a and public.b", pending)
+	}
+
+	writer.clearPendingIndexes("public.a")
+	writer.clearPendingIndexes("public.b")
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Errorf("the pending indexes file exists after clearing all tables: %v", err)
+	}
+	pending, err = ReadPendingIndexes(fileName)
+	if err != nil || len(pending.Tables) != 0 {
+		t.Errorf("ReadPendingIndexes() of a missing file = %+v, %v; want no tables", pending, err)
+	}
+}
+
+func TestFinishIndexes(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE finish_parent (id BIGINT PRIMARY KEY);
+			CREATE TABLE finish_child (id BIGINT PRIMARY KEY, parent_id BIGINT, name TEXT,
+				CONSTRAINT finish_child_parent_fk FOREIGN KEY (parent_id) REFERENCES finish_parent (id));
+			CREATE INDEX finish_child_name_idx ON finish_child (name);
+			CREATE INDEX finish_child_parent_idx ON finish_child (parent_id);`)
+		if err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+		fileName := filepath.Join(t.TempDir(), "pending.json")
+		writer := DbWriter{db: db, PendingIndexesFile: fileName}
+		indexes, err := writer.getIndexList("finish_child")
+		if err != nil {
+			t.Fatalf("getIndexList() error: %v", err)
+		}
+		constraints, err := writer.getConstraintList("finish_child")
+		if err != nil {
+			t.Fatalf("getConstraintList() error: %v", err)
+		}
+		if err = writer.markIndexesPending("public.finish_child", indexes, constraints); err != nil {
+			t.Fatalf("markIndexesPending() error: %v", err)
+		}
+		// the rebuild was interrupted after recreating only finish_child_parent_idx
+		_, err = db.Exec(context.Background(), `DROP INDEX finish_child_name_idx;
+			ALTER TABLE finish_child DROP CONSTRAINT finish_child_parent_fk;`)
+		if err != nil {
+			t.Fatalf("Failed to drop the indexes: %v", err)
+		}
+
+		created, err := writer.FinishIndexes(fileName)
+		if err != nil {
+			t.Fatalf("FinishIndexes() error: %v", err)
+		}
+		if created != 2 {
+			t.Errorf("FinishIndexes() created %d indexes and constraints; want 2", created)
+		}
+		for _, check := range []struct{ query, name string }{
+			{indexExists, "finish_child_name_idx"},
+			{indexExists, "finish_child_parent_idx"},
+			{constraintExists, "finish_child_parent_fk"},
+		} {
+			var exists bool
+			if err := db.QueryRow(context.Background(), check.query, "public.finish_child",
+				check.name).Scan(&exists); err != nil || !exists {
+				t.Errorf("%s exists = %v, %v; want true", check.name, exists, err)
+			}
+		}
+		if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+			t.Errorf("the pending indexes file exists after finishing: %v", err)
+		}
+	})
+}
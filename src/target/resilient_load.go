@@ -0,0 +1,147 @@
+package target
+
+import (
+	"context"
+	"dbrestore/utils"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	"io"
+	"strings"
+)
+
+// copyResilient copies the rows of the Parquet file as text into a temporary table, and inserts them into
+// the destination table casting every value to the type of its column (see config.Config.ResilientLoad).
+// PostgreSQL cannot skip bad rows in COPY, but a text column accepts any value, and the rows that fail the cast
+// or a constraint of the destination table are moved into the table <table>_load_errors with the error message.
+// Like copyStaged, it supports OnConflictSkip and GENERATED ALWAYS identity columns.
+// Returns the number of copied rows, the number of inserted rows and the number of rejected rows.
+func (w *DbWriter) copyResilient(mapper *FieldMapper, copyFromSource pgx.CopyFromSource) (copied int64,
+	inserted int64, rejected int64, err error) {
+	types, err := w.readColumnTypes(mapper.Info.TableName)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to read the column types of '%s': %w", mapper.Info.TableName, err)
+	}
+	columns, textColumns, castValues, err := resilientColumns(mapper.getFieldNames(), types)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("table '%s': %w", mapper.Info.TableName, err)
+	}
+	tableName, err := utils.SanitizeTableName(mapper.Info.TableName)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to load the rows resiliently: %w", err)
+	}
+	tempTable := pgx.Identifier{resilientTempTable}.Sanitize()
+	errorsTable := loadErrorsTableName(mapper.Info.TableName)
+
+	_, err = w.db.Exec(w.dbContext(), fmt.Sprintf(dropTempTable, tempTable))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to drop the temporary table: %w", err)
+	}
+	_, err = w.db.Exec(w.dbContext(), fmt.Sprintf(createResilientTempTable, tempTable, textColumns))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to create the temporary table for '%s': %w", mapper.Info.TableName, err)
+	}
+	defer func() {
+		if _, dropErr := w.db.Exec(context.Background(), fmt.Sprintf(dropTempTable, tempTable)); dropErr != nil {
+			log.Warn("Failed to drop the temporary table", zap.String("table", resilientTempTable),
+				zap.Error(dropErr))
+		}
+	}()
+
+	// the text columns accept any value, so only the CSV COPY is used
+	copied, err = w.copyFromCSV(resilientTempTable, mapper, copyFromSource)
+	if err != nil && err != io.EOF {
+		return copied, 0, 0, err
+	}
+	copyErr := err
+
+	_, err = w.db.Exec(w.dbContext(), fmt.Sprintf(createLoadErrorsTable, errorsTable))
+	if err != nil {
+		return copied, 0, 0, fmt.Errorf("failed to create the table %s: %w", errorsTable, err)
+	}
+	overriding, onConflict := "", ""
+	if len(mapper.identityAlwaysColumns) > 0 {
+		overriding = overridingSystemValue
+	}
+	if mapper.Config.OnConflictSkip {
+		onConflict = onConflictDoNothing
+	}
+	_, err = w.db.Exec(w.dbContext(), dropResilientInsertFunction)
+	if err != nil {
+		return copied, 0, 0, fmt.Errorf("failed to drop the temporary function: %w", err)
+	}
+	_, err = w.db.Exec(w.dbContext(), fmt.Sprintf(createResilientInsertFunction, tableName, columns, overriding,
+		castValues, tempTable, onConflict, errorsTable))
+	if err != nil {
+		return copied, 0, 0, fmt.Errorf("failed to create the temporary function for '%s': %w",
+			mapper.Info.TableName, err)
+	}
+	defer func() {
+		if _, dropErr := w.db.Exec(context.Background(), dropResilientInsertFunction); dropErr != nil {
+			log.Warn("Failed to drop the temporary function", zap.Error(dropErr))
+		}
+	}()
+	err = w.db.QueryRow(w.dbContext(), callResilientInsertFunction).Scan(&inserted, &rejected)
+	if err != nil {
+		return copied, 0, 0, fmt.Errorf("failed to insert rows into '%s': %w", mapper.Info.TableName, err)
+	}
+	if rejected > 0 {
+		log.Warn("Some rows were rejected by the table and moved into its load errors table",
+			zap.String("table", mapper.Info.TableName), zap.Int64("rows_rejected", rejected),
+			zap.String("errors_table", errorsTable))
+	}
+	return copied, inserted, rejected, copyErr
+}
+
+// readColumnTypes reads the types of the columns of the destination table (without the type modifiers).
+func (w *DbWriter) readColumnTypes(tableName string) (map[string]string, error) {
+	sanitizedTable, err := utils.SanitizeTableName(tableName)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := w.db.Query(w.dbContext(), selectColumnTypes, sanitizedTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]string)
+	for rows.Next() {
+		var name, columnType string
+		if err := rows.Scan(&name, &columnType); err != nil {
+			return nil, err
+		}
+		ret[name] = columnType
+	}
+	return ret, rows.Err()
+}
+
+// resilientColumns builds the SQL fragments of the resilient load for the exported columns: the quoted
+// column names, the text columns of the staging table, and the values of the staging table "s" cast
+// to the types of the destination columns.
+func resilientColumns(names []string, types map[string]string) (columns string, textColumns string,
+	castValues string, err error) {
+	quoted := make([]string, 0, len(names))
+	texts := make([]string, 0, len(names))
+	casts := make([]string, 0, len(names))
+	for _, name := range names {
+		columnType, exists := types[name]
+		if !exists {
+			return "", "", "", fmt.Errorf("the column '%s' is missing in the destination table", name)
+		}
+		column := pgx.Identifier{name}.Sanitize()
+		quoted = append(quoted, column)
+		texts = append(texts, column+" TEXT")
+		casts = append(casts, fmt.Sprintf("s.%s::%s", column, columnType))
+	}
+	return strings.Join(quoted, ", "), strings.Join(texts, ", "), strings.Join(casts, ", "), nil
+}
+
+// loadErrorsTableName returns the quoted name of the table into which the resilient load moves the rejected rows
+// of the table: <table>_load_errors in the schema of the table.
+func loadErrorsTableName(tableName string) string {
+	schema, table := utils.SplitFullTableName(tableName)
+	if schema == "" {
+		return pgx.Identifier{table + loadErrorsSuffix}.Sanitize()
+	}
+	return pgx.Identifier{schema, table + loadErrorsSuffix}.Sanitize()
+}
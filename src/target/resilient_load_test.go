@@ -0,0 +1,110 @@
+package target
+
+import (
+	"context"
+	"dbrestore/source"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestResilientColumns(t *testing.T) {
+	types := map[string]string{"id": "integer", "Name": "character varying", "tags": "text[]"}
+	columns, textColumns, castValues, err := resilientColumns([]string{"id", "Name", "tags"}, types)
+	if err != nil {
+		t.Fatalf("resilientColumns() error: %v", err)
+	}
+	if columns != `"id", "Name", "tags"` {
+		t.Errorf("columns = %s", columns)
+	}
+	if textColumns != `"id" TEXT, "Name" TEXT, "tags" TEXT` {
+		t.Errorf("textColumns = %s", textColumns)
+	}
+	if castValues != `s."id"::integer, s."Name"::character varying, s."tags"::text[]` {
+		t.Errorf("castValues = %s", castValues)
+	}
+	if _, _, _, err := resilientColumns([]string{"id", "missing"}, types); err == nil {
+		t.Errorf("resilientColumns() accepted a column missing in the destination table")
+	}
+}
+
+func TestLoadErrorsTableName(t *testing.T) {
+	if name := loadErrorsTableName("public.orders"); name != `"public"."orders_load_errors"` {
+		t.Errorf("loadErrorsTableName() = %s", name)
+	}
+	if name := loadErrorsTableName("orders"); name != `"orders_load_errors"` {
+		t.Errorf("loadErrorsTableName() = %s", name)
+	}
+}
+
+// resilientRow is a Parquet fixture row whose text values do not always fit the destination columns.
+type resilientRow struct {
+	ID   int32  `parquet:"id"`
+	Qty  string `parquet:"qty"`
+	Name string `parquet:"name"`
+}
+
+func TestWriteTablePartResilientLoad(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(),
+			"CREATE TABLE resilient_table (id INTEGER PRIMARY KEY, qty INTEGER NOT NULL, name VARCHAR(5));")
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		rows := make([]resilientRow, 10)
+		for i := range rows {
+			rows[i] = resilientRow{ID: int32(i + 1), Qty: fmt.Sprint(i * 10), Name: fmt.Sprintf("n%d", i)}
+		}
+		rows[2].Qty = "abc"              // not an integer
+		rows[6].Name = "a too long name" // longer than VARCHAR(5)
+		root := writeTableFixture(t, "resilient_table", map[string][]resilientRow{"1/part-00000.parquet": rows})
+
+		mapper := newTestMapper("public.resilient_table",
+			source.ColumnInfo{ColumnName: "id", OriginalType: "integer", ExpectedExportedType: "int32"},
+			source.ColumnInfo{ColumnName: "qty", OriginalType: "text", ExpectedExportedType: "binary (UTF8)"},
+			source.ColumnInfo{ColumnName: "name", OriginalType: "text", ExpectedExportedType: "binary (UTF8)"})
+		mapper.Config.ResilientLoad = true
+		writer := DbWriter{db: db}
+		accounting, err := writer.writeTablePart(source.NewLocalSource(root), &mapper,
+			filepath.Join("db", "public.resilient_table", "1", "part-00000.parquet"))
+		if err != nil {
+			t.Fatalf("writeTablePart() error: %v", err)
+		}
+		expected := RowAccounting{Read: 10, Emitted: 10, Copied: 10, Inserted: 8, Rejected: 2}
+		if accounting != expected {
+			t.Errorf("writeTablePart() = %+v; want %+v", accounting, expected)
+		}
+
+		var count int
+		if err := db.QueryRow(context.Background(), "SELECT COUNT(*) FROM resilient_table").Scan(&count); err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if count != 8 {
+			t.Errorf("The table has %d rows; want 8", count)
+		}
+		errorRows, err := db.Query(context.Background(),
+			"SELECT row_number, row_data->>'id', sqlstate FROM resilient_table_load_errors ORDER BY row_number")
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		type rejectedRow struct {
+			number   int64
+			id       string
+			sqlState string
+		}
+		rejected, err := pgx.CollectRows(errorRows, func(row pgx.CollectableRow) (ret rejectedRow, err error) {
+			err = row.Scan(&ret.number, &ret.id, &ret.sqlState)
+			return ret, err
+		})
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		// invalid_text_representation and string_data_right_truncation
+		expectedRejected := []rejectedRow{{number: 3, id: "3", sqlState: "22P02"}, {number: 7, id: "7", sqlState: "22001"}}
+		if fmt.Sprint(rejected) != fmt.Sprint(expectedRejected) {
+			t.Errorf("The rejected rows = %v; want %v", rejected, expectedRejected)
+		}
+	})
+}
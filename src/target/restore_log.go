@@ -0,0 +1,110 @@
+package target
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+// restoreLogSchema the schema of the restore log (see RestoreLog); it is never listed, ordered or truncated
+// like the system schemas
+const restoreLogSchema = "dbrestore"
+
+// restoreLogStatusLoaded the status of a Parquet file committed into its table
+const restoreLogStatusLoaded = "loaded"
+
+// createRestoreLog creates the restore log in the destination database if it does not exist yet
+const createRestoreLog = `
+	CREATE SCHEMA IF NOT EXISTS dbrestore;
+	CREATE TABLE IF NOT EXISTS dbrestore.restore_log (
+		snapshot TEXT NOT NULL,
+		source_database TEXT NOT NULL,
+		table_name TEXT NOT NULL,
+		parquet_file TEXT NOT NULL,
+		rows_copied BIGINT,
+		started_at TIMESTAMPTZ NOT NULL,
+		finished_at TIMESTAMPTZ NOT NULL,
+		status TEXT NOT NULL,
+		PRIMARY KEY (snapshot, source_database, parquet_file)
+	)`
+
+// upsertRestoreLog records a Parquet file loaded into the table, replacing the record of an earlier run
+const upsertRestoreLog = `
+	INSERT INTO dbrestore.restore_log (snapshot, source_database, table_name, parquet_file, rows_copied,
+		started_at, finished_at, status)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (snapshot, source_database, parquet_file) DO UPDATE SET table_name = EXCLUDED.table_name,
+		rows_copied = EXCLUDED.rows_copied, started_at = EXCLUDED.started_at,
+		finished_at = EXCLUDED.finished_at, status = EXCLUDED.status`
+
+// selectRestoredTables lists the tables with the files loaded from the snapshot (the parameters $1 and $2)
+const selectRestoredTables = `
+	SELECT DISTINCT table_name FROM dbrestore.restore_log
+	WHERE snapshot = $1 AND source_database = $2 AND status = $3
+	ORDER BY table_name`
+
+// RestoreLog identifies the export whose restore is recorded in the table dbrestore.restore_log
+// of the destination database: every Parquet file is recorded in the transaction of its table, right after
+// its rows are copied, so the record is committed or rolled back together with the rows. A table is loaded
+// in a single transaction, so the tables with recorded files were committed completely (see RestoredTables).
+type RestoreLog struct {
+	// Snapshot the name of the exported snapshot
+	Snapshot string
+	// SourceDatabase the name of the database in the snapshot
+	SourceDatabase string
+}
+
+// CreateRestoreLog creates the table of the restore log if it does not exist, and enables recording
+// the loaded Parquet files in it.
+func (w *DbWriter) CreateRestoreLog(restoreLog RestoreLog) error {
+	if _, err := w.db.Exec(w.dbContext(), createRestoreLog); err != nil {
+		return fmt.Errorf("failed to create the restore log table %s.restore_log: %w", restoreLogSchema, err)
+	}
+	w.restoreLog = &restoreLog
+	return nil
+}
+
+// RestoredTables returns the tables committed by the previous runs restoring the same export,
+// as recorded in the restore log (see CreateRestoreLog).
+func (w *DbWriter) RestoredTables() (ret []string, err error) {
+	if w.restoreLog == nil {
+		return nil, fmt.Errorf("the restore log is not enabled")
+	}
+	rows, err := w.db.Query(w.dbContext(), selectRestoredTables, w.restoreLog.Snapshot,
+		w.restoreLog.SourceDatabase, restoreLogStatusLoaded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the restore log: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, fmt.Errorf("failed to read the restore log: %w", err)
+		}
+		ret = append(ret, table)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the restore log: %w", err)
+	}
+	return ret, nil
+}
+
+// recordLoadedFiles records the Parquet files loaded into the table in the restore log, in the current transaction
+// of the table; rows is the number of copied rows, or nil when the files were copied together and the rows
+// of each file are unknown (see writeTableParts). Without the restore log, it does nothing.
+func (w *DbWriter) recordLoadedFiles(table string, files []string, rows *int64, started time.Time) error {
+	if w.restoreLog == nil {
+		return nil
+	}
+	finished := time.Now()
+	for _, file := range files {
+		_, err := w.db.Exec(w.dbContext(), upsertRestoreLog, w.restoreLog.Snapshot, w.restoreLog.SourceDatabase,
+			table, file, rows, started, finished, restoreLogStatusLoaded)
+		if err != nil {
+			return fmt.Errorf("failed to record the file '%s' in the restore log: %w", file, err)
+		}
+	}
+	log.Debug("Recorded the loaded files in the restore log", zap.String("table", table),
+		zap.Int("files", len(files)))
+	return nil
+}
@@ -0,0 +1,70 @@
+package target
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestRestoreLogTransactions(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		writer := DbWriter{db: db}
+		if err := writer.recordLoadedFiles("public.t", []string{"db/public.t/1/part-00000.parquet"}, nil,
+			time.Now()); err != nil {
+			t.Errorf("recordLoadedFiles() without the restore log error: %v", err)
+		}
+		if err := writer.CreateRestoreLog(RestoreLog{Snapshot: "snap", SourceDatabase: "db"}); err != nil {
+			t.Fatalf("CreateRestoreLog() error: %v", err)
+		}
+		defer func() {
+			_, _ = db.Exec(context.Background(), "DROP SCHEMA dbrestore CASCADE")
+		}()
+		record := func(table string, commit bool) {
+			tx, err := db.Begin(context.Background())
+			if err != nil {
+				t.Fatalf("Begin() error: %v", err)
+			}
+			rows := int64(2)
+			err = writer.recordLoadedFiles(table, []string{"db/" + table + "/1/part-00000.parquet"}, &rows,
+				time.Now())
+			if err != nil {
+				t.Fatalf("recordLoadedFiles() error: %v", err)
+			}
+			if commit {
+				err = tx.Commit(context.Background())
+			} else {
+				err = tx.Rollback(context.Background())
+			}
+			if err != nil {
+				t.Fatalf("Failed to finish the transaction: %v", err)
+			}
+		}
+		record("public.rolled_back", false)
+		record("public.committed", true)
+		record("public.committed", true) // a repeated restore replaces the record
+
+		tables, err := writer.RestoredTables()
+		if err != nil {
+			t.Fatalf("RestoredTables() error: %v", err)
+		}
+		if expected := []string{"public.committed"}; !reflect.DeepEqual(tables, expected) {
+			t.Errorf("RestoredTables() = %v; want %v", tables, expected)
+		}
+		other := DbWriter{db: db, restoreLog: &RestoreLog{Snapshot: "other", SourceDatabase: "db"}}
+		if tables, err := other.RestoredTables(); err != nil || len(tables) != 0 {
+			t.Errorf("RestoredTables() of another snapshot = %v, %v; want none", tables, err)
+		}
+		listed, err := writer.GetTablesOrdered(context.Background())
+		if err != nil {
+			t.Fatalf("GetTablesOrdered() error: %v", err)
+		}
+		for _, table := range listed {
+			if table == "dbrestore.restore_log" {
+				t.Errorf("GetTablesOrdered() lists the restore log")
+			}
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package target
+
+import (
+	"dbrestore/config"
+	"fmt"
+	"go.uber.org/zap"
+)
+
+// RowAccounting counts the rows of a Parquet part file (or of all parts of a table, see Add) on their way
+// from the Parquet reader into the table, so that the rows skipped on purpose are not confused with
+// the rows lost by COPY.
+type RowAccounting struct {
+	// Read the number of rows in the Parquet files, as reported by the reader
+	Read int64 `json:"read"`
+	// Dropped the number of rows not passed to COPY on purpose, by a filter or a limit (see rowDropper)
+	Dropped int64 `json:"dropped"`
+	// Emitted the number of rows passed to COPY
+	Emitted int64 `json:"emitted"`
+	// Copied the number of rows reported by COPY
+	Copied int64 `json:"copied"`
+	// Inserted the number of rows added to the table; fewer than Copied when the conflicting rows are skipped
+	// (see config.Config.OnConflictSkip)
+	Inserted int64 `json:"inserted"`
+	// Rejected the number of copied rows that the table rejected and that were moved into its load errors table
+	// (see config.Config.ResilientLoad)
+	Rejected int64 `json:"rejected"`
+	// Files the number of Parquet part files the rows were read from
+	Files int64 `json:"files"`
+}
+
+// Add adds the rows of another part to the accounting.
+func (a *RowAccounting) Add(other RowAccounting) {
+	a.Read += other.Read
+	a.Dropped += other.Dropped
+	a.Emitted += other.Emitted
+	a.Copied += other.Copied
+	a.Inserted += other.Inserted
+	a.Rejected += other.Rejected
+	a.Files += other.Files
+}
+
+// check validates the accounting of a part: every row of the Parquet files must be either dropped or emitted,
+// and COPY must report every emitted row. Depending on the policy (see config.CopyCountMismatch), a difference
+// between the emitted and the copied rows, for example when a trigger filters rows, is either an error or a warning.
+func (a RowAccounting) check(tableName string, policy string) error {
+	if a.Read != a.Dropped+a.Emitted {
+		return fmt.Errorf("%d rows were read from Parquet for the table '%s', but %d rows were dropped "+
+			"and %d rows were passed to COPY", a.Read, tableName, a.Dropped, a.Emitted)
+	}
+	if a.Emitted == a.Copied {
+		return nil
+	}
+	if policy == config.CopyCountMismatchWarn {
+		log.Warn("COPY reported a different number of rows than was passed to it",
+			zap.String("table", tableName), zap.Int64("rows_emitted", a.Emitted), zap.Int64("rows_copied", a.Copied))
+		return nil
+	}
+	return fmt.Errorf("COPY into the table '%s' reported %d rows, but %d rows were passed to it",
+		tableName, a.Copied, a.Emitted)
+}
+
+// checkTableSize validates that the table grew exactly by the inserted rows of the part.
+func (a RowAccounting) checkTableSize(oldTableSize int64, newTableSize int64) error {
+	if newTableSize != oldTableSize+a.Inserted {
+		return fmt.Errorf("table size mismatch: expected = %d, new actual size = %d",
+			oldTableSize+a.Inserted, newTableSize)
+	}
+	return nil
+}
+
+// rowDropper is implemented by the sources of rows for COPY that do not pass some rows of the Parquet files
+// on purpose (filters and limits); the dropped rows are counted separately from the rows lost by COPY.
+type rowDropper interface {
+	// RowsDropped returns the number of rows of the Parquet files that were not passed on, including the rows
+	// left unread when a limit stops reading
+	RowsDropped() int64
+}
+
+// rowCounter wraps a source of rows for COPY and counts the rows passed to COPY.
+type rowCounter struct {
+	rowSource
+
+	// dropper the filter or the limit in the wrapped sources, if any
+	dropper rowDropper
+	// emitted the number of rows passed to COPY so far
+	emitted int64
+}
+
+// newRowCounter counts the rows of copyFromSource passed to COPY (see accounting).
+func newRowCounter(copyFromSource rowSource) *rowCounter {
+	dropper, _ := copyFromSource.(rowDropper)
+	return &rowCounter{rowSource: copyFromSource, dropper: dropper}
+}
+
+// Next advances to the next row and counts it.
+// It implements the interface pgx.CopyFromSource
+func (c *rowCounter) Next() bool {
+	if !c.rowSource.Next() {
+		return false
+	}
+	c.emitted++
+	return true
+}
+
+// accounting returns the accounting of the rows passed to COPY, with the numbers of rows reported by COPY
+// and inserted into the table (see also Rejected).
+func (c *rowCounter) accounting(copied int64, inserted int64) RowAccounting {
+	ret := RowAccounting{Read: c.RowCount(), Emitted: c.emitted, Copied: copied, Inserted: inserted}
+	if c.dropper != nil {
+		ret.Dropped = c.dropper.RowsDropped()
+	}
+	return ret
+}
@@ -0,0 +1,162 @@
+package target
+
+import (
+	"context"
+	"dbrestore/config"
+	"dbrestore/source"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// testRowFilter drops the rows of the wrapped source for which keep returns false.
+type testRowFilter struct {
+	rowSource
+
+	keep    func(index int64) bool
+	index   int64
+	dropped int64
+}
+
+func (f *testRowFilter) Next() bool {
+	for f.rowSource.Next() {
+		f.index++
+		if f.keep(f.index - 1) {
+			return true
+		}
+		f.dropped++
+	}
+	return false
+}
+
+func (f *testRowFilter) RowsDropped() int64 {
+	return f.dropped
+}
+
+// testRowLimit stops reading the wrapped source after maxRows rows.
+type testRowLimit struct {
+	rowSource
+
+	maxRows int64
+	rows    int64
+}
+
+func (l *testRowLimit) Next() bool {
+	if l.rows >= l.maxRows || !l.rowSource.Next() {
+		return false
+	}
+	l.rows++
+	return true
+}
+
+func (l *testRowLimit) RowsDropped() int64 {
+	return l.RowCount() - l.rows
+}
+
+func TestRowAccountingCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		accounting  RowAccounting
+		policy      string
+		expectError bool
+	}{
+		{name: "Counts match", accounting: RowAccounting{Read: 10, Emitted: 10, Copied: 10},
+			policy: config.CopyCountMismatchError, expectError: false},
+		{name: "Rows dropped by a filter", accounting: RowAccounting{Read: 10, Dropped: 4, Emitted: 6, Copied: 6},
+			policy: config.CopyCountMismatchError, expectError: false},
+		{name: "Rows filtered by a trigger, error policy", accounting: RowAccounting{Read: 10, Emitted: 10, Copied: 7},
+			policy: config.CopyCountMismatchError, expectError: true},
+		{name: "Rows filtered by a trigger, warn policy", accounting: RowAccounting{Read: 10, Emitted: 10, Copied: 7},
+			policy: config.CopyCountMismatchWarn, expectError: false},
+		{name: "Default policy is error", accounting: RowAccounting{Read: 10, Emitted: 10, Copied: 7},
+			policy: "", expectError: true},
+		{name: "Rows lost before COPY", accounting: RowAccounting{Read: 10, Dropped: 2, Emitted: 6, Copied: 6},
+			policy: config.CopyCountMismatchWarn, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.accounting.check("public.t", tt.policy)
+			if (err != nil) != tt.expectError {
+				t.Errorf("check() error = %v; expectError %v", err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestRowAccountingTableSize(t *testing.T) {
+	var table RowAccounting
+	table.Add(RowAccounting{Read: 10, Dropped: 4, Emitted: 6, Copied: 6, Inserted: 6, Files: 1})
+	table.Add(RowAccounting{Read: 5, Emitted: 5, Copied: 5, Inserted: 3, Files: 1})
+	expected := RowAccounting{Read: 15, Dropped: 4, Emitted: 11, Copied: 11, Inserted: 9, Files: 2}
+	if table != expected {
+		t.Errorf("Add() = %+v; want %+v", table, expected)
+	}
+	if err := table.checkTableSize(100, 109); err != nil {
+		t.Errorf("checkTableSize() error: %v", err)
+	}
+	if err := table.checkTableSize(100, 111); err == nil {
+		t.Errorf("checkTableSize() with the emitted rows instead of the inserted rows did not fail")
+	}
+}
+
+func TestRowCounter(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "part-00000.parquet")
+	rows := make([]partRow, 10)
+	for i := range rows {
+		rows[i].ID = int64(i)
+	}
+	if err := parquet.WriteFile(fileName, rows); err != nil {
+		t.Fatalf("Failed to write the Parquet fixture: %v", err)
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("Failed to stat the Parquet fixture: %v", err)
+	}
+	mapper := newTestMapper("public.t",
+		source.ColumnInfo{ColumnName: "id", OriginalType: "bigint", ExpectedExportedType: "int64"})
+
+	tests := []struct {
+		name     string
+		wrap     func(reader rowSource) rowSource
+		expected RowAccounting
+	}{
+		{name: "All rows", wrap: func(reader rowSource) rowSource { return reader },
+			expected: RowAccounting{Read: 10, Emitted: 10, Copied: 10, Inserted: 10}},
+		{name: "Filter", wrap: func(reader rowSource) rowSource {
+			return &testRowFilter{rowSource: reader, keep: func(index int64) bool { return index%3 == 0 }}
+		}, expected: RowAccounting{Read: 10, Dropped: 6, Emitted: 4, Copied: 4, Inserted: 4}},
+		{name: "Limit", wrap: func(reader rowSource) rowSource {
+			return &testRowLimit{rowSource: reader, maxRows: 7}
+		}, expected: RowAccounting{Read: 10, Dropped: 3, Emitted: 7, Copied: 7, Inserted: 7}},
+		{name: "Limit over a filter", wrap: func(reader rowSource) rowSource {
+			filter := &testRowFilter{rowSource: reader, keep: func(index int64) bool { return index%2 == 0 }}
+			return &testRowLimit{rowSource: filter, maxRows: 20}
+		}, expected: RowAccounting{Read: 10, Dropped: 5, Emitted: 5, Copied: 5, Inserted: 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := source.NewParquetReader(context.Background(),
+				source.FileInfo{LocalPath: fileName, Size: info.Size()}, &mapper)
+			defer reader.Cancel()
+			counter := newRowCounter(tt.wrap(reader))
+			copied := int64(0)
+			for counter.Next() {
+				if _, err := counter.Values(); err != nil {
+					t.Fatalf("Values() error: %v", err)
+				}
+				copied++
+			}
+			accounting := counter.accounting(copied, copied)
+			if accounting != tt.expected {
+				t.Errorf("accounting() = %+v; want %+v", accounting, tt.expected)
+			}
+			if err := accounting.check("public.t", config.CopyCountMismatchError); err != nil {
+				t.Errorf("check() error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package target
+
+import (
+	"dbrestore/source"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/parquet-go/parquet-go"
+	"go.uber.org/zap"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// unsupportedTypes the column types whose exported values cannot be converted; a table with such columns fails
+// before loading (see FieldMapper.checkColumnTypes) unless its values are loaded as strings
+var unsupportedTypes = map[string]struct{}{"point": {}, "line": {}, "lseg": {}, "box": {}, "path": {},
+	"polygon": {}, "circle": {}}
+
+// clusterSpecificTypes the column types whose values identify objects or transactions of the source cluster;
+// they are loaded as they are, but the same OIDs (and names) may refer to other objects in the destination cluster
+var clusterSpecificTypes = map[string]struct{}{"oid": {}, "xid": {}, "cid": {}, "regclass": {}, "regtype": {}}
+
+// checkColumnTypes rejects the table if it has columns of the unsupported types (see unsupportedTypes),
+// naming the first such column; the raw strings and the type overrides load any column as a string.
+// The columns of the cluster-specific types (see clusterSpecificTypes) are loaded with a warning.
+func (m *FieldMapper) checkColumnTypes() error {
+	for _, column := range m.Info.Columns {
+		if _, specific := clusterSpecificTypes[column.OriginalType]; specific {
+			log.Warn("The column refers to the objects or transactions of the source cluster, "+
+				"which may not match those of the destination cluster", zap.String("table", m.Info.TableName),
+				zap.String("column", column.ColumnName), zap.String("type", column.OriginalType))
+		}
+	}
+	if m.rawStrings() {
+		return nil
+	}
+	for _, column := range m.Info.Columns {
+		if _, unsupported := unsupportedTypes[column.OriginalType]; !unsupported {
+			continue
+		}
+		if _, overridden := m.Config.TypeOverrides[column.OriginalType]; overridden {
+			continue
+		}
+		return fmt.Errorf("the column '%s' of the table '%s' has the unsupported type %s; skip the table "+
+			"with --exclude-tables, or load the column as text with --type-override %s=string",
+			column.ColumnName, m.Info.TableName, column.OriginalType, column.OriginalType)
+	}
+	return nil
+}
+
+// intervalValue converts an interval value. The text COPY formats parse any text representation of PostgreSQL,
+// but the binary COPY relies on the parser of pgx, which does not support the ISO 8601 format
+// (for example "P1Y2M3DT4H5M6S"), so the values are converted into pgtype.Interval for it.
+func (m *FieldMapper) intervalValue(x parquet.Value, column source.ColumnInfo) (any, error) {
+	text := strings.TrimSpace(x.String())
+	if m.csvCopy() {
+		return text, nil
+	}
+	var ret pgtype.Interval
+	var err error
+	if strings.HasPrefix(strings.TrimPrefix(text, "-"), "P") {
+		ret, err = parseISO8601Interval(text)
+	} else {
+		err = ret.Scan(text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("column '%s': invalid interval '%s': %w", column.ColumnName, text, err)
+	}
+	return ret, nil
+}
+
+// parseISO8601Interval parses an interval in the ISO 8601 format with designators, for example "P1Y2M3DT4H5M6.5S"
+// or "P-1Y-2M3DT-4H" (the output of IntervalStyle iso_8601); a leading minus negates the whole interval.
+// Only the seconds can be fractional.
+func parseISO8601Interval(text string) (ret pgtype.Interval, err error) {
+	rest, negative := strings.CutPrefix(text, "-")
+	rest, found := strings.CutPrefix(rest, "P")
+	if !found || rest == "" {
+		return ret, fmt.Errorf("not an ISO 8601 interval")
+	}
+	inTime := false
+	for rest != "" {
+		if rest[0] == 'T' && !inTime {
+			inTime = true
+			rest = rest[1:]
+			continue
+		}
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '-' && r != '+' && r != '.'
+		})
+		if end <= 0 {
+			return ret, fmt.Errorf("expected a number followed by a designator at '%s'", rest)
+		}
+		number, designator := rest[:end], rest[end]
+		rest = rest[end+1:]
+		if inTime && designator == 'S' {
+			microseconds, err := secondsToMicroseconds(number)
+			if err != nil {
+				return ret, err
+			}
+			ret.Microseconds += microseconds
+			continue
+		}
+		value, err := strconv.ParseInt(number, 10, 32)
+		if err != nil {
+			return ret, fmt.Errorf("invalid number '%s': %w", number, err)
+		}
+		switch {
+		case !inTime && designator == 'Y':
+			ret.Months += int32(value * 12)
+		case !inTime && designator == 'M':
+			ret.Months += int32(value)
+		case !inTime && designator == 'W':
+			ret.Days += int32(value * 7)
+		case !inTime && designator == 'D':
+			ret.Days += int32(value)
+		case inTime && designator == 'H':
+			ret.Microseconds += value * 3600_000_000
+		case inTime && designator == 'M':
+			ret.Microseconds += value * 60_000_000
+		default:
+			return ret, fmt.Errorf("unexpected designator '%c'", designator)
+		}
+	}
+	if negative {
+		ret.Months, ret.Days, ret.Microseconds = -ret.Months, -ret.Days, -ret.Microseconds
+	}
+	ret.Valid = true
+	return ret, nil
+}
+
+// secondsToMicroseconds converts a number of seconds with an optional fraction into microseconds;
+// the digits of the fraction after the microseconds are dropped.
+func secondsToMicroseconds(number string) (int64, error) {
+	sign := int64(1)
+	digits := strings.TrimPrefix(number, "+")
+	if after, negative := strings.CutPrefix(digits, "-"); negative {
+		sign, digits = -1, after
+	}
+	whole, fraction, _ := strings.Cut(digits, ".")
+	seconds, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid seconds '%s': %w", number, err)
+	}
+	microseconds := int64(0)
+	if fraction != "" {
+		fraction = (fraction + "000000")[:6]
+		if microseconds, err = strconv.ParseInt(fraction, 10, 64); err != nil || microseconds < 0 {
+			return 0, fmt.Errorf("invalid seconds '%s'", number)
+		}
+	}
+	return sign * (seconds*1000_000 + microseconds), nil
+}
+
+// uint32Value converts a value of the unsigned 32-bit catalog types (oid, xid and cid), which the export stores
+// as integers or strings: the text COPY formats get the decimal number like a bigint, and the binary COPY
+// gets an uint32, the integer that pgx encodes into these types.
+func (m *FieldMapper) uint32Value(x parquet.Value, column source.ColumnInfo) (any, error) {
+	v, err := int64Value(x, column)
+	if err != nil {
+		return nil, err
+	}
+	if x.Kind() == parquet.Int32 {
+		// the values above 2^31 wrap around in a signed 32-bit column
+		v = int64(uint32(x.Int32()))
+	}
+	if v < 0 || v > math.MaxUint32 {
+		return nil, fmt.Errorf("column '%s': the value %d is out of the range of %s", column.ColumnName, v,
+			column.OriginalType)
+	}
+	if m.csvCopy() {
+		return strconv.FormatInt(v, 10), nil
+	}
+	return uint32(v), nil
+}
+
+// moneyValue converts a money value, which the export formats according to the locale of the source database
+// (for example "$1,234.56", "-$1,234.56" or "($1,234.56)"), into a plain number like "-1234.56" that the money
+// input accepts regardless of lc_monetary. The last '.' or ',' is the decimal separator unless exactly three digits
+// follow it; the other separators, the currency symbols and the spaces are dropped.
+func moneyValue(x parquet.Value, column source.ColumnInfo) (string, error) {
+	text := strings.TrimSpace(x.String())
+	if x.Kind() != parquet.ByteArray && x.Kind() != parquet.FixedLenByteArray {
+		// a numeric value needs no cleanup
+		return text, nil
+	}
+	negative := strings.Contains(text, "-") || (strings.HasPrefix(text, "(") && strings.HasSuffix(text, ")"))
+	decimal := strings.LastIndexAny(text, ".,")
+	if decimal >= 0 && len(strings.TrimFunc(text[decimal+1:], isNotDigit)) == 3 {
+		decimal = -1 // a thousands separator
+	}
+	var ret strings.Builder
+	if negative {
+		ret.WriteByte('-')
+	}
+	digits := 0
+	for i, r := range text {
+		if i == decimal {
+			ret.WriteByte('.')
+		} else if !isNotDigit(r) {
+			ret.WriteRune(r)
+			digits++
+		}
+	}
+	if digits == 0 {
+		return "", fmt.Errorf("column '%s': invalid money value '%s'", column.ColumnName, text)
+	}
+	return ret.String(), nil
+}
+
+// isNotDigit checks whether the character is not an ASCII digit.
+func isNotDigit(r rune) bool {
+	return r < '0' || r > '9'
+}
+
+// bitStringValue converts a bit string value (bit and bit varying) into the text of its bits, for example "0101",
+// which both COPY formats accept (the same as the literal B'0101'). A value that already consists of the characters
+// '0' and '1' is passed as is; the other binary values are converted bit by bit, 8 bits per byte
+// with the most significant bit first.
+func bitStringValue(x parquet.Value, column source.ColumnInfo) (string, error) {
+	if x.Kind() != parquet.ByteArray && x.Kind() != parquet.FixedLenByteArray {
+		return "", fmt.Errorf("column '%s': unexpected Parquet type %s of a bit string", column.ColumnName, x.Kind())
+	}
+	raw := x.ByteArray()
+	if strings.Trim(string(raw), "01") == "" {
+		return string(raw), nil
+	}
+	var ret strings.Builder
+	ret.Grow(len(raw) * 8)
+	for _, octet := range raw {
+		for bit := 7; bit >= 0; bit-- {
+			ret.WriteByte('0' + (octet>>bit)&1)
+		}
+	}
+	return ret.String(), nil
+}
@@ -0,0 +1,187 @@
+package target
+
+import (
+	"dbrestore/source"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestIntervalValue(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expected      pgtype.Interval
+		expectedError bool
+	}{
+		{name: "Postgres style", input: "1 year 2 mons 3 days 04:05:06.5",
+			expected: pgtype.Interval{Months: 14, Days: 3, Microseconds: 14706500000, Valid: true}},
+		{name: "ISO 8601", input: "P1Y2M3DT4H5M6.5S",
+			expected: pgtype.Interval{Months: 14, Days: 3, Microseconds: 14706500000, Valid: true}},
+		{name: "ISO 8601 weeks", input: "P2W", expected: pgtype.Interval{Days: 14, Valid: true}},
+		{name: "ISO 8601 negative components", input: "P-1Y3DT-4H-0.25S",
+			expected: pgtype.Interval{Months: -12, Days: 3, Microseconds: -14400250000, Valid: true}},
+		{name: "ISO 8601 negated", input: "-PT1M", expected: pgtype.Interval{Microseconds: -60000000, Valid: true}},
+		{name: "ISO 8601 without components", input: "P", expectedError: true},
+		{name: "ISO 8601 fractional days", input: "P1.5D", expectedError: true},
+		{name: "ISO 8601 unknown designator", input: "PT1D", expectedError: true},
+		{name: "Garbage", input: "soon", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := newTestMapper("public.t", source.ColumnInfo{ColumnName: "c", OriginalType: "interval"})
+			result, err := mapper.Transform(parquet.ValueOf(tt.input).Level(0, 1, 0))
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("Transform() error = %v; expectedError %v", err, tt.expectedError)
+			}
+			if !tt.expectedError && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Transform() = %#v; want %#v", result, tt.expected)
+			}
+		})
+	}
+
+	// the text formats of COPY parse the value themselves
+	mapper := newTestMapper("public.t", source.ColumnInfo{ColumnName: "c", OriginalType: "interval"})
+	mapper.Config.ResilientLoad = true
+	if result, err := mapper.Transform(parquet.ValueOf(" P1D ").Level(0, 1, 0)); err != nil || result != "P1D" {
+		t.Errorf("Transform() = %v, %v; want P1D", result, err)
+	}
+}
+
+func TestMoneyValue(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         any
+		expected      string
+		expectedError bool
+	}{
+		{name: "Dollars", input: "$1,234.56", expected: "1234.56"},
+		{name: "Negative", input: "-$1,234.56", expected: "-1234.56"},
+		{name: "Parentheses", input: "($12.50)", expected: "-12.50"},
+		{name: "Thousands only", input: "$1,234,567", expected: "1234567"},
+		{name: "Decimal comma", input: "1.234,56 €", expected: "1234.56"},
+		{name: "Plain", input: "7", expected: "7"},
+		{name: "Numeric", input: 12.5, expected: "12.5"},
+		{name: "No digits", input: "$", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := newTestMapper("public.t", source.ColumnInfo{ColumnName: "c", OriginalType: "money"})
+			result, err := mapper.Transform(parquet.ValueOf(tt.input).Level(0, 1, 0))
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("Transform() error = %v; expectedError %v", err, tt.expectedError)
+			}
+			if !tt.expectedError && result != tt.expected {
+				t.Errorf("Transform() = %v; want %s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBitStringValue(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         any
+		expected      string
+		expectedError bool
+	}{
+		{name: "Text", input: "0101", expected: "0101"},
+		{name: "Binary", input: []byte{0xA5, 0x01}, expected: "1010010100000001"},
+		{name: "Not binary", input: int64(5), expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := newTestMapper("public.t", source.ColumnInfo{ColumnName: "c", OriginalType: "bit varying"})
+			result, err := mapper.Transform(parquet.ValueOf(tt.input).Level(0, 1, 0))
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("Transform() error = %v; expectedError %v", err, tt.expectedError)
+			}
+			if !tt.expectedError && result != tt.expected {
+				t.Errorf("Transform() = %v; want %s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCatalogTypeValues(t *testing.T) {
+	tests := []struct {
+		name          string
+		originalType  string
+		input         any
+		csv           bool
+		expected      any
+		expectedError bool
+	}{
+		{name: "name", originalType: "name", input: "pg_class", expected: "pg_class"},
+		{name: "regclass", originalType: "regclass", input: "public.orders", expected: "public.orders"},
+		{name: "regtype", originalType: "regtype", input: "integer", expected: "integer"},
+		{name: "oid binary", originalType: "oid", input: int64(16384), expected: uint32(16384)},
+		{name: "oid text", originalType: "oid", input: int64(4294967295), csv: true, expected: "4294967295"},
+		{name: "xid string", originalType: "xid", input: "731", expected: uint32(731)},
+		{name: "xid wrapped int32", originalType: "xid", input: int32(-1), expected: uint32(4294967295)},
+		{name: "cid", originalType: "cid", input: int64(3), expected: uint32(3)},
+		{name: "oid negative", originalType: "oid", input: int64(-1), expectedError: true},
+		{name: "oid too large", originalType: "oid", input: int64(4294967296), expectedError: true},
+		{name: "oid not a number", originalType: "oid", input: "pg_class", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := newTestMapper("public.t", source.ColumnInfo{ColumnName: "c", OriginalType: tt.originalType})
+			mapper.Config.ResilientLoad = tt.csv
+			if !mapper.isKnownType(mapper.Info.Columns[0]) {
+				t.Errorf("isKnownType(%s) = false; want true", tt.originalType)
+			}
+			result, err := mapper.Transform(parquet.ValueOf(tt.input).Level(0, 1, 0))
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("Transform() error = %v; expectedError %v", err, tt.expectedError)
+			}
+			if !tt.expectedError && result != tt.expected {
+				t.Errorf("Transform() = %v (%T); want %v (%T)", result, result, tt.expected, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCheckColumnTypes(t *testing.T) {
+	mapper := newTestMapper("public.shops", source.ColumnInfo{ColumnName: "id", OriginalType: "bigint"},
+		source.ColumnInfo{ColumnName: "location", OriginalType: "point"})
+	err := mapper.checkColumnTypes()
+	if err == nil || !strings.Contains(err.Error(), "'location'") || !strings.Contains(err.Error(), "point=string") {
+		t.Errorf("checkColumnTypes() = %v; want an error naming the column and the override", err)
+	}
+	if mapper.isKnownType(mapper.Info.Columns[1]) {
+		t.Errorf("isKnownType(point) = true; want false")
+	}
+
+	mapper.Config.TypeOverrides = map[string]string{"point": "string"}
+	if err := mapper.checkColumnTypes(); err != nil {
+		t.Errorf("checkColumnTypes() with the override = %v; want nil", err)
+	}
+	mapper.Config.TypeOverrides = nil
+	mapper.Config.RawStrings = true
+	if err := mapper.checkColumnTypes(); err != nil {
+		t.Errorf("checkColumnTypes() with the raw strings = %v; want nil", err)
+	}
+
+	money := newTestMapper("public.t", source.ColumnInfo{ColumnName: "price", OriginalType: "money"})
+	if !money.hasTextOnlyColumn() || !money.csvCopy() {
+		t.Errorf("a table with a money column is not loaded with the CSV COPY")
+	}
+
+	// the cluster-specific types are loaded with a warning
+	catalog := newTestMapper("public.t", source.ColumnInfo{ColumnName: "relid", OriginalType: "oid"},
+		source.ColumnInfo{ColumnName: "rel", OriginalType: "regclass"})
+	if err := catalog.checkColumnTypes(); err != nil {
+		t.Errorf("checkColumnTypes() of the catalog types = %v; want nil", err)
+	}
+	if !catalog.csvCopy() {
+		t.Errorf("a table with a regclass column is not loaded with the CSV COPY")
+	}
+}
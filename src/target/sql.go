@@ -1,11 +1,20 @@
 package target
 
-const findIndexes = "SELECT indexname, indexdef FROM pg_indexes WHERE tablename = $1 ORDER BY indexname"
+// findIndexes lists the indexes of the table (the parameter $1) with their definitions and whether they are unique
+// or primary keys; the indexes of the exclusion constraints cannot be dropped separately and are not listed
+const findIndexes = `
+            SELECT i.relname, pg_get_indexdef(x.indexrelid), x.indisunique, x.indisprimary
+            FROM pg_index x JOIN pg_class i ON i.oid = x.indexrelid
+            WHERE x.indrelid = $1::regclass AND NOT x.indisexclusion
+            ORDER BY i.relname
+        `
 
+// findConstrains lists the constraints of the table (the parameter $1) with their definitions and types
+// (see ConstraintInfo.Type)
 const findConstrains = `
-            SELECT conname, pg_get_constraintdef(oid) AS definition
+            SELECT conname, pg_get_constraintdef(oid) AS definition, contype::text
             FROM pg_constraint
-            WHERE conrelid = (SELECT oid FROM pg_class WHERE relname = $1)
+            WHERE conrelid = $1::regclass
             ORDER BY conname, definition
         `
 
@@ -15,10 +24,43 @@ const addConstraint = "ALTER TABLE %s ADD CONSTRAINT %s %s;"
 
 const dropIndex = "DROP INDEX IF EXISTS %s;"
 
+// dropIndexConcurrently drops an index (the invalid index left by a failed concurrent build) without locking the table
+const dropIndexConcurrently = "DROP INDEX CONCURRENTLY IF EXISTS %s;"
+
+const analyzeTable = "ANALYZE %s;"
+
+// indexExists checks whether the table (the parameter $1) has the index with the name $2
+const indexExists = `
+            SELECT EXISTS (SELECT 1 FROM pg_index x JOIN pg_class i ON i.oid = x.indexrelid
+                           WHERE x.indrelid = $1::regclass AND i.relname = $2)
+        `
+
+// constraintExists checks whether the table (the parameter $1) has the constraint with the name $2
+const constraintExists = "SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = $1::regclass AND conname = $2)"
+
+// listTables lists the tables except the system schemas (the parameter $1, see config.Config.SystemSchemas),
+// the tables owned by extensions and the tables in the schemas owned by extensions (see listExtensionTables)
 const listTables = `
-	SELECT table_schema || '.' || table_name AS name  FROM information_schema.tables
-	WHERE table_schema NOT IN ('pg_catalog', 'information_schema') AND table_type NOT IN ('VIEW')
-	ORDER BY table_schema, table_name
+	SELECT t.table_schema || '.' || t.table_name AS name FROM information_schema.tables t
+		JOIN pg_namespace n ON n.nspname = t.table_schema
+		JOIN pg_class c ON c.relname = t.table_name AND c.relnamespace = n.oid
+	WHERE t.table_schema NOT IN ('pg_catalog', 'information_schema') AND t.table_schema <> ALL($1::text[])
+		AND t.table_type NOT IN ('VIEW')
+		AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.deptype = 'e'
+			AND ((d.classid = 'pg_class'::regclass AND d.objid = c.oid)
+				OR (d.classid = 'pg_namespace'::regclass AND d.objid = n.oid)))
+	ORDER BY t.table_schema, t.table_name
+	`
+
+// listExtensionTables lists the tables owned by extensions or in the schemas owned by extensions
+const listExtensionTables = `
+	SELECT n.nspname || '.' || c.relname AS name FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('r', 'p', 'f')
+		AND EXISTS (SELECT 1 FROM pg_depend d WHERE d.deptype = 'e'
+			AND ((d.classid = 'pg_class'::regclass AND d.objid = c.oid)
+				OR (d.classid = 'pg_namespace'::regclass AND d.objid = n.oid)))
+	ORDER BY 1
 	`
 
 const listFKeys = `
@@ -26,10 +68,12 @@ const listFKeys = `
        c.contype                                     AS constraint_type,
        sch.nspname                                   AS "self_schema",
        tbl.relname                                   AS "self_table",
-       STRING_AGG(col.attname, ',') AS "self_columns",
+       ARRAY_AGG(col.attname::text ORDER BY u.attposition)
+           FILTER (WHERE col.attname IS NOT NULL)    AS "self_columns",
        f_sch.nspname                                 AS "foreign_schema",
        f_tbl.relname                                 AS "foreign_table",
-       STRING_AGG(f_col.attname, ',') AS "foreign_columns",
+       ARRAY_AGG(f_col.attname::text ORDER BY u.attposition)
+           FILTER (WHERE f_col.attname IS NOT NULL)  AS "foreign_columns",
        pg_get_constraintdef(c.oid)                   AS definition
 	FROM pg_constraint c
          LEFT JOIN LATERAL UNNEST(c.conkey) WITH ORDINALITY AS u(attnum, attposition) ON TRUE
@@ -40,11 +84,30 @@ const listFKeys = `
          LEFT JOIN pg_class f_tbl ON f_tbl.oid = c.confrelid
          LEFT JOIN pg_namespace f_sch ON f_sch.oid = f_tbl.relnamespace
          LEFT JOIN pg_attribute f_col ON (f_col.attrelid = f_tbl.oid AND f_col.attnum = f_u.attnum)
-	WHERE sch.nspname NOT IN ('pg_catalog')
+	WHERE sch.nspname NOT IN ('pg_catalog', 'information_schema') AND sch.nspname <> ALL($1::text[])
+		AND (f_sch.nspname IS NULL OR f_sch.nspname <> ALL($1::text[]))
+		AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.deptype = 'e'
+			AND ((d.classid = 'pg_class'::regclass AND d.objid IN (tbl.oid, c.confrelid))
+				OR (d.classid = 'pg_namespace'::regclass AND d.objid IN (sch.oid, f_sch.oid))))
 	GROUP BY constraint_name, constraint_type, "self_schema", "self_table", definition, "foreign_schema", "foreign_table"
 	ORDER BY "self_schema", "self_table";
 	`
 
+const selectColumnDetails = `
+	SELECT column_name, ordinal_position, COALESCE(character_maximum_length, 0), COALESCE(identity_generation, ''),
+		CASE WHEN data_type = 'ARRAY' THEN udt_name ELSE '' END
+	FROM information_schema.columns
+	WHERE table_schema = $1 AND table_name = $2
+	ORDER BY ordinal_position
+	`
+
+const selectPrimaryKeyColumns = `
+	SELECT col.attname FROM pg_index idx
+		JOIN pg_attribute col ON col.attrelid = idx.indrelid AND col.attnum = ANY(idx.indkey)
+	WHERE idx.indrelid = $1::text::regclass AND idx.indisprimary
+	ORDER BY array_position(idx.indkey::int2[], col.attnum)
+	`
+
 const selectTableSize = "SELECT COUNT(*) FROM %s"
 
 const disableTriggers = "ALTER TABLE %s DISABLE TRIGGER ALL;"
@@ -57,4 +120,126 @@ const truncateTable = "TRUNCATE TABLE %s CASCADE;"
 
 const checkIfTableIsNotEmpty = "SELECT EXISTS (SELECT 1 FROM %s LIMIT 1)"
 
-const copyTableFromCSV = "COPY %s (%s) FROM STDIN WITH (FORMAT CSV);"
+// selectTableEstimate returns the number of rows of the table estimated by the planner statistics
+// (0 for a table that was never analyzed) and the size of its main data file in bytes
+const selectTableEstimate = "SELECT GREATEST(reltuples, 0)::bigint, pg_relation_size(oid) FROM pg_class WHERE oid = $1::regclass"
+
+// copyTableFromCSV the CSV COPY of the columns of the table with the options of utils.CopyCSVOptions.SQL
+const copyTableFromCSV = "COPY %s (%s) FROM STDIN WITH (%s);"
+
+// stagingTempTable the temporary table into which rows are copied before inserting them into the destination table
+const stagingTempTable = "dbrestore_staging"
+
+const createTempTableAs = "CREATE TEMP TABLE %s AS SELECT %s FROM %s WITH NO DATA;"
+
+// partsStagingTable the prefix of the unlogged tables into which the Parquet files of a table are copied
+// concurrently before inserting them into the destination table (see stageTableParts)
+const partsStagingTable = "dbrestore_parts"
+
+const createUnloggedTableAs = "CREATE UNLOGGED TABLE %s AS SELECT %s FROM %s WITH NO DATA;"
+
+// estimateTempTable the temporary table into which the samples of the command "estimate" are loaded
+const estimateTempTable = "dbrestore_estimate"
+
+const createTempTableLike = "CREATE TEMP TABLE %s (LIKE %s);"
+
+const dropTempTable = "DROP TABLE IF EXISTS %s;"
+
+const insertFromStaging = "INSERT INTO %s (%s)%s SELECT %s FROM %s%s;"
+
+const overridingSystemValue = " OVERRIDING SYSTEM VALUE"
+
+const onConflictDoNothing = " ON CONFLICT DO NOTHING"
+
+// selectOwnedSequences lists the sequences owned by the columns of a table: serial columns (deptype 'a')
+// and identity columns (deptype 'i')
+const selectOwnedSequences = `
+	SELECT seq.oid::regclass::text, col.attname
+	FROM pg_depend dep
+		JOIN pg_class seq ON seq.oid = dep.objid AND seq.relkind = 'S'
+		JOIN pg_attribute col ON col.attrelid = dep.refobjid AND col.attnum = dep.refobjsubid
+	WHERE dep.classid = 'pg_class'::regclass AND dep.refclassid = 'pg_class'::regclass
+		AND dep.deptype IN ('a', 'i') AND dep.refobjid = $1::text::regclass
+	ORDER BY col.attname
+	`
+
+// resetSequence moves the sequence to the maximal value of its column; it does nothing for an empty table
+const resetSequence = "SELECT setval($1::text::regclass, MAX(%s)) FROM %s HAVING MAX(%s) IS NOT NULL;"
+
+const createSchema = "CREATE SCHEMA IF NOT EXISTS %s;"
+
+const createTable = "CREATE TABLE IF NOT EXISTS %s (\n%s\n);"
+
+// resilientTempTable the temporary table of text columns into which rows are copied by the resilient load
+// (see config.Config.ResilientLoad)
+const resilientTempTable = "dbrestore_resilient"
+
+// resilientRowColumn the column of resilientTempTable numbering the copied rows
+const resilientRowColumn = "dbrestore_row"
+
+const createResilientTempTable = "CREATE TEMP TABLE %s (" + resilientRowColumn +
+	" BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY, %s);"
+
+// selectColumnTypes lists the types of the columns of a table without their modifiers (the length limits
+// and the numeric precision are checked when the cast value is assigned to the column)
+const selectColumnTypes = `
+	SELECT attname, format_type(atttypid, NULL) FROM pg_attribute
+	WHERE attrelid = $1::text::regclass AND attnum > 0 AND NOT attisdropped
+	ORDER BY attnum
+	`
+
+// loadErrorsSuffix the suffix of the table into which the resilient load moves the rejected rows of a table
+const loadErrorsSuffix = "_load_errors"
+
+const createLoadErrorsTable = `CREATE TABLE IF NOT EXISTS %s (
+	row_number BIGINT,
+	row_data JSONB,
+	error TEXT,
+	sqlstate TEXT,
+	loaded_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);`
+
+// resilientInsertFunction the temporary function inserting the rows of resilientTempTable
+const resilientInsertFunction = "pg_temp.dbrestore_resilient_insert"
+
+const dropResilientInsertFunction = "DROP FUNCTION IF EXISTS " + resilientInsertFunction + "();"
+
+// createResilientInsertFunction creates resilientInsertFunction; the arguments are the destination table,
+// its columns, OVERRIDING SYSTEM VALUE or nothing, the values cast from the text columns of the staging table "s",
+// the staging table, ON CONFLICT DO NOTHING or nothing, and the errors table.
+// The set-based insert is tried first, and if it fails, the rows are inserted one by one, each in its own
+// subtransaction, and the rows that fail are moved into the errors table.
+const createResilientInsertFunction = `
+	CREATE FUNCTION ` + resilientInsertFunction + `(OUT inserted BIGINT, OUT rejected BIGINT)
+	LANGUAGE plpgsql AS $$
+	#variable_conflict use_column
+	DECLARE
+		r RECORD;
+		n BIGINT;
+	BEGIN
+		inserted := 0;
+		rejected := 0;
+		BEGIN
+			INSERT INTO %[1]s (%[2]s)%[3]s SELECT %[4]s FROM %[5]s s%[6]s;
+			GET DIAGNOSTICS inserted = ROW_COUNT;
+			RETURN;
+		EXCEPTION WHEN OTHERS THEN
+			NULL; -- some rows are rejected, inserting the rows one by one below
+		END;
+		FOR r IN SELECT ` + resilientRowColumn + ` AS id FROM %[5]s ORDER BY ` + resilientRowColumn + ` LOOP
+			BEGIN
+				INSERT INTO %[1]s (%[2]s)%[3]s SELECT %[4]s FROM %[5]s s
+					WHERE s.` + resilientRowColumn + ` = r.id%[6]s;
+				GET DIAGNOSTICS n = ROW_COUNT;
+				inserted := inserted + n;
+			EXCEPTION WHEN OTHERS THEN
+				INSERT INTO %[7]s (row_number, row_data, error, sqlstate)
+					SELECT r.id, to_jsonb(s) - '` + resilientRowColumn + `', SQLERRM, SQLSTATE FROM %[5]s s
+					WHERE s.` + resilientRowColumn + ` = r.id;
+				rejected := rejected + 1;
+			END;
+		END LOOP;
+	END;
+	$$;`
+
+const callResilientInsertFunction = "SELECT inserted, rejected FROM " + resilientInsertFunction + "();"
@@ -0,0 +1,52 @@
+package target
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// successMarkers holds the success marker settings of the whole program; it holds nil until they are set
+// (see SetSuccessMarkers), and then no file is accepted as a success marker.
+var successMarkers atomic.Pointer[successMarkerSettings]
+
+// successMarkerSettings are the success marker settings of the subfolders of the tables.
+type successMarkerSettings struct {
+	// names the accepted names of the success marker files
+	names []string
+	// optional the subfolders without a success marker are loaded too
+	optional bool
+}
+
+// SetSuccessMarkers sets the accepted names of the success marker files of the subfolders of the tables
+// (config.Config.SuccessMarkers, which holds the defaults unless they are configured); with optional,
+// the subfolders without a success marker are loaded and validated as complete. It is meant to be called once
+// at startup.
+func SetSuccessMarkers(names []string, optional bool) {
+	successMarkers.Store(&successMarkerSettings{names: slices.Clone(names), optional: optional})
+}
+
+// currentSuccessMarkers returns the success marker settings of the program (see SetSuccessMarkers).
+func currentSuccessMarkers() *successMarkerSettings {
+	if settings := successMarkers.Load(); settings != nil {
+		return settings
+	}
+	return &successMarkerSettings{}
+}
+
+// isSuccessMarker checks whether the file is the success marker of a subfolder (see SetSuccessMarkers).
+func isSuccessMarker(file string) bool {
+	return slices.Contains(currentSuccessMarkers().names, filepath.Base(file))
+}
+
+// checkSuccessMarker returns an error if the files of the subfolder contain no success marker,
+// unless the success markers are optional.
+func checkSuccessMarker(subfolder string, files []string) error {
+	settings := currentSuccessMarkers()
+	if settings.optional || slices.ContainsFunc(files, isSuccessMarker) {
+		return nil
+	}
+	return fmt.Errorf("missing success marker (%s) in subfolder: %s", strings.Join(settings.names, ", "), subfolder)
+}
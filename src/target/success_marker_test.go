@@ -0,0 +1,48 @@
+package target
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// testSuccessMarkers the success markers of the RDS export, which the program takes from the configuration
+// (see config.Config.SuccessMarkers)
+var testSuccessMarkers = []string{"_success", "_SUCCESS"}
+
+func TestMain(m *testing.M) {
+	SetSuccessMarkers(testSuccessMarkers, false)
+	os.Exit(m.Run())
+}
+
+func TestCheckSuccessMarker(t *testing.T) {
+	t.Cleanup(func() {
+		SetSuccessMarkers(testSuccessMarkers, false)
+	})
+	files := []string{"db/public.t/1/part-00000.parquet", "db/public.t/1/_SUCCESS.crc"}
+
+	// the markers of the RDS export
+	if !isSuccessMarker("db/public.t/1/_success") || !isSuccessMarker("db/public.t/1/_SUCCESS") {
+		t.Errorf("isSuccessMarker() does not accept the configured markers")
+	}
+	err := checkSuccessMarker("db/public.t/1", files)
+	if err == nil || !strings.Contains(err.Error(), "_success, _SUCCESS") {
+		t.Errorf("checkSuccessMarker() = %v; want the missing marker", err)
+	}
+
+	SetSuccessMarkers([]string{"_SUCCESS.crc"}, false)
+	if err := checkSuccessMarker("db/public.t/1", files); err != nil {
+		t.Errorf("checkSuccessMarker() with the configured marker = %v; want nil", err)
+	}
+	if isSuccessMarker("db/public.t/1/_SUCCESS") {
+		t.Errorf("isSuccessMarker() accepts a marker that is not configured")
+	}
+
+	SetSuccessMarkers(testSuccessMarkers, true)
+	if err := checkSuccessMarker("db/public.t/1", files[:1]); err != nil {
+		t.Errorf("checkSuccessMarker() with optional markers = %v; want nil", err)
+	}
+	if !isSuccessMarker("db/public.t/1/_success") {
+		t.Errorf("isSuccessMarker() with optional markers does not accept the configured markers")
+	}
+}
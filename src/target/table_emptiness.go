@@ -0,0 +1,84 @@
+package target
+
+import (
+	"dbrestore/utils"
+	"fmt"
+	"go.uber.org/zap"
+)
+
+// tableEmptiness caches whether the tables of the destination database are empty, so that GetFieldMapper,
+// the check of config.Config.KeepIndexes and the truncation share a single query per table. The entry of a table
+// is forgotten when the table is written, and updated when it is truncated or its rows are counted.
+type tableEmptiness struct {
+	// probe queries whether the table is not empty (the database query, replaced in the tests)
+	probe func(table string) (notEmpty bool, err error)
+	// notEmpty the known results by the table name
+	notEmpty map[string]bool
+}
+
+// isNotEmpty returns the cached result for the table, or probes it.
+func (c *tableEmptiness) isNotEmpty(table string) (bool, error) {
+	if notEmpty, found := c.notEmpty[table]; found {
+		return notEmpty, nil
+	}
+	notEmpty, err := c.probe(table)
+	if err != nil {
+		return false, err
+	}
+	c.set(table, notEmpty)
+	return notEmpty, nil
+}
+
+// set records whether the table is not empty.
+func (c *tableEmptiness) set(table string, notEmpty bool) {
+	if c.notEmpty == nil {
+		c.notEmpty = make(map[string]bool)
+	}
+	c.notEmpty[table] = notEmpty
+}
+
+// forget removes the result of the table, which is probed again when needed.
+func (c *tableEmptiness) forget(table string) {
+	delete(c.notEmpty, table)
+}
+
+// TableNotEmpty checks whether the table of the destination database has rows; the result is cached
+// (see tableEmptiness), so the checks of the same table before it is written cost a single query.
+func (w *DbWriter) TableNotEmpty(table string) (bool, error) {
+	if w.emptiness.probe == nil {
+		w.emptiness.probe = w.queryTableNotEmpty
+	}
+	return w.emptiness.isNotEmpty(table)
+}
+
+// queryTableNotEmpty queries whether the table has rows, without counting them.
+func (w *DbWriter) queryTableNotEmpty(table string) (notEmpty bool, err error) {
+	sanitizedTable, err := utils.SanitizeTableName(table)
+	if err != nil {
+		return false, fmt.Errorf("checking if table '%s' is not empty failed: %w", table, err)
+	}
+	err = w.db.QueryRow(w.dbContext(), fmt.Sprintf(checkIfTableIsNotEmpty, sanitizedTable)).Scan(&notEmpty)
+	if err != nil {
+		return false, fmt.Errorf("checking if table '%s' is not empty failed: %w", table, err)
+	}
+	return notEmpty, nil
+}
+
+// probeEmptiness records in the mapper whether the destination table has rows, which FieldMapper.ShouldSkip
+// needs only with config.Config.SkipNotEmpty; the tables skipped by the configuration are not queried.
+// A failed query is logged, and the table is not skipped.
+func (w *DbWriter) probeEmptiness(mapper *FieldMapper) {
+	if !mapper.Config.SkipNotEmpty {
+		return
+	}
+	if _, skip := mapper.configSkipReason(); skip {
+		return
+	}
+	notEmpty, err := w.TableNotEmpty(mapper.Info.TableName)
+	if err != nil {
+		log.Error("Failed to check if the table is empty", zap.String("table_name", mapper.Info.TableName),
+			zap.Error(err))
+		return
+	}
+	mapper.targetNotEmpty = notEmpty
+}
@@ -0,0 +1,61 @@
+package target
+
+import (
+	"dbrestore/source"
+	"testing"
+)
+
+func TestTableNotEmptyQueries(t *testing.T) {
+	queries := make(map[string]int)
+	writer := &DbWriter{emptiness: tableEmptiness{probe: func(table string) (bool, error) {
+		queries[table]++
+		return table == "public.full", nil
+	}}}
+	mapper := newTestMapper("public.full",
+		source.ColumnInfo{ColumnName: "id", OriginalType: "integer", ExpectedExportedType: "int32"})
+
+	writer.probeEmptiness(&mapper)
+	if reason, skip := mapper.ShouldSkip(); skip || reason != "" {
+		t.Errorf("ShouldSkip() = %v, %v; want no reason without SkipNotEmpty", reason, skip)
+	}
+	if queries["public.full"] != 0 {
+		t.Errorf("probeEmptiness() without SkipNotEmpty queried the table %d times; want none",
+			queries["public.full"])
+	}
+
+	// the tables skipped by the configuration are not queried
+	mapper.Config.SkipNotEmpty = true
+	mapper.Config.ExcludeTables = map[string]struct{}{"public.full": {}}
+	writer.probeEmptiness(&mapper)
+	if queries["public.full"] != 0 {
+		t.Errorf("probeEmptiness() of an excluded table queried it %d times; want none", queries["public.full"])
+	}
+
+	mapper.Config.ExcludeTables = map[string]struct{}{}
+	mapper.Config.KeepIndexes = true
+	writer.probeEmptiness(&mapper)
+	if reason, skip := mapper.ShouldSkip(); !skip || reason != ReasonNotEmpty {
+		t.Errorf("ShouldSkip() = %v, %v; want %v, true", reason, skip, ReasonNotEmpty)
+	}
+	if keep, err := writer.keepIndexes(&mapper); !keep || err != nil {
+		t.Errorf("keepIndexes() = %v, %v; want true", keep, err)
+	}
+	if notEmpty, err := writer.TableNotEmpty("public.full"); !notEmpty || err != nil {
+		t.Errorf("TableNotEmpty() = %v, %v; want true", notEmpty, err)
+	}
+	if queries["public.full"] != 1 {
+		t.Errorf("the checks queried the table %d times; want 1", queries["public.full"])
+	}
+
+	// written or truncated tables are checked again, or not at all
+	writer.emptiness.forget("public.full")
+	if notEmpty, _ := writer.TableNotEmpty("public.full"); !notEmpty || queries["public.full"] != 2 {
+		t.Errorf("TableNotEmpty() after forget = %v with %d queries; want true with 2", notEmpty,
+			queries["public.full"])
+	}
+	writer.emptiness.set("public.empty", false)
+	if notEmpty, _ := writer.TableNotEmpty("public.empty"); notEmpty || queries["public.empty"] != 0 {
+		t.Errorf("TableNotEmpty() of a truncated table = %v with %d queries; want false with none", notEmpty,
+			queries["public.empty"])
+	}
+}
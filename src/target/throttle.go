@@ -0,0 +1,205 @@
+package target
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// throttleBurst the time of writing at the full rate that a limiter allows in advance, after a pause
+const throttleBurst = 100 * time.Millisecond
+
+// throttleLogInterval the interval between the progress lines of a throttled COPY
+const throttleLogInterval = 10 * time.Second
+
+// writeRateLimit holds the limiters of the write rate shared by all COPY operations of the program;
+// it holds nil when there is no limit (see SetWriteRateLimit).
+var writeRateLimit atomic.Pointer[writeLimits]
+
+// writeLimits are the limiters of the write rate; a nil limiter means no limit of its unit.
+type writeLimits struct {
+	// rows limits the rows per second
+	rows *rateLimiter
+	// bytes limits the estimated bytes per second
+	bytes *rateLimiter
+}
+
+// SetWriteRateLimit limits the rate of the rows written by COPY in the whole program, in rows and in the estimated
+// bytes per second (see source.ParquetReader.RowSize), so that the tables loaded at the same time share the limits;
+// 0 means no limit. It is meant to be called once at startup.
+func SetWriteRateLimit(rowsPerSec int64, bytesPerSec float64) {
+	if rowsPerSec <= 0 && bytesPerSec <= 0 {
+		writeRateLimit.Store(nil)
+		return
+	}
+	limits := writeLimits{}
+	if rowsPerSec > 0 {
+		limits.rows = newRateLimiter(float64(rowsPerSec), time.Now)
+	}
+	if bytesPerSec > 0 {
+		limits.bytes = newRateLimiter(bytesPerSec, time.Now)
+	}
+	writeRateLimit.Store(&limits)
+}
+
+// rateLimiter is a token bucket: the tokens are added at a constant rate up to the burst, and every unit
+// written takes a token. A caller that takes more tokens than available goes into debt and waits until
+// it is paid off, so that the concurrent callers are paced in the order of their calls.
+type rateLimiter struct {
+	// mutex protects the tokens and the time of the last update
+	mutex sync.Mutex
+	// rate the number of tokens added per second
+	rate float64
+	// burst the maximal number of tokens
+	burst float64
+	// tokens the number of available tokens; negative while in debt
+	tokens float64
+	// last the time of the last update of the tokens
+	last time.Time
+	// now returns the current time (replaced by the tests)
+	now func() time.Time
+}
+
+// newRateLimiter creates a limiter of the given number of units per second, which starts with the full burst.
+func newRateLimiter(rate float64, now func() time.Time) *rateLimiter {
+	burst := max(1, rate*throttleBurst.Seconds())
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: now(), now: now}
+}
+
+// reserve takes the given number of tokens and returns how long the caller must wait before writing the units.
+func (l *rateLimiter) reserve(units float64) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = min(l.burst, l.tokens+elapsed.Seconds()*l.rate)
+		l.last = now
+	}
+	l.tokens -= units
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// rowSizer is implemented by the Parquet readers that estimate the size of their rows
+// (see source.ParquetReader.RowSize).
+type rowSizer interface {
+	// RowSize returns the average size of a row in bytes, or 0 if unknown
+	RowSize() float64
+}
+
+// throttledSource wraps a source of rows for COPY and paces its rows with the write limits (see SetWriteRateLimit),
+// logging the effective pace periodically.
+type throttledSource struct {
+	pgx.CopyFromSource
+
+	// ctx interrupts the waiting
+	ctx context.Context
+	// tableName the table for the progress log
+	tableName string
+	// limits the shared limiters
+	limits *writeLimits
+	// sizer estimates the size of the rows, or nil
+	sizer rowSizer
+	// now returns the current time (replaced by the tests)
+	now func() time.Time
+	// sleep waits for the given duration or until the context is cancelled (replaced by the tests)
+	sleep func(ctx context.Context, d time.Duration) error
+	// err the error that interrupted the waiting
+	err error
+	// start the time of the first row
+	start time.Time
+	// lastLog the time of the last progress line
+	lastLog time.Time
+	// rows the number of rows passed so far
+	rows int64
+	// bytes the estimated size of the rows passed so far
+	bytes float64
+	// waited the total time spent waiting for the limiters
+	waited time.Duration
+}
+
+// throttle paces the rows of copyFromSource with the write limits of the program, if any;
+// sizer estimates the size of the rows for the limit of bytes.
+func (w *DbWriter) throttle(tableName string, copyFromSource pgx.CopyFromSource, sizer rowSizer) pgx.CopyFromSource {
+	limits := writeRateLimit.Load()
+	if limits == nil {
+		return copyFromSource
+	}
+	return &throttledSource{CopyFromSource: copyFromSource, ctx: w.dbContext(), tableName: tableName, limits: limits,
+		sizer: sizer, now: time.Now, sleep: sleepContext}
+}
+
+// sleepContext waits for the given duration or until the context is cancelled, returning its error.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Next advances to the next row, waiting until the limits allow writing it.
+// It implements the interface pgx.CopyFromSource
+func (s *throttledSource) Next() bool {
+	if s.err != nil || !s.CopyFromSource.Next() {
+		return false
+	}
+	now := s.now()
+	if s.rows == 0 {
+		s.start, s.lastLog = now, now
+	}
+	var rowSize float64
+	if s.sizer != nil {
+		rowSize = s.sizer.RowSize()
+	}
+	var wait time.Duration
+	if s.limits.rows != nil {
+		wait = s.limits.rows.reserve(1)
+	}
+	if s.limits.bytes != nil && rowSize > 0 {
+		wait = max(wait, s.limits.bytes.reserve(rowSize))
+	}
+	s.rows++
+	s.bytes += rowSize
+	if wait > 0 {
+		s.waited += wait
+		if err := s.sleep(s.ctx, wait); err != nil {
+			s.err = err
+			return false
+		}
+	}
+	if now = s.now(); now.Sub(s.lastLog) >= throttleLogInterval {
+		s.lastLog = now
+		s.logPace(now)
+	}
+	return true
+}
+
+// Err returns the error that interrupted the waiting, or the error of the wrapped source.
+// It implements the interface pgx.CopyFromSource
+func (s *throttledSource) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.CopyFromSource.Err()
+}
+
+// logPace reports the effective write rate of the table and the share of the time spent waiting for the limits.
+func (s *throttledSource) logPace(now time.Time) {
+	elapsed := now.Sub(s.start).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	log.Info("Writing the table with the throttled rate", zap.String("table", s.tableName),
+		zap.Int64("rows", s.rows), zap.Float64("rows_per_sec", float64(s.rows)/elapsed),
+		zap.Float64("mb_per_sec", s.bytes/elapsed/1e6),
+		zap.Float64("throttled_ratio", min(1, s.waited.Seconds()/elapsed)))
+}
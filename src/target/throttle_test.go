@@ -0,0 +1,158 @@
+package target
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock for the pacing tests: sleeping advances the time instantly.
+type fakeClock struct {
+	current time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.current
+}
+
+func (c *fakeClock) sleep(_ context.Context, d time.Duration) error {
+	c.current = c.current.Add(d)
+	return nil
+}
+
+// sizedRows is a source of the given number of rows of the given size.
+type sizedRows struct {
+	rows    int
+	size    float64
+	current int
+}
+
+func (s *sizedRows) Next() bool {
+	if s.current >= s.rows {
+		return false
+	}
+	s.current++
+	return true
+}
+
+func (s *sizedRows) Values() ([]any, error) {
+	return []any{int64(s.current)}, nil
+}
+
+func (s *sizedRows) Err() error {
+	return nil
+}
+
+func (s *sizedRows) RowSize() float64 {
+	return s.size
+}
+
+// newFakeThrottledSource paces the rows with the limits using the fake clock.
+func newFakeThrottledSource(clock *fakeClock, rows *sizedRows, limits *writeLimits) *throttledSource {
+	return &throttledSource{CopyFromSource: rows, ctx: context.Background(), tableName: "public.t", limits: limits,
+		sizer: rows, now: clock.now, sleep: clock.sleep}
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	clock := &fakeClock{current: time.Unix(0, 0)}
+	limiter := newRateLimiter(10, clock.now)
+	if limiter.burst != 1 {
+		t.Fatalf("burst = %f; want 1", limiter.burst)
+	}
+	if wait := limiter.reserve(1); wait != 0 {
+		t.Errorf("reserve() of the burst = %s; want 0", wait)
+	}
+	if wait := limiter.reserve(1); wait != 100*time.Millisecond {
+		t.Errorf("reserve() over the burst = %s; want 100ms", wait)
+	}
+	if wait := limiter.reserve(1); wait != 200*time.Millisecond {
+		t.Errorf("reserve() in debt = %s; want 200ms", wait)
+	}
+	clock.current = clock.current.Add(time.Hour)
+	if wait := limiter.reserve(1); wait != 0 {
+		t.Errorf("reserve() after a pause = %s; want 0, the tokens are capped by the burst", wait)
+	}
+	if wait := limiter.reserve(1); wait != 100*time.Millisecond {
+		t.Errorf("reserve() after the burst = %s; want 100ms", wait)
+	}
+}
+
+func TestThrottledSourcePace(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits func(now func() time.Time) *writeLimits
+	}{
+		{name: "rows", limits: func(now func() time.Time) *writeLimits {
+			return &writeLimits{rows: newRateLimiter(1000, now)}
+		}},
+		{name: "bytes", limits: func(now func() time.Time) *writeLimits {
+			return &writeLimits{bytes: newRateLimiter(1e6, now)}
+		}},
+		{name: "the slower of both", limits: func(now func() time.Time) *writeLimits {
+			return &writeLimits{rows: newRateLimiter(5000, now), bytes: newRateLimiter(1e6, now)}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 10000 rows of 1000 bytes at 1000 rows (1 MB) per second take 10 seconds
+			clock := &fakeClock{current: time.Unix(0, 0)}
+			source := newFakeThrottledSource(clock, &sizedRows{rows: 10000, size: 1000}, tt.limits(clock.now))
+			start := clock.now()
+			rows := 0
+			for source.Next() {
+				rows++
+			}
+			if rows != 10000 || source.Err() != nil {
+				t.Fatalf("Next() passed %d rows, Err() = %v; want 10000 rows", rows, source.Err())
+			}
+			if elapsed := clock.now().Sub(start).Seconds(); math.Abs(elapsed-10) > 0.2 {
+				t.Errorf("the load took %.3f seconds; want 10 seconds within 0.2 seconds", elapsed)
+			}
+		})
+	}
+}
+
+func TestThrottledSourceShared(t *testing.T) {
+	// two tables loaded at the same time share the limit of 1000 rows per second
+	clock := &fakeClock{current: time.Unix(0, 0)}
+	limits := &writeLimits{rows: newRateLimiter(1000, clock.now)}
+	first := newFakeThrottledSource(clock, &sizedRows{rows: 5000}, limits)
+	second := newFakeThrottledSource(clock, &sizedRows{rows: 5000}, limits)
+	start := clock.now()
+	for first.Next() && second.Next() {
+	}
+	if elapsed := clock.now().Sub(start).Seconds(); math.Abs(elapsed-10) > 0.2 {
+		t.Errorf("the load took %.3f seconds; want 10 seconds within 0.2 seconds", elapsed)
+	}
+}
+
+func TestThrottledSourceCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	source := &throttledSource{CopyFromSource: &sizedRows{rows: 10}, ctx: ctx, tableName: "public.t",
+		limits: &writeLimits{rows: newRateLimiter(0.001, time.Now)}, now: time.Now, sleep: sleepContext}
+	rows := 0
+	for source.Next() {
+		rows++
+	}
+	if rows != 1 || !errors.Is(source.Err(), context.Canceled) {
+		t.Errorf("Next() passed %d rows, Err() = %v; want 1 row of the burst and context.Canceled", rows, source.Err())
+	}
+}
+
+func TestThrottledSourceRealClock(t *testing.T) {
+	const rate, rows = 200, 60
+	limits := &writeLimits{rows: newRateLimiter(rate, time.Now)}
+	source := &throttledSource{CopyFromSource: &sizedRows{rows: rows}, ctx: context.Background(), tableName: "public.t",
+		limits: limits, now: time.Now, sleep: sleepContext}
+	// the rows of the burst are not delayed
+	expected := time.Duration(float64(rows-limits.rows.burst) / rate * float64(time.Second))
+	start := time.Now()
+	for source.Next() {
+	}
+	if elapsed := time.Since(start); elapsed < expected*9/10 || elapsed > expected+time.Second {
+		t.Errorf("the load took %s; want about %s", elapsed, expected)
+	}
+}
@@ -0,0 +1,79 @@
+package target
+
+import (
+	"context"
+	"dbrestore/utils"
+	"errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// transientRetryDelay the delay before the first retry after a transient database error; it doubles with every
+// retry up to maxTransientRetryDelay (a variable, so that the tests do not wait)
+var transientRetryDelay = time.Second
+
+// maxTransientRetryDelay the maximal delay between the retries after transient database errors
+const maxTransientRetryDelay = 30 * time.Second
+
+// transientSQLStates the SQLSTATE codes of the server errors that may not happen again when the statement
+// is retried: serialization_failure, deadlock_detected, admin_shutdown, crash_shutdown and cannot_connect_now;
+// the class 08 (connection exceptions) is transient as well
+var transientSQLStates = map[string]struct{}{"40001": {}, "40P01": {}, "57P01": {}, "57P02": {}, "57P03": {}}
+
+// isTransientError checks whether a failed database operation is worth retrying: the connection broke
+// (for example, "unexpected EOF" over a flaky network), the statement was not sent at all, or the server reported
+// a serialization failure or its shutdown. The errors of the data and the constraints, the fatal errors,
+// the table timeout and the cancellation are not transient.
+func isTransientError(err error) bool {
+	if err == nil || utils.IsFatalError(err) || errors.Is(err, ErrTableTimeout) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		_, transient := transientSQLStates[pgErr.Code]
+		return transient || strings.HasPrefix(pgErr.Code, "08")
+	}
+	var netErr net.Error
+	return pgconn.SafeToRetry(err) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
+
+// waitTransientRetry checks whether the operation that failed in the given attempt is retried (see MaxAttempts
+// and isTransientError), and waits with an exponential backoff before the retry; it returns false without waiting
+// when the operation is not retried, and when the context is cancelled while waiting.
+func (w *DbWriter) waitTransientRetry(ctx context.Context, attempt int, err error, fields ...zap.Field) bool {
+	if attempt >= w.MaxAttempts || !isTransientError(err) {
+		return false
+	}
+	delay := transientRetryDelay
+	for i := 1; i < attempt && delay < maxTransientRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxTransientRetryDelay)
+	log.Warn("Transient database error, retrying", append(fields, zap.Int("attempt", attempt),
+		zap.Int("max_attempts", w.MaxAttempts), zap.Duration("delay", delay), zap.Error(err))...)
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// retryTransient runs a database operation that is safe to repeat, like a metadata query, retrying it after
+// transient errors (see waitTransientRetry). The operations of a writer bound to a connection (see acquire)
+// run once, because a broken connection or an aborted transaction cannot be retried statement by statement;
+// the whole table is retried instead (see WriteTable).
+func (w *DbWriter) retryTransient(operation string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if w.acquired != nil || w.pool == nil ||
+			!w.waitTransientRetry(w.dbContext(), attempt, err, zap.String("operation", operation)) {
+			return err
+		}
+	}
+}
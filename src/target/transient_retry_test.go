@@ -0,0 +1,86 @@
+package target
+
+import (
+	"context"
+	"dbrestore/utils"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "unexpected EOF", err: fmt.Errorf("COPY failed: %w", io.ErrUnexpectedEOF), expected: true},
+		{name: "network", err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")},
+			expected: true},
+		{name: "serialization failure", err: &pgconn.PgError{Code: "40001"}, expected: true},
+		{name: "admin shutdown", err: &pgconn.PgError{Code: "57P01"}, expected: true},
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}, expected: true},
+		{name: "unique violation", err: &pgconn.PgError{Code: "23505"}},
+		{name: "invalid text", err: &pgconn.PgError{Code: "22P02"}},
+		{name: "data error", err: errors.New("column 'id': invalid syntax")},
+		{name: "fatal", err: utils.NewFatalError(io.ErrUnexpectedEOF)},
+		{name: "table timeout", err: fmt.Errorf("%w: %w", ErrTableTimeout, io.ErrUnexpectedEOF)},
+		{name: "cancelled", err: fmt.Errorf("interrupted: %w", context.Canceled)},
+		{name: "nil"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := isTransientError(tt.err); result != tt.expected {
+				t.Errorf("isTransientError(%v) = %v; want %v", tt.err, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	defer func(delay time.Duration) { transientRetryDelay = delay }(transientRetryDelay)
+	transientRetryDelay = time.Millisecond
+	// the pool connects lazily, and the operations below do not use it
+	pool, err := pgxpool.New(context.Background(), closedPortURL(t))
+	if err != nil {
+		t.Fatalf("pgxpool.New() error: %v", err)
+	}
+	writer := DbWriter{pool: pool, db: pool, MaxAttempts: 3}
+	defer writer.Close()
+
+	attempts := 0
+	failing := func(errs ...error) func() error {
+		attempts = 0
+		return func() error {
+			attempts++
+			if attempts <= len(errs) {
+				return errs[attempts-1]
+			}
+			return nil
+		}
+	}
+	if err := writer.retryTransient("query", failing(io.ErrUnexpectedEOF)); err != nil || attempts != 2 {
+		t.Errorf("retryTransient() = %v after %d attempts; want success after 2", err, attempts)
+	}
+	err = writer.retryTransient("query", failing(io.ErrUnexpectedEOF, io.ErrUnexpectedEOF, io.ErrUnexpectedEOF))
+	if !errors.Is(err, io.ErrUnexpectedEOF) || attempts != 3 {
+		t.Errorf("retryTransient() = %v after %d attempts; want the error after 3", err, attempts)
+	}
+	violation := &pgconn.PgError{Code: "23505"}
+	if err := writer.retryTransient("query", failing(violation)); err != violation || attempts != 1 {
+		t.Errorf("retryTransient() = %v after %d attempts; want the constraint violation at once", err, attempts)
+	}
+
+	// a writer bound to a connection does not retry single statements
+	writer.acquired = &pgxpool.Conn{}
+	if err := writer.retryTransient("query", failing(io.ErrUnexpectedEOF)); err == nil || attempts != 1 {
+		t.Errorf("retryTransient() of an acquired connection = %v after %d attempts; want 1", err, attempts)
+	}
+	writer.acquired = nil
+}
@@ -0,0 +1,91 @@
+package target
+
+import (
+	"context"
+	"dbrestore/utils"
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+// truncateProgressInterval the number of tables between the progress lines of TruncateAllTables
+const truncateProgressInterval = 100
+
+// truncateExactCountMaxBytes the maximal size of a table whose rows are counted exactly before truncating it;
+// the rows of the larger tables are estimated from the planner statistics (see TruncateResult.RowsEstimated)
+const truncateExactCountMaxBytes = 16 * 1024 * 1024
+
+// TruncateResult is the result of truncating a table (see DbWriter.TruncateAllTables).
+type TruncateResult struct {
+	// Table the name of the table including the schema name
+	Table string
+	// Truncated indicates that the table was truncated; empty tables are left as is
+	Truncated bool
+	// Rows the number of rows removed from the table
+	Rows int64
+	// RowsEstimated indicates that Rows is the estimate of the planner statistics rather than the exact count
+	// (of the tables larger than truncateExactCountMaxBytes)
+	RowsEstimated bool
+	// Duration the time of counting the rows and truncating the table
+	Duration time.Duration
+}
+
+// TruncateAllTables truncates the specified tables in reverse order if they are not empty and returns the results
+// of the processed tables, including the tables processed before an error. Cancelling the context stops
+// at the current table.
+func (w *DbWriter) TruncateAllTables(ctx context.Context, tables []string) (ret []TruncateResult, err error) {
+	defer w.bindContext(ctx)()
+	for i := len(tables) - 1; i >= 0; i-- {
+		result, err := w.truncateTable(tables[i])
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, result)
+		if done := len(ret); done%truncateProgressInterval == 0 && done < len(tables) {
+			log.Info("Truncating tables", zap.Int("done", done), zap.Int("total", len(tables)))
+		}
+	}
+	return ret, nil
+}
+
+// truncateTable counts the rows of the table (see TruncateResult.RowsEstimated) and truncates it if it is not empty.
+func (w *DbWriter) truncateTable(table string) (ret TruncateResult, err error) {
+	start := time.Now()
+	ret.Table = table
+	sanitizedTable, err := utils.SanitizeTableName(table)
+	if err != nil {
+		return ret, fmt.Errorf("truncating table '%s' failed: %w", table, err)
+	}
+	var estimate, size int64
+	err = w.db.QueryRow(w.dbContext(), selectTableEstimate, sanitizedTable).Scan(&estimate, &size)
+	if err != nil {
+		return ret, fmt.Errorf("estimating the size of table '%s' failed: %w", table, err)
+	}
+	var tableNotEmpty bool
+	if size <= truncateExactCountMaxBytes {
+		err = w.db.QueryRow(w.dbContext(), fmt.Sprintf(selectTableSize, sanitizedTable)).Scan(&ret.Rows)
+		tableNotEmpty = ret.Rows > 0
+	} else {
+		err = w.db.QueryRow(w.dbContext(), fmt.Sprintf(checkIfTableIsNotEmpty, sanitizedTable)).Scan(&tableNotEmpty)
+		ret.Rows, ret.RowsEstimated = estimate, true
+	}
+	if err != nil {
+		return ret, fmt.Errorf("checking if table '%s' is not empty failed: %w", table, err)
+	}
+	if !tableNotEmpty {
+		w.emptiness.set(table, false)
+		ret.Rows = 0
+		ret.Duration = time.Since(start)
+		return ret, nil
+	}
+	_, err = w.db.Exec(w.dbContext(), fmt.Sprintf(truncateTable, sanitizedTable))
+	if err != nil {
+		return ret, fmt.Errorf("truncating table '%s' failed: %w", table, err)
+	}
+	w.emptiness.set(table, false)
+	ret.Truncated = true
+	ret.Duration = time.Since(start)
+	log.Info("Truncated table", zap.String("table", table), zap.Int64("rows", ret.Rows),
+		zap.Bool("rows_estimated", ret.RowsEstimated), zap.Duration("time", ret.Duration))
+	return ret, nil
+}
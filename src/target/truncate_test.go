@@ -0,0 +1,42 @@
+package target
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestTruncateAllTables(t *testing.T) {
+	withTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		_, err := db.Exec(context.Background(), `CREATE TABLE truncate_full (id BIGINT);
+			INSERT INTO truncate_full SELECT generate_series(1, 25);
+			CREATE TABLE truncate_empty (id BIGINT);`)
+		if err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+		writer := DbWriter{db: db}
+		results, err := writer.TruncateAllTables(context.Background(), []string{"public.truncate_full", "public.truncate_empty"})
+		if err != nil {
+			t.Fatalf("TruncateAllTables() error: %v", err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("TruncateAllTables() returned %d results; want 2", len(results))
+		}
+		// the tables are truncated in the reverse order
+		if results[0].Table != "public.truncate_empty" || results[0].Truncated || results[0].Rows != 0 {
+			t.Errorf("results[0] = %+v; want the empty table left as is", results[0])
+		}
+		if results[1].Table != "public.truncate_full" || !results[1].Truncated || results[1].Rows != 25 ||
+			results[1].RowsEstimated {
+			t.Errorf("results[1] = %+v; want 25 counted rows removed", results[1])
+		}
+		var count int
+		if err := db.QueryRow(context.Background(), "SELECT COUNT(*) FROM truncate_full").Scan(&count); err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if count != 0 {
+			t.Errorf("The table has %d rows after truncation; want 0", count)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package target
+
+import (
+	"dbrestore/source"
+	"dbrestore/utils"
+	"fmt"
+	"go.uber.org/zap"
+)
+
+// RowCountCheck is the result of the post-restore reconciliation of a table:
+// the rows expected from the Parquet files against the rows actually present in the table.
+type RowCountCheck struct {
+	// Table the name of the table including the schema name
+	Table string
+	// Expected the number of rows in the table before loading plus the number of rows in its Parquet files,
+	// except the rows dropped on purpose (see RowAccounting)
+	Expected int64
+	// Actual the number of rows in the table after loading
+	Actual int64
+}
+
+// Delta returns the difference between the actual and the expected number of rows.
+func (c RowCountCheck) Delta() int64 {
+	return c.Actual - c.Expected
+}
+
+// TableRowCount returns the number of rows in the table.
+func (w *DbWriter) TableRowCount(tableName string) (ret int64, err error) {
+	sanitizedTable, err := utils.SanitizeTableName(tableName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the rows: %w", err)
+	}
+	query := fmt.Sprintf(selectTableSize, sanitizedTable)
+	err = w.retryTransient("count the rows", func() error {
+		return w.db.QueryRow(w.dbContext(), query).Scan(&ret)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the rows of the table '%s': %w", tableName, err)
+	}
+	w.emptiness.set(tableName, ret > 0)
+	return ret, nil
+}
+
+// ParquetRowCount returns the total number of rows in the Parquet files of the table,
+// read from the footers of the files independently of loading them (see ListTableParts).
+func ParquetRowCount(src source.Source, sourceDatabase string, tableName string) (ret int64, err error) {
+	parts, err := ListTableParts(src, sourceDatabase, tableName)
+	if err != nil {
+		return 0, err
+	}
+	for _, part := range parts {
+		ret += part.Rows
+	}
+	return ret, nil
+}
+
+// ReportRowCounts logs the summary of the row count reconciliation and returns the number of mismatching tables.
+// With conflictsSkipped (see config.Config.OnConflictSkip) the tables may legitimately have fewer rows
+// than expected, so only the tables with more rows than expected are mismatches.
+func ReportRowCounts(checks []RowCountCheck, conflictsSkipped bool) (mismatches int) {
+	var expected, actual int64
+	for _, check := range checks {
+		expected += check.Expected
+		actual += check.Actual
+		fields := []zap.Field{zap.String("table", check.Table), zap.Int64("expected", check.Expected),
+			zap.Int64("actual", check.Actual), zap.Int64("delta", check.Delta())}
+		switch {
+		case check.Delta() == 0:
+			log.Info("Row count verified", fields...)
+		case check.Delta() < 0 && conflictsSkipped:
+			log.Info("Row count verified, conflicting rows were skipped", fields...)
+		default:
+			mismatches++
+			log.Error("Row count mismatch", fields...)
+		}
+	}
+	log.Info("Row count verification finished", zap.Int("tables", len(checks)),
+		zap.Int("mismatches", mismatches), zap.Int64("expected", expected), zap.Int64("actual", actual))
+	return mismatches
+}
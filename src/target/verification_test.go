@@ -0,0 +1,48 @@
+package target
+
+import (
+	"dbrestore/source"
+	"testing"
+)
+
+func TestReportRowCounts(t *testing.T) {
+	checks := []RowCountCheck{
+		{Table: "public.equal", Expected: 10, Actual: 10},
+		{Table: "public.fewer", Expected: 10, Actual: 7},
+		{Table: "public.more", Expected: 10, Actual: 12},
+	}
+	tests := []struct {
+		name               string
+		conflictsSkipped   bool
+		expectedMismatches int
+	}{
+		{name: "plain load", conflictsSkipped: false, expectedMismatches: 2},
+		{name: "conflicts skipped", conflictsSkipped: true, expectedMismatches: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if mismatches := ReportRowCounts(checks, tt.conflictsSkipped); mismatches != tt.expectedMismatches {
+				t.Errorf("ReportRowCounts() = %d; want %d", mismatches, tt.expectedMismatches)
+			}
+		})
+	}
+	if delta := checks[1].Delta(); delta != -3 {
+		t.Errorf("Delta() = %d; want -3", delta)
+	}
+}
+
+func TestParquetRowCount(t *testing.T) {
+	root := writeTableFixture(t, "t", map[string][]partRow{
+		"1/part-00000.parquet": make([]partRow, 3),
+		"2/part-00000.parquet": make([]partRow, 4),
+	})
+
+	rows, err := ParquetRowCount(source.NewLocalSource(root), "db", "public.t")
+	if err != nil {
+		t.Fatalf("ParquetRowCount() error: %v", err)
+	}
+	if rows != 7 {
+		t.Errorf("ParquetRowCount() = %d; want 7", rows)
+	}
+}
@@ -0,0 +1,100 @@
+// Package testdb provides the temporary databases in the local PostgreSQL for the tests of all packages.
+// The password of the postgres user is read from the file .test_config.yaml in the src folder; the tests
+// using a database are skipped when the file is missing.
+package testdb
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"gopkg.in/yaml.v3"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ConfigFileName the name of the local test configuration file in the src folder (next to go.mod)
+const ConfigFileName = ".test_config.yaml"
+
+// databaseNamePrefix the prefix of the names of the temporary test databases
+const databaseNamePrefix = "test_database_"
+
+// connectionString the connection string of a database in the local PostgreSQL, formatted with the password
+// and the name of the database
+const connectionString = "postgresql://postgres:%s@localhost:5432/%s"
+
+// configFile returns the path of the local test configuration file: it is searched for from the working directory
+// of the test, which is the folder of its package, up to the folder of go.mod.
+func configFile() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return filepath.Join(dir, ConfigFileName), nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("go.mod not found above the working directory")
+		}
+		dir = parent
+	}
+}
+
+// password returns the password of the postgres user from the local test configuration file;
+// the test is skipped when the file is missing.
+func password(t *testing.T) string {
+	fileName, err := configFile()
+	if err != nil {
+		t.Fatalf("Failed to find the local test configuration: %v", err)
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Skipf("Local PostgreSQL test configuration is missing: %s", fileName)
+	}
+	var testConfig map[string]any
+	if err := yaml.Unmarshal(content, &testConfig); err != nil {
+		t.Fatalf("Failed to parse the local test configuration %s: %v", fileName, err)
+	}
+	pwd, _ := testConfig["password"].(string)
+	return pwd
+}
+
+// WithTestDatabase creates a temporary test database in the local PostgreSQL, connects to it,
+// runs the test function and drops the database at the end.
+// The test is skipped when the local test configuration file is missing.
+func WithTestDatabase(t *testing.T, fn func(t *testing.T, db *pgx.Conn, connectionString string)) {
+	pwd := password(t)
+	db, err := pgx.Connect(context.Background(), fmt.Sprintf(connectionString, pwd, "postgres"))
+	if err != nil {
+		t.Fatalf("WithTestDatabase() error: %v", err)
+	}
+	defer func() {
+		_ = db.Close(context.Background())
+	}()
+
+	testDatabaseName := databaseNamePrefix + fmt.Sprintf("%d", 1000+rand.Intn(9000))
+	_, err = db.Exec(context.Background(), fmt.Sprintf("CREATE DATABASE %s;", testDatabaseName))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer func() {
+		_, err = db.Exec(context.Background(), fmt.Sprintf("DROP DATABASE %s WITH (FORCE);", testDatabaseName))
+		if err != nil {
+			t.Errorf("Failed to drop test database '%s': %v", testDatabaseName, err)
+		}
+	}()
+
+	testConnectionString := fmt.Sprintf(connectionString, pwd, testDatabaseName)
+	testDb, err := pgx.Connect(context.Background(), testConnectionString)
+	if err != nil {
+		t.Fatalf("WithTestDatabase() error: %v", err)
+	}
+	defer func() {
+		_ = testDb.Close(context.Background())
+	}()
+
+	fn(t, testDb, testConnectionString)
+}
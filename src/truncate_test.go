@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	config2 "dbrestore/config"
+	source2 "dbrestore/source"
+	"dbrestore/testdb"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestPlanTruncation(t *testing.T) {
+	// public.a <- public.b <- public.c, public.d; only public.a and public.d are in the export
+	tables := []string{"public.a", "public.d", "public.b", "public.c", "public.e"}
+	references := map[string][]string{"public.b": {"public.a"}, "public.c": {"public.b"}}
+	loaded := map[string]source2.ParquetFileInfo{"public.a": {}, "public.d": {}}
+	plan := planTruncation(tables, loaded, false, func(table string) []string { return references[table] })
+	expected := truncationPlan{truncate: []string{"public.a", "public.d"}, kept: []string{"public.e"},
+		cascaded: []string{"public.b", "public.c"}}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("planTruncation() = %+v; want %+v", plan, expected)
+	}
+
+	plan = planTruncation(tables, loaded, true, func(table string) []string { return references[table] })
+	expected = truncationPlan{truncate: tables, notInExport: 3}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("planTruncation() of all tables = %+v; want %+v", plan, expected)
+	}
+}
+
+func TestTruncateLoadedTables(t *testing.T) {
+	testdb.WithTestDatabase(t, func(t *testing.T, db *pgx.Conn, connectionString string) {
+		// the export has only golden_parent and golden_child, the other tables keep their rows by default
+		_, err := db.Exec(context.Background(), `CREATE TABLE golden_parent (id BIGINT PRIMARY KEY, name TEXT);
+			CREATE TABLE golden_child (id BIGINT PRIMARY KEY, parent_id BIGINT REFERENCES golden_parent (id));
+			CREATE TABLE golden_audit (id BIGINT PRIMARY KEY, note TEXT);
+			CREATE TABLE golden_setting (name TEXT PRIMARY KEY, value TEXT);
+			INSERT INTO golden_parent VALUES (1, 'old'), (7, 'stale');
+			INSERT INTO golden_child VALUES (70, 7);
+			INSERT INTO golden_audit VALUES (1, 'kept');
+			INSERT INTO golden_setting VALUES ('mode', 'kept');`)
+		if err != nil {
+			t.Fatalf("Failed to create the tables: %v", err)
+		}
+		counts := func() map[string]int64 {
+			ret := make(map[string]int64)
+			for _, table := range []string{"golden_parent", "golden_child", "golden_audit", "golden_setting"} {
+				var count int64
+				if err := db.QueryRow(context.Background(), "SELECT count(*) FROM "+table).Scan(&count); err != nil {
+					t.Fatalf("Failed to count the rows of %s: %v", table, err)
+				}
+				ret[table] = count
+			}
+			return ret
+		}
+		root := writeExportFixture(t, t.TempDir())
+		restore := func(truncateTargetAll bool) error {
+			conf := &config2.Config{LocalDir: root, SourceDatabase: "db", DBURL: connectionString,
+				ParquetBatchSize: 1000, UnknownTypeFallback: config2.UnknownTypeString,
+				CopyCountMismatch: config2.CopyCountMismatchError, TableTimeoutAction: config2.TableTimeoutAbort,
+				TruncateAllCommand: true, TruncateTargetAll: truncateTargetAll}
+			return run(context.Background(), conf, newCheckpoint())
+		}
+
+		if err := restore(false); err != nil {
+			t.Fatalf("run() error: %v", err)
+		}
+		expected := map[string]int64{"golden_parent": 3, "golden_child": 3, "golden_audit": 1, "golden_setting": 1}
+		if actual := counts(); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("the row counts after the restore = %v; want %v, the tables missing in the export "+
+				"keep their rows", actual, expected)
+		}
+
+		if err := restore(true); err != nil {
+			t.Fatalf("run() with --truncate-target-all error: %v", err)
+		}
+		expected = map[string]int64{"golden_parent": 3, "golden_child": 3, "golden_audit": 0, "golden_setting": 0}
+		if actual := counts(); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("the row counts after the restore with --truncate-target-all = %v; want %v", actual, expected)
+		}
+
+		// a table missing in the export that references a loaded table would be emptied by the cascade
+		if _, err := db.Exec(context.Background(), `CREATE TABLE golden_note (id BIGINT PRIMARY KEY,
+			parent_id BIGINT REFERENCES golden_parent (id)); INSERT INTO golden_note VALUES (1, 1);`); err != nil {
+			t.Fatalf("Failed to create the table: %v", err)
+		}
+		err = restore(false)
+		if err == nil || !strings.Contains(err.Error(), "public.golden_note") {
+			t.Errorf("run() = %v; want the error naming public.golden_note", err)
+		}
+		if actual := counts(); actual["golden_parent"] != 3 {
+			t.Errorf("the rows of golden_parent = %d; want 3, nothing is truncated", actual["golden_parent"])
+		}
+	})
+}
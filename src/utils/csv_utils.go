@@ -1,8 +1,8 @@
 package utils
 
 import (
+	"bufio"
 	"context"
-	"encoding/csv"
 	"fmt"
 	"github.com/jackc/pgx/v5"
 	"go.uber.org/zap"
@@ -10,145 +10,269 @@ import (
 	"strings"
 )
 
-// NeverHappeningCharacter is a constant representing a rarely-used ASCII character (\x7F)
-// for internal placeholder usage. This is the Delete control character.
-// - This character is not printable. It is primarily used for legacy systems or specific communication protocols
-// (e.g., terminal controls). Therefore, it never supposed to appear in an input string.
-// IMPORTANT: We need it for a dirty trick to enforce the "encoding/csv" package to distinguish
-// between nil and "" values, which is critical for correct processing in PostgreSQL.
-const NeverHappeningCharacter = "\x7F"
+// CopyCSVOptions are the options of the CSV format of COPY FROM (see SQL and ConvertToCSVReader).
+// The empty values mean the defaults of PostgreSQL: a comma delimiter, a double quote, the escape character
+// equal to the quote, and NULL written as an unquoted empty string. The YAML keys are used in the configuration file.
+type CopyCSVOptions struct {
+	// Delimiter the single-byte character separating the values, for example "\t"
+	Delimiter string `yaml:"delimiter"`
+	// Quote the single-byte character quoting the values
+	Quote string `yaml:"quote"`
+	// Escape the single-byte character preceding the quote (or itself) inside a quoted value
+	Escape string `yaml:"escape"`
+	// Null the string representing NULL; the values equal to it are quoted
+	// (the key "null" would be read by YAML as a null value, hence "null_string")
+	Null string `yaml:"null_string"`
+	// Header writes the column names as the first line, which COPY skips
+	Header bool `yaml:"header"`
+}
 
-// ConvertToCSVReader converts a ParquetReader source into an io.Reader providing CSV data,
-// utilizing a streaming approach (with a pipe inside).
-// It processes rows from the ParquetReader and writes them as CSV records to a pipe
-// for consumption by the returned reader.
-// Context cancellation is supported to terminate processing early,
-// ensuring the PipeWriter and CSV writer are closed properly.
-// The returned CSV stream is specially prepared for PostgreSQL, so that NULL values and empty strings
-// are recognized properly by PostgreSQL.
-// This is not quite supported by the "encoding/csv" package and requires a dirty (and inefficient) hack
-// (I could not find a better approach).
-// When passing nil values to "encoding/csv", we replace them with unquoted empty strings -
-// PostgreSQL recognizes those as NULLs.
-// But when passing empty strings, we replace them with NeverHappeningCharacter,
-// and after "encoding/csv" generates our CSV, we replace this character with double quotes -
-// PostgreSQL recognizes those as empty strings and not NULLs.
-func ConvertToCSVReader(ctx context.Context, source pgx.CopyFromSource) (io.Reader, error) {
-	pr, pw := io.Pipe() // Create a pipe for streaming
+// delimiter returns the delimiter character, or the default comma.
+func (o CopyCSVOptions) delimiter() byte {
+	return optionByte(o.Delimiter, ',')
+}
 
-	go func() {
-		defer func(pw *io.PipeWriter) {
-			err := pw.Close()
-			if err != nil {
-				Logger.Error("Error closing pipe writer", zap.Error(err))
-			}
-		}(pw) // Close the writer when done
+// quote returns the quote character, or the default double quote.
+func (o CopyCSVOptions) quote() byte {
+	return optionByte(o.Quote, '"')
+}
 
-		csvWriter := csv.NewWriter(pw)
+// escape returns the escape character, or the quote character by default.
+func (o CopyCSVOptions) escape() byte {
+	return optionByte(o.Escape, o.quote())
+}
 
-		for source.Next() {
-			select {
-			case <-ctx.Done(): // Check for cancellation
-				csvWriter.Flush()
-				if err := csvWriter.Error(); err != nil {
-					Logger.Error("Error during flush after cancellation", zap.Error(err))
-				}
-				return // Exit goroutine if context is cancelled
-			default:
-				values, err := source.Values()
-				if err != nil {
-					Logger.Error("Error getting values", zap.Error(err))
-					return // Exit goroutine on error
-				}
-
-				record := make([]string, len(values))
-				for i, v := range values {
-					if v == nil {
-						record[i] = ""
-					} else {
-						record[i] = fmt.Sprint(v) // Convert all values to string
-						// IMPORTANT: We need it for a dirty trick to enforce the "encoding/csv" package to distinguish
-						// between nil and "" values, which is critical for correct processing in PostgreSQL.
-						if record[i] == "" {
-							record[i] = NeverHappeningCharacter
-						}
-					}
-				}
-
-				if err := csvWriter.Write(record); err != nil {
-					Logger.Error("Error writing CSV record", zap.Error(err))
-					return // Exit goroutine on error
-				}
-			}
+// optionByte returns the character of a single-byte option, or the default if the option is empty.
+func optionByte(option string, defaultValue byte) byte {
+	if option == "" {
+		return defaultValue
+	}
+	return option[0]
+}
+
+// Validate checks the options the same way as PostgreSQL does, so that a wrong combination fails before loading:
+// the delimiter, the quote and the escape must be single-byte characters other than a line break, the delimiter
+// must differ from the quote, and the NULL string must contain neither a line break, nor the delimiter,
+// nor the quote.
+func (o CopyCSVOptions) Validate() error {
+	for _, option := range []struct{ name, value string }{
+		{"delimiter", o.Delimiter}, {"quote", o.Quote}, {"escape", o.Escape},
+	} {
+		if option.value == "" {
+			continue
+		}
+		if len(option.value) != 1 {
+			return fmt.Errorf("the CSV %s must be a single one-byte character, got '%s'", option.name, option.value)
+		}
+		if option.value == "\r" || option.value == "\n" {
+			return fmt.Errorf("the CSV %s cannot be a line break", option.name)
 		}
+	}
+	if o.delimiter() == o.quote() {
+		return fmt.Errorf("the CSV delimiter and quote must differ, both are '%c'", o.delimiter())
+	}
+	if strings.ContainsAny(o.Null, "\r\n") {
+		return fmt.Errorf("the CSV NULL string cannot contain a line break")
+	}
+	if strings.IndexByte(o.Null, o.delimiter()) >= 0 || strings.IndexByte(o.Null, o.quote()) >= 0 {
+		return fmt.Errorf("the CSV NULL string '%s' cannot contain the delimiter or the quote", o.Null)
+	}
+	return nil
+}
 
-		if err := source.Err(); err != nil {
-			Logger.Error("Error from source", zap.Error(err))
+// SQL returns the options of COPY for the CSV format, for example "FORMAT CSV, DELIMITER E'\t'";
+// the options equal to the defaults are omitted.
+func (o CopyCSVOptions) SQL() string {
+	ret := []string{"FORMAT CSV"}
+	if o.Delimiter != "" && o.Delimiter != "," {
+		ret = append(ret, "DELIMITER "+quoteCopyOption(o.Delimiter))
+	}
+	if o.Quote != "" && o.Quote != `"` {
+		ret = append(ret, "QUOTE "+quoteCopyOption(o.Quote))
+	}
+	if o.Escape != "" && o.escape() != o.quote() {
+		ret = append(ret, "ESCAPE "+quoteCopyOption(o.Escape))
+	}
+	if o.Null != "" {
+		ret = append(ret, "NULL "+quoteCopyOption(o.Null))
+	}
+	if o.Header {
+		ret = append(ret, "HEADER")
+	}
+	return strings.Join(ret, ", ")
+}
+
+// quoteCopyOption quotes the value of a COPY option as a string literal; the escape string syntax (E'...')
+// is used for the values with control characters or backslashes, so that they are readable in the logs.
+func quoteCopyOption(value string) string {
+	if !strings.ContainsFunc(value, func(r rune) bool { return r < ' ' || r == '\\' }) {
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	var ret strings.Builder
+	ret.WriteString("E'")
+	for _, r := range value {
+		switch {
+		case r == '\'' || r == '\\':
+			ret.WriteRune('\\')
+			ret.WriteRune(r)
+		case r == '\t':
+			ret.WriteString(`\t`)
+		case r < ' ':
+			ret.WriteString(fmt.Sprintf(`\x%02X`, r))
+		default:
+			ret.WriteRune(r)
 		}
+	}
+	ret.WriteString("'")
+	return ret.String()
+}
+
+// csvEncoder writes the records in the CSV format of COPY FROM according to the options. Unlike "encoding/csv",
+// it distinguishes NULL from an empty string: nil is written as the NULL string, and a value equal to the NULL
+// string (for example an empty string with the default options) is quoted, which PostgreSQL reads as a value.
+type csvEncoder struct {
+	// writer the buffered output
+	writer *bufio.Writer
+	// delimiter, quote and escape the characters of the format
+	delimiter, quote, escape byte
+	// null the string representing NULL
+	null string
+}
+
+// newCSVEncoder creates an encoder writing to the writer with the (valid) options.
+func newCSVEncoder(writer io.Writer, options CopyCSVOptions) *csvEncoder {
+	return &csvEncoder{writer: bufio.NewWriter(writer), delimiter: options.delimiter(), quote: options.quote(),
+		escape: options.escape(), null: options.Null}
+}
 
-		csvWriter.Flush()
-		if err := csvWriter.Error(); err != nil {
-			Logger.Error("Error flushing CSV writer", zap.Error(err))
+// write writes a record of values; the values other than nil and strings are formatted with fmt.Sprint.
+func (e *csvEncoder) write(values []any) error {
+	for i, value := range values {
+		if i > 0 {
+			_ = e.writer.WriteByte(e.delimiter)
 		}
-	}()
+		if value == nil {
+			_, _ = e.writer.WriteString(e.null)
+			continue
+		}
+		field, ok := value.(string)
+		if !ok {
+			field = fmt.Sprint(value)
+		}
+		e.writeField(field)
+	}
+	return e.writer.WriteByte('\n')
+}
 
-	newPr := wrapPipeReaderWithProcessing(context.Background(), pr, replaceNeverHappeningCharacter)
+// writeField writes a value, quoting it when needed.
+func (e *csvEncoder) writeField(field string) {
+	if !e.needsQuotes(field) {
+		_, _ = e.writer.WriteString(field)
+		return
+	}
+	_ = e.writer.WriteByte(e.quote)
+	for i := 0; i < len(field); i++ {
+		if field[i] == e.quote || field[i] == e.escape {
+			_ = e.writer.WriteByte(e.escape)
+		}
+		_ = e.writer.WriteByte(field[i])
+	}
+	_ = e.writer.WriteByte(e.quote)
+}
 
-	return newPr, nil
+// needsQuotes checks whether the value must be quoted: the values equal to the NULL string, the values with
+// the special characters, the values with leading spaces (like "encoding/csv" does) and the end-of-data
+// marker "\." of PostgreSQL.
+func (e *csvEncoder) needsQuotes(field string) bool {
+	if field == e.null || field == `\.` {
+		return true
+	}
+	if field != "" && (field[0] == ' ' || field[0] == '\t') {
+		return true
+	}
+	for i := 0; i < len(field); i++ {
+		switch field[i] {
+		case e.delimiter, e.quote, e.escape, '\r', '\n':
+			return true
+		}
+	}
+	return false
 }
 
-// replaceNeverHappeningCharacter replaces all occurrences of NeverHappeningCharacter in the input string
-// with empty quotes (""). This is because the standard behavior of the "encoding/csv" package is not to wrap
-// strings with quotes unless really needed, and for us this character indicates an empty string that
-// we want to arrive to PostgreSQL double-quoted.
-func replaceNeverHappeningCharacter(s string) string {
-	return strings.ReplaceAll(s, NeverHappeningCharacter, "\"\"")
+// flush writes the buffered data to the output.
+func (e *csvEncoder) flush() error {
+	return e.writer.Flush()
 }
 
-// wrapPipeReaderWithProcessing takes a context, a PipeReader, and a processing function
-// It returns a new PipeReader with data processed by the provided function before being read.
-// Handles context cancellation and errors during reading and writing operations.
-func wrapPipeReaderWithProcessing(ctx context.Context, pr *io.PipeReader, processFunc func(string) string) *io.PipeReader {
-	r, w := io.Pipe() // Create another pipe for wrapping
+// ConvertToCSVReader converts a ParquetReader source into an io.Reader providing CSV data in the format
+// of the options, utilizing a streaming approach (with a pipe inside). With options.Header, the column names
+// are written as the first line.
+// It processes rows from the ParquetReader and writes them as CSV records to a pipe
+// for consumption by the returned reader.
+// Context cancellation is supported to terminate processing early; the reader then fails with the error
+// of the context instead of seeing a truncated but complete-looking CSV, and so it does on the errors
+// of the source.
+// The returned CSV stream is specially prepared for PostgreSQL, so that NULL values and empty strings
+// are recognized properly by PostgreSQL (see csvEncoder).
+func ConvertToCSVReader(ctx context.Context, source pgx.CopyFromSource, options CopyCSVOptions,
+	columns []string) (io.Reader, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe() // Create a pipe for streaming
 
 	go func() {
 		defer func() {
-			if err := pr.Close(); err != nil {
-				Logger.Error("Error closing original pipe reader", zap.Error(err))
-			}
-			if err := w.Close(); err != nil {
-				Logger.Error("Error closing new pipe writer", zap.Error(err))
+			// a panic of the source must not kill the program from this goroutine
+			if recovered := recover(); recovered != nil {
+				panicErr := NewPanicError(recovered)
+				Logger.Error("Recovered from a panic converting the rows to CSV", zap.Error(panicErr),
+					zap.String("stack", panicErr.Stack))
+				_ = pw.CloseWithError(NewFatalError(panicErr))
 			}
 		}()
-
-		buf := make([]byte, 1024) // Buffer for reading from the original reader
-		for {
-			select {
-			case <-ctx.Done():
-				Logger.Info("Context canceled in wrapPipeReaderWithProcessing")
+		encoder := newCSVEncoder(pw, options)
+		fail := func(message string, err error) {
+			Logger.Error(message, zap.Error(err))
+			_ = pw.CloseWithError(err)
+		}
+		if options.Header {
+			header := make([]any, len(columns))
+			for i, column := range columns {
+				header[i] = column
+			}
+			if err := encoder.write(header); err != nil {
+				fail("Error writing the CSV header", err)
 				return
-			default:
-				n, err := pr.Read(buf)
-				if err != nil {
-					if err != io.EOF {
-						Logger.Error("Error reading from original pipe", zap.Error(err))
-					}
-					return
-				}
-
-				// Preprocess the data using the supplied function
-				inputData := string(buf[:n])
-				processedData := processFunc(inputData)
-
-				// Write the processed data to the new pipe writer
-				_, err = w.Write([]byte(processedData))
-				if err != nil {
-					Logger.Error("Error writing to new pipe", zap.Error(err))
-					return
-				}
 			}
 		}
+		for source.Next() {
+			if err := ctx.Err(); err != nil { // Check for cancellation
+				_ = pw.CloseWithError(err)
+				return // Exit goroutine if context is cancelled
+			}
+			values, err := source.Values()
+			if err != nil {
+				fail("Error getting values", err)
+				return // Exit goroutine on error
+			}
+			if err := encoder.write(values); err != nil {
+				fail("Error writing CSV record", err)
+				return // Exit goroutine on error
+			}
+		}
+		if err := source.Err(); err != nil {
+			fail("Error from source", err)
+			return
+		}
+		if err := encoder.flush(); err != nil {
+			fail("Error flushing CSV writer", err)
+			return
+		}
+		if err := pw.Close(); err != nil {
+			Logger.Error("Error closing pipe writer", zap.Error(err))
+		}
 	}()
 
-	return r
+	return pr, nil
 }
@@ -2,10 +2,12 @@ package utils
 
 import (
 	"bytes"
-	"encoding/csv"
-	"fmt"
-	"strings"
+	"context"
+	"errors"
+	"io"
 	"testing"
+
+	"github.com/jackc/pgx/v5"
 )
 
 func TestCSVWriterNilAndEmptyStrings(t *testing.T) {
@@ -21,36 +23,13 @@ func TestCSVWriterNilAndEmptyStrings(t *testing.T) {
 
 	// Write to an in-memory buffer instead of a file
 	var buffer bytes.Buffer
-	writer := csv.NewWriter(&buffer)
-
-	// Write data to the CSV
+	encoder := newCSVEncoder(&buffer, CopyCSVOptions{})
 	for _, row := range data {
-		stringRow := make([]string, len(row))
-		for i, v := range row {
-			switch value := v.(type) {
-			case nil:
-				stringRow[i] = "" // nil -> empty, represented without quotes
-			case string:
-				stringRow[i] = value // Empty string or regular string
-				if stringRow[i] == "" {
-					stringRow[i] = NeverHappeningCharacter
-				}
-			default:
-				stringRow[i] = toString(value) // Convert other types to string
-				if stringRow[i] == "" {
-					stringRow[i] = NeverHappeningCharacter
-				}
-			}
-		}
-		// Write row to the CSV
-		if err := writer.Write(stringRow); err != nil {
+		if err := encoder.write(row); err != nil {
 			t.Fatalf("error writing row to CSV: %v", err)
 		}
 	}
-	writer.Flush()
-
-	// Check for errors during the flush process
-	if err := writer.Error(); err != nil {
+	if err := encoder.flush(); err != nil {
 		t.Fatalf("error flushing CSV writer: %v", err)
 	}
 
@@ -62,18 +41,127 @@ func TestCSVWriterNilAndEmptyStrings(t *testing.T) {
 4,"",Empty Description
 5,,"one,two"
 `
+	if output := buffer.String(); output != expected {
+		t.Errorf("CSV output did not match expected result.\nExpected:\n%s\nGot:\n%s", expected, output)
+	}
+}
 
-	// Compare the generated output to the expected CSV
-	output := buffer.String()
+func TestCSVWriterOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  CopyCSVOptions
+		row      []any
+		expected string
+	}{
+		{"tab", CopyCSVOptions{Delimiter: "\t"}, []any{1, "a,b", "c\td", nil, ""}, "1\ta,b\t\"c\td\"\t\t\"\"\n"},
+		{"null string", CopyCSVOptions{Null: `\N`}, []any{nil, "", `\N`, " x"}, `\N,,"\N"," x"` + "\n"},
+		{"quote and escape", CopyCSVOptions{Quote: "'", Escape: `\`}, []any{`it's`, `a\b`, `"`},
+			`'it\'s','a\\b',"` + "\n"},
+		{"end of data", CopyCSVOptions{}, []any{`\.`, "multi\nline"}, "\"\\.\",\"multi\nline\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			encoder := newCSVEncoder(&buffer, tt.options)
+			if err := encoder.write(tt.row); err != nil {
+				t.Fatalf("write() error: %v", err)
+			}
+			if err := encoder.flush(); err != nil {
+				t.Fatalf("flush() error: %v", err)
+			}
+			if output := buffer.String(); output != tt.expected {
+				t.Errorf("write() = %q; want %q", output, tt.expected)
+			}
+		})
+	}
+}
 
-	output = strings.ReplaceAll(output, NeverHappeningCharacter, "\"\"")
+func TestCopyCSVOptionsSQL(t *testing.T) {
+	tests := []struct {
+		options  CopyCSVOptions
+		expected string
+	}{
+		{CopyCSVOptions{}, "FORMAT CSV"},
+		{CopyCSVOptions{Delimiter: ",", Quote: `"`, Escape: `"`}, "FORMAT CSV"},
+		{CopyCSVOptions{Delimiter: "\t", Null: `\N`, Header: true}, `FORMAT CSV, DELIMITER E'\t', NULL E'\\N', HEADER`},
+		{CopyCSVOptions{Delimiter: ";", Quote: "'", Escape: `\`}, `FORMAT CSV, DELIMITER ';', QUOTE '''', ESCAPE E'\\'`},
+	}
+	for _, tt := range tests {
+		if actual := tt.options.SQL(); actual != tt.expected {
+			t.Errorf("SQL() of %+v = %s; want %s", tt.options, actual, tt.expected)
+		}
+	}
+}
 
-	if output != expected {
-		t.Errorf("CSV output did not match expected result.\nExpected:\n%s\nGot:\n%s", expected, output)
+func TestCopyCSVOptionsValidate(t *testing.T) {
+	valid := []CopyCSVOptions{{}, {Delimiter: "\t", Null: `\N`}, {Delimiter: "|", Quote: "'", Escape: `\`}}
+	for _, options := range valid {
+		if err := options.Validate(); err != nil {
+			t.Errorf("Validate() of %+v = %v; want no error", options, err)
+		}
 	}
+	invalid := []CopyCSVOptions{{Delimiter: "||"}, {Delimiter: "é"}, {Delimiter: `"`}, {Delimiter: "'", Quote: "'"},
+		{Quote: "\n"}, {Null: "a,b"}, {Null: "\r"}, {Null: `"`}}
+	for _, options := range invalid {
+		if err := options.Validate(); err == nil {
+			t.Errorf("Validate() of %+v = nil; want an error", options)
+		}
+	}
+}
+
+func TestConvertToCSVReaderHeader(t *testing.T) {
+	source := pgx.CopyFromRows([][]any{{1, "a"}, {2, nil}})
+	reader, err := ConvertToCSVReader(context.Background(), source, CopyCSVOptions{Delimiter: "|", Header: true},
+		[]string{"id", "name"})
+	if err != nil {
+		t.Fatalf("ConvertToCSVReader() error: %v", err)
+	}
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll() error: %v", err)
+	}
+	if expected := "id|name\n1|a\n2|\n"; string(output) != expected {
+		t.Errorf("ConvertToCSVReader() = %q; want %q", output, expected)
+	}
+
+	if _, err := ConvertToCSVReader(context.Background(), source, CopyCSVOptions{Delimiter: `"`}, nil); err == nil {
+		t.Errorf("ConvertToCSVReader() with invalid options = nil; want an error")
+	}
+}
+
+func TestConvertToCSVReaderCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	reader, err := ConvertToCSVReader(ctx, pgx.CopyFromRows([][]any{{1}}), CopyCSVOptions{}, nil)
+	if err != nil {
+		t.Fatalf("ConvertToCSVReader() error: %v", err)
+	}
+	if _, err := io.ReadAll(reader); !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadAll() error = %v; want context.Canceled", err)
+	}
+}
+
+// panickingSource is a pgx.CopyFromSource that panics when its values are read.
+type panickingSource struct {
+	pgx.CopyFromSource
 }
 
-// Helper function for converting interface{} to string
-func toString(value interface{}) string {
-	return strings.TrimSpace(strings.Trim(fmt.Sprintf("%v", value), "\n"))
+func (s panickingSource) Values() ([]any, error) {
+	panic("broken converter")
+}
+
+func TestConvertToCSVReaderPanic(t *testing.T) {
+	source := panickingSource{pgx.CopyFromRows([][]any{{1}})}
+	reader, err := ConvertToCSVReader(context.Background(), source, CopyCSVOptions{}, nil)
+	if err != nil {
+		t.Fatalf("ConvertToCSVReader() error: %v", err)
+	}
+	_, err = io.ReadAll(reader)
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "broken converter" {
+		t.Fatalf("ReadAll() error = %v; want the panic", err)
+	}
+	if !IsFatalError(err) || panicErr.Stack == "" {
+		t.Errorf("the panic is not fatal or has no stack: %v", err)
+	}
 }
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin)
+
+package utils
+
+// FreeDiskSpace is not supported on this platform - the check is skipped by the callers.
+func FreeDiskSpace(path string) (free uint64, supported bool, err error) {
+	return 0, false, nil
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin
+
+package utils
+
+import "syscall"
+
+// FreeDiskSpace returns the number of bytes available to an unprivileged user on the volume containing the path.
+// The second return value indicates whether the check is supported on the current platform.
+func FreeDiskSpace(path string) (free uint64, supported bool, err error) {
+	var stat syscall.Statfs_t
+	if err = syscall.Statfs(path, &stat); err != nil {
+		return 0, true, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), true, nil
+}
@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+)
+
+// CheckWritableDir verifies that the path exists, is a directory and that files can be created in it.
+func CheckWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("directory '%s' is not accessible: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", dir)
+	}
+	file, err := os.CreateTemp(dir, ".dbrestore-write-check-*")
+	if err != nil {
+		return fmt.Errorf("directory '%s' is not writable: %w", dir, err)
+	}
+	_ = file.Close()
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckWritableDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to create a file: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		dir         string
+		expectError bool
+	}{
+		{name: "Writable directory", dir: dir, expectError: false},
+		{name: "Missing directory", dir: filepath.Join(dir, "missing"), expectError: true},
+		{name: "Regular file", dir: file, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckWritableDir(tt.dir)
+			if (err != nil) != tt.expectError {
+				t.Errorf("CheckWritableDir(%q) error = %v; expectError %v", tt.dir, err, tt.expectError)
+			}
+		})
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("CheckWritableDir() left files behind: %v", entries)
+	}
+}
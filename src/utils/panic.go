@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is a recovered panic converted into an error, so that a panic while loading a table fails the table
+// instead of killing the program with a raw stack trace.
+type PanicError struct {
+	// Value the value passed to panic
+	Value any
+	// Stack the stack trace of the goroutine at the moment of the panic
+	Stack string
+}
+
+// Error implements the error interface.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the error passed to panic, or nil if the panic value is not an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// NewPanicError converts the value returned by recover() into an error with the current stack trace;
+// it must be called by the deferred function that recovered the panic, while the stack of the panic is still there.
+// The same data panics again in the next attempt, so the caller usually marks the error fatal (see NewFatalError).
+func NewPanicError(recovered any) *PanicError {
+	return &PanicError{Value: recovered, Stack: string(debug.Stack())}
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"time"
+)
+
+// FatalError wraps an error that cannot be fixed by retrying the operation,
+// for example a configuration problem or a schema mismatch between the export and the target database.
+type FatalError struct {
+	// Err the original error
+	Err error
+}
+
+// Error implements the error interface.
+func (e *FatalError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the original error, so that errors.Is and errors.As keep working.
+func (e *FatalError) Unwrap() error {
+	return e.Err
+}
+
+// NewFatalError marks the given error as fatal (not worth retrying). Returns nil for a nil error.
+func NewFatalError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &FatalError{Err: err}
+}
+
+// IsFatalError checks whether the error (or any error wrapped by it) was marked as fatal.
+func IsFatalError(err error) bool {
+	var fatal *FatalError
+	return errors.As(err, &fatal)
+}
+
+// RetryAttempts calls the function up to maxAttempts times (at least once), waiting for the given delay
+// between attempts. The attempt number passed to the function starts from 1.
+// It stops immediately on success or when the function returns a fatal error (see NewFatalError).
+// Returns the error of the last attempt.
+func RetryAttempts(maxAttempts int, delay time.Duration, fn func(attempt int) error) (err error) {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = fn(attempt)
+		if err == nil {
+			return nil
+		}
+		if IsFatalError(err) {
+			Logger.Debug("Fatal error, not retrying", zap.Int("attempt", attempt), zap.Error(err))
+			return err
+		}
+		if attempt < maxAttempts {
+			Logger.Warn("Attempt failed, retrying", zap.Int("attempt", attempt),
+				zap.Int("max_attempts", maxAttempts), zap.Duration("delay", delay), zap.Error(err))
+			time.Sleep(delay)
+		}
+	}
+	return err
+}
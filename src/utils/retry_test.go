@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRetryAttempts(t *testing.T) {
+	errTransient := errors.New("connection reset")
+
+	tests := []struct {
+		name          string
+		maxAttempts   int
+		failures      int  // how many times the function fails before succeeding
+		fatal         bool // whether failures are fatal
+		expectedCalls int
+		expectedError bool
+	}{
+		{
+			name:          "Succeeds on the first attempt",
+			maxAttempts:   3,
+			failures:      0,
+			expectedCalls: 1,
+		},
+		{
+			name:          "Fails once then succeeds within the budget",
+			maxAttempts:   2,
+			failures:      1,
+			expectedCalls: 2,
+		},
+		{
+			name:          "Exhausts the attempt budget",
+			maxAttempts:   3,
+			failures:      5,
+			expectedCalls: 3,
+			expectedError: true,
+		},
+		{
+			name:          "Fatal errors are not retried",
+			maxAttempts:   3,
+			failures:      5,
+			fatal:         true,
+			expectedCalls: 1,
+			expectedError: true,
+		},
+		{
+			name:          "Zero attempts still runs once",
+			maxAttempts:   0,
+			failures:      0,
+			expectedCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := RetryAttempts(tt.maxAttempts, 0, func(attempt int) error {
+				calls++
+				if attempt != calls {
+					t.Errorf("attempt = %d; want %d", attempt, calls)
+				}
+				if calls <= tt.failures {
+					if tt.fatal {
+						return NewFatalError(fmt.Errorf("schema mismatch: %w", errTransient))
+					}
+					return errTransient
+				}
+				return nil
+			})
+			if calls != tt.expectedCalls {
+				t.Errorf("RetryAttempts() calls = %d; want %d", calls, tt.expectedCalls)
+			}
+			if (err != nil) != tt.expectedError {
+				t.Errorf("RetryAttempts() error = %v; want error %v", err, tt.expectedError)
+			}
+			if err != nil && !errors.Is(err, errTransient) {
+				t.Errorf("RetryAttempts() error = %v; want it to wrap %v", err, errTransient)
+			}
+		})
+	}
+}
@@ -9,43 +9,51 @@ import (
 
 // CreatePgxIdentifier constructs pgx.Identifier out of a table name, optionally including schema.
 // The input string can be SCHEMA.TABLE or TABLE (no matter the letter case).
-// A wrong input string with more than one "." symbol will report an error to the log and return
-// the whole input string to be wrapped as a single name,
-// usually resulting in a wrong identifier that will fail the SQL query.
-func CreatePgxIdentifier(tableNameWithOrWithoutSchema string) pgx.Identifier {
+// A wrong input string with more than one "." symbol is reported as an error that includes the name.
+func CreatePgxIdentifier(tableNameWithOrWithoutSchema string) (pgx.Identifier, error) {
 	s := tableNameWithOrWithoutSchema
 	if strings.Contains(s, ".") {
 		parts := strings.Split(s, ".")
 		if len(parts) != 2 {
-			// Handle the error if the identifier format is invalid (e.g., missing schema or table name)
-			Logger.Error("Invalid identifier format. Expected 'schema_name.table_name'",
-				zap.String("tableName", s))
-		} else {
-			return pgx.Identifier{parts[0], parts[1]}
+			return nil, fmt.Errorf("invalid table name '%s': expected 'schema_name.table_name' or 'table_name'", s)
 		}
+		return pgx.Identifier{parts[0], parts[1]}, nil
 	}
-	return pgx.Identifier{s}
+	return pgx.Identifier{s}, nil
 }
 
 // SanitizeTableName sanitizes a table name, optionally including schema, ensuring the format is valid for SQL queries.
 // The input string SCHEMA.TABLE will be returned as "SCHEMA"."TABLE",
 // and the input string "TABLE" will be returned as "TABLE".
-// A wrong input string with more than one "." symbol will report an error to the log and return the input string as-is.
-func SanitizeTableName(tableNameWithOrWithoutSchema string) string {
-	s := tableNameWithOrWithoutSchema
-	if strings.Contains(s, ".") {
-		parts := strings.Split(s, ".")
-		if len(parts) != 2 {
-			// Handle the error if the identifier format is invalid (e.g., missing schema or table name)
-			Logger.Error("Invalid identifier format. Expected 'schema_name.table_name'",
-				zap.String("tableName", s))
-		} else {
-			identifier := pgx.Identifier{parts[0], parts[1]}
-			return identifier.Sanitize() // Format the identifier
-		}
+// A wrong input string with more than one "." symbol is reported as an error (see CreatePgxIdentifier).
+func SanitizeTableName(tableNameWithOrWithoutSchema string) (string, error) {
+	identifier, err := CreatePgxIdentifier(tableNameWithOrWithoutSchema)
+	if err != nil {
+		return "", err
+	}
+	return identifier.Sanitize(), nil // Format the identifier
+}
+
+// CreatePgxIdentifierOrLog is the former CreatePgxIdentifier: a wrong input string is reported to the log,
+// and the whole input string is wrapped as a single name, usually resulting in a wrong identifier
+// that will fail the SQL query.
+//
+// Deprecated: use CreatePgxIdentifier, which returns the error to the caller.
+func CreatePgxIdentifierOrLog(tableNameWithOrWithoutSchema string) pgx.Identifier {
+	ret, err := CreatePgxIdentifier(tableNameWithOrWithoutSchema)
+	if err != nil {
+		Logger.Error("Invalid identifier format", zap.Error(err))
+		return pgx.Identifier{tableNameWithOrWithoutSchema}
 	}
-	identifier := pgx.Identifier{s}
-	return identifier.Sanitize() // Format the identifier
+	return ret
+}
+
+// SanitizeTableNameOrLog is the former SanitizeTableName: a wrong input string is reported to the log
+// and wrapped as a single name.
+//
+// Deprecated: use SanitizeTableName, which returns the error to the caller.
+func SanitizeTableNameOrLog(tableNameWithOrWithoutSchema string) string {
+	return CreatePgxIdentifierOrLog(tableNameWithOrWithoutSchema).Sanitize()
 }
 
 // SplitFullTableName splits a full table name into its schema and table components if a schema is specified.
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -9,6 +10,7 @@ func TestCreatePgxIdentifier(t *testing.T) {
 		name           string
 		input          string
 		expectedResult string
+		expectedError  string
 	}{
 		{
 			name:           "Test simple name",
@@ -21,9 +23,9 @@ func TestCreatePgxIdentifier(t *testing.T) {
 			expectedResult: `"schema"."table"`,
 		},
 		{
-			name:           "Test wrong name",
-			input:          "database.schema.table",
-			expectedResult: `"database.schema.table"`,
+			name:          "Test wrong name",
+			input:         "database.schema.table",
+			expectedError: "invalid table name 'database.schema.table'",
 		},
 		{
 			name:           "Test empty string",
@@ -34,8 +36,17 @@ func TestCreatePgxIdentifier(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := CreatePgxIdentifier(tt.input).Sanitize()
-			if result != tt.expectedResult {
+			identifier, err := CreatePgxIdentifier(tt.input)
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("CreatePgxIdentifier(%v) error = %v; want %q", tt.input, err, tt.expectedError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreatePgxIdentifier(%v) error: %v", tt.input, err)
+			}
+			if result := identifier.Sanitize(); result != tt.expectedResult {
 				t.Errorf("CreatePgxIdentifier(%v) = %v; want %v", tt.input, result, tt.expectedResult)
 			}
 		})
@@ -47,6 +58,7 @@ func TestSanitizeTableName(t *testing.T) {
 		name           string
 		input          string
 		expectedResult string
+		expectedError  string
 	}{
 		{
 			name:           "Test simple name",
@@ -59,9 +71,9 @@ func TestSanitizeTableName(t *testing.T) {
 			expectedResult: `"schema"."table"`,
 		},
 		{
-			name:           "Test wrong name",
-			input:          "database.schema.table",
-			expectedResult: `"database.schema.table"`,
+			name:          "Test wrong name",
+			input:         "database.schema.table",
+			expectedError: "invalid table name 'database.schema.table'",
 		},
 		{
 			name:           "Test empty string",
@@ -72,10 +84,28 @@ func TestSanitizeTableName(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := SanitizeTableName(tt.input)
+			result, err := SanitizeTableName(tt.input)
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("SanitizeTableName(%v) error = %v; want %q", tt.input, err, tt.expectedError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SanitizeTableName(%v) error: %v", tt.input, err)
+			}
 			if result != tt.expectedResult {
 				t.Errorf("SanitizeTableName(%v) = %v; want %v", tt.input, result, tt.expectedResult)
 			}
 		})
 	}
 }
+
+func TestDeprecatedWrappers(t *testing.T) {
+	if result := SanitizeTableNameOrLog("database.schema.table"); result != `"database.schema.table"` {
+		t.Errorf("SanitizeTableNameOrLog() = %v; want the whole name wrapped", result)
+	}
+	if result := CreatePgxIdentifierOrLog("schema.table").Sanitize(); result != `"schema"."table"` {
+		t.Errorf("CreatePgxIdentifierOrLog() = %v; want %v", result, `"schema"."table"`)
+	}
+}
@@ -1,7 +1,11 @@
 package utils
 
 import (
+	"cmp"
+	"fmt"
+	"math"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -9,3 +13,92 @@ import (
 func FindFilePathCharacters(s string) bool {
 	return strings.Contains(s, "..") || strings.ContainsRune(s, filepath.Separator)
 }
+
+// byteSizeUnits the supported suffixes of byte sizes, from the longest to the shortest (binary multiples)
+var byteSizeUnits = []struct {
+	suffix     string
+	multiplier uint64
+}{
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+}
+
+// ParseByteSize parses a human-readable byte size like "512MB", "2GB" or "1024" (plain bytes).
+// The suffixes are case-insensitive and are interpreted as binary multiples (1KB = 1024 bytes).
+func ParseByteSize(s string) (uint64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := uint64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(str, unit.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	value, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte size '%s': %w", s, err)
+	}
+	if value > math.MaxUint64/multiplier {
+		return 0, fmt.Errorf("invalid byte size '%s': too large", s)
+	}
+	return value * multiplier, nil
+}
+
+// FormatByteSize formats a byte count in a human-readable form, for example "1.5 GB".
+func FormatByteSize(size uint64) string {
+	for _, unit := range byteSizeUnits[:4] {
+		if size >= unit.multiplier {
+			return fmt.Sprintf("%.1f %s", float64(size)/float64(unit.multiplier), unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+// NaturalCompare compares two strings like strings.Compare, except that runs of digits are compared by their numeric
+// values, so that the numbered folders and part files of an export sort in their numeric order ("2" before "10",
+// "part-00002" before "part-00010"). Equal numbers with different leading zeros sort the shorter run first.
+func NaturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			aNumber, aRest := splitDigits(a)
+			bNumber, bRest := splitDigits(b)
+			if c := compareNumbers(aNumber, bNumber); c != 0 {
+				return c
+			}
+			a, b = aRest, bRest
+			continue
+		}
+		if a[0] != b[0] {
+			return cmp.Compare(a[0], b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// isDigit checks whether the byte is an ASCII digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// splitDigits splits the leading run of digits from the rest of the string.
+func splitDigits(s string) (digits string, rest string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// compareNumbers compares two runs of digits of any length by their numeric values.
+func compareNumbers(a, b string) int {
+	aTrimmed, bTrimmed := strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if c := cmp.Compare(len(aTrimmed), len(bTrimmed)); c != 0 {
+		return c
+	}
+	if c := strings.Compare(aTrimmed, bTrimmed); c != 0 {
+		return c
+	}
+	return cmp.Compare(len(a), len(b))
+}
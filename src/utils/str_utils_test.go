@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		expectedResult uint64
+		expectedError  bool
+	}{
+		{name: "Plain bytes", input: "1024", expectedResult: 1024},
+		{name: "Megabytes", input: "512MB", expectedResult: 512 << 20},
+		{name: "Gigabytes lowercase with space", input: "2 gb", expectedResult: 2 << 30},
+		{name: "Short suffix", input: "3G", expectedResult: 3 << 30},
+		{name: "Zero", input: "0", expectedResult: 0},
+		{name: "Largest value", input: "16777215TB", expectedResult: 16777215 << 40},
+		{name: "Too large", input: "16777216TB", expectedError: true},
+		{name: "Wrong value", input: "many", expectedError: true},
+		{name: "Empty string", input: "", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseByteSize(tt.input)
+			if (err != nil) != tt.expectedError {
+				t.Errorf("ParseByteSize(%v) error = %v; want error %v", tt.input, err, tt.expectedError)
+			}
+			if result != tt.expectedResult {
+				t.Errorf("ParseByteSize(%v) = %v; want %v", tt.input, result, tt.expectedResult)
+			}
+		})
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	tests := []struct {
+		input          uint64
+		expectedResult string
+	}{
+		{input: 100, expectedResult: "100 B"},
+		{input: 1536, expectedResult: "1.5 KB"},
+		{input: 3 << 30, expectedResult: "3.0 GB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expectedResult, func(t *testing.T) {
+			result := FormatByteSize(tt.input)
+			if result != tt.expectedResult {
+				t.Errorf("FormatByteSize(%v) = %v; want %v", tt.input, result, tt.expectedResult)
+			}
+		})
+	}
+}
+
+func TestNaturalCompare(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        string
+		b        string
+		expected int
+	}{
+		{name: "numbered folders", a: "2", b: "10", expected: -1},
+		{name: "equal folders", a: "10", b: "10", expected: 0},
+		{name: "part files", a: "part-00010-6f1b.c000.gz.parquet", b: "part-00002-6f1b.c000.gz.parquet", expected: 1},
+		{name: "paths", a: "db/public.events/2/part-00000.parquet", b: "db/public.events/10/part-00000.parquet",
+			expected: -1},
+		{name: "leading zeros", a: "part-2", b: "part-002", expected: -1},
+		{name: "text", a: "public.a", b: "public.b", expected: -1},
+		{name: "prefix", a: "part", b: "part-00000", expected: -1},
+		{name: "digits before letters", a: "part-1", b: "part-a", expected: -1},
+		{name: "long numbers", a: "part-18446744073709551616", b: "part-18446744073709551617", expected: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := NaturalCompare(tt.a, tt.b); result != tt.expected {
+				t.Errorf("NaturalCompare(%q, %q) = %d; want %d", tt.a, tt.b, result, tt.expected)
+			}
+			if result := NaturalCompare(tt.b, tt.a); result != -tt.expected {
+				t.Errorf("NaturalCompare(%q, %q) = %d; want %d", tt.b, tt.a, result, -tt.expected)
+			}
+		})
+	}
+
+	files := []string{
+		"db/public.events/10/part-00000-6f1b.c000.gz.parquet",
+		"db/public.events/2/_SUCCESS",
+		"db/public.events/2/part-00010-6f1b.c000.gz.parquet",
+		"db/public.events/1/part-00000-6f1b.c000.gz.parquet",
+		"db/public.events/2/part-00002-6f1b.c000.gz.parquet",
+	}
+	slices.SortFunc(files, NaturalCompare)
+	expected := []string{
+		"db/public.events/1/part-00000-6f1b.c000.gz.parquet",
+		"db/public.events/2/_SUCCESS",
+		"db/public.events/2/part-00002-6f1b.c000.gz.parquet",
+		"db/public.events/2/part-00010-6f1b.c000.gz.parquet",
+		"db/public.events/10/part-00000-6f1b.c000.gz.parquet",
+	}
+	if !slices.Equal(files, expected) {
+		t.Errorf("SortFunc(NaturalCompare) = %v; want %v", files, expected)
+	}
+}
@@ -0,0 +1,25 @@
+// Package version keeps the build information of the program, injected at build time with -ldflags, for example:
+//
+//	go build -ldflags "-X dbrestore/version.Version=0.1.0 -X dbrestore/version.Commit=$(git rev-parse --short HEAD) \
+//	    -X dbrestore/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// The build information; the values are "dev" in the builds without -ldflags
+var (
+	// Version the semantic version of the program
+	Version = "dev"
+	// Commit the git commit the program was built from
+	Commit = "dev"
+	// Date the build date
+	Date = "dev"
+)
+
+// String returns the version of the program with the build information and the Go runtime version.
+func String() string {
+	return fmt.Sprintf("dbrestore %s (commit %s, built %s, %s)", Version, Commit, Date, runtime.Version())
+}
@@ -0,0 +1,16 @@
+package version
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestDefaults(t *testing.T) {
+	if Version != "dev" || Commit != "dev" || Date != "dev" {
+		t.Errorf("Version, Commit, Date = %s, %s, %s; want dev without -ldflags", Version, Commit, Date)
+	}
+	expected := "dbrestore dev (commit dev, built dev, " + runtime.Version() + ")"
+	if String() != expected {
+		t.Errorf("String() = %s; want %s", String(), expected)
+	}
+}